@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	configValidateCmd.Flags().StringP("file", "f", "", "Config file to validate")
+	configUpgradeCmd.Flags().StringP("file", "f", "", "Config file to upgrade")
+	configUpgradeCmd.Flags().BoolP("write", "w", false, "Write the upgraded config back to the file instead of stdout")
+	configCmd.AddCommand(configValidateCmd, configSchemaCmd, configUpgradeCmd)
+	rootCmd.AddCommand(configCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with config files",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check a config file",
+	Long: `validate parses a config file and checks every source and sink
+			config in it, without contacting AWS or CI providers and without
+			reading their tokens`,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse config flag")
+		}
+		conf, err := config.LoadFile(file)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s is a valid version %d config with %d secret(s).\n", file, conf.Version, len(conf.Secrets))
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:           "schema",
+	Short:         "Print the JSON Schema of config files",
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schema, err := config.Schema()
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), string(schema))
+		return errors.Wrap(err, "unable to print schema")
+	},
+}
+
+var configUpgradeCmd = &cobra.Command{
+	Use:   "upgrade",
+	Short: "Rewrite a config file in the current version",
+	Long: `upgrade rewrites a config file of an older version in the current
+			version, keeping its comments. Older versions are also upgraded
+			on the fly when read by other commands.`,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse config flag")
+		}
+		write, err := cmd.Flags().GetBool("write")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse write flag")
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read config %s", file)
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrapf(err, "Could not read config %s", file)
+		}
+		upgraded, err := config.Upgrade(b)
+		if err != nil {
+			return err
+		}
+		_, err = config.Load(upgraded)
+		if err != nil {
+			return errors.Wrap(err, "upgraded config is invalid")
+		}
+		if write {
+			return errors.Wrapf(ioutil.WriteFile(file, upgraded, info.Mode()), "unable to write config %s", file)
+		}
+		_, err = cmd.OutOrStdout().Write(upgraded)
+		return errors.Wrap(err, "unable to print config")
+	},
+}
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigValidateCommand(t *testing.T) {
+	r := require.New(t)
+	defer util.ResetEnv(os.Environ())
+	// validation must not need CI tokens or AWS credentials
+	os.Clearenv()
+
+	tmpFile, err := ioutil.TempFile("", "tmpConfig")
+	r.NoError(err)
+	defer tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.WriteString(`
+version: 2
+secrets:
+  - name: aws
+    max_age: 24h
+    source:
+      kind: aws
+      role_arn: arn:aws:iam::123456789101:role/admin
+      username: example-user
+    sinks:
+      - kind: TravisCI
+        repo_slug: example/repo
+        key_to_name:
+          accessKeyId: AWS_ACCESS_KEY_ID
+      - kind: GitHubActionsSecret
+        owner: example
+        repo: repo
+        key_to_name:
+          secretAccessKey: AWS_SECRET_ACCESS_KEY
+`)
+	r.NoError(err)
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"config", "validate", "-f", tmpFile.Name()})
+	r.NoError(rootCmd.Execute())
+	r.Contains(out.String(), "valid version 2 config with 1 secret(s)")
+
+	_, err = tmpFile.WriteString("    unknown: field\n")
+	r.NoError(err)
+	rootCmd.SetArgs([]string{"config", "validate", "-f", tmpFile.Name()})
+	err = rootCmd.Execute()
+	r.Error(err)
+	r.Contains(err.Error(), `line 20: unknown field "unknown"`)
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+
+	// exitChangesPending is the exit status of plan --detailed-exitcode
+	// when at least one secret would be rotated.
+	exitChangesPending exitCode = 2
+)
+
+func init() {
+	planCmd.Flags().StringP("file", "f", "", "Config file to read from")
+	planCmd.Flags().StringP("output", "o", outputText, "Output format, text or json")
+	planCmd.Flags().Bool("force", false, "Plan as if every secret were forced to rotate.")
+	planCmd.Flags().Bool("detailed-exitcode", false, "Exit with 0 if no secret would be rotated, 1 on error and 2 if at least one secret would be rotated.")
+	planCmd.Flags().Duration("timeout", 0, "Maximum duration of the whole run, overrides the timeout set in the config file. 0 means no timeout.")
+	rootCmd.AddCommand(planCmd)
+}
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Show which secrets would be rotated",
+	Long: `plan parses a config file and shows which secrets are due for
+			rotation, why, and which names would be written in each sink,
+			without changing anything`,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse config flag")
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse output flag")
+		}
+		if output != outputText && output != outputJSON {
+			return errors.Errorf("unknown output format %q", output)
+		}
+		detailed, err := cmd.Flags().GetBool("detailed-exitcode")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse detailed-exitcode flag")
+		}
+		config, err := config.FromFile(file)
+		if err != nil {
+			return errors.Wrap(err, "unable to read config from file")
+		}
+		err = applyRunFlags(cmd, config)
+		if err != nil {
+			return err
+		}
+
+		ctx, cancel := contextWithSignals(context.Background())
+		defer cancel()
+		plan, planErr := PlanSecrets(ctx, config)
+		if plan == nil {
+			return planErr
+		}
+
+		if output == outputJSON {
+			err = writePlanJSON(cmd.OutOrStdout(), plan)
+		} else {
+			err = writePlanText(cmd.OutOrStdout(), plan)
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to print plan")
+		}
+		if planErr != nil {
+			return planErr
+		}
+		if detailed && plan.Changes {
+			return exitChangesPending
+		}
+		return nil
+	},
+}
+
+func writePlanJSON(w io.Writer, plan *Plan) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(plan)
+}
+
+// writePlanText prints plan for humans. Writes to names the sink
+// already holds are marked with "~", writes that create a name with "+",
+// and writes whose target the sink cannot check with "*".
+func writePlanText(w io.Writer, plan *Plan) error {
+	var rotate, keep int
+	for _, p := range plan.Secrets {
+		action := "no change"
+		if p.Rotate {
+			action = "rotate"
+			rotate++
+		} else {
+			keep++
+		}
+		_, err := fmt.Fprintf(w, "%s (%s source): %s, %s\n", p.Secret, p.Source, action, p.Reason)
+		if err != nil {
+			return err
+		}
+		if p.Error != "" {
+			_, err = fmt.Fprintf(w, "  ! error: %s\n", p.Error)
+			if err != nil {
+				return err
+			}
+		}
+		for _, wr := range p.Writes {
+			marker := "*"
+			if wr.Exists != nil && *wr.Exists {
+				marker = "~"
+			} else if wr.Exists != nil {
+				marker = "+"
+			}
+			if wr.Error != "" {
+				marker = "!"
+			}
+			_, err = fmt.Fprintf(w, "  %s %s: %s -> %s\n", marker, wr.Sink, wr.Key, wr.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "\nPlan: %d secret(s) to rotate, %d unchanged.\n", rotate, keep)
+	return err
+}
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanSecretsWithState(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	buf := sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "name"})
+	conf := &config.Config{Secrets: []config.Secret{{Name: "test", Source: src, Sinks: sink.Sinks{buf}}}}
+	conf.Secrets[0].MaxAge = 24 * time.Hour
+
+	plan, err := planSecrets(ctx, conf, store)
+	r.NoError(err)
+	r.True(plan.Changes)
+	r.Equal("never rotated", plan.Secrets[0].Reason)
+	r.Len(plan.Secrets[0].Writes, 1)
+	r.Equal("name", plan.Secrets[0].Writes[0].Name)
+	r.False(*plan.Secrets[0].Writes[0].Exists)
+	r.False(src.activated)
+
+	r.NoError(rotateSecrets(ctx, conf, store))
+	plan, err = planSecrets(ctx, conf, store)
+	r.NoError(err)
+	r.False(plan.Changes)
+	r.True(*plan.Secrets[0].Writes[0].Exists)
+
+	conf.Secrets[0].Force = true
+	plan, err = planSecrets(ctx, conf, store)
+	r.NoError(err)
+	r.True(plan.Changes)
+	r.Equal("forced", plan.Secrets[0].Reason)
+}
+
+func TestPlanSecretsUsesSourceKeys(t *testing.T) {
+	r := require.New(t)
+	defer util.ResetEnv(os.Environ())
+	r.NoError(os.Setenv("TEST_ENV", "value"))
+
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: source.NewEnvSource().WithName("TEST_ENV"),
+		Sinks: sink.Sinks{
+			sink.NewBufSink().WithKeyToName(map[string]string{"TEST_ENV": "name"}),
+			sink.NewBufSink().WithKeyToName(map[string]string{"OTHER": "name"}),
+		},
+	}}}
+
+	plan, err := planSecrets(context.Background(), conf, nil)
+	r.Error(err)
+	r.True(plan.Changes)
+	writes := plan.Secrets[0].Writes
+	r.Len(writes, 2)
+	r.Equal("name", writes[0].Name)
+	r.Empty(writes[0].Error)
+	r.Equal("OTHER", writes[1].Key)
+	r.NotEmpty(writes[1].Error)
+	r.NotEmpty(plan.Secrets[0].Error)
+}
+
+func TestPlanCommandDetailedExitCode(t *testing.T) {
+	r := require.New(t)
+	tmpFile, err := ioutil.TempFile("", "tmpConfig")
+	r.NoError(err)
+	defer tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	_, err = tmpFile.WriteString(`
+version: 1
+secrets:
+  - name: test
+    source:
+      kind: dummy
+    sinks:
+      - kind: Stdout
+        key_to_name:
+          secret: SECRET
+`)
+	r.NoError(err)
+
+	out := &bytes.Buffer{}
+	rootCmd.SetOut(out)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"plan", "-f", tmpFile.Name(), "-o", "json", "--detailed-exitcode"})
+	err = rootCmd.Execute()
+	r.Equal(exitChangesPending, err)
+
+	plan := &Plan{}
+	r.NoError(json.Unmarshal(out.Bytes(), plan))
+	r.True(plan.Changes)
+	r.Equal("test", plan.Secrets[0].Secret)
+	r.Equal(sink.KindStdout, plan.Secrets[0].Writes[0].Sink)
+	r.Equal("SECRET", plan.Secrets[0].Writes[0].Name)
+	r.Nil(plan.Secrets[0].Writes[0].Exists)
+}
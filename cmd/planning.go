@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"context"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// Plan describes what a rotation run would do.
+type Plan struct {
+	// Changes is true if at least one secret would be rotated.
+	Changes bool         `json:"changes"`
+	Secrets []SecretPlan `json:"secrets"`
+}
+
+// SecretPlan describes what rotating a secret would do.
+type SecretPlan struct {
+	Secret string      `json:"secret"`
+	Source source.Kind `json:"source"`
+	Rotate bool        `json:"rotate"`
+	// Reason explains Rotate, e.g. the age of the current credential.
+	Reason string      `json:"reason"`
+	Writes []WritePlan `json:"writes"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// WritePlan describes one value that would be written to a sink.
+type WritePlan struct {
+	Sink sink.Kind `json:"sink"`
+	Key  string    `json:"key"`
+	Name string    `json:"name"`
+	// Exists tells whether the sink already holds a value under Name.
+	// It is nil if the sink cannot tell.
+	Exists *bool  `json:"exists,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// PlanSecrets works out which secrets in conf would be rotated, why, and
+// which names would be written in each sink, without changing anything.
+// It returns the plan along with any error encountered while planning;
+// the errors are also recorded in the plan of the secret they concern.
+func PlanSecrets(ctx context.Context, conf *config.Config) (*Plan, error) {
+	store, err := openStore(conf)
+	if err != nil {
+		return nil, err
+	}
+	if store != nil {
+		defer store.Close()
+	}
+	return planSecrets(ctx, conf, store)
+}
+
+// planSecrets is PlanSecrets with an open store, which may be nil.
+func planSecrets(ctx context.Context, conf *config.Config, store state.Store) (*Plan, error) {
+	if conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.Timeout)
+		defer cancel()
+	}
+
+	plan := &Plan{Secrets: []SecretPlan{}}
+	var errs *multierror.Error
+	for _, secret := range conf.Secrets {
+		secrets, err := secret.Discover(ctx)
+		if err != nil {
+			plan.Secrets = append(plan.Secrets, SecretPlan{Secret: secret.Name, Source: secret.Source.Kind(), Writes: []WritePlan{}, Error: err.Error()})
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		for _, secret := range secrets {
+			p, err := planSecret(ctx, secret, store)
+			if err != nil {
+				p.Error = err.Error()
+				errs = multierror.Append(errs, err)
+			}
+			plan.Changes = plan.Changes || p.Rotate
+			plan.Secrets = append(plan.Secrets, p)
+		}
+	}
+	return plan, errs.ErrorOrNil()
+}
+
+// planSecret works out whether secret is due for rotation the same way
+// a rotation would, and lists the writes the rotation would make.
+func planSecret(ctx context.Context, secret config.Secret, store state.Store) (SecretPlan, error) {
+	src := secret.Source
+	p := SecretPlan{Secret: secret.Name, Source: src.Kind(), Writes: []WritePlan{}}
+
+	var fingerprints []string
+	var rec *state.Record
+	if store != nil {
+		var err error
+		fingerprints, err = sinkFingerprints(secret)
+		if err != nil {
+			return p, errors.Wrapf(err, "%s: unable to fingerprint sinks", secret.Name)
+		}
+		rec, err = store.Get(ctx, secret.Name)
+		if err != nil {
+			return p, errors.Wrapf(err, "%s: unable to read rotation state", secret.Name)
+		}
+		setDistributed(secret, rec, fingerprints)
+	}
+	err := inspect(ctx, secret)
+	if err != nil {
+		return p, err
+	}
+	var srcPlan *source.Plan
+	if planner, ok := src.(source.Planner); ok {
+		sp, err := planner.Plan(ctx)
+		if err != nil {
+			return p, errors.Wrapf(err, "%s: unable to plan rotation at %s source", secret.Name, src.Kind())
+		}
+		srcPlan = &sp
+	}
+
+	p.Rotate, p.Reason = true, "source cannot tell whether it is due"
+	if srcPlan != nil {
+		p.Rotate, p.Reason = srcPlan.Due, srcPlan.Reason
+	}
+	if store != nil && p.Rotate {
+		p.Rotate, p.Reason = dueByState(secret, rec, fingerprints, time.Now())
+	}
+	if secret.Force {
+		p.Rotate, p.Reason = true, "forced"
+	}
+
+	var keys []string
+	if srcPlan != nil {
+		keys = srcPlan.Keys
+	}
+	var errs *multierror.Error
+	for _, s := range secret.Sinks {
+		writes, err := planSinkWrites(ctx, secret, s, keys)
+		p.Writes = append(p.Writes, writes...)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	return p, errs.ErrorOrNil()
+}
+
+// planSinkWrites lists the writes a rotation would make to s, one for
+// each key in the sink's key_to_name, given the keys of the credential
+// produced by the source. If keys is nil, the source cannot tell, and
+// every key is assumed to be produced.
+func planSinkWrites(ctx context.Context, secret config.Secret, s sink.Sink, keys []string) ([]WritePlan, error) {
+	keyToName, err := secret.KeyToName(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(keyToName) == 0 {
+		return nil, errors.Errorf("%s: missing value in KeyToName field for %s sink", secret.Name, s.Kind())
+	}
+	produced := map[string]bool{}
+	for _, k := range keys {
+		produced[k] = true
+	}
+
+	var errs *multierror.Error
+	var writes []WritePlan
+	for _, k := range sortedKeys(keyToName) {
+		w := WritePlan{Sink: s.Kind(), Key: k, Name: keyToName[k]}
+		if keys != nil && !produced[k] {
+			err := errors.Errorf("%s: %s sink maps key %s, which %s source does not produce", secret.Name, s.Kind(), k, secret.Source.Kind())
+			w.Error = err.Error()
+			errs = multierror.Append(errs, err)
+			writes = append(writes, w)
+			continue
+		}
+		if restorer, ok := s.(sink.Restorer); ok {
+			_, err := restorer.Current(ctx, w.Name)
+			switch {
+			case err == nil:
+				w.Exists = boolPtr(true)
+			case errors.Is(err, sink.ErrNotFound):
+				w.Exists = boolPtr(false)
+			default:
+				err = errors.Wrapf(err, "%s: unable to read %s from %s sink", secret.Name, w.Name, s.Kind())
+				w.Error = err.Error()
+				errs = multierror.Append(errs, err)
+			}
+		}
+		writes = append(writes, w)
+	}
+	return writes, errs.ErrorOrNil()
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	reactivateCmd.Flags().StringP("file", "f", "", "Config file to read from")
+	reactivateCmd.Flags().String("secret", "", "Name of the secret whose retired credential to reactivate")
+	reactivateCmd.Flags().String("id", "", "ID of the credential to reactivate, e.g. an AWS access key ID. Defaults to the only retired credential.")
+	rootCmd.AddCommand(reactivateCmd)
+}
+
+var reactivateCmd = &cobra.Command{
+	Use:   "reactivate",
+	Short: "Reactivate a retired credential",
+	Long: `reactivate reactivates a credential that rotation deactivated
+			but has not deleted yet, e.g. an AWS access key inside its
+			inactive_grace that a forgotten job still needs`,
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		file, err := cmd.Flags().GetString("file")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse config flag")
+		}
+		name, err := cmd.Flags().GetString("secret")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse secret flag")
+		}
+		id, err := cmd.Flags().GetString("id")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse id flag")
+		}
+		config, err := config.FromFile(file)
+		if err != nil {
+			return errors.Wrap(err, "unable to read config from file")
+		}
+
+		ctx, cancel := contextWithSignals(context.Background())
+		defer cancel()
+		id, err = reactivate(ctx, config, name, id)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(cmd.OutOrStdout(), "%s: reactivated %s\n", name, id)
+		return err
+	},
+}
+
+// reactivate reactivates the retired credential with id, or the only
+// one if id is empty, at the source of the secret with name.
+func reactivate(ctx context.Context, conf *config.Config, name string, id string) (string, error) {
+	if name == "" {
+		return "", errors.New("missing secret name")
+	}
+	for _, secret := range conf.Secrets {
+		// discovered secrets are named after the secret they were
+		// discovered by
+		if secret.Name != name && !strings.HasPrefix(name, secret.Name+"/") {
+			continue
+		}
+		secrets, err := secret.Discover(ctx)
+		if err != nil {
+			return "", err
+		}
+		for _, secret := range secrets {
+			if secret.Name != name {
+				continue
+			}
+			r, ok := secret.Source.(source.Reactivator)
+			if !ok {
+				return "", errors.Errorf("%s: %s source cannot reactivate credentials", name, secret.Source.Kind())
+			}
+			id, err := r.Reactivate(ctx, id)
+			return id, errors.Wrapf(err, "%s: unable to reactivate credential at %s source", name, secret.Source.Kind())
+		}
+	}
+	return "", errors.Errorf("no secret named %s", name)
+}
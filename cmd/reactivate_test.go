@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// reactivatingSource has a single retired credential, "old".
+type reactivatingSource struct {
+	testSource
+	reactivated string
+}
+
+func (src *reactivatingSource) Reactivate(ctx context.Context, id string) (string, error) {
+	if id != "" && id != "old" {
+		return "", errors.Errorf("no retired credential %s", id)
+	}
+	src.reactivated = "old"
+	return "old", nil
+}
+
+func TestReactivate(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src := &reactivatingSource{}
+	conf := &config.Config{Secrets: []config.Secret{
+		{Name: "plain", Source: &testSource{}},
+		{Name: "keys", Source: src},
+	}}
+
+	id, err := reactivate(ctx, conf, "keys", "")
+	r.NoError(err)
+	r.Equal("old", id)
+	r.Equal("old", src.reactivated)
+
+	_, err = reactivate(ctx, conf, "keys", "other")
+	r.Error(err)
+	_, err = reactivate(ctx, conf, "plain", "")
+	r.Error(err)
+	r.Contains(err.Error(), "cannot reactivate")
+	_, err = reactivate(ctx, conf, "missing", "")
+	r.Error(err)
+	_, err = reactivate(ctx, conf, "", "")
+	r.Error(err)
+
+	var _ source.Reactivator = &source.AwsIamSource{}
+}
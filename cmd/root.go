@@ -1,10 +1,12 @@
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/chanzuckerberg/go-misc/cmds"
+	"github.com/chanzuckerberg/rotator/pkg/redact"
 	"github.com/getsentry/sentry-go"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
@@ -18,6 +20,7 @@ var rootCmd = cmds.Root("rotator", shortDescription, longDescription)
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	logrus.AddHook(redact.Hook{})
 	sentryEnabled, err := setUpSentry()
 	if err != nil {
 		logrus.Warn(errors.Wrap(err, "unable to set up Sentry notifier"))
@@ -27,6 +30,9 @@ func Execute() {
 	}
 
 	if err := rootCmd.Execute(); err != nil {
+		if code, ok := errors.Cause(err).(exitCode); ok {
+			os.Exit(int(code))
+		}
 		if sentryEnabled {
 			sentry.CaptureException(err)
 			sentry.Flush(time.Second * 5)
@@ -35,6 +41,14 @@ func Execute() {
 	}
 }
 
+// exitCode is returned by commands that need to exit with a non-zero
+// status without having failed, e.g. plan --detailed-exitcode.
+type exitCode int
+
+func (c exitCode) Error() string {
+	return fmt.Sprintf("exit status %d", int(c))
+}
+
 func setUpSentry() (bool, error) {
 	env := os.Getenv("ENV")
 	if env == "" {
@@ -46,6 +60,7 @@ func setUpSentry() (bool, error) {
 		err := sentry.Init(sentry.ClientOptions{
 			Dsn:         sentryDsn,
 			Environment: env,
+			BeforeSend:  redact.ScrubEvent,
 		})
 		if err != nil {
 			return false, errors.Wrap(err, "sentry initialization failed")
@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"context"
-	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/sink"
 	"github.com/fatih/color"
-	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"github.com/segmentio/go-prompt"
 	"github.com/sirupsen/logrus"
@@ -16,6 +18,14 @@ import (
 func init() {
 	rotateCmd.Flags().StringP("file", "f", "", "Config file to read from")
 	rotateCmd.Flags().BoolP("yes", "y", false, "Assume \"yes\" to all prompts and run non-interactively.")
+	rotateCmd.Flags().Duration("timeout", 0, "Maximum duration of the whole run, overrides the timeout set in the config file. 0 means no timeout.")
+	rotateCmd.Flags().Duration("secret-timeout", 0, "Maximum duration of the rotation of each secret, overrides the secret_timeout set in the config file. 0 means no timeout.")
+	rotateCmd.Flags().Int("concurrency", 0, "Number of secrets rotated at the same time, overrides concurrency.secrets in the config file.")
+	rotateCmd.Flags().Int("sink-concurrency", 0, "Number of sinks of a secret written to at the same time, overrides concurrency.sinks_per_secret in the config file.")
+	rotateCmd.Flags().Bool("dry-run", false, "Go through the rotation without changing anything: sources report what they would create and sinks check that they could be written to.")
+	rotateCmd.Flags().Bool("reveal", false, "Print secret values in the clear in Stdout sinks. Values are redacted otherwise.")
+	rotateCmd.Flags().Bool("force", false, "Rotate every secret, even those that are not due.")
+	rotateCmd.Flags().StringToInt("sink-kind-concurrency", nil, "Number of sinks of a kind written to at the same time across all secrets, e.g. TravisCI=2. Overrides concurrency.sink_kinds in the config file.")
 	rootCmd.AddCommand(rotateCmd)
 }
 
@@ -35,7 +45,18 @@ var rotateCmd = &cobra.Command{
 		if err != nil {
 			return errors.Wrap(err, "unable to read config from file")
 		}
-		printPlan(config)
+		err = applyRunFlags(cmd, config)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := contextWithSignals(context.Background())
+		defer cancel()
+		printPlan(ctx, config)
+
+		if config.DryRun {
+			logrus.Println("Dry run: checking the actions described above without performing them.")
+			return RotateSecrets(ctx, config)
+		}
 
 		// prompt user to continue if necessary
 		skipPrompt, err := cmd.Flags().GetBool("yes")
@@ -48,22 +69,93 @@ var rotateCmd = &cobra.Command{
 
 		// rotate secrets
 		logrus.Println("Performing the actions described above.")
-		return RotateSecrets(config)
+		return RotateSecrets(ctx, config)
 	},
 }
 
-func printPlan(config *config.Config) {
-	for _, secret := range config.Secrets {
-		logrus.Println("Rotating secret", secret.Name)
-
-		src := secret.Source
-		logrus.Println("*", "reading new credentials from", src.Kind(), "source")
+// applyRunFlags overrides the run settings in config with the flags set on cmd.
+func applyRunFlags(cmd *cobra.Command, config *config.Config) error {
+	var err error
+	flags := cmd.Flags()
+	if flags.Changed("timeout") {
+		config.Timeout, err = flags.GetDuration("timeout")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse timeout flag")
+		}
+	}
+	if flags.Changed("secret-timeout") {
+		config.SecretTimeout, err = flags.GetDuration("secret-timeout")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse secret-timeout flag")
+		}
+	}
+	if flags.Changed("concurrency") {
+		config.Concurrency.Secrets, err = flags.GetInt("concurrency")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse concurrency flag")
+		}
+	}
+	if flags.Changed("sink-concurrency") {
+		config.Concurrency.SinksPerSecret, err = flags.GetInt("sink-concurrency")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse sink-concurrency flag")
+		}
+	}
+	if flags.Changed("dry-run") {
+		config.DryRun, err = flags.GetBool("dry-run")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse dry-run flag")
+		}
+	}
+	if flags.Changed("reveal") {
+		reveal, err := flags.GetBool("reveal")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse reveal flag")
+		}
+		for _, secret := range config.Secrets {
+			for _, s := range secret.Sinks {
+				if stdout, ok := s.(*sink.StdoutSink); ok {
+					stdout.WithReveal(reveal)
+				}
+			}
+		}
+	}
+	if flags.Changed("force") {
+		force, err := flags.GetBool("force")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse force flag")
+		}
+		for i := range config.Secrets {
+			config.Secrets[i].Force = force
+		}
+	}
+	if flags.Changed("sink-kind-concurrency") {
+		kinds, err := flags.GetStringToInt("sink-kind-concurrency")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse sink-kind-concurrency flag")
+		}
+		config.Concurrency.SinkKinds = map[sink.Kind]int{}
+		for kind, n := range kinds {
+			config.Concurrency.SinkKinds[sink.Kind(kind)] = n
+		}
+	}
+	return nil
+}
 
-		for _, sink := range secret.Sinks {
-			logrus.Println("*", "writing new credentials to", sink.Kind(), "sink")
+// printPlan prints what rotating the secrets in config would do. Secrets
+// that cannot be planned are still attempted by the rotation.
+func printPlan(ctx context.Context, config *config.Config) {
+	plan, err := PlanSecrets(ctx, config)
+	if plan != nil {
+		err := writePlanText(logrus.StandardLogger().Out, plan)
+		if err != nil {
+			logrus.Warn(errors.Wrap(err, "unable to print plan"))
 		}
 		logrus.Println()
 	}
+	if err != nil {
+		logrus.Warn(errors.Wrap(err, "unable to plan every secret"))
+	}
 }
 
 func getPrompt() bool {
@@ -87,43 +179,20 @@ func getPrompt() bool {
 	return yes
 }
 
-// RotateSecrets takes a config, reads the secret from the source,
-// and writes it to each sink.
-func RotateSecrets(config *config.Config) error {
-	var errs *multierror.Error
-	ctx := context.Background()
-	for _, secret := range config.Secrets {
-		// Rotate credential at source
-		src := secret.Source
-		newCreds, err := src.Read()
-		if err != nil {
-			errs = multierror.Append(errs, errors.Wrapf(err, "%s: unable to rotate secret at %s", secret.Name, src.Kind()))
-			continue
+// contextWithSignals returns a context that is cancelled on SIGINT or SIGTERM,
+// so that in-flight rotations can stop and roll back before the process exits.
+func contextWithSignals(parent context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-sigs:
+			logrus.Warnf("received %s, cancelling rotation", sig)
+			cancel()
+		case <-ctx.Done():
 		}
-		if newCreds == nil { // not time to rotate yet
-			continue
-		}
-
-		// Write new credentials to each sink
-		for _, sink := range secret.Sinks {
-			keyToName := sink.GetKeyToName()
-			if keyToName == nil {
-				errs = multierror.Append(errs, errors.New(fmt.Sprintf("%s: missing value in KeyToName field for %s sink", secret.Name, sink.Kind())))
-				continue
-			}
-			for k, v := range newCreds {
-				name, ok := keyToName[k]
-				if !ok {
-					errs = multierror.Append(errs, errors.New(fmt.Sprintf("%s: no name specified for credential with key %s for %s sink", secret.Name, k, sink.Kind())))
-					continue
-				}
-				err = sink.Write(ctx, name, v)
-				if err != nil {
-					errs = multierror.Append(errors.Wrapf(err, "%s: unable to write secret to %s sink", secret.Name, sink.Kind()))
-					continue
-				}
-			}
-		}
-	}
-	return errs.ErrorOrNil()
+		signal.Stop(sigs)
+	}()
+	return ctx, cancel
 }
@@ -3,6 +3,7 @@
 package cmd_test
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -93,7 +94,7 @@ func TestRotate(t *testing.T) {
 			r.Nil(err)
 			// r.Equal(tt.config, configFromFile)
 
-			err = cmd.RotateSecrets(configFromFile)
+			err = cmd.RotateSecrets(context.Background(), configFromFile)
 			r.Nil(err)
 		})
 	}
@@ -1,18 +1,27 @@
 package cmd
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/redact"
 	"github.com/chanzuckerberg/rotator/pkg/sink"
 	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/chanzuckerberg/rotator/pkg/state"
 	"github.com/chanzuckerberg/rotator/pkg/util"
 	heroku "github.com/heroku/heroku-go/v5"
 	"github.com/julienschmidt/httprouter"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 )
 
@@ -117,7 +126,7 @@ func TestHerokuSinkWrite(t *testing.T) {
 			},
 		},
 	}
-	err = RotateSecrets(testHerokuSinkConfig)
+	err = RotateSecrets(context.Background(), testHerokuSinkConfig)
 	r.NoError(err)
 
 	// check that the secret value is updated
@@ -127,3 +136,649 @@ func TestHerokuSinkWrite(t *testing.T) {
 	r.NotNil(configVarUpdate[secretName])
 	r.Equal(NewSecret, *configVarUpdate[secretName])
 }
+
+// testSource hands out a fixed credential and records the lifecycle
+// calls made by RotateSecrets.
+type testSource struct {
+	creds     map[string]string
+	activated bool
+	revoked   bool
+}
+
+func (src *testSource) Read(ctx context.Context) (map[string]string, error) {
+	return src.creds, nil
+}
+func (src *testSource) Create(ctx context.Context) (map[string]string, error) {
+	return src.creds, nil
+}
+func (src *testSource) Activate(ctx context.Context) error { src.activated = true; return nil }
+func (src *testSource) Revoke(ctx context.Context) error   { src.revoked = true; return nil }
+func (src *testSource) Kind() source.Kind                  { return source.KindDummy }
+
+// failingSink fails every write.
+type failingSink struct {
+	sink.BaseSink
+}
+
+func (s *failingSink) Write(ctx context.Context, name string, val string) error {
+	return errors.New("write failed")
+}
+func (s *failingSink) Kind() sink.Kind { return sink.KindStdout }
+
+// writeOnlySink accepts every write but can neither read back nor verify values.
+type writeOnlySink struct {
+	sink.BaseSink
+}
+
+func (s *writeOnlySink) Write(ctx context.Context, name string, val string) error { return nil }
+func (s *writeOnlySink) Kind() sink.Kind                                          { return sink.KindStdout }
+
+func TestRotateSecretsActivatesAfterAllSinks(t *testing.T) {
+	r := require.New(t)
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	buf := sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "name"})
+	conf := &config.Config{Secrets: []config.Secret{{Name: "test", Source: src, Sinks: sink.Sinks{buf}}}}
+
+	r.NoError(RotateSecrets(context.Background(), conf))
+	r.True(src.activated)
+	r.False(src.revoked)
+	val, err := buf.Current(context.Background(), "name")
+	r.NoError(err)
+	r.Equal("new", val)
+}
+
+func TestRotateSecretsWritesOnlyMappedKeys(t *testing.T) {
+	r := require.New(t)
+
+	src := &testSource{creds: map[string]string{"public": "pub", "private": "priv"}}
+	public := sink.NewBufSink().WithKeyToName(map[string]string{"public": "pub_name"})
+	private := sink.NewBufSink().WithKeyToName(map[string]string{"private": "priv_name"})
+	conf := &config.Config{Secrets: []config.Secret{{Name: "test", Source: src, Sinks: sink.Sinks{public, private}}}}
+
+	r.NoError(RotateSecrets(context.Background(), conf))
+	r.True(src.activated)
+	ctx := context.Background()
+	val, err := public.Current(ctx, "pub_name")
+	r.NoError(err)
+	r.Equal("pub", val)
+	_, err = public.Current(ctx, "priv_name")
+	r.Equal(sink.ErrNotFound, err)
+	val, err = private.Current(ctx, "priv_name")
+	r.NoError(err)
+	r.Equal("priv", val)
+	_, err = private.Current(ctx, "pub_name")
+	r.Equal(sink.ErrNotFound, err)
+}
+
+func TestRotateSecretsFailsOnUnproducedKey(t *testing.T) {
+	r := require.New(t)
+
+	src := &testSource{creds: map[string]string{"public": "pub"}}
+	buf := sink.NewBufSink().WithKeyToName(map[string]string{"public": "pub_name", "private": "priv_name"})
+	conf := &config.Config{Secrets: []config.Secret{{Name: "test", Source: src, Sinks: sink.Sinks{buf}}}}
+
+	r.Error(RotateSecrets(context.Background(), conf))
+	r.False(src.activated)
+	r.Empty(buf.Read())
+}
+
+func TestRotateSecretsRollsBackOnFailedWrite(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	keyToName := map[string]string{source.Secret: "name"}
+	buf := sink.NewBufSink().WithKeyToName(keyToName)
+	r.NoError(buf.Write(ctx, "name", "old"))
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{buf, &failingSink{BaseSink: sink.BaseSink{KeyToName: keyToName}}},
+	}}}
+
+	r.Error(RotateSecrets(context.Background(), conf))
+	r.False(src.activated)
+	r.True(src.revoked)
+	val, err := buf.Current(ctx, "name")
+	r.NoError(err)
+	r.Equal("old", val)
+}
+
+func TestRotateSecretsKeepsCredentialIfSinkCannotBeRestored(t *testing.T) {
+	r := require.New(t)
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	keyToName := map[string]string{source.Secret: "name"}
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks: sink.Sinks{
+			&writeOnlySink{BaseSink: sink.BaseSink{KeyToName: keyToName}},
+			&failingSink{BaseSink: sink.BaseSink{KeyToName: keyToName}},
+		},
+	}}}
+
+	r.Error(RotateSecrets(context.Background(), conf))
+	r.False(src.activated)
+	r.False(src.revoked)
+}
+
+// activatingSink records the values it is asked to activate, and
+// whether the source was activated by then.
+type activatingSink struct {
+	writeOnlySink
+	src       *testSource
+	activated []string
+}
+
+func (s *activatingSink) Activate(ctx context.Context, name string, val string) error {
+	if !s.src.activated {
+		return errors.New("source not activated yet")
+	}
+	s.activated = append(s.activated, name+"="+val)
+	return nil
+}
+
+func TestRotateSecretsActivatesSinksAfterSource(t *testing.T) {
+	r := require.New(t)
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	keyToName := map[string]string{source.Secret: "name"}
+	s := &activatingSink{writeOnlySink: writeOnlySink{BaseSink: sink.BaseSink{KeyToName: keyToName}}, src: src}
+	conf := &config.Config{Secrets: []config.Secret{{Name: "test", Source: src, Sinks: sink.Sinks{s}}}}
+	r.NoError(RotateSecrets(context.Background(), conf))
+	r.Equal([]string{"name=new"}, s.activated)
+
+	// sinks are not activated by a rotation that is rolled back
+	src = &testSource{creds: map[string]string{source.Secret: "new"}}
+	s = &activatingSink{writeOnlySink: writeOnlySink{BaseSink: sink.BaseSink{KeyToName: keyToName}}, src: src}
+	conf = &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{s, &failingSink{BaseSink: sink.BaseSink{KeyToName: keyToName}}},
+	}}}
+	r.Error(RotateSecrets(context.Background(), conf))
+	r.Empty(s.activated)
+}
+
+// undeletableSink reads back the values written to it but cannot delete
+// them.
+type undeletableSink struct {
+	writeOnlySink
+	vals map[string]string
+}
+
+func (s *undeletableSink) Write(ctx context.Context, name string, val string) error {
+	s.vals[name] = val
+	return nil
+}
+
+func (s *undeletableSink) Current(ctx context.Context, name string) (string, error) {
+	val, ok := s.vals[name]
+	if !ok {
+		return "", sink.ErrNotFound
+	}
+	return val, nil
+}
+
+func TestRotateSecretsRollsBackEmptySink(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	// the new value is deleted from a sink that held none
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	keyToName := map[string]string{source.Secret: "name"}
+	buf := sink.NewBufSink().WithKeyToName(keyToName)
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{buf, &failingSink{BaseSink: sink.BaseSink{KeyToName: keyToName}}},
+	}}}
+	r.Error(RotateSecrets(ctx, conf))
+	r.True(src.revoked)
+	_, err := buf.Current(ctx, "name")
+	r.Equal(sink.ErrNotFound, err)
+
+	// or the new credential is left active if it cannot be
+	src = &testSource{creds: map[string]string{source.Secret: "new"}}
+	undeletable := &undeletableSink{writeOnlySink: writeOnlySink{BaseSink: sink.BaseSink{KeyToName: keyToName}}, vals: map[string]string{}}
+	conf = &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{undeletable, &failingSink{BaseSink: sink.BaseSink{KeyToName: keyToName}}},
+	}}}
+	r.Error(RotateSecrets(ctx, conf))
+	r.False(src.revoked)
+	r.Equal(map[string]string{"name": "new"}, undeletable.vals)
+}
+
+// blockingSource blocks until its context is done.
+type blockingSource struct {
+	testSource
+}
+
+func (src *blockingSource) Create(ctx context.Context) (map[string]string, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRotateSecretsSecretTimeout(t *testing.T) {
+	r := require.New(t)
+
+	src := &blockingSource{}
+	conf := &config.Config{
+		SecretTimeout: 10 * time.Millisecond,
+		Secrets: []config.Secret{
+			{Name: "slow", Source: src, Sinks: sink.Sinks{sink.NewBufSink()}},
+			{Name: "slower", Source: src, Sinks: sink.Sinks{sink.NewBufSink()}, Timeout: 20 * time.Millisecond},
+		},
+	}
+
+	err := RotateSecrets(context.Background(), conf)
+	r.Error(err)
+	r.Contains(err.Error(), "slow: unable to rotate secret")
+	r.Contains(err.Error(), "slower: unable to rotate secret")
+	r.Contains(err.Error(), context.DeadlineExceeded.Error())
+}
+
+func TestRotateSecretsRunCancelled(t *testing.T) {
+	r := require.New(t)
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "name"})},
+	}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := RotateSecrets(ctx, conf)
+	r.Error(err)
+	r.Contains(err.Error(), "test: not rotated")
+	r.False(src.activated)
+}
+
+// countingSink records the largest number of writes in flight at once
+// across all its instances.
+type countingSink struct {
+	sink.BaseSink
+
+	mu       *sync.Mutex
+	inFlight *int
+	max      *int
+}
+
+func (s *countingSink) Write(ctx context.Context, name string, val string) error {
+	s.mu.Lock()
+	*s.inFlight++
+	if *s.inFlight > *s.max {
+		*s.max = *s.inFlight
+	}
+	s.mu.Unlock()
+
+	time.Sleep(20 * time.Millisecond)
+
+	s.mu.Lock()
+	*s.inFlight--
+	s.mu.Unlock()
+	return nil
+}
+func (s *countingSink) Kind() sink.Kind { return sink.KindTravisCi }
+
+func TestRotateSecretsConcurrencyLimits(t *testing.T) {
+	r := require.New(t)
+
+	var mu sync.Mutex
+	inFlight, max := 0, 0
+	keyToName := map[string]string{source.Secret: "name"}
+	newSink := func() sink.Sink {
+		return &countingSink{BaseSink: sink.BaseSink{KeyToName: keyToName}, mu: &mu, inFlight: &inFlight, max: &max}
+	}
+
+	conf := &config.Config{
+		Concurrency: config.Concurrency{
+			Secrets:        4,
+			SinksPerSecret: 3,
+			SinkKinds:      map[sink.Kind]int{sink.KindTravisCi: 2},
+		},
+	}
+	var srcs []*testSource
+	for i := 0; i < 4; i++ {
+		src := &testSource{creds: map[string]string{source.Secret: "new"}}
+		srcs = append(srcs, src)
+		conf.Secrets = append(conf.Secrets, config.Secret{
+			Name:   fmt.Sprintf("secret%d", i),
+			Source: src,
+			Sinks:  sink.Sinks{newSink(), newSink(), newSink()},
+		})
+	}
+
+	r.NoError(RotateSecrets(context.Background(), conf))
+	r.Equal(2, max)
+	for _, src := range srcs {
+		r.True(src.activated)
+	}
+}
+
+// loggingSource logs through the logger of the rotation when creating
+// credentials.
+type loggingSource struct {
+	testSource
+	name string
+}
+
+func (src *loggingSource) Create(ctx context.Context) (map[string]string, error) {
+	util.Logger(ctx).Infof("%s: logged by source", src.name)
+	return src.testSource.Create(ctx)
+}
+
+func TestRotateSecretsGroupsLogOutputBySecret(t *testing.T) {
+	r := require.New(t)
+
+	out := &bytes.Buffer{}
+	std := logrus.StandardLogger()
+	origOut := std.Out
+	std.SetOutput(out)
+	defer std.SetOutput(origOut)
+
+	conf := &config.Config{Concurrency: config.Concurrency{Secrets: 2}}
+	for _, name := range []string{"first", "second"} {
+		conf.Secrets = append(conf.Secrets, config.Secret{
+			Name:   name,
+			Source: &loggingSource{testSource: testSource{creds: map[string]string{source.Secret: "new"}}, name: name},
+			Sinks: sink.Sinks{
+				sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "a"}),
+				sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "b"}),
+			},
+		})
+	}
+	r.NoError(RotateSecrets(context.Background(), conf))
+
+	// each secret's lines are contiguous
+	var order []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		name := "first"
+		if strings.Contains(line, "second:") {
+			name = "second"
+		}
+		if len(order) == 0 || order[len(order)-1] != name {
+			order = append(order, name)
+		}
+	}
+	r.Len(order, 2)
+	r.Contains(out.String(), "first: logged by source")
+	r.Contains(out.String(), "second: logged by source")
+}
+
+func TestRotateSecretsHonorsMaxAgeWithState(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	secret := config.Secret{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "a"})},
+		MaxAge: time.Hour,
+	}
+	conf := &config.Config{Secrets: []config.Secret{secret}}
+
+	r.NoError(rotateSecrets(ctx, conf, store))
+	r.True(src.activated)
+	rec, err := store.Get(ctx, "test")
+	r.NoError(err)
+	r.NotNil(rec)
+	r.Equal(state.OutcomeRotated, rec.Outcome)
+	r.Len(rec.Sinks, 1)
+
+	// not due again within max_age
+	src.activated = false
+	r.NoError(rotateSecrets(ctx, conf, store))
+	r.False(src.activated)
+
+	// adding a sink makes it due
+	conf.Secrets[0].Sinks = append(conf.Secrets[0].Sinks,
+		sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "b"}))
+	r.NoError(rotateSecrets(ctx, conf, store))
+	r.True(src.activated)
+	rec, err = store.Get(ctx, "test")
+	r.NoError(err)
+	r.Len(rec.Sinks, 2)
+
+	// and so does passing max_age
+	rec.LastRotated = rec.LastRotated.Add(-2 * time.Hour)
+	r.NoError(store.Put(ctx, rec))
+	src.activated = false
+	r.NoError(rotateSecrets(ctx, conf, store))
+	r.True(src.activated)
+}
+
+func TestRotateSecretsRecordsRollback(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	keyToName := map[string]string{source.Secret: "name"}
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		// a heroku sink without a client fails
+		Sinks: sink.Sinks{sink.NewBufSink().WithKeyToName(keyToName), &sink.HerokuSink{BaseSink: sink.BaseSink{KeyToName: keyToName}}},
+	}}}
+
+	r.Error(rotateSecrets(ctx, conf, store))
+	r.True(src.revoked)
+	rec, err := store.Get(ctx, "test")
+	r.NoError(err)
+	r.NotNil(rec)
+	r.Equal(state.OutcomeRolledBack, rec.Outcome)
+	r.True(rec.LastRotated.IsZero())
+	r.False(rec.LastAttempt.IsZero())
+}
+
+func TestRotateSecretsDryRun(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	keyToName := map[string]string{source.Secret: "name"}
+	client := &authorizedHerokuClient{configVarInfo: map[string]*string{"name": heroku.String("old")}}
+	herokuSink := &sink.HerokuSink{AppIdentity: "app", Client: client, BaseSink: sink.BaseSink{KeyToName: keyToName}}
+	conf := &config.Config{
+		DryRun:  true,
+		Secrets: []config.Secret{{Name: "test", Source: source.NewDummySource(), Sinks: sink.Sinks{herokuSink}}},
+	}
+
+	// preflight passes and nothing is written
+	r.NoError(RotateSecrets(ctx, conf))
+	r.Equal("old", *client.configVarInfo["name"])
+
+	// preflight fails for a sink that cannot be reached
+	conf.Secrets[0].Sinks = sink.Sinks{&sink.HerokuSink{AppIdentity: "app", BaseSink: sink.BaseSink{KeyToName: keyToName}}}
+	r.Error(RotateSecrets(ctx, conf))
+
+	// sources that cannot plan do not support dry runs
+	src := &testSource{creds: map[string]string{source.Secret: "new"}}
+	conf.Secrets[0] = config.Secret{Name: "test", Source: src, Sinks: sink.Sinks{herokuSink}}
+	r.Error(RotateSecrets(ctx, conf))
+	r.False(src.activated)
+}
+
+func TestRotateSecretsRegistersValuesForRedaction(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	src := &testSource{creds: map[string]string{source.Secret: "new-secret-value"}}
+	buf := sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "name"})
+	r.NoError(buf.Write(ctx, "name", "old-secret-value"))
+	conf := &config.Config{Secrets: []config.Secret{{Name: "test", Source: src, Sinks: sink.Sinks{buf}}}}
+
+	r.NoError(RotateSecrets(ctx, conf))
+	r.Equal(redact.Redacted, redact.Scrub("new-secret-value"))
+	r.Equal(redact.Redacted, redact.Scrub("old-secret-value"))
+}
+
+func TestRotateSecretsReleasesScopedValues(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	src := &testSource{creds: map[string]string{source.Secret: "scoped-new-value"}}
+	buf := sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "name"})
+	r.NoError(buf.Write(ctx, "name", "scoped-old-value"))
+	conf := &config.Config{Secrets: []config.Secret{{Name: "test", Source: src, Sinks: sink.Sinks{buf}}}}
+
+	scope := redact.NewScope()
+	r.NoError(RotateSecrets(redact.WithScope(ctx, scope), conf))
+	r.Equal(redact.Redacted, redact.Scrub("scoped-new-value"))
+	r.Equal(redact.Redacted, redact.Scrub("scoped-old-value"))
+
+	// the values are released with the scope of the run
+	scope.Release()
+	r.Equal("scoped-new-value", redact.Scrub("scoped-new-value"))
+	r.Equal("scoped-old-value", redact.Scrub("scoped-old-value"))
+}
+
+// inspectingSource records what Inspect was passed and only hands out
+// a credential if nothing is in use.
+type inspectingSource struct {
+	testSource
+	inspected map[string]string
+}
+
+func (src *inspectingSource) InspectKeys() []string { return []string{source.Secret} }
+func (src *inspectingSource) Inspect(current map[string]string) {
+	src.inspected = current
+}
+func (src *inspectingSource) Create(ctx context.Context) (map[string]string, error) {
+	if src.inspected[source.Secret] != "" {
+		return nil, nil
+	}
+	return src.creds, nil
+}
+
+func TestRotateSecretsInspectsCurrentValue(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	keyToName := map[string]string{source.Secret: "name"}
+	src := &inspectingSource{testSource: testSource{creds: map[string]string{source.Secret: "new"}}}
+	buf := sink.NewBufSink().WithKeyToName(keyToName)
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{&writeOnlySink{BaseSink: sink.BaseSink{KeyToName: keyToName}}, buf},
+	}}}
+
+	// nothing in use yet
+	r.NoError(RotateSecrets(ctx, conf))
+	r.Empty(src.inspected)
+	r.True(src.activated)
+
+	// the value written is read back from the sink that can
+	src.activated = false
+	r.NoError(RotateSecrets(ctx, conf))
+	r.Equal(map[string]string{source.Secret: "new"}, src.inspected)
+	r.False(src.activated)
+}
+
+// versionedSource hands out a credential only if its version was not
+// distributed yet.
+type versionedSource struct {
+	testSource
+	version     string
+	distributed string
+}
+
+func (src *versionedSource) CredentialID(creds map[string]string) string { return src.version }
+func (src *versionedSource) SetDistributed(id string)                    { src.distributed = id }
+func (src *versionedSource) Create(ctx context.Context) (map[string]string, error) {
+	if src.distributed == src.version {
+		return nil, nil
+	}
+	return src.creds, nil
+}
+
+func TestRotateSecretsPassesDistributedVersion(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	store := state.NewMemoryStore()
+
+	src := &versionedSource{testSource: testSource{creds: map[string]string{source.Secret: "new"}}, version: "v1"}
+	conf := &config.Config{Secrets: []config.Secret{{
+		Name:   "test",
+		Source: src,
+		Sinks:  sink.Sinks{sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "a"})},
+	}}}
+
+	r.NoError(rotateSecrets(ctx, conf, store))
+	r.True(src.activated)
+	r.Equal("", src.distributed)
+
+	// the version distributed is passed back
+	src.activated = false
+	r.NoError(rotateSecrets(ctx, conf, store))
+	r.Equal("v1", src.distributed)
+	r.False(src.activated)
+
+	// unless a sink was added, which needs the version too
+	conf.Secrets[0].Sinks = append(conf.Secrets[0].Sinks,
+		sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "b"}))
+	src.distributed = ""
+	r.NoError(rotateSecrets(ctx, conf, store))
+	r.Equal("", src.distributed)
+	r.True(src.activated)
+}
+
+// discoveringSource discovers a testSource for each repo.
+type discoveringSource struct {
+	testSource
+	found map[string]*testSource
+}
+
+func (src *discoveringSource) Discovers() bool { return true }
+
+func (src *discoveringSource) Discover(ctx context.Context) ([]source.Discovered, error) {
+	var found []source.Discovered
+	for _, repo := range []string{"a", "b"} {
+		found = append(found, source.Discovered{
+			Name:   "user-" + repo,
+			Source: src.found[repo],
+			Vars:   struct{ Repo string }{repo},
+		})
+	}
+	return found, nil
+}
+
+func TestRotateSecretsDiscovered(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	src := &discoveringSource{found: map[string]*testSource{
+		"a": {creds: map[string]string{source.Secret: "new-a"}},
+		"b": {creds: map[string]string{source.Secret: "new-b"}},
+	}}
+	buf := sink.NewBufSink().WithKeyToName(map[string]string{source.Secret: "{{ .Repo }}_SECRET"})
+	conf := &config.Config{Secrets: []config.Secret{{Name: "ci", Source: src, Sinks: sink.Sinks{buf}}}}
+
+	plan, err := planSecrets(ctx, conf, nil)
+	r.NoError(err)
+	r.Len(plan.Secrets, 2)
+	r.Equal("ci/user-a", plan.Secrets[0].Secret)
+	r.Equal("a_SECRET", plan.Secrets[0].Writes[0].Name)
+
+	r.NoError(RotateSecrets(ctx, conf))
+	for repo, found := range src.found {
+		r.True(found.activated, repo)
+		val, err := buf.Current(ctx, repo+"_SECRET")
+		r.NoError(err)
+		r.Equal("new-"+repo, val)
+	}
+	r.False(src.activated)
+
+	// a name the discovered credential has no data for fails its rotation
+	buf.WithKeyToName(map[string]string{source.Secret: "{{ .Tags.repo }}_SECRET"})
+	r.Error(RotateSecrets(ctx, conf))
+}
@@ -0,0 +1,753 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/redact"
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// rollbackTimeout bounds a rollback. Rollbacks do not inherit the
+	// deadline of the rotation they undo, which may already have passed.
+	rollbackTimeout = 5 * time.Minute
+	// stateTimeout bounds recording the outcome of a rotation, which
+	// has to happen even if the rotation timed out.
+	stateTimeout = time.Minute
+)
+
+// RotateSecrets takes a config and rotates each secret in two phases.
+// A new credential is created at the source, written to each sink and
+// verified. Only then is it activated at the source. If any of these
+// steps fail, the sinks are restored to their previous values and the
+// new credential is revoked.
+//
+// Secrets, and the sinks of each secret, are processed concurrently
+// within the limits set in config. The log output of each secret is
+// held back until the secret is done so that it is not interleaved
+// with the output of other secrets.
+//
+// If a state store is configured, secrets whose max_age has not passed
+// since their last rotation are skipped, and the outcome of each
+// rotation is recorded.
+//
+// The run stops when ctx is done or the timeouts set in config expire.
+func RotateSecrets(ctx context.Context, conf *config.Config) error {
+	store, err := openStore(conf)
+	if err != nil {
+		return err
+	}
+	if store != nil {
+		defer store.Close()
+	}
+	return rotateSecrets(ctx, conf, store)
+}
+
+// openStore opens the state store configured in conf, or returns nil
+// if none is configured.
+func openStore(conf *config.Config) (state.Store, error) {
+	if conf.State == nil {
+		return nil, nil
+	}
+	store, err := conf.State.Open()
+	return store, errors.Wrap(err, "unable to open state store")
+}
+
+// rotateSecrets is RotateSecrets with an open store, which may be nil.
+func rotateSecrets(ctx context.Context, conf *config.Config, store state.Store) error {
+	return rotateWithLimits(ctx, conf, store, newLimits(conf.Concurrency))
+}
+
+// rotateWithLimits is rotateSecrets with limits that may be shared with
+// other runs, as in rotator serve.
+func rotateWithLimits(ctx context.Context, conf *config.Config, store state.Store, limits *limits) error {
+	if conf.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, conf.Timeout)
+		defer cancel()
+	}
+
+	var errs *multierror.Error
+	var mu sync.Mutex // guards errs and log output
+	var wg sync.WaitGroup
+	for _, secret := range conf.Secrets {
+		secrets, err := secret.Discover(ctx)
+		if err != nil {
+			mu.Lock()
+			errs = multierror.Append(errs, err)
+			mu.Unlock()
+			continue
+		}
+		for _, secret := range secrets {
+			err := limits.acquireSecret(ctx)
+			if err != nil {
+				mu.Lock()
+				errs = multierror.Append(errs, errors.Wrapf(err, "%s: not rotated", secret.Name))
+				mu.Unlock()
+				continue
+			}
+
+			wg.Add(1)
+			go func(secret config.Secret) {
+				defer wg.Done()
+				defer limits.releaseSecret()
+
+				r := newRotation(secret, limits, store)
+				r.dryRun = conf.DryRun
+				err := r.runWithTimeout(ctx, conf.TimeoutFor(secret))
+
+				mu.Lock()
+				defer mu.Unlock()
+				r.flushLog()
+				if err != nil {
+					errs = multierror.Append(errs, err)
+				}
+			}(secret)
+		}
+	}
+	wg.Wait()
+	return errs.ErrorOrNil()
+}
+
+// limits bounds the number of secrets and sink operations in flight.
+type limits struct {
+	secrets        chan struct{}
+	sinksPerSecret int
+	sinkKinds      map[sink.Kind]chan struct{}
+}
+
+func newLimits(conf config.Concurrency) *limits {
+	l := &limits{
+		secrets:        make(chan struct{}, atLeastOne(conf.Secrets)),
+		sinksPerSecret: atLeastOne(conf.SinksPerSecret),
+		sinkKinds:      map[sink.Kind]chan struct{}{},
+	}
+	for kind, n := range conf.SinkKinds {
+		l.sinkKinds[kind] = make(chan struct{}, atLeastOne(n))
+	}
+	return l
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+	return n
+}
+
+func (l *limits) acquireSecret(ctx context.Context) error {
+	return acquire(ctx, l.secrets)
+}
+
+func (l *limits) releaseSecret() {
+	<-l.secrets
+}
+
+// acquireSink waits for a free slot for a sink of the given kind. It
+// returns a func that releases the slot.
+func (l *limits) acquireSink(ctx context.Context, kind sink.Kind) (func(), error) {
+	sem, ok := l.sinkKinds[kind]
+	if !ok {
+		return func() {}, nil
+	}
+	if err := acquire(ctx, sem); err != nil {
+		return nil, err
+	}
+	return func() { <-sem }, nil
+}
+
+func acquire(ctx context.Context, sem chan struct{}) error {
+	// fail fast rather than racing a done context against a free slot
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// write is a single credential value to be written to a sink.
+type write struct {
+	sink sink.Sink
+	name string
+	val  redact.Value
+
+	// prev is the value the sink held before the write, or nil if it
+	// held none.
+	prev *redact.Value
+	// restorable is true if the sink can be rolled back to prev, or the
+	// value written deleted if prev is nil.
+	restorable bool
+	// attempted is true once the write has been attempted, and
+	// landed is true if it succeeded.
+	attempted bool
+	landed    bool
+}
+
+// rotation is the rotation of a single secret.
+type rotation struct {
+	secret config.Secret
+	limits *limits
+	store  state.Store
+	dryRun bool
+
+	log    *logrus.Logger
+	logBuf *bytes.Buffer
+}
+
+func newRotation(secret config.Secret, limits *limits, store state.Store) *rotation {
+	std := logrus.StandardLogger()
+	buf := &bytes.Buffer{}
+	log := logrus.New()
+	log.Out = buf
+	log.Formatter = std.Formatter
+	log.Level = std.Level
+	log.AddHook(redact.Hook{})
+	return &rotation{secret: secret, limits: limits, store: store, log: log, logBuf: buf}
+}
+
+// flushLog writes the log output held back for this secret to the standard logger.
+func (r *rotation) flushLog() {
+	_, err := logrus.StandardLogger().Out.Write(r.logBuf.Bytes())
+	if err != nil {
+		logrus.Warnf("%s: unable to write log output: %s", r.secret.Name, err)
+	}
+	r.logBuf.Reset()
+}
+
+func (r *rotation) runWithTimeout(ctx context.Context, timeout time.Duration) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	return r.run(ctx)
+}
+
+func (r *rotation) run(ctx context.Context) error {
+	secret := r.secret
+	// sources and sinks log to the output held back for this secret
+	ctx = util.WithLogger(ctx, r.log)
+
+	var fingerprints []string
+	var prev *state.Record
+	if r.store != nil {
+		var err error
+		fingerprints, err = sinkFingerprints(secret)
+		if err != nil {
+			return errors.Wrapf(err, "%s: unable to fingerprint sinks", secret.Name)
+		}
+		prev, err = r.store.Get(ctx, secret.Name)
+		if err != nil {
+			return errors.Wrapf(err, "%s: unable to read rotation state", secret.Name)
+		}
+		setDistributed(secret, prev, fingerprints)
+		due, reason := dueByState(secret, prev, fingerprints, time.Now())
+		if !due && !secret.Force {
+			r.log.Infof("%s: not due for rotation: %s", secret.Name, reason)
+			return nil
+		}
+		if !secret.Force {
+			r.log.Infof("%s: due for rotation: %s", secret.Name, reason)
+		}
+	}
+
+	// Stage new credential at source
+	src := secret.Source
+	if secret.Force {
+		r.log.Infof("%s: forcing rotation", secret.Name)
+		if f, ok := src.(source.Forcer); ok {
+			f.Force()
+		}
+	}
+	err := inspect(ctx, secret)
+	if err != nil {
+		return r.record(prev, fingerprints, nil, state.OutcomeFailed, err)
+	}
+	if r.dryRun {
+		return r.runDry(ctx)
+	}
+	newCreds, err := src.Create(ctx)
+	if err != nil {
+		err = errors.Wrapf(err, "%s: unable to rotate secret at %s", secret.Name, src.Kind())
+		return r.record(prev, fingerprints, nil, state.OutcomeFailed, err)
+	}
+	if newCreds == nil { // not time to rotate yet
+		r.log.Infof("%s: not due for rotation", secret.Name)
+		return nil
+	}
+	redact.ScopeFrom(ctx).RegisterMap(newCreds)
+	r.log.Infof("%s: created new credentials at %s source", secret.Name, src.Kind())
+
+	writes, err := planWrites(secret, newCreds)
+	if err == nil {
+		err = r.forEachSink(ctx, writes, r.snapshot)
+	}
+	if err == nil {
+		err = r.forEachSink(ctx, writes, r.write)
+	}
+	if err == nil {
+		err = r.forEachSink(ctx, writes, r.verify)
+	}
+	if err != nil {
+		outcome, err := r.rollback(writes, err)
+		return r.record(prev, fingerprints, nil, outcome, err)
+	}
+
+	err = src.Activate(ctx)
+	if err != nil {
+		err = errors.Wrapf(err, "%s: new credentials written to all sinks but unable to activate them at %s", secret.Name, src.Kind())
+		return r.record(prev, fingerprints, nil, state.OutcomeFailed, err)
+	}
+	r.log.Infof("%s: activated new credentials at %s source", secret.Name, src.Kind())
+
+	// the new credentials are in use either way, so the rotation is
+	// recorded even if sinks fail to retire the values they replaced
+	err = r.forEachSink(ctx, writes, r.activate)
+	return r.record(prev, fingerprints, newCreds, state.OutcomeRotated, err)
+}
+
+// runDry goes through the rotation without changing anything. The
+// source reports what it would create, and each sink checks that the
+// write would succeed. Nothing is recorded in the state store.
+func (r *rotation) runDry(ctx context.Context) error {
+	secret := r.secret
+	src := secret.Source
+	planner, ok := src.(source.Planner)
+	if !ok {
+		return errors.Errorf("%s: %s source does not support dry runs", secret.Name, src.Kind())
+	}
+	plan, err := planner.Plan(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "%s: unable to plan rotation at %s source", secret.Name, src.Kind())
+	}
+	if !plan.Due {
+		r.log.Infof("%s: not due for rotation: %s", secret.Name, plan.Reason)
+		return nil
+	}
+	r.log.Infof("%s: would create new credentials at %s source: %s", secret.Name, src.Kind(), plan.Reason)
+
+	// placeholder credentials, so that the same writes are planned
+	creds := map[string]string{}
+	for _, k := range plan.Keys {
+		creds[k] = ""
+	}
+	writes, err := planWrites(secret, creds)
+	if err != nil {
+		return err
+	}
+	return r.forEachSink(ctx, writes, r.preflight)
+}
+
+// preflight checks that w would succeed, if the sink can tell.
+func (r *rotation) preflight(ctx context.Context, w *write) error {
+	p, ok := w.sink.(sink.Preflighter)
+	if !ok {
+		r.log.Infof("%s: %s sink has no preflight check, would write %s", r.secret.Name, w.sink.Kind(), w.name)
+		return nil
+	}
+	err := p.Preflight(ctx, w.name)
+	if err != nil {
+		return errors.Wrapf(err, "%s: preflight of %s in %s sink failed", r.secret.Name, w.name, w.sink.Kind())
+	}
+	r.log.Infof("%s: preflight of %s in %s sink passed", r.secret.Name, w.name, w.sink.Kind())
+	return nil
+}
+
+// inspect passes sources that implement source.Inspector the values
+// the sinks of secret currently hold. Each value is read from the first
+// sink that maps its key and can read it back.
+func inspect(ctx context.Context, secret config.Secret) error {
+	in, ok := secret.Source.(source.Inspector)
+	if !ok {
+		return nil
+	}
+	current := map[string]string{}
+	for _, key := range in.InspectKeys() {
+		for _, s := range secret.Sinks {
+			restorer, ok := s.(sink.Restorer)
+			if !ok {
+				continue
+			}
+			keyToName, err := secret.KeyToName(s)
+			if err != nil {
+				return err
+			}
+			name, mapped := keyToName[key]
+			if !mapped {
+				continue
+			}
+			val, err := restorer.Current(ctx, name)
+			if errors.Is(err, sink.ErrNotFound) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "%s: unable to read current value of %s from %s sink", secret.Name, name, s.Kind())
+			}
+			redact.ScopeFrom(ctx).Register(val)
+			current[key] = val
+			break
+		}
+	}
+	in.Inspect(current)
+	return nil
+}
+
+// sinkFingerprints returns the fingerprint of each sink of secret, with
+// the names it is written to as rendered for the secret.
+func sinkFingerprints(secret config.Secret) ([]string, error) {
+	var fingerprints []string
+	for _, s := range secret.Sinks {
+		keyToName, err := secret.KeyToName(s)
+		if err != nil {
+			return nil, err
+		}
+		fp, err := sink.FingerprintAs(s, keyToName)
+		if err != nil {
+			return nil, err
+		}
+		fingerprints = append(fingerprints, fp)
+	}
+	return fingerprints, nil
+}
+
+// dueByState reports whether secret is due for rotation given the record
+// of its last rotation, and why.
+func dueByState(secret config.Secret, rec *state.Record, fingerprints []string, now time.Time) (bool, string) {
+	if rec == nil || rec.LastRotated.IsZero() {
+		return true, "never rotated"
+	}
+	if sinksChanged(rec, fingerprints) {
+		return true, "sinks changed since last rotation"
+	}
+	age := now.Sub(rec.LastRotated).Round(time.Second)
+	if secret.MaxAge == 0 {
+		return true, fmt.Sprintf("last rotated %s ago, no max_age set", age)
+	}
+	if age >= secret.MaxAge {
+		return true, fmt.Sprintf("last rotated %s ago, max_age is %s", age, secret.MaxAge)
+	}
+	return false, fmt.Sprintf("last rotated %s ago, max_age is %s", age, secret.MaxAge)
+}
+
+// sinksChanged reports whether the sinks of a secret differ from those
+// in the record of its last rotation.
+func sinksChanged(rec *state.Record, fingerprints []string) bool {
+	if len(rec.Sinks) != len(fingerprints) {
+		return true
+	}
+	for i, s := range rec.Sinks {
+		if s.Fingerprint != fingerprints[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// setDistributed passes a Versioned source the credential distributed by
+// the last rotation recorded in rec, unless the sinks changed since.
+func setDistributed(secret config.Secret, rec *state.Record, fingerprints []string) {
+	v, ok := secret.Source.(source.Versioned)
+	if !ok || rec == nil || rec.LastRotated.IsZero() || sinksChanged(rec, fingerprints) {
+		return
+	}
+	v.SetDistributed(rec.CredentialID)
+}
+
+// record stores the outcome of the rotation, if a store is configured,
+// and returns err along with any error encountered storing it. Only a
+// successful rotation updates what is known about the credential in use.
+func (r *rotation) record(prev *state.Record, fingerprints []string, creds map[string]string, outcome state.Outcome, err error) error {
+	if r.store == nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	rec := &state.Record{Secret: r.secret.Name}
+	if prev != nil {
+		rec = prev
+	}
+	rec.LastAttempt = now
+	rec.Outcome = outcome
+	if outcome == state.OutcomeRotated {
+		rec.LastRotated = now
+		rec.CredentialID = ""
+		if id, ok := r.secret.Source.(source.Identifier); ok {
+			rec.CredentialID = id.CredentialID(creds)
+		}
+		rec.Sinks = nil
+		for i, s := range r.secret.Sinks {
+			rec.Sinks = append(rec.Sinks, state.SinkRecord{
+				Kind:        string(s.Kind()),
+				Fingerprint: fingerprints[i],
+				LastWritten: now,
+				Outcome:     outcome,
+			})
+		}
+	}
+
+	putErr := r.store.Put(ctx, rec)
+	if putErr == nil {
+		return err
+	}
+	putErr = errors.Wrapf(putErr, "%s: unable to record rotation state", r.secret.Name)
+	if err == nil {
+		return putErr
+	}
+	return multierror.Append(err, putErr)
+}
+
+// planWrites maps each credential to the name it is stored under in each
+// sink. A sink only gets the keys its key_to_name maps, e.g. only the
+// public key of a keypair, but it must map at least one, and only keys
+// the source produced.
+func planWrites(secret config.Secret, creds map[string]string) ([]*write, error) {
+	var errs *multierror.Error
+	var writes []*write
+	for _, sink := range secret.Sinks {
+		keyToName, err := secret.KeyToName(sink)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+			continue
+		}
+		if len(keyToName) == 0 {
+			errs = multierror.Append(errs, errors.New(fmt.Sprintf("%s: missing value in KeyToName field for %s sink", secret.Name, sink.Kind())))
+			continue
+		}
+		for _, k := range sortedKeys(keyToName) {
+			v, ok := creds[k]
+			if !ok {
+				errs = multierror.Append(errs, errors.New(fmt.Sprintf("%s: %s sink maps key %s, which %s source does not produce", secret.Name, sink.Kind(), k, secret.Source.Kind())))
+				continue
+			}
+			writes = append(writes, &write{sink: sink, name: keyToName[k], val: redact.Value(v)})
+		}
+	}
+	return writes, errs.ErrorOrNil()
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// forEachSink calls f for each write. Writes to the same sink are made in
+// order and stop at the first error; different sinks are handled
+// concurrently within the limits of the run.
+func (r *rotation) forEachSink(ctx context.Context, writes []*write, f func(context.Context, *write) error) error {
+	var bySink []sink.Sink
+	groups := map[sink.Sink][]*write{}
+	for _, w := range writes {
+		if _, ok := groups[w.sink]; !ok {
+			bySink = append(bySink, w.sink)
+		}
+		groups[w.sink] = append(groups[w.sink], w)
+	}
+
+	var errs *multierror.Error
+	var mu sync.Mutex // guards errs
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, r.limits.sinksPerSecret)
+	for _, s := range bySink {
+		wg.Add(1)
+		go func(s sink.Sink) {
+			defer wg.Done()
+			err := r.withSinkSlot(ctx, sem, s, func() error {
+				for _, w := range groups[s] {
+					if err := f(ctx, w); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				mu.Lock()
+				errs = multierror.Append(errs, err)
+				mu.Unlock()
+			}
+		}(s)
+	}
+	wg.Wait()
+	return errs.ErrorOrNil()
+}
+
+// withSinkSlot calls f once both a slot of this secret and a slot for the
+// kind of s are free.
+func (r *rotation) withSinkSlot(ctx context.Context, sem chan struct{}, s sink.Sink, f func() error) error {
+	if err := acquire(ctx, sem); err != nil {
+		return errors.Wrapf(err, "%s: gave up waiting for %s sink", r.secret.Name, s.Kind())
+	}
+	defer func() { <-sem }()
+	release, err := r.limits.acquireSink(ctx, s.Kind())
+	if err != nil {
+		return errors.Wrapf(err, "%s: gave up waiting for %s sink", r.secret.Name, s.Kind())
+	}
+	defer release()
+	return f()
+}
+
+// snapshot saves the current value of w if its sink can restore it. A
+// sink that holds no value yet can only be rolled back if it can delete
+// the new one.
+func (r *rotation) snapshot(ctx context.Context, w *write) error {
+	s, ok := w.sink.(sink.Restorer)
+	if !ok {
+		return nil
+	}
+	prev, err := s.Current(ctx, w.name)
+	if errors.Is(err, sink.ErrNotFound) {
+		// nothing to restore, but the new value must be removed again
+		_, w.restorable = w.sink.(sink.Deleter)
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "%s: unable to read current value of %s from %s sink", r.secret.Name, w.name, w.sink.Kind())
+	}
+	redact.ScopeFrom(ctx).Register(prev)
+	val := redact.Value(prev)
+	w.prev = &val
+	w.restorable = true
+	return nil
+}
+
+func (r *rotation) write(ctx context.Context, w *write) error {
+	w.attempted = true
+	err := w.sink.Write(ctx, w.name, w.val.Reveal())
+	if err != nil {
+		return errors.Wrapf(err, "%s: unable to write secret to %s sink", r.secret.Name, w.sink.Kind())
+	}
+	w.landed = true
+	r.log.Infof("%s: wrote %s to %s sink", r.secret.Name, w.name, w.sink.Kind())
+	return nil
+}
+
+// verify checks that the sink of w holds the value written to it. Sinks
+// that can neither read back values nor verify writes are trusted.
+func (r *rotation) verify(ctx context.Context, w *write) error {
+	switch s := w.sink.(type) {
+	case sink.Verifier:
+		err := s.Verify(ctx, w.name, w.val.Reveal())
+		if err != nil {
+			return errors.Wrapf(err, "%s: unable to verify %s in %s sink", r.secret.Name, w.name, w.sink.Kind())
+		}
+	case sink.Restorer:
+		cur, err := s.Current(ctx, w.name)
+		if err != nil {
+			return errors.Wrapf(err, "%s: unable to verify %s in %s sink", r.secret.Name, w.name, w.sink.Kind())
+		}
+		if cur != w.val.Reveal() {
+			return errors.Errorf("%s: %s in %s sink does not hold the new value", r.secret.Name, w.name, w.sink.Kind())
+		}
+	}
+	return nil
+}
+
+// activate lets the sink of w retire the value w replaced, if it needs to.
+func (r *rotation) activate(ctx context.Context, w *write) error {
+	a, ok := w.sink.(sink.Activator)
+	if !ok {
+		return nil
+	}
+	err := a.Activate(ctx, w.name, w.val.Reveal())
+	if err != nil {
+		return errors.Wrapf(err, "%s: unable to retire previous %s in %s sink", r.secret.Name, w.name, w.sink.Kind())
+	}
+	r.log.Infof("%s: activated %s in %s sink", r.secret.Name, w.name, w.sink.Kind())
+	return nil
+}
+
+// rollback restores every sink that was written to, deleting the new
+// value from sinks that held none, and revokes the new credential at the
+// source. If a sink cannot be restored it may now hold
+// the new credential, so the credential is left active rather than
+// breaking whatever reads from that sink.
+//
+// The rollback runs under its own timeout rather than the context of the
+// rotation, which may already be done.
+func (r *rotation) rollback(writes []*write, cause error) (state.Outcome, error) {
+	ctx, cancel := context.WithTimeout(util.WithLogger(context.Background(), r.log), rollbackTimeout)
+	defer cancel()
+
+	secret := r.secret
+	errs := multierror.Append(nil, errors.Wrapf(cause, "%s: rotation failed, rolling back", secret.Name))
+	r.log.Warnf("%s: rotation failed, rolling back", secret.Name)
+
+	// restore records its own errors so that every sink is restored,
+	// not only those up to the first failure
+	var unrestored []*write
+	var mu sync.Mutex // guards errs and unrestored
+	restore := func(ctx context.Context, w *write) error {
+		if !w.attempted {
+			return nil
+		}
+		if !w.restorable {
+			// a failed write is assumed not to have changed the sink
+			if w.landed {
+				mu.Lock()
+				unrestored = append(unrestored, w)
+				mu.Unlock()
+			}
+			return nil
+		}
+		if w.prev == nil {
+			err := w.sink.(sink.Deleter).Delete(ctx, w.name)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				unrestored = append(unrestored, w)
+				errs = multierror.Append(errs, errors.Wrapf(err, "%s: unable to delete %s from %s sink", secret.Name, w.name, w.sink.Kind()))
+				return nil
+			}
+			r.log.Infof("%s: deleted %s from %s sink", secret.Name, w.name, w.sink.Kind())
+			return nil
+		}
+		err := w.sink.Write(ctx, w.name, w.prev.Reveal())
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			unrestored = append(unrestored, w)
+			errs = multierror.Append(errs, errors.Wrapf(err, "%s: unable to restore %s in %s sink", secret.Name, w.name, w.sink.Kind()))
+			return nil
+		}
+		r.log.Infof("%s: restored %s in %s sink", secret.Name, w.name, w.sink.Kind())
+		return nil
+	}
+	err := r.forEachSink(ctx, writes, restore)
+	if err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	src := secret.Source
+	if len(unrestored) > 0 {
+		r.log.Warnf("%s: %d sink value(s) could not be restored, leaving the new credentials active at %s", secret.Name, len(unrestored), src.Kind())
+		return state.OutcomeFailed, errs.ErrorOrNil()
+	}
+	err = src.Revoke(ctx)
+	if err != nil {
+		return state.OutcomeFailed, multierror.Append(errs, errors.Wrapf(err, "%s: unable to revoke new credentials at %s", secret.Name, src.Kind()))
+	}
+	r.log.Infof("%s: revoked new credentials at %s source", secret.Name, src.Kind())
+	return state.OutcomeRolledBack, errs.ErrorOrNil()
+}
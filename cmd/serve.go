@@ -0,0 +1,373 @@
+package cmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/redact"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/getsentry/sentry-go"
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultReloadInterval = 30 * time.Second
+	defaultDrainTimeout   = 5 * time.Minute
+)
+
+func init() {
+	serveCmd.Flags().StringP("file", "f", "", "Config file to read from")
+	serveCmd.Flags().Duration("reload-interval", defaultReloadInterval, "How often to check the config file for changes. 0 means the config is only reloaded on SIGHUP.")
+	serveCmd.Flags().Duration("drain-timeout", defaultDrainTimeout, "How long to let in-flight rotations finish on SIGINT or SIGTERM before cancelling and rolling them back.")
+	serveCmd.Flags().Bool("rotate-on-start", false, "Rotate every secret once at start, then on its schedule.")
+	serveCmd.Flags().Duration("timeout", 0, "Maximum duration of each scheduled run, overrides the timeout set in the config file. 0 means no timeout.")
+	serveCmd.Flags().Duration("secret-timeout", 0, "Maximum duration of the rotation of each secret, overrides the secret_timeout set in the config file. 0 means no timeout.")
+	serveCmd.Flags().Int("concurrency", 0, "Number of secrets rotated at the same time, overrides concurrency.secrets in the config file.")
+	serveCmd.Flags().Int("sink-concurrency", 0, "Number of sinks of a secret written to at the same time, overrides concurrency.sinks_per_secret in the config file.")
+	serveCmd.Flags().StringToInt("sink-kind-concurrency", nil, "Number of sinks of a kind written to at the same time across all secrets, e.g. TravisCI=2. Overrides concurrency.sink_kinds in the config file.")
+	serveCmd.Flags().Bool("dry-run", false, "Go through scheduled rotations without changing anything.")
+	rootCmd.AddCommand(serveCmd)
+}
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Rotate secrets on their schedules",
+	Long: `serve runs until it is stopped, rotating each secret of a config
+			file on its schedule. Changes to the config file are picked up
+			without a restart. On SIGINT or SIGTERM, serve stops scheduling
+			rotations and waits for the ones in flight to finish`,
+	SilenceErrors: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		flags := cmd.Flags()
+		file, err := flags.GetString("file")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse config flag")
+		}
+		reloadInterval, err := flags.GetDuration("reload-interval")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse reload-interval flag")
+		}
+		drainTimeout, err := flags.GetDuration("drain-timeout")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse drain-timeout flag")
+		}
+		rotateOnStart, err := flags.GetBool("rotate-on-start")
+		if err != nil {
+			return errors.Wrap(err, "unable to parse rotate-on-start flag")
+		}
+
+		d := newDaemon(file, func(conf *config.Config) error {
+			return applyRunFlags(cmd, conf)
+		})
+		defer d.close()
+		_, err = d.reload()
+		if err != nil {
+			return errors.Wrap(err, "unable to read config from file")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		stop := make(chan os.Signal, 1)
+		signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+		defer signal.Stop(stop)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			select {
+			case sig := <-stop:
+				logrus.Warnf("received %s, draining", sig)
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		if rotateOnStart {
+			d.rotateAll()
+		}
+		d.serve(ctx, hup, reloadInterval, drainTimeout)
+		return nil
+	},
+}
+
+// daemon rotates the secrets of a config file on their schedules, as
+// rotator serve. Changes to the file are picked up by reload.
+type daemon struct {
+	file       string
+	applyFlags func(*config.Config) error
+	cron       *cron.Cron
+
+	// runCtx is the context of rotations. Stopping the daemon does not
+	// cancel it, so that rotations in flight can finish. It is only
+	// cancelled once draining times out.
+	runCtx    context.Context
+	cancelRun context.CancelFunc
+	inFlight  sync.WaitGroup
+
+	mu       sync.Mutex // guards the fields below
+	conf     *config.Config
+	digest   [sha256.Size]byte
+	// rejected is the digest of the last config that failed to load,
+	// so that it is only reported once.
+	rejected [sha256.Size]byte
+	store    state.Store
+	limits   *limits
+	entries  []cron.EntryID
+	running  map[string]bool
+	draining bool
+}
+
+// newDaemon returns a daemon for the config in file. applyFlags is
+// called on every config read from file, to override its settings with
+// the command line.
+func newDaemon(file string, applyFlags func(*config.Config) error) *daemon {
+	runCtx, cancelRun := context.WithCancel(context.Background())
+	return &daemon{
+		file:       file,
+		applyFlags: applyFlags,
+		cron:       cron.New(),
+		runCtx:     runCtx,
+		cancelRun:  cancelRun,
+		running:    map[string]bool{},
+	}
+}
+
+// reload reads the config file and, if it changed since it was last
+// read, schedules the secrets of the new config in place of those of
+// the old one. If the new config is invalid, the old one is kept.
+// Rotations in flight finish with the config they started with.
+//
+// The state store is opened with the first config. Later changes to
+// the state config only take effect after a restart.
+//
+// reload reports whether the config changed. A config that failed to
+// load is not reported again until the file changes.
+func (d *daemon) reload() (bool, error) {
+	b, err := ioutil.ReadFile(d.file)
+	if err != nil {
+		return false, errors.Wrapf(err, "Could not read config %s", d.file)
+	}
+	digest := sha256.Sum256(b)
+	d.mu.Lock()
+	unchanged := d.conf != nil && (digest == d.digest || digest == d.rejected)
+	d.mu.Unlock()
+	if unchanged {
+		return false, nil
+	}
+
+	conf, schedules, err := d.load(b)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err != nil {
+		d.rejected = digest
+		return false, err
+	}
+	if d.conf == nil {
+		d.store, err = openStore(conf)
+		if err != nil {
+			return false, err
+		}
+	} else if !reflect.DeepEqual(d.conf.State, conf.State) {
+		logrus.Warn("the state config changed, restart rotator serve to use the new state store")
+	}
+
+	for _, id := range d.entries {
+		d.cron.Remove(id)
+	}
+	d.entries = nil
+	limits := newLimits(conf.Concurrency)
+	for i, secret := range conf.Secrets {
+		secret := secret
+		id := d.cron.Schedule(schedules[i], cron.FuncJob(func() {
+			d.rotate(secret, conf, limits)
+		}))
+		d.entries = append(d.entries, id)
+		logrus.Infof("%s: scheduled %s", secret.Name, conf.ScheduleFor(secret))
+	}
+	d.conf, d.digest, d.limits = conf, digest, limits
+	return true, nil
+}
+
+// load parses and initializes the config in b and returns it along
+// with the schedule of each of its secrets.
+func (d *daemon) load(b []byte) (*config.Config, []cron.Schedule, error) {
+	conf, err := config.Load(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = conf.Init()
+	if err != nil {
+		return nil, nil, err
+	}
+	err = d.applyFlags(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	schedules := make([]cron.Schedule, len(conf.Secrets))
+	for i, secret := range conf.Secrets {
+		schedules[i], err = config.ParseSchedule(conf.ScheduleFor(secret))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "%s", secret.Name)
+		}
+	}
+	return conf, schedules, nil
+}
+
+// reloadOrKeep reloads the config file, logging whether it changed.
+func (d *daemon) reloadOrKeep() {
+	changed, err := d.reload()
+	if err != nil {
+		err = errors.Wrap(err, "unable to reload config, keeping the previous one")
+		logrus.Error(err)
+		sentry.CaptureException(err)
+		return
+	}
+	if changed {
+		logrus.Infof("reloaded config %s", d.file)
+	}
+}
+
+// rotate rotates secret with the settings of conf, unless a rotation
+// of the secret is still in flight or the daemon is draining.
+func (d *daemon) rotate(secret config.Secret, conf *config.Config, limits *limits) {
+	if !d.begin(secret.Name) {
+		return
+	}
+	defer d.end(secret.Name)
+	d.run(secret, conf, limits)
+}
+
+// rotateAll starts a rotation of every secret of the current config.
+func (d *daemon) rotateAll() {
+	d.mu.Lock()
+	conf, limits := d.conf, d.limits
+	d.mu.Unlock()
+	for _, secret := range conf.Secrets {
+		if !d.begin(secret.Name) {
+			continue
+		}
+		go func(secret config.Secret) {
+			defer d.end(secret.Name)
+			d.run(secret, conf, limits)
+		}(secret)
+	}
+}
+
+// begin marks a rotation of the named secret as in flight. It returns
+// false if one already is or the daemon is draining.
+func (d *daemon) begin(name string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return false
+	}
+	if d.running[name] {
+		logrus.Warnf("%s: skipping scheduled rotation, the previous one is still running", name)
+		return false
+	}
+	d.running[name] = true
+	d.inFlight.Add(1)
+	return true
+}
+
+// end marks the rotation of the named secret started by begin as done.
+func (d *daemon) end(name string) {
+	d.mu.Lock()
+	delete(d.running, name)
+	d.mu.Unlock()
+	d.inFlight.Done()
+}
+
+func (d *daemon) run(secret config.Secret, conf *config.Config, limits *limits) {
+	d.mu.Lock()
+	store := d.store
+	d.mu.Unlock()
+
+	// values seen by the run are only scrubbed until its errors are
+	// reported, so that a long-running daemon does not accumulate them
+	scope := redact.NewScope()
+	defer scope.Release()
+	ctx := redact.WithScope(d.runCtx, scope)
+
+	run := *conf
+	run.Secrets = []config.Secret{secret}
+	err := rotateWithLimits(ctx, &run, store, limits)
+	if err != nil {
+		logrus.Error(err)
+		sentry.CaptureException(err)
+	}
+}
+
+// serve runs scheduled rotations until ctx is done, reloading the
+// config every reloadInterval and on every signal received from hup.
+// It then drains, waiting up to drainTimeout for rotations in flight.
+func (d *daemon) serve(ctx context.Context, hup <-chan os.Signal, reloadInterval, drainTimeout time.Duration) {
+	d.cron.Start()
+	var tick <-chan time.Time
+	if reloadInterval > 0 {
+		ticker := time.NewTicker(reloadInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			d.drain(drainTimeout)
+			return
+		case <-tick:
+			d.reloadOrKeep()
+		case <-hup:
+			d.reloadOrKeep()
+		}
+	}
+}
+
+// drain stops scheduling rotations and waits for those in flight. After
+// timeout, they are cancelled, which rolls them back, and drain waits
+// for the rollbacks to finish.
+func (d *daemon) drain(timeout time.Duration) {
+	d.cron.Stop()
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		logrus.Warnf("rotations still running after %s, cancelling them", timeout)
+		d.cancelRun()
+		<-done
+	}
+	logrus.Info("drained, stopping")
+}
+
+// close releases the state store. It must only be called once no
+// rotation is in flight.
+func (d *daemon) close() {
+	d.cancelRun()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.store != nil {
+		err := d.store.Close()
+		if err != nil {
+			logrus.Warn(errors.Wrap(err, "unable to close state store"))
+		}
+		d.store = nil
+	}
+}
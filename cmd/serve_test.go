@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/stretchr/testify/require"
+)
+
+const serveTestConfig = `
+version: 2
+schedule: 6h
+state:
+  backend: bolt
+  path: %s
+secrets:
+`
+
+const serveTestHourly = `
+  - name: hourly
+    schedule: "0 * * * *"
+    source:
+      kind: dummy
+    sinks:
+      - kind: Buffer
+        key_to_name:
+          secret: SECRET
+`
+
+const serveTestDefault = `
+  - name: default
+    source:
+      kind: dummy
+    sinks:
+      - kind: Buffer
+        key_to_name:
+          secret: SECRET
+`
+
+func writeServeConfig(r *require.Assertions, dir string, content string) string {
+	file := filepath.Join(dir, "config.yaml")
+	r.NoError(ioutil.WriteFile(file, []byte(content), 0644))
+	return file
+}
+
+func TestDaemonReload(t *testing.T) {
+	r := require.New(t)
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	conf := fmt.Sprintf(serveTestConfig, filepath.Join(dir, "state.db")) + serveTestHourly
+	file := writeServeConfig(r, dir, conf+serveTestDefault)
+
+	d := newDaemon(file, func(*config.Config) error { return nil })
+	defer d.close()
+	changed, err := d.reload()
+	r.NoError(err)
+	r.True(changed)
+	r.Len(d.cron.Entries(), 2)
+	r.Equal("0 * * * *", d.conf.ScheduleFor(d.conf.Secrets[0]))
+	r.Equal("6h", d.conf.ScheduleFor(d.conf.Secrets[1]))
+
+	// unchanged files are not reloaded
+	changed, err = d.reload()
+	r.NoError(err)
+	r.False(changed)
+
+	// invalid configs are not loaded
+	writeServeConfig(r, dir, conf+serveTestDefault+"    unknown: field\n")
+	_, err = d.reload()
+	r.Error(err)
+	r.Len(d.cron.Entries(), 2)
+	r.Len(d.conf.Secrets, 2)
+	changed, err = d.reload()
+	r.NoError(err)
+	r.False(changed)
+
+	// removed secrets are unscheduled
+	writeServeConfig(r, dir, conf)
+	changed, err = d.reload()
+	r.NoError(err)
+	r.True(changed)
+	r.Len(d.cron.Entries(), 1)
+	r.Len(d.conf.Secrets, 1)
+}
+
+func TestDaemonRotateAndDrain(t *testing.T) {
+	r := require.New(t)
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	file := writeServeConfig(r, dir, fmt.Sprintf(serveTestConfig, filepath.Join(dir, "state.db"))+serveTestHourly+serveTestDefault)
+
+	d := newDaemon(file, func(*config.Config) error { return nil })
+	defer d.close()
+	_, err = d.reload()
+	r.NoError(err)
+
+	// a secret whose previous rotation is still running is skipped
+	d.running["default"] = true
+	d.rotateAll()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		d.serve(ctx, nil, 0, time.Minute)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		r.FailNow("serve did not drain")
+	}
+
+	rec, err := d.store.Get(context.Background(), "hourly")
+	r.NoError(err)
+	r.NotNil(rec)
+	r.Equal(state.OutcomeRotated, rec.Outcome)
+	rec, err = d.store.Get(context.Background(), "default")
+	r.NoError(err)
+	r.Nil(rec)
+}
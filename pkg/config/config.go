@@ -1,347 +1,316 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
-	"net/http"
-	"os"
+	"strings"
+	"text/template"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
-	"github.com/aws/aws-sdk-go/aws/session"
-	cziAws "github.com/chanzuckerberg/go-misc/aws"
 	"github.com/chanzuckerberg/rotator/pkg/sink"
 	"github.com/chanzuckerberg/rotator/pkg/source"
-	"github.com/davecgh/go-spew/spew"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/chanzuckerberg/rotator/pkg/util"
 	"github.com/hashicorp/go-multierror"
-	heroku "github.com/heroku/heroku-go/v5"
-	"github.com/jszwedko/go-circleci"
-	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
-	"github.com/shuheiktgw/go-travis"
-	"github.com/sirupsen/logrus"
-	"gopkg.in/yaml.v2"
+	"github.com/robfig/cron/v3"
+	"gopkg.in/yaml.v3"
 )
 
-const (
-	envCircleCIAuthToken      = "CIRCLECI_AUTH_TOKEN"
-	envTravisCIAuthToken      = "TRAVIS_API_AUTH_TOKEN"
-	envGitHubActionsAuthToken = "GITHUB_ACTIONS_AUTH_TOKEN"
-)
+// CurrentVersion is the version of the config format. Files of older
+// versions are upgraded when loaded, and configs are always written in
+// the current version.
+const CurrentVersion = 2
 
 type Config struct {
-	Version int      `yaml:"version"`
-	Secrets []Secret `yaml:"secrets"`
+	Version int `yaml:"version"`
+	// Timeout bounds a whole rotation run. Zero means no timeout.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// SecretTimeout bounds the rotation of each secret, unless the
+	// secret sets its own timeout. Zero means no timeout.
+	SecretTimeout time.Duration `yaml:"secret_timeout,omitempty"`
+	Concurrency   Concurrency   `yaml:"concurrency,omitempty"`
+	// State configures where rotator records past rotations. If unset,
+	// nothing is recorded and only sources that track the age of their
+	// credentials honor max_age.
+	State *state.Config `yaml:"state,omitempty"`
+	// Schedule is when rotator serve rotates secrets that do not set
+	// their own schedule. If unset, DefaultSchedule is used.
+	Schedule string   `yaml:"schedule,omitempty"`
+	Secrets  []Secret `yaml:"secrets"`
+	// DryRun goes through rotations without changing anything. It is
+	// set from the command line, never from the config file.
+	DryRun bool `yaml:"-"`
+}
+
+// Concurrency limits how much of a rotation run happens at the same time.
+// Zero values mean one at a time.
+type Concurrency struct {
+	// Secrets is the number of secrets rotated at the same time.
+	Secrets int `yaml:"secrets,omitempty"`
+	// SinksPerSecret is the number of sinks of a single secret
+	// written to at the same time.
+	SinksPerSecret int `yaml:"sinks_per_secret,omitempty"`
+	// SinkKinds caps the number of sinks of a kind written to at the
+	// same time across all secrets, e.g. to stay within API rate limits.
+	SinkKinds map[sink.Kind]int `yaml:"sink_kinds,omitempty"`
 }
 
 type Secret struct {
 	Name   string        `yaml:"name"`
 	Source source.Source `yaml:"source"`
 	Sinks  sink.Sinks    `yaml:"sinks"`
+	// Timeout overrides Config.SecretTimeout for this secret.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// MaxAge is the minimum time between rotations of the secret. It is
+	// passed on to sources that track the age of their credentials, and
+	// when a state store is configured, the secret is not rotated again
+	// until MaxAge has passed since its last rotation.
+	MaxAge time.Duration `yaml:"max_age,omitempty"`
+	// Schedule overrides Config.Schedule for this secret.
+	Schedule string `yaml:"schedule,omitempty"`
+	// Force rotates the secret even if it is not due. It is set from
+	// the command line, never from the config file.
+	Force bool `yaml:"-"`
+	// NameVars are the data the names in the key_to_name of the sinks
+	// are rendered with, if set. It is set by Discover for each
+	// credential found, never from the config file.
+	NameVars interface{} `yaml:"-"`
 }
 
-type HerokuEnv struct {
-	Bearer_Token string
-}
-
-func loadHerokuEnv() (*HerokuEnv, error) {
-	env := &HerokuEnv{}
-	err := envconfig.Process("heroku", env)
-	return env, errors.Wrap(err, "Unable to load all the heroku environment variables")
-}
-
-// parseIface converts an interface to the type map[string]string.
-// It also returns a second map[string]string if a "key_to_name" entry
-// exists, or nil otherwise.
-// It returns any error encountered as a result of iface not being of
-// the correct type.
-func parseIface(iface interface{}) (mapStr map[string]string, keyToName map[string]string, err error) {
-	// first convert to map[interface{}]interface{}
-	mapIface, ok := iface.(map[interface{}]interface{})
-	if !ok {
-		return nil, nil, errors.New("interface is not a map")
-	}
-
-	// then convert to map[string]string
-	mapStr = make(map[string]string)
-	for k, v := range mapIface {
-		strK, ok := k.(string)
-		if !ok {
-			return nil, nil, errors.New("key is not a string")
-		}
-		switch strK {
-		case "key_to_name":
-			var m map[string]string
-			var err error
-			keyToName, m, err = parseIface(v)
-			if err != nil {
-				return nil, nil, errors.Wrap(err, "incorrect key_to_name format")
-			}
-			if m != nil {
-				return nil, nil, errors.New("incorrect key_to_name format")
-			}
-		default:
-			strV, ok := v.(string)
-			if !ok {
-				return nil, nil, errors.New("value is not a string")
-			}
-			mapStr[strK] = strV
-		}
+// Discover returns the secrets secret stands for: one for each
+// credential found if its source is a source.Discoverer configured to
+// discover credentials, or else secret itself. Discovered secrets are
+// named after the secret and the credential, e.g. ci-keys/ci-user.
+func (secret Secret) Discover(ctx context.Context) ([]Secret, error) {
+	d, ok := secret.Source.(source.Discoverer)
+	if !ok || !d.Discovers() {
+		return []Secret{secret}, nil
 	}
-	return mapStr, keyToName, nil
-}
-
-// unmarshalSource converts an interface to a type that implements
-// the source.Source interface.
-func unmarshalSource(srcIface interface{}) (source.Source, error) {
-	// convert srcIface to the type map[string]string
-	srcMapStr, _, err := parseIface(srcIface)
+	found, err := d.Discover(ctx)
 	if err != nil {
-		return nil, errors.Wrap(err, "incorrect source format in secret config")
+		return nil, errors.Wrapf(err, "%s: unable to discover credentials at %s source", secret.Name, secret.Source.Kind())
 	}
-
-	// determine source kind
-	srcKind, ok := srcMapStr["kind"]
-	if !ok {
-		return nil, errors.New("missing kind in source config")
+	secrets := make([]Secret, 0, len(found))
+	for _, f := range found {
+		s := secret
+		s.Name = fmt.Sprintf("%s/%s", secret.Name, f.Name)
+		s.Source = f.Source
+		s.NameVars = f.Vars
+		secrets = append(secrets, s)
 	}
+	return secrets, nil
+}
 
-	var src source.Source
-	switch source.Kind(srcKind) {
-	case source.KindDummy:
-		src = &source.DummySource{}
-	case source.KindAws:
-		if err = validate(srcMapStr, "role_arn", "max_age"); err != nil {
-			return nil, errors.Wrap(err, "missing keys in aws iam source config")
-		}
-
-		// set up AWS session and IAM service client
-		sess, err := session.NewSession(&aws.Config{})
+// KeyToName returns the key_to_name of sink s of the secret, with each
+// name rendered as a template with NameVars if set.
+func (secret Secret) KeyToName(s sink.Sink) (map[string]string, error) {
+	keyToName := s.GetKeyToName()
+	if secret.NameVars == nil || keyToName == nil {
+		return keyToName, nil
+	}
+	rendered := make(map[string]string, len(keyToName))
+	for k, name := range keyToName {
+		t, err := parseName(name)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
+			return nil, errors.Wrapf(err, "%s: invalid name of %s in %s sink", secret.Name, k, s.Kind())
 		}
-		// create a Credentials object that wraps the AssumeRoleProvider, passing along the external ID if set
-		sess.Config.Credentials = stscreds.NewCredentials(sess, srcMapStr["role_arn"], func(p *stscreds.AssumeRoleProvider) {
-			if externalID, ok := srcMapStr["external_id"]; ok && externalID != "" {
-				p.ExternalID = &externalID
-			}
-		})
-		client := cziAws.New(sess).WithIAM(sess.Config)
-
-		// parse max age
-		maxAge, err := time.ParseDuration(srcMapStr["max_age"])
+		var b strings.Builder
+		err = t.Execute(&b, secret.NameVars)
 		if err != nil {
-			return nil, errors.Wrap(err, "incorrect max_age format in aws iam source config")
-		}
-		src = source.NewAwsIamSource().WithUserName(srcMapStr["username"]).WithAwsClient(client).WithMaxAge(maxAge)
-	case source.KindEnv:
-		if err = validate(srcMapStr, "name"); err != nil {
-			return nil, errors.Wrap(err, "missing keys in env source config")
+			return nil, errors.Wrapf(err, "%s: unable to render name of %s in %s sink", secret.Name, k, s.Kind())
 		}
-		src = source.NewEnvSource().WithName(srcMapStr["name"])
-	default:
-		return nil, source.ErrUnknownKind
+		rendered[k] = b.String()
 	}
-	return src, nil
+	return rendered, nil
 }
 
-// unmarshalSource converts an interface to the type sink.Sinks.
-func unmarshalSinks(sinksIface interface{}) (sink.Sinks, error) {
-	is, ok := sinksIface.([]interface{})
-	if !ok {
-		return nil, errors.New("incorrect sinks format in secret config")
-	}
-	var sinks sink.Sinks
-
-	for _, i := range is {
-		// convert each interface to the type map[string]string and retrieve keyToName mapping
-		sinkMapStr, keyToName, err := parseIface(i)
-		if err != nil {
-			return nil, errors.Wrap(err, "incorrect sink format in secret config")
-		}
-		if keyToName == nil {
-			return nil, errors.New("missing key_to_name in sink config")
-		}
+// parseName parses a name in key_to_name as a template. Missing keys,
+// e.g. a tag a discovered user does not have, are errors rather than
+// rendering as "<no value>".
+func parseName(name string) (*template.Template, error) {
+	return template.New("name").Option("missingkey=error").Parse(name)
+}
 
-		// determine sink kind
-		sinkKind, ok := sinkMapStr["kind"]
-		if !ok {
-			return nil, errors.New("missing kind in sink config")
+// DefaultSchedule is the schedule of secrets in rotator serve when
+// neither the secret nor the config set one.
+const DefaultSchedule = "@hourly"
+
+// ParseSchedule parses the schedule of a secret: either a duration,
+// e.g. "6h", to rotate at that interval, or a cron expression with five
+// fields or a descriptor such as "@daily", as accepted by
+// cron.ParseStandard.
+func ParseSchedule(spec string) (cron.Schedule, error) {
+	if d, err := time.ParseDuration(spec); err == nil {
+		if d < time.Second {
+			return nil, errors.Errorf("schedule interval %s is shorter than a second", d)
 		}
-		switch sink.Kind(sinkKind) {
-		case sink.KindBuf:
-			sinks = append(sinks, sink.NewBufSink().WithKeyToName(keyToName))
-		case sink.KindTravisCi:
-			if err = validate(sinkMapStr, "repo_slug"); err != nil {
-				return nil, errors.Wrap(err, "missing keys in travis CI sink config")
-			}
-
-			// set up Travis CI API client
-			travisToken, present := os.LookupEnv(envTravisCIAuthToken)
-			if !present {
-				return nil, errors.Errorf("missing env var: %s", envTravisCIAuthToken)
-			}
-			client := travis.NewClient(sink.TravisBaseURL, travisToken)
-			sinks = append(sinks, &sink.TravisCiSink{BaseSink: sink.BaseSink{KeyToName: keyToName}, RepoSlug: sinkMapStr["repo_slug"], Client: client})
-
-		case sink.KindCircleCi:
-			if err = validate(sinkMapStr, "account", "repo"); err != nil {
-				return nil, errors.Wrap(err, "missing keys in circle CI sink config")
-			}
-			circleToken, present := os.LookupEnv(envCircleCIAuthToken)
-			if !present {
-				return nil, errors.Errorf("missing env var: %s", envCircleCIAuthToken)
-			}
-			client := &circleci.Client{Token: circleToken}
-			sink := &sink.CircleCiSink{BaseSink: sink.BaseSink{KeyToName: keyToName}}
-			sink.WithCircleClient(client, sinkMapStr["account"], sinkMapStr["repo"])
-			sinks = append(sinks, sink)
-
-		case sink.KindGithubActionsSecret:
-			if err = validate(sinkMapStr, "owner", "repo"); err != nil {
-				return nil, errors.Wrapf(err, "missing keys in %s sink", sink.KindGithubActionsSecret)
-			}
-
-			githubToken, present := os.LookupEnv(envGitHubActionsAuthToken)
-			if !present {
-				return nil, errors.Errorf("missing env var: %s", envGitHubActionsAuthToken)
-			}
-
-			sink := &sink.GitHubActionsSecretSink{
-				BaseSink: sink.BaseSink{KeyToName: keyToName},
-			}
-			sink = sink.WithStaticTokenAuthClient(githubToken, sinkMapStr["owner"], sinkMapStr["repo"])
-
-			sinks = append(sinks, sink)
-
-		case sink.KindAwsParamStore:
-			if err = validate(sinkMapStr, "role_arn", "region"); err != nil {
-				return nil, errors.Wrap(err, "missing keys in aws parameter store sink config")
-			}
+		return cron.Every(d), nil
+	}
+	schedule, err := cron.ParseStandard(spec)
+	return schedule, errors.Wrapf(err, "invalid schedule %q", spec)
+}
 
-			// create an AWS SSM client from a session
-			sess, err := session.NewSession(&aws.Config{
-				Region: aws.String(sinkMapStr["region"]), // SSM functions require region configuration
-			})
-			if err != nil {
-				return nil, errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
-			}
-			sess.Config.Credentials = stscreds.NewCredentials(sess, sinkMapStr["role_arn"], func(p *stscreds.AssumeRoleProvider) {
-				if externalID, ok := sinkMapStr["external_id"]; ok && externalID != "" {
-					p.ExternalID = &externalID
-				}
-			})
-			client := cziAws.New(sess).WithIAM(sess.Config)
+// ScheduleFor returns the schedule that applies to secret in rotator serve.
+func (c *Config) ScheduleFor(secret Secret) string {
+	if secret.Schedule != "" {
+		return secret.Schedule
+	}
+	if c.Schedule != "" {
+		return c.Schedule
+	}
+	return DefaultSchedule
+}
 
-			sinks = append(sinks, &sink.AwsParamSink{BaseSink: sink.BaseSink{KeyToName: keyToName}, Client: client})
-		case sink.KindAwsSecretsManager:
-			if err = validate(sinkMapStr, "role_arn", "region"); err != nil {
-				return nil, errors.Wrap(err, "missing keys in aws secrets manager sink config")
-			}
+// TimeoutFor returns the timeout that applies to the rotation of secret.
+func (c *Config) TimeoutFor(secret Secret) time.Duration {
+	if secret.Timeout != 0 {
+		return secret.Timeout
+	}
+	return c.SecretTimeout
+}
 
-			// create an AWS Secrets Manager client from a session
-			sess, err := session.NewSession(&aws.Config{
-				Region: aws.String(sinkMapStr["region"]), // Secrets Manager functions require region configuration
-			})
+// Init sets up the clients and credentials of every source and sink in
+// c. Decoding a config only validates it, so that it can be checked
+// without credentials.
+func (c *Config) Init() error {
+	var errs *multierror.Error
+	for _, secret := range c.Secrets {
+		if i, ok := secret.Source.(source.Initializer); ok {
+			err := i.Init()
 			if err != nil {
-				return nil, errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
+				errs = multierror.Append(errs, errors.Wrapf(err, "%s: unable to set up %s source", secret.Name, secret.Source.Kind()))
 			}
-			sess.Config.Credentials = stscreds.NewCredentials(sess, sinkMapStr["role_arn"], func(p *stscreds.AssumeRoleProvider) {
-				if externalID, ok := sinkMapStr["external_id"]; ok && externalID != "" {
-					p.ExternalID = &externalID
+		}
+		for _, s := range secret.Sinks {
+			if i, ok := s.(sink.Initializer); ok {
+				err := i.Init()
+				if err != nil {
+					errs = multierror.Append(errs, errors.Wrapf(err, "%s: unable to set up %s sink", secret.Name, s.Kind()))
 				}
-			})
-			client := cziAws.New(sess).WithSecretsManager(sess.Config)
-
-			sinks = append(sinks, &sink.AwsSecretsManagerSink{BaseSink: sink.BaseSink{KeyToName: keyToName}, Client: client})
-		case sink.KindStdout:
-			sinks = append(sinks, &sink.StdoutSink{BaseSink: sink.BaseSink{KeyToName: keyToName}})
-		case sink.KindHeroku:
-			herokuEnv, err := loadHerokuEnv()
-			if err != nil {
-				return nil, errors.Wrap(err, "Error loading Heroku Environment Variables")
-			}
-			if err = validate(sinkMapStr, "AppIdentity"); err != nil {
-				return nil, errors.Wrap(err, "missing AppIdentity in Heroku sink config")
-			}
-
-			// Set up Heroku service
-			headers := http.Header{}
-			headers.Set("Accept", "application/vnd.heroku+json; version=3")
-			transport := heroku.Transport{
-				BearerToken:       herokuEnv.Bearer_Token,
-				AdditionalHeaders: headers,
-			}
-			heroku.DefaultClient.Transport = &transport
-			herokuService := heroku.NewService(heroku.DefaultClient)
-
-			// Set up herokuSink
-			herokuSink := sink.HerokuSink{
-				BaseSink:    sink.BaseSink{KeyToName: keyToName},
-				AppIdentity: sinkMapStr["AppIdentity"],
 			}
-			herokuSink.WithKeyToName(keyToName)
-			herokuSink.WithHerokuClient(herokuService)
-
-			// Add heroku sink to sinks
-			sinks = append(sinks, &herokuSink)
-		default:
-			return nil, fmt.Errorf("unknown sink kind: %s", sinkKind)
 		}
 	}
-	return sinks, nil
+	return errs.ErrorOrNil()
 }
 
-// validate returns an error if any key is not present in m
-func validate(m map[string]string, keys ...string) error {
+// Validate checks the parts of c that are not checked while decoding
+// its secrets: the version, that secret names are unique and the state
+// config. Like decoding, it does not reach out to the network or read
+// credentials.
+func (c *Config) Validate() error {
 	var errs *multierror.Error
-	for _, k := range keys {
-		if _, ok := m[k]; !ok {
-			errs = multierror.Append(errs, errors.New(fmt.Sprintf("missing %s", k)))
+	if c.Version != CurrentVersion {
+		errs = multierror.Append(errs, errors.Errorf("unsupported config version %d, expected %d", c.Version, CurrentVersion))
+	}
+	names := map[string]bool{}
+	for _, secret := range c.Secrets {
+		if names[secret.Name] {
+			errs = multierror.Append(errs, errors.Errorf("duplicate secret name %s", secret.Name))
+		}
+		names[secret.Name] = true
+	}
+	if c.Schedule != "" {
+		_, err := ParseSchedule(c.Schedule)
+		if err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if c.State != nil {
+		err := c.State.Validate()
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrap(err, "invalid state config"))
 		}
 	}
-	return errors.Wrapf(errs.ErrorOrNil(), "config %#v missing keys", m)
+	return errs.ErrorOrNil()
 }
 
-func (secret *Secret) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	var secretFields map[string]interface{}
-	if err := unmarshal(&secretFields); err != nil {
-		return errors.Wrap(err, "incorrect secret config")
+// UnmarshalYAML decodes a secret in the current config format. Every
+// problem found, including in the source and sink configs, is returned
+// in a single *yaml.TypeError so that all of them are reported at once.
+func (secret *Secret) UnmarshalYAML(node *yaml.Node) error {
+	var fields struct {
+		Name     string        `yaml:"name"`
+		MaxAge   time.Duration `yaml:"max_age"`
+		Schedule string        `yaml:"schedule"`
+		Timeout  time.Duration `yaml:"timeout"`
+		Source   yaml.Node     `yaml:"source"`
+		Sinks    []yaml.Node   `yaml:"sinks"`
 	}
-	logrus.Debug(secretFields)
-
-	// unmarshal secret.Name
-	name, ok := secretFields["name"]
-	if !ok {
-		return errors.New("missing name in secret config")
+	err := util.DecodeStrict(node, &fields)
+	if _, ok := err.(*yaml.TypeError); err != nil && !ok {
+		return err
+	}
+	msgs := util.ErrorMessages(err)
+	secret.Name = fields.Name
+	secret.MaxAge = fields.MaxAge
+	secret.Schedule = fields.Schedule
+	secret.Timeout = fields.Timeout
+
+	if secret.Name == "" {
+		msgs = append(msgs, fmt.Sprintf("line %d: missing name in secret config", node.Line))
 	}
-	secret.Name, ok = name.(string)
-	if !ok {
-		return errors.New("incorrect name format in secret config")
+	if fields.MaxAge < 0 {
+		msgs = append(msgs, fmt.Sprintf("line %d: negative max_age in secret %s", node.Line, secret.Name))
+	}
+	if secret.Schedule != "" {
+		if _, err := ParseSchedule(secret.Schedule); err != nil {
+			msgs = append(msgs, fmt.Sprintf("line %d: %s in secret %s", node.Line, err, secret.Name))
+		}
 	}
 
 	// unmarshal secret.Source
-	srcIface, ok := secretFields["source"]
-	if !ok {
-		return errors.New("missing source in secret config")
-	}
-	src, err := unmarshalSource(srcIface)
-	if err != nil {
-		return errors.Wrap(err, "unable to unmarshal source")
+	if fields.Source.Kind == 0 {
+		msgs = append(msgs, fmt.Sprintf("line %d: missing source in secret %s", node.Line, secret.Name))
+	} else {
+		src, err := source.Decode(&fields.Source)
+		msgs = append(msgs, util.ErrorMessages(err)...)
+		if err == nil {
+			if a, ok := src.(source.Ager); ok {
+				a.SetMaxAge(secret.MaxAge)
+			}
+			if v, ok := src.(source.Validator); ok {
+				for _, msg := range util.ErrorMessages(v.Validate()) {
+					msgs = append(msgs, fmt.Sprintf("line %d: invalid %s source config: %s", fields.Source.Line, src.Kind(), msg))
+				}
+			}
+			secret.Source = src
+		}
 	}
-	secret.Source = src
 
-	// unmarshall secret.Sinks
-	sinksIface, ok := secretFields["sinks"]
-	if !ok {
-		return errors.New("missing sinks in secret config")
+	// unmarshal secret.Sinks
+	if fields.Sinks == nil {
+		msgs = append(msgs, fmt.Sprintf("line %d: missing sinks in secret %s", node.Line, secret.Name))
 	}
-	sinks, err := unmarshalSinks(sinksIface)
-	if err != nil {
-		return errors.Wrap(err, "unable to unmarshal sinks")
+	secret.Sinks = nil
+	// names are templates if the source discovers credentials
+	d, ok := secret.Source.(source.Discoverer)
+	templated := ok && d.Discovers()
+	for i := range fields.Sinks {
+		s, err := sink.Decode(&fields.Sinks[i])
+		msgs = append(msgs, util.ErrorMessages(err)...)
+		if err != nil {
+			continue
+		}
+		if v, ok := s.(sink.Validator); ok {
+			for _, msg := range util.ErrorMessages(v.Validate()) {
+				msgs = append(msgs, fmt.Sprintf("line %d: invalid %s sink config: %s", fields.Sinks[i].Line, s.Kind(), msg))
+			}
+		}
+		if templated {
+			for k, name := range s.GetKeyToName() {
+				if _, err := parseName(name); err != nil {
+					msgs = append(msgs, fmt.Sprintf("line %d: invalid name of %s in %s sink config: %s", fields.Sinks[i].Line, k, s.Kind(), err))
+				}
+			}
+		}
+		secret.Sinks = append(secret.Sinks, s)
+	}
+
+	if len(msgs) > 0 {
+		return &yaml.TypeError{Errors: msgs}
 	}
-	secret.Sinks = sinks
 	return nil
 }
 
@@ -352,40 +321,69 @@ func (secret Secret) MarshalYAML() (interface{}, error) {
 	secretFields["name"] = secret.Name
 
 	// marshal secret.Source
-	switch secret.Source.Kind() {
-	case source.KindDummy:
-		secretFields["source"] = map[string]string{"kind": string(source.KindDummy)}
-	case source.KindAws:
-		awsIamSrc := secret.Source.(*source.AwsIamSource)
-		secretFields["source"] = map[string]string{"kind": string(source.KindAws),
-			"username":    awsIamSrc.UserName,
-			"role_arn":    awsIamSrc.RoleArn,
-			"external_id": awsIamSrc.ExternalID,
-			"max_age":     awsIamSrc.MaxAge.String(),
-		}
-	case source.KindEnv:
-		envSource := secret.Source.(*source.Env)
-		secretFields["source"] = map[string]string{
-			"kind": string(source.KindEnv),
-			"name": envSource.Name,
-		}
-	default:
-		return nil, errors.New("Unrecognized source")
+	srcFields, err := source.Encode(secret.Source)
+	if err != nil {
+		return nil, err
 	}
+	secretFields["source"] = srcFields
 
 	// marshal secret.Sinks
 	secretFields["sinks"] = secret.Sinks
+
+	// marshal secret.MaxAge, secret.Schedule and secret.Timeout
+	if secret.MaxAge != 0 {
+		secretFields["max_age"] = secret.MaxAge.String()
+	}
+	if secret.Schedule != "" {
+		secretFields["schedule"] = secret.Schedule
+	}
+	if secret.Timeout != 0 {
+		secretFields["timeout"] = secret.Timeout.String()
+	}
 	return &secretFields, nil
 }
 
-func FromFile(file string) (*Config, error) {
+// Load parses and validates a config, upgrading it from an older
+// version of the format if needed. It does not initialize sources and
+// sinks, so it needs neither network access nor credentials.
+func Load(b []byte) (*Config, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not parse config")
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.New("Could not parse config: empty file")
+	}
+	root := doc.Content[0]
+	err = upgrade(root)
+	if err != nil {
+		return nil, err
+	}
+
+	conf := &Config{}
+	err = util.DecodeStrict(root, conf)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not unmarshal config")
+	}
+	return conf, errors.Wrap(conf.Validate(), "Invalid config")
+}
+
+// LoadFile reads file and loads it with Load.
+func LoadFile(file string) (*Config, error) {
 	b, err := ioutil.ReadFile(file)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Could not read config %s", file)
 	}
+	return Load(b)
+}
 
-	conf := &Config{}
-	err = yaml.Unmarshal(b, conf)
-	spew.Dump(conf)
-	return conf, errors.Wrap(err, "Could not unmarshal config")
+// FromFile loads the config in file and initializes its sources and
+// sinks, ready for rotation.
+func FromFile(file string) (*Config, error) {
+	conf, err := LoadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return conf, conf.Init()
 }
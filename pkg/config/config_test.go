@@ -1,18 +1,24 @@
 package config_test
 
 import (
+	"flag"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chanzuckerberg/rotator/pkg/config"
 	"github.com/chanzuckerberg/rotator/pkg/sink"
 	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/chanzuckerberg/rotator/pkg/state"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/stretchr/testify/require"
-	"gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
 )
 
+var update = flag.Bool("update", false, "update schema.json")
+
 func TestFromFile(t *testing.T) {
 	r := require.New(t)
 
@@ -21,7 +27,7 @@ func TestFromFile(t *testing.T) {
 	defer tmpFile.Close()
 	defer os.Remove(tmpFile.Name())
 
-	c1 := &config.Config{Secrets: []config.Secret{}}
+	c1 := &config.Config{Version: config.CurrentVersion, Secrets: []config.Secret{}}
 	bytes, err := yaml.Marshal(c1)
 	r.Nil(err)
 	_, err = tmpFile.Write(bytes)
@@ -48,7 +54,7 @@ func TestSingleStringPairs(t *testing.T) {
 	testSource.WithName("blah")
 
 	c1 := &config.Config{
-		Version: 1,
+		Version: config.CurrentVersion,
 		Secrets: []config.Secret{
 			{
 				Name:   "foo",
@@ -70,3 +76,629 @@ func TestSingleStringPairs(t *testing.T) {
 
 	r.Equal(c1, c2)
 }
+
+func TestTimeouts(t *testing.T) {
+	r := require.New(t)
+	tmpFile, err := ioutil.TempFile("", "tmpConfig")
+	r.Nil(err)
+	defer tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.WriteString(`
+version: 2
+timeout: 30m
+secret_timeout: 2m
+secrets:
+  - name: default
+    source:
+      kind: dummy
+    sinks:
+      - kind: Stdout
+        key_to_name:
+          secret: SECRET
+  - name: custom
+    timeout: 5m
+    source:
+      kind: dummy
+    sinks:
+      - kind: Stdout
+        key_to_name:
+          secret: SECRET
+`)
+	r.NoError(err)
+
+	c, err := config.FromFile(tmpFile.Name())
+	r.NoError(err)
+	r.Equal(30*time.Minute, c.Timeout)
+	r.Equal(2*time.Minute, c.TimeoutFor(c.Secrets[0]))
+	r.Equal(5*time.Minute, c.TimeoutFor(c.Secrets[1]))
+
+	// timeouts survive a round trip
+	bytes, err := yaml.Marshal(c)
+	r.NoError(err)
+	c2, err := config.Load(bytes)
+	r.NoError(err)
+	r.Equal(c, c2)
+}
+
+func TestStateAndMaxAge(t *testing.T) {
+	r := require.New(t)
+	tmpFile, err := ioutil.TempFile("", "tmpConfig")
+	r.Nil(err)
+	defer tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+
+	_, err = tmpFile.WriteString(`
+version: 2
+state:
+  backend: bolt
+  path: /tmp/rotator.db
+secrets:
+  - name: env
+    max_age: 24h
+    source:
+      kind: env
+      name: TEST_ENV
+    sinks:
+      - kind: Stdout
+        key_to_name:
+          secret: SECRET
+`)
+	r.NoError(err)
+
+	c, err := config.FromFile(tmpFile.Name())
+	r.NoError(err)
+	r.NotNil(c.State)
+	r.Equal(state.BackendBolt, c.State.Backend)
+	r.Equal("/tmp/rotator.db", c.State.Path)
+	r.Equal(24*time.Hour, c.Secrets[0].MaxAge)
+
+	// state and max_age survive a round trip
+	bytes, err := yaml.Marshal(c)
+	r.NoError(err)
+	c2, err := config.Load(bytes)
+	r.NoError(err)
+	r.Equal(c, c2)
+}
+
+func TestRoundTripAllKinds(t *testing.T) {
+	r := require.New(t)
+	in := []byte(`
+version: 2
+secrets:
+- max_age: 1h40m0s
+  name: aws
+  source:
+    external_id: some-id
+    kind: aws
+    role_arn: arn:aws:iam::123456789101:role/admin
+    username: example-user
+    inactive_grace: 168h0m0s
+    quiet_period: 24h0m0s
+    fail_on_recent_use: true
+    adopt: true
+    owned_only: true
+  sinks:
+  - key_to_name:
+      accessKeyId: AWS_ACCESS_KEY_ID
+    kind: TravisCI
+    repo_slug: example/repo
+  - account: example
+    key_to_name:
+      accessKeyId: AWS_ACCESS_KEY_ID
+    kind: CircleCI
+    repo: repo
+  - key_to_name:
+      accessKeyId: AWS_ACCESS_KEY_ID
+    kind: GitHubActionsSecret
+    owner: example
+    repo: repo
+  - external_id: ""
+    key_to_name:
+      accessKeyId: /example/access_key_id
+    kind: AWSParameterStore
+    region: us-west-2
+    role_arn: arn:aws:iam::123456789101:role/ssm
+  - external_id: other-id
+    key_to_name:
+      accessKeyId: example/access_key_id
+    kind: AWSSecretsManager
+    region: us-east-1
+    role_arn: arn:aws:iam::123456789101:role/secrets
+  - app: example-app
+    key_to_name:
+      accessKeyId: AWS_ACCESS_KEY_ID
+    kind: Heroku
+- max_age: 1h40m0s
+  name: ci-keys
+  source:
+    external_id: ""
+    kind: aws
+    role_arn: arn:aws:iam::123456789101:role/admin
+    username: ""
+    discover:
+      path_prefix: /ci/
+      tags:
+        rotator:managed: "true"
+  sinks:
+  - external_id: ""
+    key_to_name:
+      accessKeyId: /ci/{{ .Tags.ci_repo }}/AWS_ACCESS_KEY_ID
+      secretAccessKey: /ci/{{ .Tags.ci_repo }}/AWS_SECRET_ACCESS_KEY
+    kind: AWSParameterStore
+    region: us-west-2
+    role_arn: arn:aws:iam::123456789101:role/ssm
+- max_age: 24h0m0s
+  name: env
+  source:
+    kind: env
+    name: TEST_ENV
+  sinks:
+  - key_to_name:
+      TEST_ENV: test_env
+    kind: Stdout
+  - key_to_name:
+      TEST_ENV: test_env
+    kind: Buffer
+- name: generated
+  source:
+    kind: generated
+    outputs:
+    - exclude_ambiguous: true
+      key: password
+      length: 24
+      require:
+      - upper
+      - lower
+      - digit
+    - alphabet: custom
+      characters: abc123
+      key: token
+  sinks:
+  - key_to_name:
+      password: DB_PASSWORD
+      token: API_TOKEN
+    kind: Buffer
+- name: postgres
+  max_age: 168h0m0s
+  source:
+    kind: postgres
+    admin_dsn_env: ADMIN_DSN
+    roles:
+    - app_a
+    - app_b
+    host: db.example.com
+    port: 5433
+    database: app
+    sslmode: require
+    password_length: 40
+    password_encryption: server
+  sinks:
+  - key_to_name:
+      dsn: DATABASE_URL
+    kind: Buffer
+- name: mysql
+  max_age: 168h0m0s
+  source:
+    kind: mysql
+    admin_dsn_env: ADMIN_DSN
+    username: app
+    user_host: 10.0.%
+    host: db.example.com
+    port: 3307
+    database: app
+    tls: "true"
+    password_length: 40
+  sinks:
+  - key_to_name:
+      dsn: DATABASE_DSN
+      password: DATABASE_PASSWORD
+    kind: Buffer
+- name: deploy-key
+  source:
+    kind: ssh_keypair
+    type: rsa
+    bits: 3072
+    comment: rotator
+  sinks:
+  - key_to_name:
+      public_key: ci
+    kind: GitHubDeployKey
+    owner: example
+    repo: app
+    read_write: true
+    grace_period: 24h0m0s
+  - key_to_name:
+      private_key: DEPLOY_KEY
+    kind: Buffer
+- name: tls
+  source:
+    kind: tls_cert
+    ca:
+      sink:
+        key_to_name:
+          cert: CA_CERT
+          key: CA_KEY
+        kind: Buffer
+    common_name: svc
+    dns_names:
+    - svc.example.internal
+    ip_addresses:
+    - 10.0.0.1
+    uris:
+    - spiffe://example/svc
+    key_algorithm: rsa-3072
+    ext_key_usage:
+    - server
+    validity: 720h0m0s
+    renew_before: 240h0m0s
+  sinks:
+  - key_to_name:
+      cert_pem: TLS_CERT
+      key_pem: TLS_KEY
+    kind: Buffer
+- name: acme
+  source:
+    kind: acme
+    directory_url: https://acme-staging-v02.api.letsencrypt.org/directory
+    ca_file: /etc/rotator/ca.pem
+    email: ops@example.com
+    accept_tos: true
+    account:
+      key_file: /var/lib/rotator/acme.pem
+    domains:
+    - example.com
+    - '*.example.com'
+    key_algorithm: ecdsa-p384
+    renew_before: 720h0m0s
+    challenge: dns-01
+    route53:
+      hosted_zone_id: Z123
+      role_arn: arn:aws:iam::123456789101:role/dns
+      external_id: dns
+  sinks:
+  - key_to_name:
+      cert_pem: TLS_CERT
+    kind: Buffer
+- name: acme-http
+  source:
+    kind: acme
+    accept_tos: true
+    account:
+      sink:
+        key_to_name:
+          account_key: ACME_ACCOUNT
+        kind: Buffer
+    domains:
+    - www.example.com
+    challenge: http-01
+    http_address: :8080
+  sinks:
+  - key_to_name:
+      cert_pem: TLS_CERT
+    kind: Buffer
+- name: vault
+  source:
+    kind: vault_kv
+    address: https://vault.example.com:8200
+    namespace: team
+    auth:
+      method: approle
+      mount: ci-approle
+      role_id: 1a2b
+      secret_id_env: ROTATOR_SECRET_ID
+    mount: kv
+    path: app/db
+    kv_version: 1
+    fields:
+    - password
+  sinks:
+  - key_to_name:
+      password: DB_PASSWORD
+    kind: Buffer
+- name: vault-kubernetes
+  source:
+    kind: vault_kv
+    auth:
+      method: kubernetes
+      role: rotator
+      jwt_file: /var/run/token
+    path: app/api
+  sinks:
+  - key_to_name:
+      token: API_TOKEN
+    kind: Buffer
+- name: vault-database
+  max_age: 12h0m0s
+  source:
+    kind: vault_dynamic
+    auth:
+      method: approle
+      role_id: 1a2b
+    path: database/creds/app
+  sinks:
+  - key_to_name:
+      password: DB_PASSWORD
+      username: DB_USERNAME
+    kind: Buffer
+- name: vault-aws
+  max_age: 1h0m0s
+  source:
+    kind: vault_dynamic
+    path: aws/sts/deploy
+    data:
+      ttl: 3h
+    fields:
+    - access_key
+    - secret_key
+    - security_token
+  sinks:
+  - key_to_name:
+      access_key: AWS_ACCESS_KEY_ID
+      secret_key: AWS_SECRET_ACCESS_KEY
+      security_token: AWS_SESSION_TOKEN
+    kind: Buffer
+- name: vendor-file
+  source:
+    kind: file
+    path: /run/rotator/vendor.env
+    format: dotenv
+    fields:
+    - API_KEY
+    hash: true
+  sinks:
+  - key_to_name:
+      API_KEY: VENDOR_API_KEY
+    kind: Buffer
+- name: vendor-exec
+  source:
+    kind: exec
+    command:
+    - ./rotate-vendor-key.sh
+    - --account
+    - ci
+    activate_command:
+    - ./delete-old-vendor-keys.sh
+    revoke_command:
+    - ./delete-vendor-key.sh
+    timeout: 30s
+  sinks:
+  - key_to_name:
+      api_key: VENDOR_API_KEY
+    kind: Buffer
+- name: app-tls
+  source:
+    kind: kubernetes_secret
+    kubeconfig: /etc/rotator/kubeconfig
+    context: prod
+    namespace: cert-manager
+    name: app-tls
+    keys:
+    - tls.crt
+    - tls.key
+  sinks:
+  - key_to_name:
+      tls.crt: TLS_CERT
+      tls.key: TLS_KEY
+    kind: Buffer
+- name: ci-session
+  schedule: 15m
+  source:
+    kind: aws_sts
+    role_arn: arn:aws:iam::123456789101:role/ci
+    external_id: some-id
+    session_name: ci
+    duration: 2h0m0s
+    renew_before: 1h0m0s
+    policy: '{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]}'
+    policy_arns:
+    - arn:aws:iam::aws:policy/ReadOnlyAccess
+  sinks:
+  - key_to_name:
+      accessKeyId: AWS_ACCESS_KEY_ID
+      secretAccessKey: AWS_SECRET_ACCESS_KEY
+      sessionToken: AWS_SESSION_TOKEN
+    kind: GitHubActionsSecret
+    owner: example
+    repo: repo
+`)
+
+	c, err := config.Load(in)
+	r.NoError(err)
+
+	// every field survives, in both directions
+	out, err := yaml.Marshal(c)
+	r.NoError(err)
+	var want, got map[string]interface{}
+	r.NoError(yaml.Unmarshal(in, &want))
+	r.NoError(yaml.Unmarshal(out, &got))
+	r.Equal(want, got)
+
+	awsParam, ok := c.Secrets[0].Sinks[3].(*sink.AwsParamSink)
+	r.True(ok)
+	r.Equal("us-west-2", awsParam.Region)
+	r.Equal("arn:aws:iam::123456789101:role/ssm", awsParam.RoleArn)
+}
+
+func TestValidation(t *testing.T) {
+	r := require.New(t)
+	tests := map[string]string{
+		"unknown source kind":   `{name: s, source: {kind: nope}, sinks: []}`,
+		"unknown sink kind":     `{name: s, source: {kind: dummy}, sinks: [{kind: nope, key_to_name: {secret: S}}]}`,
+		"missing role_arn":      `{name: s, max_age: 1h, source: {kind: aws}, sinks: []}`,
+		"missing max_age":       `{name: s, source: {kind: aws, role_arn: r}, sinks: []}`,
+		"negative grace":        `{name: s, max_age: 1h, source: {kind: aws, role_arn: r, inactive_grace: -1h}, sinks: []}`,
+		"recent use":            `{name: s, max_age: 1h, source: {kind: aws, role_arn: r, fail_on_recent_use: true}, sinks: []}`,
+		"discover and username": `{name: s, max_age: 1h, source: {kind: aws, role_arn: r, username: u, discover: {path_prefix: /ci/}}, sinks: []}`,
+		"discover everyone":     `{name: s, max_age: 1h, source: {kind: aws, role_arn: r, discover: {}}, sinks: []}`,
+		"invalid name template": `{name: s, max_age: 1h, source: {kind: aws, role_arn: r, discover: {path_prefix: /ci/}}, sinks: [{kind: Stdout, key_to_name: {accessKeyId: "{{ .Tags.repo"}}]}`,
+		"missing key_to_name":   `{name: s, source: {kind: dummy}, sinks: [{kind: Stdout}]}`,
+		"missing repo_slug":     `{name: s, source: {kind: dummy}, sinks: [{kind: TravisCI, key_to_name: {secret: S}}]}`,
+		"missing region":        `{name: s, source: {kind: dummy}, sinks: [{kind: AWSSecretsManager, role_arn: r, key_to_name: {secret: S}}]}`,
+		"missing role":          `{name: s, source: {kind: postgres, host: h, database: d}, sinks: []}`,
+		"missing mysql age":     `{name: s, source: {kind: mysql, username: u, host: h}, sinks: []}`,
+		"missing postgres age":  `{name: s, source: {kind: postgres, roles: [a, b], host: h, database: d}, sinks: []}`,
+		"unknown key type":      `{name: s, source: {kind: ssh_keypair, type: dsa}, sinks: []}`,
+		"unknown key alg":       `{name: s, source: {kind: tls_cert, ca: {cert_file: c, key_file: k}, dns_names: [a], key_algorithm: dsa}, sinks: []}`,
+		"unreadable ca sink":    `{name: s, source: {kind: tls_cert, ca: {sink: {kind: Stdout, key_to_name: {cert: C, key: K}}}, dns_names: [a]}, sinks: []}`,
+		"unknown ca field":      `{name: s, source: {kind: tls_cert, ca: {sink: {kind: Buffer, nope: 1, key_to_name: {cert: C, key: K}}}, dns_names: [a]}, sinks: []}`,
+		"acme tos":              `{name: s, source: {kind: acme, account: {key_file: a}, domains: [a], challenge: http-01}, sinks: []}`,
+		"acme challenge":        `{name: s, source: {kind: acme, accept_tos: true, account: {key_file: a}, domains: [a], challenge: tls-alpn-01}, sinks: []}`,
+		"vault auth method":     `{name: s, source: {kind: vault_kv, path: p, auth: {method: ldap}}, sinks: []}`,
+		"vault unknown field":   `{name: s, source: {kind: vault_kv, path: p, auth: {method: token, nope: 1}}, sinks: []}`,
+		"vault lease age":       `{name: s, source: {kind: vault_dynamic, path: database/creds/app}, sinks: []}`,
+		"file format":           `{name: s, source: {kind: file, path: creds.txt}, sinks: []}`,
+		"exec command":          `{name: s, source: {kind: exec, command: []}, sinks: []}`,
+		"missing namespace":     `{name: s, source: {kind: kubernetes_secret, name: n}, sinks: []}`,
+		"sts duration":          `{name: s, source: {kind: aws_sts, role_arn: r, duration: 24h}, sinks: []}`,
+		"missing owner":         `{name: s, source: {kind: dummy}, sinks: [{kind: GitHubDeployKey, repo: r, key_to_name: {secret: S}}]}`,
+		"missing name":          `{source: {kind: dummy}, sinks: []}`,
+		"missing source":        `{name: s, sinks: []}`,
+		"missing sinks":         `{name: s, source: {kind: dummy}}`,
+		"wrong type":            `{name: s, timeout: [1], source: {kind: dummy}, sinks: []}`,
+		"invalid schedule":      `{name: s, schedule: "61 * * * *", source: {kind: dummy}, sinks: []}`,
+		"short interval":        `{name: s, schedule: 10ms, source: {kind: dummy}, sinks: []}`,
+		"duplicate name":        `{name: s, source: {kind: dummy}, sinks: []}, {name: s, source: {kind: dummy}, sinks: []}`,
+	}
+	for name, secret := range tests {
+		_, err := config.Load([]byte("version: 2\nsecrets: [" + secret + "]"))
+		r.Error(err, name)
+	}
+
+	_, err := config.Load([]byte("version: 3\nsecrets: []"))
+	r.Error(err)
+	r.Contains(err.Error(), "unsupported config version 3")
+}
+
+func TestSchedules(t *testing.T) {
+	r := require.New(t)
+	c, err := config.Load([]byte(`
+version: 2
+schedule: "@daily"
+secrets:
+  - name: default
+    source:
+      kind: dummy
+    sinks: []
+  - name: interval
+    schedule: 6h
+    source:
+      kind: dummy
+    sinks: []
+  - name: cron
+    schedule: "*/15 * * * *"
+    source:
+      kind: dummy
+    sinks: []
+`))
+	r.NoError(err)
+	r.Equal("@daily", c.ScheduleFor(c.Secrets[0]))
+
+	start := time.Date(2020, 9, 1, 10, 7, 0, 0, time.UTC)
+	next := func(secret config.Secret) time.Time {
+		schedule, err := config.ParseSchedule(c.ScheduleFor(secret))
+		r.NoError(err)
+		return schedule.Next(start)
+	}
+	r.Equal(time.Date(2020, 9, 2, 0, 0, 0, 0, time.UTC), next(c.Secrets[0]))
+	r.Equal(start.Add(6*time.Hour), next(c.Secrets[1]))
+	r.Equal(time.Date(2020, 9, 1, 10, 15, 0, 0, time.UTC), next(c.Secrets[2]))
+
+	c.Schedule = ""
+	r.Equal(config.DefaultSchedule, c.ScheduleFor(c.Secrets[0]))
+}
+
+func TestUnknownFields(t *testing.T) {
+	r := require.New(t)
+	_, err := config.Load([]byte(`version: 2
+secrets:
+  - name: aws
+    max_age: 1h
+    source:
+      kind: aws
+      role_arn: arn:aws:iam::123456789101:role/admin
+      username: example-user
+      user_name: typo
+    sinks:
+      - kind: AWSParameterStore
+        role_arn: arn:aws:iam::123456789101:role/ssm
+        regoin: us-west-2
+        key_to_name:
+          accessKeyId: /example/access_key_id
+    timeuot: 5m
+concurency:
+  secrets: 2
+`))
+	r.Error(err)
+	// every unknown field is reported at once, with its line
+	r.Contains(err.Error(), `line 9: unknown field "user_name" in aws source config`)
+	r.Contains(err.Error(), `line 13: unknown field "regoin" in AWSParameterStore sink config`)
+	r.Contains(err.Error(), `line 16: unknown field "timeuot"`)
+	r.Contains(err.Error(), `line 17: unknown field "concurency"`)
+}
+
+func TestUpgradeV1(t *testing.T) {
+	r := require.New(t)
+	v1 := []byte(`# rotated nightly
+version: 1
+secrets:
+  - name: heroku
+    source:
+      kind: env
+      name: TEST_ENV
+      max_age: 24h # once a day
+    sinks:
+      - kind: Heroku
+        AppIdentity: example-app
+        key_to_name:
+          TEST_ENV: TEST_ENV
+`)
+	v2 := []byte(`version: 2
+secrets:
+  - name: heroku
+    max_age: 24h
+    source:
+      kind: env
+      name: TEST_ENV
+    sinks:
+      - kind: Heroku
+        app: example-app
+        key_to_name:
+          TEST_ENV: TEST_ENV
+`)
+
+	c1, err := config.Load(v1)
+	r.NoError(err)
+	c2, err := config.Load(v2)
+	r.NoError(err)
+	r.Equal(c2, c1)
+	r.Equal(config.CurrentVersion, c1.Version)
+	r.Equal(24*time.Hour, c1.Secrets[0].MaxAge)
+
+	// the upgraded file keeps its comments and loads the same
+	upgraded, err := config.Upgrade(v1)
+	r.NoError(err)
+	r.Contains(string(upgraded), "# rotated nightly")
+	r.Contains(string(upgraded), "# once a day")
+	r.Contains(string(upgraded), "version: 2")
+	c3, err := config.Load(upgraded)
+	r.NoError(err)
+	r.Equal(c2, c3)
+
+	// files without a version are version 1
+	c4, err := config.Load([]byte(strings.Replace(string(v1), "version: 1\n", "", 1)))
+	r.NoError(err)
+	r.Equal(c2, c4)
+}
+
+func TestSchemaUpToDate(t *testing.T) {
+	r := require.New(t)
+	schema, err := config.Schema()
+	r.NoError(err)
+	if *update {
+		r.NoError(ioutil.WriteFile("schema.json", schema, 0644))
+	}
+	published, err := ioutil.ReadFile("schema.json")
+	r.NoError(err)
+	r.JSONEq(string(published), string(schema), "schema.json is out of date, run go test ./pkg/config -update")
+}
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/pkg/errors"
+)
+
+// durationPattern matches the durations accepted by time.ParseDuration.
+const durationPattern = `^[-+]?(0|([0-9]*(\.[0-9]*)?(ns|us|µs|ms|s|m|h))+)$`
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+	locationType = reflect.TypeOf(sink.Location{})
+)
+
+// Schema returns a JSON Schema (draft-07) of the current config format.
+// Each registered source and sink kind gets a definition generated from
+// the fields of its type. Checks done by Validate methods, e.g. on
+// required fields, are not part of the schema.
+func Schema() ([]byte, error) {
+	definitions := map[string]interface{}{
+		"duration": map[string]interface{}{
+			"type":    "string",
+			"pattern": durationPattern,
+		},
+	}
+
+	var sinks []interface{}
+	for _, kind := range sink.Kinds() {
+		s, err := sink.New(kind)
+		if err != nil {
+			return nil, err
+		}
+		name := "sink." + string(kind)
+		def := kindSchema(reflect.TypeOf(s), string(kind))
+		def["required"] = []string{"key_to_name", "kind"}
+		definitions[name] = def
+		sinks = append(sinks, ref(name))
+	}
+	definitions["sink"] = map[string]interface{}{"oneOf": sinks}
+
+	var sources []interface{}
+	for _, kind := range source.Kinds() {
+		src, err := source.New(kind)
+		if err != nil {
+			return nil, err
+		}
+		name := "source." + string(kind)
+		definitions[name] = kindSchema(reflect.TypeOf(src), string(kind))
+		sources = append(sources, ref(name))
+	}
+
+	definitions["secret"] = map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"name", "sinks", "source"},
+		"properties": map[string]interface{}{
+			"name":     map[string]interface{}{"type": "string", "minLength": 1},
+			"max_age":  ref("duration"),
+			"schedule": map[string]interface{}{"type": "string"},
+			"timeout":  ref("duration"),
+			"source":   map[string]interface{}{"oneOf": sources},
+			"sinks": map[string]interface{}{
+				"type":  "array",
+				"items": ref("sink"),
+			},
+		},
+	}
+
+	stateSchema := typeSchema(reflect.TypeOf(state.Config{}))
+	stateSchema["properties"].(map[string]interface{})["backend"] = map[string]interface{}{
+		"enum": []state.Backend{state.BackendBolt, state.BackendS3},
+	}
+	stateSchema["required"] = []string{"backend"}
+
+	schema := map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "rotator config",
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"secrets", "version"},
+		"properties": map[string]interface{}{
+			"version":        map[string]interface{}{"const": CurrentVersion},
+			"timeout":        ref("duration"),
+			"secret_timeout": ref("duration"),
+			"concurrency":    typeSchema(reflect.TypeOf(Concurrency{})),
+			"state":          stateSchema,
+			"schedule":       map[string]interface{}{"type": "string"},
+			"secrets": map[string]interface{}{
+				"type":  "array",
+				"items": ref("secret"),
+			},
+		},
+		"definitions": definitions,
+	}
+	b, err := json.MarshalIndent(schema, "", "  ")
+	return b, errors.Wrap(err, "unable to marshal schema")
+}
+
+func ref(definition string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/definitions/" + definition}
+}
+
+// kindSchema returns the schema of the config of a source or sink kind
+// of type t.
+func kindSchema(t reflect.Type, kind string) map[string]interface{} {
+	schema := typeSchema(t)
+	schema["properties"].(map[string]interface{})["kind"] = map[string]interface{}{"const": kind}
+	schema["required"] = []string{"kind"}
+	return schema
+}
+
+// typeSchema returns the schema of the YAML encoding of values of type t.
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case durationType:
+		return ref("duration")
+	case locationType:
+		return ref("sink")
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": typeSchema(t.Elem()),
+		}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": typeSchema(t.Elem()),
+		}
+	case reflect.Struct:
+		fields, anyKey := util.YAMLFields(t)
+		properties := map[string]interface{}{}
+		for name, f := range fields {
+			properties[name] = typeSchema(f.Type)
+		}
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": anyKey,
+			"properties":           properties,
+		}
+	}
+	return map[string]interface{}{}
+}
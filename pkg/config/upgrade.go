@@ -0,0 +1,143 @@
+package config
+
+import (
+	"bytes"
+	"strconv"
+
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Upgrade rewrites a config in the current version of the format,
+// keeping its comments and layout where possible. Configs already in
+// the current version are returned unchanged.
+func Upgrade(b []byte) ([]byte, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not parse config")
+	}
+	if len(doc.Content) == 0 {
+		return nil, errors.New("Could not parse config: empty file")
+	}
+	err = upgrade(doc.Content[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	err = enc.Encode(&doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to marshal config")
+	}
+	return buf.Bytes(), errors.Wrap(enc.Close(), "unable to marshal config")
+}
+
+// upgrade rewrites root, the top level mapping of a config, to the
+// current version in place. Configs without a version predate
+// versioning and are version 1.
+func upgrade(root *yaml.Node) error {
+	if root.Kind != yaml.MappingNode {
+		return errors.Errorf("line %d: config must be a mapping", root.Line)
+	}
+	version := 1
+	versionNode := mappingValue(root, "version")
+	if versionNode != nil {
+		err := versionNode.Decode(&version)
+		if err != nil {
+			return errors.Wrap(err, "incorrect version format in config")
+		}
+	}
+
+	switch version {
+	case 0, 1:
+		logrus.Debugf("upgrading config from version %d to %d", version, CurrentVersion)
+		upgradeV1(root)
+	case CurrentVersion:
+		return nil
+	default:
+		return errors.Errorf("unsupported config version %d, expected at most %d", version, CurrentVersion)
+	}
+
+	if versionNode == nil {
+		versionNode = &yaml.Node{Kind: yaml.ScalarNode}
+		root.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: "version"}, versionNode}, root.Content...)
+	}
+	versionNode.Tag = "!!int"
+	versionNode.Value = strconv.Itoa(CurrentVersion)
+	return nil
+}
+
+// upgradeV1 upgrades the secrets of a version 1 config to version 2:
+// max_age moves from the source to the secret, and the AppIdentity
+// field of Heroku sinks is renamed app.
+func upgradeV1(root *yaml.Node) {
+	secrets := mappingValue(root, "secrets")
+	if secrets == nil || secrets.Kind != yaml.SequenceNode {
+		return
+	}
+	for _, secret := range secrets.Content {
+		if secret.Kind != yaml.MappingNode {
+			continue
+		}
+		if src := mappingValue(secret, "source"); src != nil && src.Kind == yaml.MappingNode {
+			key, val := removeKey(src, "max_age")
+			if key != nil && mappingValue(secret, "max_age") == nil {
+				secret.Content = append(secret.Content, key, val)
+			}
+		}
+		sinks := mappingValue(secret, "sinks")
+		if sinks == nil || sinks.Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, s := range sinks.Content {
+			kind := mappingValue(s, "kind")
+			if kind == nil || kind.Value != string(sink.KindHeroku) {
+				continue
+			}
+			renameKey(s, "AppIdentity", "app")
+		}
+	}
+}
+
+// mappingValue returns the value of key in mapping node m, or nil.
+func mappingValue(m *yaml.Node, key string) *yaml.Node {
+	if m.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// removeKey removes key from mapping node m and returns the key and
+// value nodes removed, or nils.
+func removeKey(m *yaml.Node, key string) (*yaml.Node, *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			k, v := m.Content[i], m.Content[i+1]
+			m.Content = append(m.Content[:i], m.Content[i+2:]...)
+			return k, v
+		}
+	}
+	return nil, nil
+}
+
+// renameKey renames key in mapping node m, unless m already has newKey.
+func renameKey(m *yaml.Node, key string, newKey string) {
+	if m.Kind != yaml.MappingNode || mappingValue(m, newKey) != nil {
+		return
+	}
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i].Value = newKey
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package redact
+
+import (
+	"github.com/sirupsen/logrus"
+)
+
+// Hook is a logrus hook that scrubs registered secret values from the
+// message and fields of every entry.
+type Hook struct{}
+
+func (Hook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (Hook) Fire(entry *logrus.Entry) error {
+	entry.Message = Scrub(entry.Message)
+	if len(entry.Data) == 0 {
+		return nil
+	}
+	// entry.Data may be shared with other entries, so it is replaced
+	// rather than modified
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		switch v := v.(type) {
+		case string:
+			data[k] = Scrub(v)
+		case error:
+			data[k] = Scrub(v.Error())
+		default:
+			data[k] = v
+		}
+	}
+	entry.Data = data
+	return nil
+}
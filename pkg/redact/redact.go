@@ -0,0 +1,141 @@
+package redact
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Redacted is printed in place of secret values.
+const Redacted = "[REDACTED]"
+
+// minScrubLen is the length below which registered values are not
+// scrubbed, since scrubbing them would mangle unrelated output.
+const minScrubLen = 4
+
+// Value is a secret value. It formats as Redacted with every fmt verb,
+// and marshals as Redacted to JSON and text, so that it cannot end up in
+// logs, errors or Sentry events by accident. Use Reveal to get the value.
+type Value string
+
+// Reveal returns the secret value.
+func (v Value) Reveal() string {
+	return string(v)
+}
+
+func (v Value) String() string {
+	return Redacted
+}
+
+func (v Value) GoString() string {
+	return Redacted
+}
+
+// Format implements fmt.Formatter so that no verb prints the value.
+func (v Value) Format(f fmt.State, verb rune) {
+	_, _ = io.WriteString(f, Redacted)
+}
+
+func (v Value) MarshalJSON() ([]byte, error) {
+	return json.Marshal(Redacted)
+}
+
+func (v Value) MarshalText() ([]byte, error) {
+	return []byte(Redacted), nil
+}
+
+// registry holds the secret values seen by this process, with the
+// number of times each was registered and not released yet.
+var registry = struct {
+	sync.RWMutex
+	vals     map[string]int
+	replacer *strings.Replacer
+}{vals: map[string]int{}}
+
+// Register records secret values so that Scrub removes them from any
+// string. Values shorter than four characters are ignored. Values stay
+// registered until released as often as they were registered, see
+// Scope.
+func Register(vals ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+	changed := false
+	for _, v := range vals {
+		if len(v) < minScrubLen {
+			continue
+		}
+		if registry.vals[v] == 0 {
+			changed = true
+		}
+		registry.vals[v]++
+	}
+	if changed {
+		rebuild()
+	}
+}
+
+// Release undoes one registration of each of vals. Scrub stops removing
+// a value once it is released as often as it was registered.
+func Release(vals ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+	changed := false
+	for _, v := range vals {
+		n, ok := registry.vals[v]
+		if !ok {
+			continue
+		}
+		if n > 1 {
+			registry.vals[v] = n - 1
+			continue
+		}
+		delete(registry.vals, v)
+		changed = true
+	}
+	if changed {
+		rebuild()
+	}
+}
+
+// rebuild replaces the replacer of the registry. The caller must hold
+// the lock.
+func rebuild() {
+	if len(registry.vals) == 0 {
+		registry.replacer = nil
+		return
+	}
+	// replace longer values first, so that a value containing another
+	// is not left partly visible
+	sorted := make([]string, 0, len(registry.vals))
+	for v := range registry.vals {
+		sorted = append(sorted, v)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	oldnew := make([]string, 0, 2*len(sorted))
+	for _, v := range sorted {
+		oldnew = append(oldnew, v, Redacted)
+	}
+	registry.replacer = strings.NewReplacer(oldnew...)
+}
+
+// RegisterMap registers every value of m.
+func RegisterMap(m map[string]string) {
+	vals := make([]string, 0, len(m))
+	for _, v := range m {
+		vals = append(vals, v)
+	}
+	Register(vals...)
+}
+
+// Scrub returns s with every registered value replaced by Redacted.
+func Scrub(s string) string {
+	registry.RLock()
+	defer registry.RUnlock()
+	if registry.replacer == nil {
+		return s
+	}
+	return registry.replacer.Replace(s)
+}
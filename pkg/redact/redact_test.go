@@ -0,0 +1,106 @@
+package redact_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/redact"
+	"github.com/getsentry/sentry-go"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValueFormatsRedacted(t *testing.T) {
+	r := require.New(t)
+	v := redact.Value("hunter22")
+
+	for _, verb := range []string{"%s", "%v", "%+v", "%#v", "%q", "%x", "%10s"} {
+		r.Equal(redact.Redacted, fmt.Sprintf(verb, v), verb)
+	}
+	r.Equal("val: "+redact.Redacted, fmt.Sprint("val: ", v))
+	r.Equal(fmt.Sprintf("{%s}", redact.Redacted), fmt.Sprintf("%v", struct{ V redact.Value }{v}))
+
+	b, err := json.Marshal(map[string]redact.Value{"v": v})
+	r.NoError(err)
+	r.Equal(`{"v":"[REDACTED]"}`, string(b))
+
+	r.Equal("hunter22", v.Reveal())
+}
+
+func TestScrub(t *testing.T) {
+	r := require.New(t)
+	redact.Register("s3cr3t-value", "s3cr3t", "abc")
+	redact.RegisterMap(map[string]string{"key": "another-secret"})
+
+	r.Equal("got [REDACTED] and [REDACTED]", redact.Scrub("got s3cr3t-value and another-secret"))
+	r.Equal("got [REDACTED]", redact.Scrub("got s3cr3t"))
+	// values too short to scrub safely are left alone
+	r.Equal("abc", redact.Scrub("abc"))
+}
+
+func TestScopeRelease(t *testing.T) {
+	r := require.New(t)
+
+	run1 := redact.NewScope()
+	run2 := redact.NewScope()
+	run1.Register("run1-secret", "shared-secret")
+	run2.RegisterMap(map[string]string{"key": "shared-secret"})
+	r.Equal("[REDACTED] [REDACTED]", redact.Scrub("run1-secret shared-secret"))
+
+	// a value stays registered while another scope holds it
+	run1.Release()
+	r.Equal("run1-secret [REDACTED]", redact.Scrub("run1-secret shared-secret"))
+	run2.Release()
+	r.Equal("run1-secret shared-secret", redact.Scrub("run1-secret shared-secret"))
+
+	// releasing twice does not release values registered elsewhere
+	redact.Register("global-secret")
+	run1.Register("global-secret")
+	run1.Release()
+	run1.Release()
+	r.Equal(redact.Redacted, redact.Scrub("global-secret"))
+
+	// without a scope, values are kept for the lifetime of the process
+	var none *redact.Scope
+	none.Register("unscoped-secret")
+	none.Release()
+	r.Equal(redact.Redacted, redact.Scrub("unscoped-secret"))
+}
+
+func TestHook(t *testing.T) {
+	r := require.New(t)
+	redact.Register("logged-secret")
+
+	out := &bytes.Buffer{}
+	log := logrus.New()
+	log.Out = out
+	log.AddHook(redact.Hook{})
+
+	fields := logrus.Fields{"err": errors.New("bad logged-secret"), "val": "logged-secret"}
+	log.WithFields(fields).Error("failed with logged-secret")
+	r.NotContains(out.String(), "logged-secret")
+	r.Contains(out.String(), redact.Redacted)
+	// the fields passed in are left untouched
+	r.Equal("logged-secret", fields["val"])
+}
+
+func TestScrubEvent(t *testing.T) {
+	r := require.New(t)
+	redact.Register("event-secret")
+
+	event := &sentry.Event{
+		Message:     "event-secret",
+		Exception:   []sentry.Exception{{Value: "unable to write event-secret"}},
+		Breadcrumbs: []*sentry.Breadcrumb{{Message: "saw event-secret"}},
+		Extra:       map[string]interface{}{"val": "event-secret", "n": 1},
+	}
+	event = redact.ScrubEvent(event, nil)
+	r.Equal(redact.Redacted, event.Message)
+	r.Equal("unable to write "+redact.Redacted, event.Exception[0].Value)
+	r.Equal("saw "+redact.Redacted, event.Breadcrumbs[0].Message)
+	r.Equal(redact.Redacted, event.Extra["val"])
+	r.Equal(1, event.Extra["n"])
+}
@@ -0,0 +1,65 @@
+package redact
+
+import (
+	"context"
+	"sync"
+)
+
+// Scope tracks the values registered for a unit of work, e.g. a
+// scheduled run of rotator serve, so that they can be released once it
+// is done rather than kept for the lifetime of the process. A nil Scope
+// registers values for the lifetime of the process.
+type Scope struct {
+	mu   sync.Mutex
+	vals []string
+}
+
+// NewScope returns an empty scope.
+func NewScope() *Scope {
+	return &Scope{}
+}
+
+// Register registers vals, see Register, until s is released.
+func (s *Scope) Register(vals ...string) {
+	Register(vals...)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vals = append(s.vals, vals...)
+}
+
+// RegisterMap registers every value of m until s is released.
+func (s *Scope) RegisterMap(m map[string]string) {
+	vals := make([]string, 0, len(m))
+	for _, v := range m {
+		vals = append(vals, v)
+	}
+	s.Register(vals...)
+}
+
+// Release releases the values registered through s. Values registered
+// elsewhere as well stay registered.
+func (s *Scope) Release() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	Release(s.vals...)
+	s.vals = nil
+}
+
+type scopeKey struct{}
+
+// WithScope returns a copy of ctx carrying s.
+func WithScope(ctx context.Context, s *Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+// ScopeFrom returns the scope carried by ctx, or nil if there is none.
+func ScopeFrom(ctx context.Context) *Scope {
+	s, _ := ctx.Value(scopeKey{}).(*Scope)
+	return s
+}
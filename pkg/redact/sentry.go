@@ -0,0 +1,23 @@
+package redact
+
+import (
+	"github.com/getsentry/sentry-go"
+)
+
+// ScrubEvent scrubs registered secret values from a Sentry event. It is
+// meant to be used as sentry.ClientOptions.BeforeSend.
+func ScrubEvent(event *sentry.Event, hint *sentry.EventHint) *sentry.Event {
+	event.Message = Scrub(event.Message)
+	for i := range event.Exception {
+		event.Exception[i].Value = Scrub(event.Exception[i].Value)
+	}
+	for _, b := range event.Breadcrumbs {
+		b.Message = Scrub(b.Message)
+	}
+	for k, v := range event.Extra {
+		if s, ok := v.(string); ok {
+			event.Extra[k] = Scrub(s)
+		}
+	}
+	return event
+}
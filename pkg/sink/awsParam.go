@@ -4,6 +4,7 @@ import (
 	"context"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/ssm"
 	cziAws "github.com/chanzuckerberg/go-misc/aws"
 	"github.com/pkg/errors"
@@ -15,13 +16,36 @@ type AwsParamSink struct {
 	RoleArn    string         `yaml:"role_arn"`
 	ExternalID string         `yaml:"external_id"`
 	Region     string         `yaml:"region"`
-	Client     *cziAws.Client `yaml:"client"`
+	Client     *cziAws.Client `yaml:"-"`
+}
+
+func init() {
+	Register(KindAwsParamStore, func() Sink { return NewAwsParamSink() })
 }
 
 func NewAwsParamSink() *AwsParamSink {
 	return &AwsParamSink{}
 }
 
+// Validate checks that the role to assume and the region are set.
+func (sink *AwsParamSink) Validate() error {
+	return validateAwsTarget(sink.RoleArn, sink.Region)
+}
+
+// Init sets up an SSM client that assumes RoleArn in Region. It does
+// nothing if a client is already set.
+func (sink *AwsParamSink) Init() error {
+	if sink.Client != nil {
+		return nil
+	}
+	sess, err := newAwsSession(sink.Region, sink.RoleArn, sink.ExternalID)
+	if err != nil {
+		return err
+	}
+	sink.Client = cziAws.New(sess).WithSSM(sess.Config)
+	return nil
+}
+
 // Write updates the value of the the parameter with the given name in the
 // underlying AWS Parameter Store.
 func (sink *AwsParamSink) Write(ctx context.Context, name string, val string) error {
@@ -46,6 +70,30 @@ func (sink *AwsParamSink) Write(ctx context.Context, name string, val string) er
 	return errors.Wrapf(err, "%s: unable to edit parameter in aws parameter store", name)
 }
 
+// Current returns the decrypted value of the parameter with the given name.
+func (sink *AwsParamSink) Current(ctx context.Context, name string) (string, error) {
+	out, err := sink.Client.SSM.Svc.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           &name,
+		WithDecryption: aws.Bool(true),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterNotFound {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "%s: unable to get parameter from aws parameter store", name)
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+// Preflight checks that the parameter with the given name exists, since
+// Write only updates existing parameters. The value is not decrypted.
+func (sink *AwsParamSink) Preflight(ctx context.Context, name string) error {
+	_, err := sink.Client.SSM.Svc.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name: &name,
+	})
+	return errors.Wrapf(err, "%s: unable to get parameter from aws parameter store", name)
+}
+
 func (sink *AwsParamSink) Kind() Kind {
 	return KindAwsParamStore
 }
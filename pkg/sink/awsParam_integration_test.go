@@ -49,7 +49,7 @@ func TestWriteToAwsParamSink_Integration(t *testing.T) {
 	r.Nil(err)
 
 	// rotate the secret
-	creds, err := (&source.DummySource{}).Read()
+	creds, err := (&source.DummySource{}).Read(ctx)
 	r.Nil(err)
 	err = sink.Write(ctx, parName, creds[source.Secret])
 	r.Nil(err)
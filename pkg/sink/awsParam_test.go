@@ -92,6 +92,24 @@ func (ts *TestSuite) TestWriteToAwsParamSink() {
 	r.Nil(err)
 }
 
+func (ts *TestSuite) TestPreflightAwsParamSink() {
+	t := ts.T()
+	r := require.New(t)
+
+	// mock GetParameterWithContext for an existing and a missing parameter
+	in := &ssm.GetParameterInput{}
+	in.SetName(parName)
+	ts.mockSSM.EXPECT().GetParameterWithContext(gomock.Any(), gomock.Eq(in)).Return(&ssm.GetParameterOutput{}, nil)
+	fakeIn := &ssm.GetParameterInput{}
+	fakeIn.SetName(fakeParName)
+	errNotFound := awserr.New(ssm.ErrCodeParameterNotFound, "", nil)
+	ts.mockSSM.EXPECT().GetParameterWithContext(gomock.Any(), gomock.Eq(fakeIn)).Return(nil, errNotFound)
+
+	s := &sink.AwsParamSink{Client: ts.awsClient}
+	r.NoError(s.Preflight(ts.ctx, parName))
+	r.Error(s.Preflight(ts.ctx, fakeParName))
+}
+
 func TestProviderSuite(t *testing.T) {
 	suite.Run(t, new(TestSuite))
 }
@@ -3,6 +3,8 @@ package sink
 import (
 	"context"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/secretsmanager"
 	cziAws "github.com/chanzuckerberg/go-misc/aws"
 	"github.com/pkg/errors"
@@ -14,13 +16,36 @@ type AwsSecretsManagerSink struct {
 	RoleArn    string         `yaml:"role_arn"`
 	ExternalID string         `yaml:"external_id"`
 	Region     string         `yaml:"region"`
-	Client     *cziAws.Client `yaml:"client"`
+	Client     *cziAws.Client `yaml:"-"`
+}
+
+func init() {
+	Register(KindAwsSecretsManager, func() Sink { return NewAwsSecretsManagerSink() })
 }
 
 func NewAwsSecretsManagerSink() *AwsSecretsManagerSink {
 	return &AwsSecretsManagerSink{}
 }
 
+// Validate checks that the role to assume and the region are set.
+func (sink *AwsSecretsManagerSink) Validate() error {
+	return validateAwsTarget(sink.RoleArn, sink.Region)
+}
+
+// Init sets up a Secrets Manager client that assumes RoleArn in Region.
+// It does nothing if a client is already set.
+func (sink *AwsSecretsManagerSink) Init() error {
+	if sink.Client != nil {
+		return nil
+	}
+	sess, err := newAwsSession(sink.Region, sink.RoleArn, sink.ExternalID)
+	if err != nil {
+		return err
+	}
+	sink.Client = cziAws.New(sess).WithSecretsManager(sess.Config)
+	return nil
+}
+
 func (sink *AwsSecretsManagerSink) Write(ctx context.Context, name string, val string) error {
 	svc := sink.Client.SecretsManager.Svc
 
@@ -33,6 +58,29 @@ func (sink *AwsSecretsManagerSink) Write(ctx context.Context, name string, val s
 	return errors.Wrapf(err, "%s: unable to store a new encrypted secret value in aws secrets manager", name)
 }
 
+// Current returns the current value of the secret with the given name.
+func (sink *AwsSecretsManagerSink) Current(ctx context.Context, name string) (string, error) {
+	out, err := sink.Client.SecretsManager.Svc.GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: &name,
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == secretsmanager.ErrCodeResourceNotFoundException {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "%s: unable to get secret value from aws secrets manager", name)
+	}
+	return aws.StringValue(out.SecretString), nil
+}
+
+// Preflight checks that the secret with the given name exists, since
+// Write only stores new values of existing secrets. The value is not read.
+func (sink *AwsSecretsManagerSink) Preflight(ctx context.Context, name string) error {
+	_, err := sink.Client.SecretsManager.Svc.DescribeSecretWithContext(ctx, &secretsmanager.DescribeSecretInput{
+		SecretId: &name,
+	})
+	return errors.Wrapf(err, "%s: unable to describe secret in aws secrets manager", name)
+}
+
 func (sink *AwsSecretsManagerSink) Kind() Kind {
 	return KindAwsSecretsManager
 }
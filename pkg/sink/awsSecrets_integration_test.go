@@ -39,7 +39,7 @@ func TestWriteToAwsSecretsManagerSink_Integration(t *testing.T) {
 	r.Nil(err)
 
 	// rotate the secret
-	creds, err := (&source.DummySource{}).Read()
+	creds, err := (&source.DummySource{}).Read(ctx)
 	r.Nil(err)
 	err = sink.Write(ctx, secretName, creds[source.Secret])
 	r.Nil(err)
@@ -53,3 +53,19 @@ func (ts *TestSuite) TestWriteToAwsSecretsManagerSinkFakeSecret() {
 	err := ts.sink.Write(ts.ctx, fakeSecretName, secretVal)
 	r.NotNil(err)
 }
+
+func (ts *TestSuite) TestPreflightAwsSecretsManagerSink() {
+	t := ts.T()
+	r := require.New(t)
+
+	// mock DescribeSecretWithContext for an existing and a missing secret
+	in := &secretsmanager.DescribeSecretInput{SecretId: aws.String(secretName)}
+	ts.mockSecretsManager.EXPECT().DescribeSecretWithContext(gomock.Any(), gomock.Eq(in)).Return(&secretsmanager.DescribeSecretOutput{}, nil)
+	fakeIn := &secretsmanager.DescribeSecretInput{SecretId: aws.String(fakeSecretName)}
+	errNotFound := awserr.New(secretsmanager.ErrCodeResourceNotFoundException, "", nil)
+	ts.mockSecretsManager.EXPECT().DescribeSecretWithContext(gomock.Any(), gomock.Eq(fakeIn)).Return(nil, errNotFound)
+
+	s := &sink.AwsSecretsManagerSink{Client: ts.awsClient}
+	r.NoError(s.Preflight(ts.ctx, secretName))
+	r.Error(s.Preflight(ts.ctx, fakeSecretName))
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -12,12 +13,18 @@ import (
 type BufSink struct {
 	BaseSink `yaml:",inline"`
 
-	buf *bytes.Buffer
+	mu   sync.Mutex
+	buf  *bytes.Buffer
+	vals map[string]string
+}
+
+func init() {
+	Register(KindBuf, func() Sink { return NewBufSink() })
 }
 
 func NewBufSink() *BufSink {
 	b := bytes.NewBuffer(nil)
-	return &BufSink{buf: b}
+	return &BufSink{buf: b, vals: map[string]string{}}
 }
 
 func (sink *BufSink) WithKeyToName(m map[string]string) *BufSink {
@@ -26,14 +33,38 @@ func (sink *BufSink) WithKeyToName(m map[string]string) *BufSink {
 }
 
 func (sink *BufSink) Read() string {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
 	return sink.buf.String()
 }
 
 func (sink *BufSink) Write(ctx context.Context, name string, val string) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
 	_, err := fmt.Fprint(sink.buf, val)
 	if err != nil {
 		return errors.Wrap(err, "unable to write secret to buffer")
 	}
+	sink.vals[name] = val
+	return nil
+}
+
+// Current returns the last value written under name.
+func (sink *BufSink) Current(ctx context.Context, name string) (string, error) {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	val, ok := sink.vals[name]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return val, nil
+}
+
+// Delete removes the value stored under name.
+func (sink *BufSink) Delete(ctx context.Context, name string) error {
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	delete(sink.vals, name)
 	return nil
 }
 
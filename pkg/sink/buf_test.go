@@ -18,3 +18,22 @@ func TestWriteToBufSink(t *testing.T) {
 	written := sink.Read()
 	r.Equal(secret, written)
 }
+
+func TestCurrentFromBufSink(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	s := sink.NewBufSink()
+	_, err := s.Current(ctx, "secret")
+	r.Equal(sink.ErrNotFound, err)
+
+	r.NoError(s.Write(ctx, "secret", "first"))
+	r.NoError(s.Write(ctx, "secret", "second"))
+	val, err := s.Current(ctx, "secret")
+	r.NoError(err)
+	r.Equal("second", val)
+
+	r.NoError(s.Delete(ctx, "secret"))
+	_, err = s.Current(ctx, "secret")
+	r.Equal(sink.ErrNotFound, err)
+}
@@ -2,18 +2,33 @@ package sink
 
 import (
 	"context"
+	"os"
+	"strings"
 
+	"github.com/hashicorp/go-multierror"
 	"github.com/jszwedko/go-circleci"
 	"github.com/pkg/errors"
 )
 
+const (
+	// CircleCI returns env var values masked as "xxxx" followed by
+	// the last four characters of the value
+	circleCiMaskedSuffixLen = 4
+
+	envCircleCIAuthToken = "CIRCLECI_AUTH_TOKEN"
+)
+
+func init() {
+	Register(KindCircleCi, func() Sink { return NewCircleCiSink() })
+}
+
 // CircleCiSink is a circleci sink
 type CircleCiSink struct {
 	BaseSink `yaml:",inline"`
 
-	Client  *circleci.Client
-	Account string
-	Repo    string
+	Client  *circleci.Client `yaml:"-"`
+	Account string           `yaml:"account"`
+	Repo    string           `yaml:"repo"`
 }
 
 func NewCircleCiSink() *CircleCiSink {
@@ -29,6 +44,32 @@ func (sink *CircleCiSink) WithCircleClient(client *circleci.Client, account stri
 	return sink
 }
 
+// Validate checks that the account and repo are set.
+func (sink *CircleCiSink) Validate() error {
+	var errs *multierror.Error
+	if sink.Account == "" {
+		errs = multierror.Append(errs, errors.New("missing account"))
+	}
+	if sink.Repo == "" {
+		errs = multierror.Append(errs, errors.New("missing repo"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up a CircleCI client authenticated with the token in
+// CIRCLECI_AUTH_TOKEN. It does nothing if a client is already set.
+func (sink *CircleCiSink) Init() error {
+	if sink.Client != nil {
+		return nil
+	}
+	token, present := os.LookupEnv(envCircleCIAuthToken)
+	if !present {
+		return errors.Errorf("missing env var: %s", envCircleCIAuthToken)
+	}
+	sink.Client = &circleci.Client{Token: token}
+	return nil
+}
+
 // Write writes the value of the env var with the specified name for the given repo
 func (sink *CircleCiSink) Write(ctx context.Context, name string, val string) error {
 	f := func(ctx context.Context) error {
@@ -38,6 +79,33 @@ func (sink *CircleCiSink) Write(ctx context.Context, name string, val string) er
 	return retry(ctx, defaultRetryAttempts, defaultRetrySleep, f)
 }
 
+// Verify checks that the env var with the specified name exists for the
+// given repo. CircleCI masks values down to their last four characters,
+// so only those are compared.
+func (sink *CircleCiSink) Verify(ctx context.Context, name string, val string) error {
+	envVars, err := sink.Client.ListEnvVars(sink.Account, sink.Repo)
+	if err != nil {
+		return errors.Wrapf(err, "could not list env vars for %s/%s", sink.Account, sink.Repo)
+	}
+	for _, e := range envVars {
+		if e.Name != name {
+			continue
+		}
+		if len(val) > circleCiMaskedSuffixLen && !strings.HasSuffix(e.Value, val[len(val)-circleCiMaskedSuffixLen:]) {
+			return errors.Errorf("env var %s in %s/%s does not hold the new value", name, sink.Account, sink.Repo)
+		}
+		return nil
+	}
+	return errors.Errorf("env var %s not found in %s/%s", name, sink.Account, sink.Repo)
+}
+
+// Preflight checks that the env vars of the given repo can be listed.
+// Write creates missing env vars, so name need not exist.
+func (sink *CircleCiSink) Preflight(ctx context.Context, name string) error {
+	_, err := sink.Client.ListEnvVars(sink.Account, sink.Repo)
+	return errors.Wrapf(err, "could not list env vars for %s/%s", sink.Account, sink.Repo)
+}
+
 // Kind returns the kind of this sink
 func (sink *CircleCiSink) Kind() Kind {
 	return KindCircleCi
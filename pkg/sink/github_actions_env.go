@@ -4,25 +4,32 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"os"
 
 	"github.com/google/go-github/v29/github"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 	"golang.org/x/crypto/nacl/box"
-	"golang.org/x/oauth2"
 )
 
 const (
 	gitHubPubKeyLen = 32
+
+	envGitHubActionsAuthToken = "GITHUB_ACTIONS_AUTH_TOKEN"
 )
 
+func init() {
+	Register(KindGithubActionsSecret, func() Sink { return NewGitHubActionsSecretSink() })
+}
+
 // GitHubActionsSecretSink holds the configuration for a Github actions secret
 type GitHubActionsSecretSink struct {
 	BaseSink `yaml:",inline"`
 
-	owner string `yaml:"owner"` // github organization owner
-	repo  string `yaml:"repo"`  // github repo
+	Owner string `yaml:"owner"` // github organization owner
+	Repo  string `yaml:"repo"`  // github repo
 
-	client *github.Client `yaml:"client"`
+	client *github.Client
 }
 
 func NewGitHubActionsSecretSink() *GitHubActionsSecretSink {
@@ -31,34 +38,55 @@ func NewGitHubActionsSecretSink() *GitHubActionsSecretSink {
 
 // WithStaticTokenAuthClient configures a github client for this sink using an oauth token
 func (s *GitHubActionsSecretSink) WithStaticTokenAuthClient(token string, owner string, repo string) *GitHubActionsSecretSink {
-	ts := oauth2.StaticTokenSource(
-		&oauth2.Token{AccessToken: token},
-	)
-	tc := oauth2.NewClient(context.Background(), ts)
-	client := github.NewClient(tc)
-	return s.WithClient(client, owner, repo)
+	return s.WithClient(newGitHubClient(token), owner, repo)
 }
 
 // WithClient configures a github client for this sink
 func (s *GitHubActionsSecretSink) WithClient(client *github.Client, owner string, repo string) *GitHubActionsSecretSink {
 	s.client = client
-	s.owner = owner
-	s.repo = repo
+	s.Owner = owner
+	s.Repo = repo
 
 	return s
 }
 
+// Validate checks that the owner and repo are set.
+func (s *GitHubActionsSecretSink) Validate() error {
+	var errs *multierror.Error
+	if s.Owner == "" {
+		errs = multierror.Append(errs, errors.New("missing owner"))
+	}
+	if s.Repo == "" {
+		errs = multierror.Append(errs, errors.New("missing repo"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up a GitHub client authenticated with the token in
+// GITHUB_ACTIONS_AUTH_TOKEN. It does nothing if a client is already set.
+func (s *GitHubActionsSecretSink) Init() error {
+	if s.client != nil {
+		return nil
+	}
+	token, present := os.LookupEnv(envGitHubActionsAuthToken)
+	if !present {
+		return errors.Errorf("missing env var: %s", envGitHubActionsAuthToken)
+	}
+	s.WithStaticTokenAuthClient(token, s.Owner, s.Repo)
+	return nil
+}
+
 // Write updates the value of the env var with the specified name
 // for the given repo.
 func (s *GitHubActionsSecretSink) Write(ctx context.Context, name string, value string) error {
 	f := func(ctx context.Context) error {
 
-		receiverPublicKey, resp, err := s.client.Actions.GetPublicKey(ctx, s.owner, s.repo)
+		receiverPublicKey, resp, err := s.client.Actions.GetPublicKey(ctx, s.Owner, s.Repo)
 		if err != nil {
-			return errors.Wrapf(err, "could not fetch %s/%s public key", s.owner, s.repo)
+			return errors.Wrapf(err, "could not fetch %s/%s public key", s.Owner, s.Repo)
 		}
 		if resp.StatusCode < 200 || 300 <= resp.StatusCode {
-			return errors.New(fmt.Sprintf("unable to get public key in Github for repo %s/%s: invalid http status: %s", s.owner, s.repo, resp.Status))
+			return errors.New(fmt.Sprintf("unable to get public key in Github for repo %s/%s: invalid http status: %s", s.Owner, s.Repo, resp.Status))
 		}
 
 		if receiverPublicKey.Key == nil || receiverPublicKey.KeyID == nil {
@@ -96,15 +124,15 @@ func (s *GitHubActionsSecretSink) Write(ctx context.Context, name string, value
 
 		resp, err = s.client.Actions.CreateOrUpdateSecret(
 			ctx,
-			s.owner,
-			s.repo,
+			s.Owner,
+			s.Repo,
 			encryptedSecret,
 		)
 		if err != nil {
 			return errors.Wrap(err, "could not write encrypted secret to GitHub")
 		}
 		if resp.StatusCode < 200 || 300 <= resp.StatusCode {
-			return errors.New(fmt.Sprintf("unable to create or update env var %s in Github for repo %s/%s: invalid http status: %s", encryptedSecret.Name, s.owner, s.repo, resp.Status))
+			return errors.New(fmt.Sprintf("unable to create or update env var %s in Github for repo %s/%s: invalid http status: %s", encryptedSecret.Name, s.Owner, s.Repo, resp.Status))
 		}
 
 		return nil
@@ -113,6 +141,34 @@ func (s *GitHubActionsSecretSink) Write(ctx context.Context, name string, value
 	return retry(ctx, defaultRetryAttempts, defaultRetrySleep, f)
 }
 
+// Verify checks that a secret with the specified name exists in the
+// given repo. GitHub never returns secret values, so they cannot be
+// compared.
+func (s *GitHubActionsSecretSink) Verify(ctx context.Context, name string, value string) error {
+	_, resp, err := s.client.Actions.GetSecret(ctx, s.Owner, s.Repo, name)
+	if err != nil {
+		return errors.Wrapf(err, "could not get secret %s in %s/%s", name, s.Owner, s.Repo)
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return errors.New(fmt.Sprintf("unable to get secret %s in Github for repo %s/%s: invalid http status: %s", name, s.Owner, s.Repo, resp.Status))
+	}
+	return nil
+}
+
+// Preflight checks that the public key used to encrypt secrets for the
+// given repo can be fetched. Write creates missing secrets, so name need
+// not exist.
+func (s *GitHubActionsSecretSink) Preflight(ctx context.Context, name string) error {
+	_, resp, err := s.client.Actions.GetPublicKey(ctx, s.Owner, s.Repo)
+	if err != nil {
+		return errors.Wrapf(err, "could not fetch %s/%s public key", s.Owner, s.Repo)
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return errors.New(fmt.Sprintf("unable to get public key in Github for repo %s/%s: invalid http status: %s", s.Owner, s.Repo, resp.Status))
+	}
+	return nil
+}
+
 // Kind returns the kind of this sink
 func (s *GitHubActionsSecretSink) Kind() Kind {
 	return KindGithubActionsSecret
@@ -0,0 +1,244 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v29/github"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	envGitHubDeployKeyAuthToken = "GITHUB_DEPLOY_KEY_AUTH_TOKEN"
+)
+
+func init() {
+	Register(KindGitHubDeployKey, func() Sink { return NewGitHubDeployKeySink() })
+}
+
+// GitHubDeployKeySink registers public keys as deploy keys of a GitHub
+// repo. The name of a credential is the title of its deploy keys.
+//
+// Each write adds a deploy key rather than replacing the previous one,
+// so that clients still using the previous private key keep working.
+// Keys replaced more than GracePeriod ago are deleted once the new key
+// has been activated, see Activate.
+type GitHubDeployKeySink struct {
+	BaseSink `yaml:",inline"`
+
+	Owner string `yaml:"owner"` // github organization owner
+	Repo  string `yaml:"repo"`  // github repo
+	// ReadWrite gives the deploy keys write access to the repo.
+	ReadWrite bool `yaml:"read_write,omitempty"`
+	// GracePeriod is how long a deploy key is kept once a newer key with
+	// the same title has been added. Zero deletes it as soon as the newer
+	// key is activated.
+	GracePeriod time.Duration `yaml:"grace_period,omitempty"`
+
+	client *github.Client
+}
+
+func NewGitHubDeployKeySink() *GitHubDeployKeySink {
+	return &GitHubDeployKeySink{}
+}
+
+// WithStaticTokenAuthClient configures a github client for this sink using an oauth token
+func (s *GitHubDeployKeySink) WithStaticTokenAuthClient(token string, owner string, repo string) *GitHubDeployKeySink {
+	return s.WithClient(newGitHubClient(token), owner, repo)
+}
+
+// WithClient configures a github client for this sink
+func (s *GitHubDeployKeySink) WithClient(client *github.Client, owner string, repo string) *GitHubDeployKeySink {
+	s.client = client
+	s.Owner = owner
+	s.Repo = repo
+	return s
+}
+
+// Validate checks that the owner and repo are set.
+func (s *GitHubDeployKeySink) Validate() error {
+	var errs *multierror.Error
+	if s.Owner == "" {
+		errs = multierror.Append(errs, errors.New("missing owner"))
+	}
+	if s.Repo == "" {
+		errs = multierror.Append(errs, errors.New("missing repo"))
+	}
+	if s.GracePeriod < 0 {
+		errs = multierror.Append(errs, errors.New("grace_period must not be negative"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up a GitHub client authenticated with the token in
+// GITHUB_DEPLOY_KEY_AUTH_TOKEN. It does nothing if a client is already set.
+func (s *GitHubDeployKeySink) Init() error {
+	if s.client != nil {
+		return nil
+	}
+	token, present := os.LookupEnv(envGitHubDeployKeyAuthToken)
+	if !present {
+		return errors.Errorf("missing env var: %s", envGitHubDeployKeyAuthToken)
+	}
+	s.WithStaticTokenAuthClient(token, s.Owner, s.Repo)
+	return nil
+}
+
+// Write adds the public key value as a deploy key titled name. The keys
+// it replaces are kept until Activate.
+//
+// If value is already a deploy key titled name, e.g. when a rotation is
+// rolled back, the keys added after it are deleted instead.
+func (s *GitHubDeployKeySink) Write(ctx context.Context, name string, value string) error {
+	keys, err := s.keys(ctx, name)
+	if err != nil {
+		return err
+	}
+	for i, key := range keys {
+		if sameSSHKey(key.GetKey(), value) {
+			return s.deleteKeys(ctx, keys[i+1:])
+		}
+	}
+
+	f := func(ctx context.Context) error {
+		_, resp, err := s.client.Repositories.CreateKey(ctx, s.Owner, s.Repo, &github.Key{
+			Title:    github.String(name),
+			Key:      github.String(value),
+			ReadOnly: github.Bool(!s.ReadWrite),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "could not add deploy key %s to %s/%s", name, s.Owner, s.Repo)
+		}
+		if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+			return errors.New(fmt.Sprintf("unable to add deploy key %s in Github for repo %s/%s: invalid http status: %s", name, s.Owner, s.Repo, resp.Status))
+		}
+		return nil
+	}
+	return retry(ctx, defaultRetryAttempts, defaultRetrySleep, f)
+}
+
+// Activate deletes the deploy keys titled name that value replaced more
+// than GracePeriod ago. It is called once the new private key has reached
+// every sink, so that clients are not left with a deleted key before
+// they can read the new one.
+func (s *GitHubDeployKeySink) Activate(ctx context.Context, name string, value string) error {
+	keys, err := s.keys(ctx, name)
+	if err != nil {
+		return err
+	}
+	newest := -1
+	for i, key := range keys {
+		if sameSSHKey(key.GetKey(), value) {
+			newest = i
+		}
+	}
+	if newest < 0 {
+		return errors.Errorf("deploy key %s of %s/%s is not the new key", name, s.Owner, s.Repo)
+	}
+
+	// each key was replaced when the next one was added
+	var expired []*github.Key
+	for i, key := range keys[:newest] {
+		if time.Since(keys[i+1].GetCreatedAt().Time) >= s.GracePeriod {
+			expired = append(expired, key)
+		}
+	}
+	return s.deleteKeys(ctx, expired)
+}
+
+// keys returns the deploy keys titled name, oldest first.
+func (s *GitHubDeployKeySink) keys(ctx context.Context, name string) ([]*github.Key, error) {
+	var keys []*github.Key
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := s.client.Repositories.ListKeys(ctx, s.Owner, s.Repo, opts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not list deploy keys of %s/%s", s.Owner, s.Repo)
+		}
+		for _, key := range page {
+			if key.GetTitle() == name {
+				keys = append(keys, key)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].GetCreatedAt().Before(keys[j].GetCreatedAt().Time)
+	})
+	return keys, nil
+}
+
+func (s *GitHubDeployKeySink) deleteKeys(ctx context.Context, keys []*github.Key) error {
+	var errs *multierror.Error
+	for _, key := range keys {
+		resp, err := s.client.Repositories.DeleteKey(ctx, s.Owner, s.Repo, key.GetID())
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "could not delete deploy key %d of %s/%s", key.GetID(), s.Owner, s.Repo))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// Delete deletes every deploy key titled name.
+func (s *GitHubDeployKeySink) Delete(ctx context.Context, name string) error {
+	keys, err := s.keys(ctx, name)
+	if err != nil {
+		return err
+	}
+	return s.deleteKeys(ctx, keys)
+}
+
+// Current returns the newest deploy key titled name.
+func (s *GitHubDeployKeySink) Current(ctx context.Context, name string) (string, error) {
+	keys, err := s.keys(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	if len(keys) == 0 {
+		return "", ErrNotFound
+	}
+	return keys[len(keys)-1].GetKey(), nil
+}
+
+// Verify checks that value is the newest deploy key titled name. GitHub
+// drops the comments of keys, so they are not compared.
+func (s *GitHubDeployKeySink) Verify(ctx context.Context, name string, value string) error {
+	current, err := s.Current(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !sameSSHKey(current, value) {
+		return errors.Errorf("deploy key %s of %s/%s is not the new key", name, s.Owner, s.Repo)
+	}
+	return nil
+}
+
+// Preflight checks that the deploy keys of the repo can be listed.
+func (s *GitHubDeployKeySink) Preflight(ctx context.Context, name string) error {
+	_, err := s.keys(ctx, name)
+	return err
+}
+
+// Kind returns the kind of this sink
+func (s *GitHubDeployKeySink) Kind() Kind {
+	return KindGitHubDeployKey
+}
+
+// sameSSHKey reports whether two public keys in the authorized_keys
+// format are the same key, ignoring their comments.
+func sameSSHKey(a string, b string) bool {
+	fa, fb := strings.Fields(a), strings.Fields(b)
+	return len(fa) >= 2 && len(fb) >= 2 && fa[0] == fb[0] && fa[1] == fb[1]
+}
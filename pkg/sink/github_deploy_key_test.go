@@ -0,0 +1,164 @@
+package sink_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/google/go-github/v29/github"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	deployKeyOwner = "testo_owner"
+	deployKeyRepo  = "testo_repo"
+	deployKeyTitle = "ci"
+)
+
+// fakeDeployKeys serves the deploy keys API of a single repo.
+type fakeDeployKeys struct {
+	mu     sync.Mutex
+	keys   []*github.Key
+	nextID int64
+}
+
+func (f *fakeDeployKeys) add(title string, key string, created time.Time) {
+	f.nextID++
+	f.keys = append(f.keys, &github.Key{
+		ID:        github.Int64(f.nextID),
+		Title:     github.String(title),
+		Key:       github.String(key),
+		ReadOnly:  github.Bool(true),
+		CreatedAt: &github.Timestamp{Time: created},
+	})
+}
+
+func (f *fakeDeployKeys) list() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for _, k := range f.keys {
+		keys = append(keys, k.GetKey())
+	}
+	return keys
+}
+
+func (f *fakeDeployKeys) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := "/repos/" + deployKeyOwner + "/" + deployKeyRepo + "/keys"
+	switch {
+	case r.URL.Path == prefix && r.Method == http.MethodGet:
+		_ = json.NewEncoder(w).Encode(f.keys)
+	case r.URL.Path == prefix && r.Method == http.MethodPost:
+		var key github.Key
+		_ = json.NewDecoder(r.Body).Decode(&key)
+		for _, k := range f.keys {
+			if k.GetKey() == strings.Join(strings.Fields(key.GetKey())[:2], " ") {
+				http.Error(w, `{"message": "key is already in use"}`, http.StatusUnprocessableEntity)
+				return
+			}
+		}
+		// GitHub drops the comment of keys
+		f.add(key.GetTitle(), strings.Join(strings.Fields(key.GetKey())[:2], " "), time.Now())
+		f.keys[len(f.keys)-1].ReadOnly = key.ReadOnly
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(f.keys[len(f.keys)-1])
+	case strings.HasPrefix(r.URL.Path, prefix+"/") && r.Method == http.MethodDelete:
+		id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, prefix+"/"), 10, 64)
+		for i, k := range f.keys {
+			if k.GetID() == id {
+				f.keys = append(f.keys[:i], f.keys[i+1:]...)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newDeployKeySink(r *require.Assertions, fake *fakeDeployKeys) (*sink.GitHubDeployKeySink, func()) {
+	server := httptest.NewServer(fake)
+	client := github.NewClient(nil)
+	u, err := url.Parse(server.URL + "/")
+	r.NoError(err)
+	client.BaseURL = u
+	s := sink.NewGitHubDeployKeySink().WithClient(client, deployKeyOwner, deployKeyRepo)
+	return s, server.Close
+}
+
+func TestGitHubDeployKeySinkGracePeriod(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	fake := &fakeDeployKeys{}
+	now := time.Now()
+	// a key replaced two days ago, one replaced an hour ago and the one in use
+	fake.add(deployKeyTitle, "ssh-ed25519 AAAAold", now.Add(-72*time.Hour))
+	fake.add(deployKeyTitle, "ssh-ed25519 AAAAprev", now.Add(-48*time.Hour))
+	fake.add(deployKeyTitle, "ssh-ed25519 AAAAcur", now.Add(-time.Hour))
+	fake.add("other", "ssh-ed25519 AAAAother", now.Add(-72*time.Hour))
+	s, teardown := newDeployKeySink(r, fake)
+	defer teardown()
+	s.GracePeriod = 24 * time.Hour
+	r.NoError(s.Validate())
+	r.NoError(s.Preflight(ctx, deployKeyTitle))
+
+	cur, err := s.Current(ctx, deployKeyTitle)
+	r.NoError(err)
+	r.Equal("ssh-ed25519 AAAAcur", cur)
+
+	// keys are only deleted once the new one is activated
+	r.NoError(s.Write(ctx, deployKeyTitle, "ssh-ed25519 AAAAnew rotator@example"))
+	r.Equal([]string{"ssh-ed25519 AAAAold", "ssh-ed25519 AAAAprev", "ssh-ed25519 AAAAcur", "ssh-ed25519 AAAAother", "ssh-ed25519 AAAAnew"}, fake.list())
+	r.NoError(s.Verify(ctx, deployKeyTitle, "ssh-ed25519 AAAAnew rotator@example"))
+	r.Error(s.Verify(ctx, deployKeyTitle, "ssh-ed25519 AAAAcur"))
+
+	// restoring the previous key deletes the new one
+	r.NoError(s.Write(ctx, deployKeyTitle, cur))
+	r.Equal([]string{"ssh-ed25519 AAAAold", "ssh-ed25519 AAAAprev", "ssh-ed25519 AAAAcur", "ssh-ed25519 AAAAother"}, fake.list())
+	r.Error(s.Activate(ctx, deployKeyTitle, "ssh-ed25519 AAAAnew rotator@example"))
+
+	r.NoError(s.Write(ctx, deployKeyTitle, "ssh-ed25519 AAAAnew rotator@example"))
+	r.NoError(s.Activate(ctx, deployKeyTitle, "ssh-ed25519 AAAAnew rotator@example"))
+	r.Equal([]string{"ssh-ed25519 AAAAprev", "ssh-ed25519 AAAAcur", "ssh-ed25519 AAAAother", "ssh-ed25519 AAAAnew"}, fake.list())
+}
+
+func TestGitHubDeployKeySinkNoGracePeriod(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	fake := &fakeDeployKeys{}
+	s, teardown := newDeployKeySink(r, fake)
+	defer teardown()
+
+	_, err := s.Current(ctx, deployKeyTitle)
+	r.Equal(sink.ErrNotFound, err)
+
+	r.NoError(s.Write(ctx, deployKeyTitle, "ssh-ed25519 AAAAfirst"))
+	r.NoError(s.Write(ctx, deployKeyTitle, "ssh-ed25519 AAAAsecond"))
+	r.Equal([]string{"ssh-ed25519 AAAAfirst", "ssh-ed25519 AAAAsecond"}, fake.list())
+	r.NoError(s.Activate(ctx, deployKeyTitle, "ssh-ed25519 AAAAsecond"))
+	r.Equal([]string{"ssh-ed25519 AAAAsecond"}, fake.list())
+	r.True(fake.keys[0].GetReadOnly())
+
+	// a rotation that added the first key is rolled back by deleting it
+	r.NoError(s.Delete(ctx, deployKeyTitle))
+	r.Empty(fake.list())
+}
+
+func TestGitHubDeployKeySinkValidate(t *testing.T) {
+	r := require.New(t)
+
+	r.Error(sink.NewGitHubDeployKeySink().Validate())
+	s := &sink.GitHubDeployKeySink{Owner: "o", Repo: "r", GracePeriod: -time.Hour}
+	r.Error(s.Validate())
+}
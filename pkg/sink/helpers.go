@@ -4,6 +4,14 @@ import (
 	"context"
 	"math/rand"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/google/go-github/v29/github"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
 )
 
 const (
@@ -11,6 +19,8 @@ const (
 	defaultRetrySleep    = time.Second
 )
 
+// retry calls f until it succeeds, it has been called attempts times,
+// or ctx is done, sleeping with jitter between attempts.
 func retry(ctx context.Context, attempts int, sleep time.Duration, f func(context.Context) error) error {
 	var err error
 	for i := 0; i < attempts; i++ {
@@ -18,9 +28,56 @@ func retry(ctx context.Context, attempts int, sleep time.Duration, f func(contex
 		if err == nil {
 			return nil
 		}
+		if i == attempts-1 {
+			break
+		}
 
 		jitter := time.Duration(rand.Int63n(int64(sleep)))
-		time.Sleep(sleep + jitter)
+		timer := time.NewTimer(sleep + jitter)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrapf(err, "stopped retrying: %s", ctx.Err())
+		case <-timer.C:
+		}
 	}
 	return err
 }
+
+// validateAwsTarget checks the settings shared by the AWS sinks.
+func validateAwsTarget(roleArn string, region string) error {
+	var errs *multierror.Error
+	if roleArn == "" {
+		errs = multierror.Append(errs, errors.New("missing role_arn"))
+	}
+	if region == "" {
+		errs = multierror.Append(errs, errors.New("missing region"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// newAwsSession returns a session in region that assumes roleArn,
+// passing along externalID if set.
+func newAwsSession(region string, roleArn string, externalID string) (*session.Session, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(region), // SSM and Secrets Manager functions require region configuration
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
+	}
+	sess.Config.Credentials = stscreds.NewCredentials(sess, roleArn, func(p *stscreds.AssumeRoleProvider) {
+		if externalID != "" {
+			p.ExternalID = aws.String(externalID)
+		}
+	})
+	return sess, nil
+}
+
+// newGitHubClient returns a GitHub client authenticated with an oauth
+// token.
+func newGitHubClient(token string) *github.Client {
+	ts := oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: token},
+	)
+	return github.NewClient(oauth2.NewClient(context.Background(), ts))
+}
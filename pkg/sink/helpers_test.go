@@ -0,0 +1,43 @@
+package sink
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetrySucceeds(t *testing.T) {
+	r := require.New(t)
+
+	calls := 0
+	err := retry(context.Background(), 3, time.Millisecond, func(ctx context.Context) error {
+		calls++
+		if calls < 2 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	r.NoError(err)
+	r.Equal(2, calls)
+}
+
+func TestRetryStopsWhenContextDone(t *testing.T) {
+	r := require.New(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	calls := 0
+	start := time.Now()
+	err := retry(ctx, 5, time.Hour, func(ctx context.Context) error {
+		calls++
+		return errors.New("always fails")
+	})
+	r.Error(err)
+	r.Contains(err.Error(), context.DeadlineExceeded.Error())
+	r.Equal(1, calls)
+	r.True(time.Since(start) < time.Minute)
+}
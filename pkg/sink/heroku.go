@@ -2,10 +2,13 @@ package sink
 
 import (
 	"context"
+	"net/http"
 
+	"github.com/chanzuckerberg/rotator/pkg/redact"
+	"github.com/chanzuckerberg/rotator/pkg/util"
 	heroku "github.com/heroku/heroku-go/v5"
+	"github.com/kelseyhightower/envconfig"
 	"github.com/pkg/errors"
-	"github.com/sirupsen/logrus"
 )
 
 type HerokuServiceIface interface {
@@ -15,14 +18,55 @@ type HerokuServiceIface interface {
 
 type HerokuSink struct {
 	BaseSink    `yaml:",inline"`
-	Client      HerokuServiceIface `yaml:"client"`
-	AppIdentity string             `yaml:"AppIdentity"`
+	Client      HerokuServiceIface `yaml:"-"`
+	AppIdentity string             `yaml:"app"`
+}
+
+// herokuEnv holds the environment variables the Heroku client is set up
+// from, i.e. HEROKU_BEARER_TOKEN.
+type herokuEnv struct {
+	Bearer_Token string
+}
+
+func init() {
+	Register(KindHeroku, func() Sink { return NewHerokuSink() })
 }
 
 func NewHerokuSink() *HerokuSink {
 	return &HerokuSink{}
 }
 
+// Validate checks that the app is set.
+func (sink *HerokuSink) Validate() error {
+	if sink.AppIdentity == "" {
+		return errors.New("missing app")
+	}
+	return nil
+}
+
+// Init sets up a Heroku client authenticated with the token in
+// HEROKU_BEARER_TOKEN. It does nothing if a client is already set.
+func (sink *HerokuSink) Init() error {
+	if sink.Client != nil {
+		return nil
+	}
+	env := &herokuEnv{}
+	err := envconfig.Process("heroku", env)
+	if err != nil {
+		return errors.Wrap(err, "Unable to load all the heroku environment variables")
+	}
+
+	headers := http.Header{}
+	headers.Set("Accept", "application/vnd.heroku+json; version=3")
+	transport := heroku.Transport{
+		BearerToken:       env.Bearer_Token,
+		AdditionalHeaders: headers,
+	}
+	heroku.DefaultClient.Transport = &transport
+	sink.Client = heroku.NewService(heroku.DefaultClient)
+	return nil
+}
+
 func (sink *HerokuSink) WithHerokuClient(client HerokuServiceIface) *HerokuSink {
 	sink.Client = client
 	return sink
@@ -43,13 +87,52 @@ func (sink *HerokuSink) Write(ctx context.Context, name string, val string) erro
 
 	_, err := sink.Client.ConfigVarUpdate(ctx, sink.AppIdentity, varUpdates)
 	if err != nil {
-		return errors.Wrapf(err, "Unable to update Config var with %s:%s", name, val)
+		return errors.Wrapf(err, "Unable to update Config var %s", name)
 	}
 
-	logrus.Debugf("sink:Heroku: \n name: %s, val: %#v\n", name, val)
+	util.Logger(ctx).Debugf("sink:Heroku: \n name: %s, val: %#v\n", name, redact.Value(val))
 	return nil
 }
 
+// Current returns the value of the config var with the specified name
+func (sink *HerokuSink) Current(ctx context.Context, name string) (string, error) {
+	if sink.Client == nil {
+		return "", errors.New("Heroku Client not set")
+	}
+	vars, err := sink.Client.ConfigVarInfoForApp(ctx, sink.AppIdentity)
+	if err != nil {
+		return "", errors.Wrapf(err, "Unable to read config vars for %s", sink.AppIdentity)
+	}
+	val, ok := vars[name]
+	if !ok || val == nil {
+		return "", ErrNotFound
+	}
+	return *val, nil
+}
+
+// Delete removes the config var with the specified name
+func (sink *HerokuSink) Delete(ctx context.Context, name string) error {
+	if sink.Client == nil {
+		return errors.New("Heroku Client not set")
+	}
+	// config vars set to null are removed
+	_, err := sink.Client.ConfigVarUpdate(ctx, sink.AppIdentity, map[string]*string{name: nil})
+	return errors.Wrapf(err, "Unable to remove Config var %s", name)
+}
+
+// Preflight checks that the config vars of the app can be read. Write
+// creates missing config vars, so name need not exist.
+func (sink *HerokuSink) Preflight(ctx context.Context, name string) error {
+	if sink.Client == nil {
+		return errors.New("Heroku Client not set")
+	}
+	if sink.AppIdentity == "" {
+		return errors.New("Heroku AppIdentity not set")
+	}
+	_, err := sink.Client.ConfigVarInfoForApp(ctx, sink.AppIdentity)
+	return errors.Wrapf(err, "Unable to read config vars for %s", sink.AppIdentity)
+}
+
 // Kind returns the kind of this sink
 func (sink *HerokuSink) Kind() Kind {
 	return KindHeroku
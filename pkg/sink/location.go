@@ -0,0 +1,69 @@
+package sink
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Location is a sink embedded in the config of a source, for the source
+// to read values from, e.g. a CA certificate to issue certificates
+// with. Its key_to_name maps the keys the source reads to their names in
+// the sink, which must be able to read back values.
+type Location struct {
+	Sink
+}
+
+// UnmarshalYAML decodes the sink config in node.
+func (l *Location) UnmarshalYAML(node *yaml.Node) error {
+	s, err := Decode(node)
+	if err != nil {
+		return err
+	}
+	l.Sink = s
+	return nil
+}
+
+// MarshalYAML returns the config of the sink.
+func (l Location) MarshalYAML() (interface{}, error) {
+	return Encode(l.Sink)
+}
+
+// Validate checks that the sink can read back values, that its
+// key_to_name maps each of keys, and the config of the sink itself.
+func (l *Location) Validate(keys ...string) error {
+	if _, ok := l.Sink.(Restorer); !ok {
+		return errors.Errorf("%s sink cannot read back values", l.Kind())
+	}
+	for _, key := range keys {
+		if l.GetKeyToName()[key] == "" {
+			return errors.Errorf("missing %s in key_to_name of %s sink", key, l.Kind())
+		}
+	}
+	if v, ok := l.Sink.(Validator); ok {
+		return errors.Wrapf(v.Validate(), "%s sink", l.Kind())
+	}
+	return nil
+}
+
+// Init sets up the sink.
+func (l *Location) Init() error {
+	if i, ok := l.Sink.(Initializer); ok {
+		return errors.Wrapf(i.Init(), "%s sink", l.Kind())
+	}
+	return nil
+}
+
+// Read returns the value of key in the sink.
+func (l *Location) Read(ctx context.Context, key string) (string, error) {
+	name := l.GetKeyToName()[key]
+	val, err := l.Sink.(Restorer).Current(ctx, name)
+	return val, errors.Wrapf(err, "unable to read %s from %s sink", name, l.Kind())
+}
+
+// Store writes val under the name of key in the sink.
+func (l *Location) Store(ctx context.Context, key string, val string) error {
+	name := l.GetKeyToName()[key]
+	return errors.Wrapf(l.Sink.Write(ctx, name, val), "unable to write %s to %s sink", name, l.Kind())
+}
@@ -0,0 +1,119 @@
+package sink
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Factory returns a new, unconfigured sink of a kind. The sink
+// config is decoded into the value it returns, so the fields of the
+// sink are its config.
+type Factory func() Sink
+
+// Validator is implemented by sinks whose config needs checks beyond
+// decoding, e.g. that required fields are set. Validate must not reach
+// out to the network or read credentials.
+type Validator interface {
+	Validate() error
+}
+
+// Initializer is implemented by sinks that need clients or credentials
+// set up before use. Init is called once the config is valid.
+type Initializer interface {
+	Init() error
+}
+
+var registry = struct {
+	sync.RWMutex
+	factories map[Kind]Factory
+}{factories: map[Kind]Factory{}}
+
+// Register makes a sink kind available to config files. Kinds defined
+// outside rotator register themselves from an init function, the same
+// way the built-in kinds do. Register panics if kind is registered twice.
+func Register(kind Kind, factory Factory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, ok := registry.factories[kind]; ok {
+		panic(fmt.Sprintf("sink: Register called twice for kind %s", kind))
+	}
+	registry.factories[kind] = factory
+}
+
+// New returns a new, unconfigured sink of the given kind.
+func New(kind Kind) (Sink, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	factory, ok := registry.factories[kind]
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownKind, "%q", kind)
+	}
+	return factory(), nil
+}
+
+// Kinds returns the registered kinds in alphabetical order.
+func Kinds() []Kind {
+	registry.RLock()
+	defer registry.RUnlock()
+	kinds := make([]Kind, 0, len(registry.factories))
+	for kind := range registry.factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Slice(kinds, func(i, j int) bool { return kinds[i] < kinds[j] })
+	return kinds
+}
+
+// Decode returns the sink described by node, a sink config
+// parsed from YAML. Fields the kind does not have are reported in a
+// *yaml.TypeError, with their line numbers. The sink is neither
+// validated nor initialized.
+func Decode(node *yaml.Node) (Sink, error) {
+	var head struct {
+		Kind Kind `yaml:"kind"`
+	}
+	err := node.Decode(&head)
+	if err != nil {
+		return nil, errors.Wrap(err, "incorrect sink format in secret config")
+	}
+	if head.Kind == "" {
+		return nil, errors.Errorf("line %d: missing kind in sink config", node.Line)
+	}
+
+	s, err := New(head.Kind)
+	if err != nil {
+		return nil, errors.Wrapf(err, "line %d", node.Line)
+	}
+	err = util.DecodeStrict(node, s, "kind")
+	if err != nil {
+		msgs := util.ErrorMessages(err)
+		for i := range msgs {
+			msgs[i] = fmt.Sprintf("%s in %s sink config", msgs[i], head.Kind)
+		}
+		return nil, &yaml.TypeError{Errors: msgs}
+	}
+	if s.GetKeyToName() == nil {
+		return nil, errors.Errorf("line %d: missing key_to_name in %s sink config", node.Line, head.Kind)
+	}
+	return s, nil
+}
+
+// Encode returns the config of s, including its kind, ready to be
+// marshalled to YAML. Decoding the result returns an equal sink.
+func Encode(s Sink) (map[string]interface{}, error) {
+	b, err := yaml.Marshal(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to marshal %s sink", s.Kind())
+	}
+	fields := map[string]interface{}{}
+	err = yaml.Unmarshal(b, &fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to marshal %s sink", s.Kind())
+	}
+	fields["kind"] = string(s.Kind())
+	return fields, nil
+}
@@ -2,7 +2,11 @@ package sink
 
 import (
 	"context"
-	"fmt"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
 )
 
 // Sink is the interface for all credential sinks.
@@ -23,8 +27,64 @@ type Sink interface {
 	Kind() Kind
 }
 
+// Restorer is implemented by sinks that can read back the value
+// stored under a name. Before writing a new credential, the current
+// values are saved so that they can be written back if the rotation
+// is rolled back. They are also used to verify writes.
+//
+// Current returns ErrNotFound if the sink holds no value under name.
+type Restorer interface {
+	Current(ctx context.Context, name string) (string, error)
+}
+
+// Deleter is implemented by sinks that can remove the value stored
+// under a name. If a sink held no value before a write, the value is
+// deleted again if the rotation is rolled back.
+type Deleter interface {
+	Delete(ctx context.Context, name string) error
+}
+
+// Verifier is implemented by sinks that cannot read back the values
+// they store but can still check that a write landed.
+//
+// Verify returns an error if the sink does not hold val under name,
+// as far as the sink is able to tell.
+type Verifier interface {
+	Verify(ctx context.Context, name string, val string) error
+}
+
+// Activator is implemented by sinks that keep the values a write
+// replaced, e.g. so that clients still using them keep working.
+//
+// Activate is called for each value written once the new credential has
+// been written to and verified in every sink and activated at the
+// source. It retires the values val replaced under name, if the sink
+// needs to.
+type Activator interface {
+	Activate(ctx context.Context, name string, val string) error
+}
+
+// Preflighter is implemented by sinks that can check, without changing
+// anything, that a write would succeed: that the credentials of the sink
+// work, that they allow access to the target and that the target exists.
+//
+// Preflight returns an error describing the first problem found with
+// writing to name.
+type Preflighter interface {
+	Preflight(ctx context.Context, name string) error
+}
+
 type Kind string
 
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+const (
+	ErrNotFound    Error = "no value stored under this name"
+	ErrUnknownKind Error = "unknown sink"
+)
+
 const (
 	KindBuf                 Kind = "Buffer"
 	KindTravisCi            Kind = "TravisCI"
@@ -34,6 +94,7 @@ const (
 	KindAwsSecretsManager   Kind = "AWSSecretsManager"
 	KindStdout              Kind = "Stdout"
 	KindHeroku              Kind = "Heroku"
+	KindGitHubDeployKey     Kind = "GitHubDeployKey"
 )
 
 type Sinks []Sink
@@ -41,79 +102,38 @@ type Sinks []Sink
 func (sinks Sinks) MarshalYAML() (interface{}, error) {
 	var yamlSinks []map[string]interface{}
 	for _, s := range sinks {
-		switch s.Kind() {
-		case KindStdout:
-			sink := s.(*StdoutSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"key_to_name": sink.KeyToName,
-					"kind":        string(KindStdout),
-				})
-		case KindBuf:
-			sink := s.(*BufSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"key_to_name": sink.KeyToName,
-					"kind":        string(KindBuf),
-				})
-		case KindTravisCi:
-			sink := s.(*TravisCiSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"key_to_name": sink.KeyToName,
-					"kind":        string(KindTravisCi),
-					"repo_slug":   sink.RepoSlug,
-				})
-		case KindAwsParamStore:
-			sink := s.(*AwsParamSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"kind":        string(KindAwsParamStore),
-					"role_arn":    sink.RoleArn,
-					"external_id": sink.ExternalID,
-					"region":      sink.Region,
-				})
-		case KindAwsSecretsManager:
-			sink := s.(*AwsSecretsManagerSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"kind":        string(KindAwsSecretsManager),
-					"role_arn":    sink.RoleArn,
-					"external_id": sink.ExternalID,
-					"region":      sink.Region,
-				})
-		case KindCircleCi:
-			sink := s.(*CircleCiSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"kind":        string(KindCircleCi),
-					"key_to_name": sink.KeyToName,
-					"account":     sink.Account,
-					"repo":        sink.Repo,
-				})
-		case KindGithubActionsSecret:
-			sink := s.(*GitHubActionsSecretSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"kind":        string(KindGithubActionsSecret),
-					"key_to_name": sink.KeyToName,
-					"repo":        sink.repo,
-					"owner":       sink.owner,
-				})
-		case KindHeroku:
-			sink := s.(*HerokuSink)
-			yamlSinks = append(yamlSinks,
-				map[string]interface{}{
-					"kind":        string(KindHeroku),
-					"key_to_name": sink.KeyToName,
-				})
-		default:
-			return nil, fmt.Errorf("unknown sink kind: %s", s.Kind())
+		fields, err := Encode(s)
+		if err != nil {
+			return nil, err
 		}
+		yamlSinks = append(yamlSinks, fields)
 	}
 	return yamlSinks, nil
 }
 
+// Fingerprint returns a digest of the configuration of s, including its
+// kind, target and key_to_name mapping. It changes whenever the sink
+// would be written to differently.
+func Fingerprint(s Sink) (string, error) {
+	return FingerprintAs(s, s.GetKeyToName())
+}
+
+// FingerprintAs is Fingerprint of s writing to the names in keyToName
+// rather than those in its key_to_name, e.g. once they are rendered.
+func FingerprintAs(s Sink, keyToName map[string]string) (string, error) {
+	fields, err := Encode(s)
+	if err != nil {
+		return "", err
+	}
+	fields["key_to_name"] = keyToName
+	b, err := yaml.Marshal([]map[string]interface{}{fields})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to marshal %s sink", s.Kind())
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
 func (sink *BaseSink) WithKeyToName(m map[string]string) *BaseSink {
 	sink.KeyToName = m
 	return sink
@@ -15,6 +15,7 @@ var sink_types = []Kind{
 	KindAwsSecretsManager,
 	KindStdout,
 	KindHeroku,
+	KindGitHubDeployKey,
 }
 
 func TestMarshalSinks(t *testing.T) {
@@ -30,10 +31,17 @@ func TestMarshalSinks(t *testing.T) {
 		NewAwsSecretsManagerSink(),
 		NewStdoutSink(),
 		NewHerokuSink(),
+		NewGitHubDeployKeySink(),
 	}
 	yamlSinks, err := allSinks.MarshalYAML()
 	r.NoError(err)
 	yamlSinksList, ok := yamlSinks.([]map[string]interface{})
 	r.True(ok)
 	r.Equal(len(sink_types), len(yamlSinksList))
+	for i, s := range yamlSinksList {
+		r.Equal(string(allSinks[i].Kind()), s["kind"])
+	}
+
+	// every kind is registered
+	r.ElementsMatch(sink_types, Kinds())
 }
@@ -3,11 +3,22 @@ package sink
 import (
 	"context"
 	"fmt"
+
+	"github.com/chanzuckerberg/rotator/pkg/redact"
 )
 
-// A StdoutSink
+// A StdoutSink prints the names it is written to. Values are redacted
+// unless Reveal is set.
 type StdoutSink struct {
 	BaseSink `yaml:",inline"`
+
+	// Reveal prints values in the clear. It is set from the command
+	// line, never from the config file.
+	Reveal bool `yaml:"-"`
+}
+
+func init() {
+	Register(KindStdout, func() Sink { return NewStdoutSink() })
 }
 
 func NewStdoutSink() *StdoutSink {
@@ -19,8 +30,18 @@ func (sink *StdoutSink) WithKeyToName(m map[string]string) *StdoutSink {
 	return sink
 }
 
+// WithReveal sets whether values are printed in the clear.
+func (sink *StdoutSink) WithReveal(reveal bool) *StdoutSink {
+	sink.Reveal = reveal
+	return sink
+}
+
 func (sink *StdoutSink) Write(ctx context.Context, name string, val string) error {
-	fmt.Printf("sink:stdout: \n name: %s, val: %#v\n", name, val)
+	var v interface{} = redact.Value(val)
+	if sink.Reveal {
+		v = val
+	}
+	fmt.Printf("sink:stdout: \n name: %s, val: %#v\n", name, v)
 	return nil
 }
 
@@ -3,6 +3,7 @@ package sink
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/pkg/errors"
 	"github.com/shuheiktgw/go-travis"
@@ -11,14 +12,20 @@ import (
 const (
 	// TravisBaseURL is the base url for travisCI
 	TravisBaseURL string = travis.ApiComUrl
+
+	envTravisCIAuthToken = "TRAVIS_API_AUTH_TOKEN"
 )
 
+func init() {
+	Register(KindTravisCi, func() Sink { return NewTravisCiSink() })
+}
+
 // TravisCiSink is a travisCi sink
 type TravisCiSink struct {
 	BaseSink `yaml:",inline"`
 
 	RepoSlug string         `yaml:"repo_slug"`
-	Client   *travis.Client `yaml:"client"`
+	Client   *travis.Client `yaml:"-"`
 }
 
 func NewTravisCiSink() *TravisCiSink {
@@ -31,6 +38,28 @@ func (sink *TravisCiSink) WithTravisClient(client *travis.Client) *TravisCiSink
 	return sink
 }
 
+// Validate checks that the repository slug is set.
+func (sink *TravisCiSink) Validate() error {
+	if sink.RepoSlug == "" {
+		return errors.New("missing repo_slug")
+	}
+	return nil
+}
+
+// Init sets up a Travis CI client authenticated with the token in
+// TRAVIS_API_AUTH_TOKEN. It does nothing if a client is already set.
+func (sink *TravisCiSink) Init() error {
+	if sink.Client != nil {
+		return nil
+	}
+	token, present := os.LookupEnv(envTravisCIAuthToken)
+	if !present {
+		return errors.Errorf("missing env var: %s", envTravisCIAuthToken)
+	}
+	sink.Client = travis.NewClient(TravisBaseURL, token)
+	return nil
+}
+
 // Write updates the value of the env var with the specified name
 // for the given repository slug using the Travis CI client.
 func (sink *TravisCiSink) Write(ctx context.Context, name string, val string) error {
@@ -86,6 +115,42 @@ func (sink *TravisCiSink) update(ctx context.Context, body *travis.EnvVarBody, e
 	return retry(ctx, defaultRetryAttempts, defaultRetrySleep, f)
 }
 
+// Verify checks that an env var with the specified name exists for
+// the given repository slug. Travis CI only returns the values of
+// public env vars, so the value is only compared for those.
+func (sink *TravisCiSink) Verify(ctx context.Context, name string, val string) error {
+	esList, resp, err := sink.Client.EnvVars.ListByRepoSlug(ctx, sink.RepoSlug)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list env vars in Travis CI for repo %s", sink.RepoSlug)
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return errors.New(fmt.Sprintf("unable to list env vars in Travis CI for repo %s: invalid http status: %s", sink.RepoSlug, resp.Status))
+	}
+	for _, e := range esList {
+		if e.Name == nil || *e.Name != name {
+			continue
+		}
+		if e.Public != nil && *e.Public && (e.Value == nil || *e.Value != val) {
+			return errors.Errorf("env var %s in Travis CI repo %s does not hold the new value", name, sink.RepoSlug)
+		}
+		return nil
+	}
+	return errors.Errorf("env var %s not found in Travis CI repo %s", name, sink.RepoSlug)
+}
+
+// Preflight checks that the env vars of the given repository slug can
+// be listed. Write creates missing env vars, so name need not exist.
+func (sink *TravisCiSink) Preflight(ctx context.Context, name string) error {
+	_, resp, err := sink.Client.EnvVars.ListByRepoSlug(ctx, sink.RepoSlug)
+	if err != nil {
+		return errors.Wrapf(err, "unable to list env vars in Travis CI for repo %s", sink.RepoSlug)
+	}
+	if resp.StatusCode < 200 || 300 <= resp.StatusCode {
+		return errors.New(fmt.Sprintf("unable to list env vars in Travis CI for repo %s: invalid http status: %s", sink.RepoSlug, resp.Status))
+	}
+	return nil
+}
+
 // Kind returns the kind of this sink
 func (sink *TravisCiSink) Kind() Kind {
 	return KindTravisCi
@@ -41,7 +41,7 @@ func TestWriteToTravisCiSink_Integration(t *testing.T) {
 	r.Nil(err)
 
 	// rotate key
-	creds, err := (&source.DummySource{}).Read()
+	creds, err := (&source.DummySource{}).Read(ctx)
 	r.Nil(err)
 	err = sink.Write(ctx, name, creds[source.Secret])
 	r.Nil(err)
@@ -0,0 +1,621 @@
+package source
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+)
+
+const (
+	// Key of the account key read from and written to a sink.
+	ACMEAccountKey = "account_key"
+)
+
+// Challenge types.
+const (
+	ACMEChallengeHTTP01 = "http-01"
+	ACMEChallengeDNS01  = "dns-01"
+)
+
+const (
+	// DefaultACMEHTTPAddress is the address http-01 challenges are
+	// answered on if http_address is not set.
+	DefaultACMEHTTPAddress = ":80"
+)
+
+// ACMESource is a source that obtains TLS certificates from a CA
+// implementing ACME (RFC 8555), such as Let's Encrypt.
+//
+// Like TLSCertSource, it only obtains a new certificate once the one in
+// use, read back from the sinks of the secret, expires within
+// RenewBefore.
+type ACMESource struct {
+	// DirectoryURL is the ACME directory of the CA. Defaults to Let's
+	// Encrypt.
+	DirectoryURL string `yaml:"directory_url,omitempty"`
+	// CAFile is a PEM file of CA certificates to trust besides the
+	// system ones when connecting to DirectoryURL, e.g. for Pebble.
+	CAFile string `yaml:"ca_file,omitempty"`
+	// Email is the contact address of the account.
+	Email string `yaml:"email,omitempty"`
+	// AcceptTOS agrees to the terms of service of the CA, which is
+	// needed to create an account.
+	AcceptTOS bool `yaml:"accept_tos,omitempty"`
+	// Account is where the account key is kept across runs.
+	Account ACMEAccount `yaml:"account"`
+
+	// Domains are the DNS names of issued certificates. The first is
+	// the common name.
+	Domains []string `yaml:"domains"`
+	// KeyAlgorithm is the algorithm of the keys of issued certificates.
+	// Defaults to ecdsa-p256.
+	KeyAlgorithm string `yaml:"key_algorithm,omitempty"`
+	// RenewBefore is how long before the certificate in use expires a
+	// new one is obtained. Zero means a third of the lifetime of the
+	// certificate in use.
+	RenewBefore time.Duration `yaml:"renew_before,omitempty"`
+
+	// Challenge is the type of challenges answered to prove control of
+	// Domains: http-01 or dns-01.
+	Challenge string `yaml:"challenge"`
+	// HTTPAddress is the address http-01 challenges are answered on.
+	// Defaults to DefaultACMEHTTPAddress.
+	HTTPAddress string `yaml:"http_address,omitempty"`
+	// Route53 is the hosted zone dns-01 challenges are answered in.
+	Route53 *Route53DNS `yaml:"route53,omitempty"`
+	// DNS answers dns-01 challenges. It is set from Route53 by Init.
+	DNS ACMEDNSProvider `yaml:"-"`
+	// HTTPClient is used to connect to the CA. It is set up by Init.
+	HTTPClient *http.Client `yaml:"-"`
+
+	// current is the certificate in use, as passed to Inspect.
+	current *x509.Certificate
+	// pending is the DER of the certificate obtained by Create that has
+	// not been activated or revoked yet.
+	pending []byte
+	// client is the ACME client of the account.
+	client *acme.Client
+	// force makes the next rotation ignore the expiry of current.
+	force bool
+}
+
+// ACMEAccount is where ACMESource keeps its account key: either a PEM
+// file, or a sink whose key_to_name maps account_key to the name it is
+// stored under. A new account is created if there is no key yet.
+type ACMEAccount struct {
+	KeyFile string         `yaml:"key_file,omitempty"`
+	Sink    *sink.Location `yaml:"sink,omitempty"`
+}
+
+// ACMEDNSProvider publishes the TXT records that answer dns-01
+// challenges.
+type ACMEDNSProvider interface {
+	// SetTXT sets the TXT records of fqdn to values.
+	SetTXT(ctx context.Context, fqdn string, values []string) error
+	// DeleteTXT deletes the TXT records of fqdn set by SetTXT.
+	DeleteTXT(ctx context.Context, fqdn string, values []string) error
+}
+
+func init() {
+	Register(KindACME, func() Source { return NewACMESource() })
+}
+
+func NewACMESource() *ACMESource {
+	return &ACMESource{}
+}
+
+func (src *ACMESource) WithDirectoryURL(directoryURL string) *ACMESource {
+	src.DirectoryURL = directoryURL
+	return src
+}
+
+func (src *ACMESource) WithAccountSink(s sink.Sink) *ACMESource {
+	src.Account = ACMEAccount{Sink: &sink.Location{Sink: s}}
+	return src
+}
+
+func (src *ACMESource) WithDomains(domains ...string) *ACMESource {
+	src.Domains = domains
+	return src
+}
+
+func (src *ACMESource) WithHTTPChallenge(address string) *ACMESource {
+	src.Challenge = ACMEChallengeHTTP01
+	src.HTTPAddress = address
+	return src
+}
+
+func (src *ACMESource) WithDNSChallenge(dns ACMEDNSProvider) *ACMESource {
+	src.Challenge = ACMEChallengeDNS01
+	src.DNS = dns
+	return src
+}
+
+func (src *ACMESource) WithHTTPClient(client *http.Client) *ACMESource {
+	src.HTTPClient = client
+	return src
+}
+
+func (src *ACMESource) Kind() Kind {
+	return KindACME
+}
+
+// Validate checks the account, domains, key algorithm and challenge.
+func (src *ACMESource) Validate() error {
+	var errs *multierror.Error
+	if !src.AcceptTOS {
+		errs = multierror.Append(errs, errors.New("accept_tos must be true to create an account"))
+	}
+	switch {
+	case src.Account.Sink != nil && src.Account.KeyFile != "":
+		errs = multierror.Append(errs, errors.New("account key_file and account sink are mutually exclusive"))
+	case src.Account.Sink != nil:
+		err := src.Account.Sink.Validate(ACMEAccountKey)
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrap(err, "account"))
+		}
+	case src.Account.KeyFile == "":
+		errs = multierror.Append(errs, errors.New("missing account key_file or account sink"))
+	}
+
+	if len(src.Domains) == 0 {
+		errs = multierror.Append(errs, errors.New("missing domains"))
+	}
+	if !validKeyAlgorithm(src.KeyAlgorithm) {
+		errs = multierror.Append(errs, errors.Errorf("unknown key_algorithm %q", src.KeyAlgorithm))
+	}
+	if src.RenewBefore < 0 {
+		errs = multierror.Append(errs, errors.New("renew_before must not be negative"))
+	}
+
+	switch src.Challenge {
+	case ACMEChallengeHTTP01:
+		for _, domain := range src.Domains {
+			if strings.HasPrefix(domain, "*.") {
+				errs = multierror.Append(errs, errors.Errorf("wildcard domain %q needs the dns-01 challenge", domain))
+			}
+		}
+		if src.Route53 != nil {
+			errs = multierror.Append(errs, errors.New("route53 is only used by the dns-01 challenge"))
+		}
+	case ACMEChallengeDNS01:
+		if src.Route53 == nil && src.DNS == nil {
+			errs = multierror.Append(errs, errors.New("missing route53 for the dns-01 challenge"))
+		}
+		if src.Route53 != nil {
+			err := src.Route53.Validate()
+			if err != nil {
+				errs = multierror.Append(errs, errors.Wrap(err, "route53"))
+			}
+		}
+	default:
+		errs = multierror.Append(errs, errors.Errorf("unknown challenge %q, must be %s or %s", src.Challenge, ACMEChallengeHTTP01, ACMEChallengeDNS01))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up the account sink, the client of the CA, trusting CAFile
+// if set, and the Route53 client. It leaves a client or DNS provider
+// already set as is.
+func (src *ACMESource) Init() error {
+	if src.Account.Sink != nil {
+		err := src.Account.Sink.Init()
+		if err != nil {
+			return errors.Wrap(err, "account")
+		}
+	}
+	if src.HTTPClient == nil {
+		client, err := acmeHTTPClient(src.CAFile)
+		if err != nil {
+			return err
+		}
+		src.HTTPClient = client
+	}
+	if src.DNS == nil && src.Route53 != nil {
+		err := src.Route53.Init()
+		if err != nil {
+			return errors.Wrap(err, "route53")
+		}
+		src.DNS = src.Route53
+	}
+	return nil
+}
+
+// acmeHTTPClient returns a client that trusts the certificates in caFile
+// besides the system ones.
+func acmeHTTPClient(caFile string) (*http.Client, error) {
+	if caFile == "" {
+		return http.DefaultClient, nil
+	}
+	b, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read ca_file")
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.Errorf("no certificate found in ca_file %s", caFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
+}
+
+// InspectKeys returns the key of the certificate in use.
+func (src *ACMESource) InspectKeys() []string {
+	return []string{TLSCertPEM}
+}
+
+// Inspect keeps the certificate in use, if current holds one that can be
+// parsed.
+func (src *ACMESource) Inspect(current map[string]string) {
+	src.current = parseCertificatePEM(current[TLSCertPEM])
+}
+
+func (src *ACMESource) renewBefore(cert *x509.Certificate) time.Duration {
+	if src.RenewBefore == 0 {
+		return cert.NotAfter.Sub(cert.NotBefore) / 3
+	}
+	return src.RenewBefore
+}
+
+// due reports whether a new certificate should be obtained, and why.
+func (src *ACMESource) due(now time.Time) (bool, string) {
+	if src.force {
+		return true, "forced"
+	}
+	if src.current == nil {
+		return true, "no certificate in use found in sinks"
+	}
+	if !sameStrings(src.current.DNSNames, src.Domains) {
+		return true, "domains of certificate in use differ from config"
+	}
+	remaining := src.current.NotAfter.Sub(now)
+	renewBefore := src.renewBefore(src.current)
+	reason := fmt.Sprintf("certificate expires in %s, renew_before is %s", remaining.Round(time.Second), renewBefore.Round(time.Second))
+	return remaining < renewBefore, reason
+}
+
+// Plan reports whether Create would obtain a new certificate.
+func (src *ACMESource) Plan(ctx context.Context) (Plan, error) {
+	due, reason := src.due(time.Now())
+	return Plan{
+		Due:    due,
+		Reason: reason,
+		Keys:   []string{TLSChainPEM, TLSCertPEM, TLSKeyPEM, TLSPKCS12, TLSPKCS12Password},
+	}, nil
+}
+
+// Force makes the next rotation obtain a new certificate even if the one
+// in use does not expire soon.
+func (src *ACMESource) Force() {
+	src.force = true
+}
+
+func (src *ACMESource) Read(ctx context.Context) (map[string]string, error) {
+	creds, err := src.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return creds, src.Activate(ctx)
+}
+
+// Create obtains a new certificate for Domains, or returns nil if the
+// certificate in use does not expire within RenewBefore.
+func (src *ACMESource) Create(ctx context.Context) (map[string]string, error) {
+	if due, _ := src.due(time.Now()); !due {
+		return nil, nil
+	}
+	client, err := src.acmeClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(src.Domains...))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create order")
+	}
+	err = src.authorize(ctx, client, order)
+	if err != nil {
+		return nil, err
+	}
+	order, err = client.WaitOrder(ctx, order.URI)
+	if err != nil {
+		return nil, errors.Wrap(err, "order did not become ready")
+	}
+
+	key, err := generateTLSKey(src.KeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: src.Domains}, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create certificate request")
+	}
+	ders, _, err := client.CreateOrderCert(ctx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to finalize order")
+	}
+
+	var chain []*x509.Certificate
+	for _, der := range ders {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse issued certificate")
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no certificate issued")
+	}
+	if !samePublicKey(chain[0].PublicKey, key.Public()) {
+		return nil, errors.New("issued certificate does not match the requested key")
+	}
+	src.pending = ders[0]
+	return tlsCredentials(key, chain[0], chain[1:])
+}
+
+// acmeClient returns the client of the account, creating the account
+// and its key if there is no key yet.
+func (src *ACMESource) acmeClient(ctx context.Context) (*acme.Client, error) {
+	if src.client != nil {
+		return src.client, nil
+	}
+	key, err := src.accountKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: src.DirectoryURL,
+		HTTPClient:   src.HTTPClient,
+		UserAgent:    "rotator",
+	}
+	var contact []string
+	if src.Email != "" {
+		contact = []string{"mailto:" + src.Email}
+	}
+	// registering an existing account only looks it up
+	_, err = client.Register(ctx, &acme.Account{Contact: contact}, acme.AcceptTOS)
+	if err != nil && err != acme.ErrAccountAlreadyExists {
+		return nil, errors.Wrap(err, "unable to register account")
+	}
+	src.client = client
+	return client, nil
+}
+
+// accountKey reads the account key, or generates and stores a new one
+// if there is none.
+func (src *ACMESource) accountKey(ctx context.Context) (*ecdsa.PrivateKey, error) {
+	var keyPEM string
+	var err error
+	if src.Account.Sink != nil {
+		keyPEM, err = src.Account.Sink.Read(ctx, ACMEAccountKey)
+		if errors.Is(err, sink.ErrNotFound) {
+			err = nil
+		}
+	} else {
+		var b []byte
+		b, err = ioutil.ReadFile(src.Account.KeyFile)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		keyPEM = string(b)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read account key")
+	}
+
+	if keyPEM != "" {
+		key, err := parsePrivateKey([]byte(keyPEM))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to parse account key")
+		}
+		ecKey, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.Errorf("unsupported account key type %T, must be ecdsa", key)
+		}
+		return ecKey, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate account key")
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode account key")
+	}
+	keyPEM = string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}))
+	if src.Account.Sink != nil {
+		err = src.Account.Sink.Store(ctx, ACMEAccountKey, keyPEM)
+	} else {
+		err = ioutil.WriteFile(src.Account.KeyFile, []byte(keyPEM), 0600)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to store account key")
+	}
+	return key, nil
+}
+
+// authorize answers the challenges of the authorizations of order that
+// are not valid yet, and waits for them to become valid.
+func (src *ACMESource) authorize(ctx context.Context, client *acme.Client, order *acme.Order) error {
+	var pending []*acme.Authorization
+	var challenges []*acme.Challenge
+	for _, url := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, url)
+		if err != nil {
+			return errors.Wrap(err, "unable to get authorization")
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == src.Challenge {
+				challenge = c
+			}
+		}
+		if challenge == nil {
+			return errors.Errorf("%s challenge not offered for %s", src.Challenge, authz.Identifier.Value)
+		}
+		pending = append(pending, authz)
+		challenges = append(challenges, challenge)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	var cleanup func() error
+	var err error
+	if src.Challenge == ACMEChallengeHTTP01 {
+		cleanup, err = src.serveHTTP01(client, challenges)
+	} else {
+		cleanup, err = src.presentDNS01(ctx, client, pending, challenges)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := cleanup(); err != nil {
+			util.Logger(ctx).Warnf("acme: %s", err)
+		}
+	}()
+
+	for i, authz := range pending {
+		_, err := client.Accept(ctx, challenges[i])
+		if err != nil {
+			return errors.Wrapf(err, "unable to accept challenge for %s", authz.Identifier.Value)
+		}
+	}
+	for _, authz := range pending {
+		_, err := client.WaitAuthorization(ctx, authz.URI)
+		if err != nil {
+			return errors.Wrapf(err, "unable to authorize %s", authz.Identifier.Value)
+		}
+	}
+	return nil
+}
+
+// serveHTTP01 answers http-01 challenges on HTTPAddress until the
+// returned function is called.
+func (src *ACMESource) serveHTTP01(client *acme.Client, challenges []*acme.Challenge) (func() error, error) {
+	var mu sync.Mutex
+	responses := map[string]string{}
+	for _, c := range challenges {
+		response, err := client.HTTP01ChallengeResponse(c.Token)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to compute http-01 response")
+		}
+		responses[client.HTTP01ChallengePath(c.Token)] = response
+	}
+
+	address := src.HTTPAddress
+	if address == "" {
+		address = DefaultACMEHTTPAddress
+	}
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to listen on %s for http-01 challenges", address)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		response, ok := responses[r.URL.Path]
+		mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(response))
+	})}
+	go func() { _ = server.Serve(listener) }()
+	return server.Close, nil
+}
+
+// presentDNS01 publishes the TXT records of dns-01 challenges until the
+// returned function is called.
+func (src *ACMESource) presentDNS01(ctx context.Context, client *acme.Client, authzs []*acme.Authorization, challenges []*acme.Challenge) (func() error, error) {
+	// a domain and its wildcard share the same record
+	records := map[string][]string{}
+	var names []string
+	for i, authz := range authzs {
+		value, err := client.DNS01ChallengeRecord(challenges[i].Token)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to compute dns-01 record")
+		}
+		fqdn := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.") + "."
+		if _, ok := records[fqdn]; !ok {
+			names = append(names, fqdn)
+		}
+		records[fqdn] = append(records[fqdn], value)
+	}
+
+	cleanup := func(names []string) func() error {
+		return func() error {
+			var errs *multierror.Error
+			for _, fqdn := range names {
+				// the challenge is over, its deadline may have passed
+				err := src.DNS.DeleteTXT(context.Background(), fqdn, records[fqdn])
+				if err != nil {
+					errs = multierror.Append(errs, errors.Wrapf(err, "unable to delete TXT record of %s", fqdn))
+				}
+			}
+			return errs.ErrorOrNil()
+		}
+	}
+	for i, fqdn := range names {
+		err := src.DNS.SetTXT(ctx, fqdn, records[fqdn])
+		if err != nil {
+			_ = cleanup(names[:i])()
+			return nil, errors.Wrapf(err, "unable to set TXT record of %s", fqdn)
+		}
+	}
+	return cleanup(names), nil
+}
+
+// Activate forgets the certificate obtained by Create. The certificate
+// it replaces stays valid until it expires.
+func (src *ACMESource) Activate(ctx context.Context) error {
+	src.pending = nil
+	return nil
+}
+
+// Revoke revokes the certificate obtained by Create with the CA.
+func (src *ACMESource) Revoke(ctx context.Context) error {
+	if src.pending == nil {
+		return nil
+	}
+	err := src.client.RevokeCert(ctx, nil, src.pending, acme.CRLReasonUnspecified)
+	if err != nil {
+		return errors.Wrap(err, "unable to revoke certificate")
+	}
+	src.pending = nil
+	return nil
+}
+
+// CredentialID returns the SHA-256 fingerprint of the certificate in
+// creds.
+func (src *ACMESource) CredentialID(creds map[string]string) string {
+	return certificateID(creds)
+}
@@ -0,0 +1,64 @@
+// +build integration
+
+package source_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+// TestACMESource_Integration runs against the Pebble test CA in
+// PEBBLE_DIRECTORY_URL, trusting the certificate of its API in
+// PEBBLE_CA_FILE, e.g.:
+//
+//   docker run -d --network host -e PEBBLE_VA_ALWAYS_VALID=1 letsencrypt/pebble
+//   PEBBLE_DIRECTORY_URL=https://localhost:14000/dir
+//   PEBBLE_CA_FILE=test/certs/pebble.minica.pem   # from the pebble repo
+//
+// With PEBBLE_VA_ALWAYS_VALID unset, Pebble checks the http-01 challenges
+// answered on port 5002.
+func TestACMESource_Integration(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	directoryURL, ok := os.LookupEnv("PEBBLE_DIRECTORY_URL")
+	if !ok {
+		t.Skip("PEBBLE_DIRECTORY_URL is not set")
+	}
+
+	account := sink.NewBufSink().WithKeyToName(map[string]string{source.ACMEAccountKey: "account"})
+	src := source.NewACMESource().
+		WithDirectoryURL(directoryURL).
+		WithAccountSink(account).
+		WithDomains("localhost").
+		WithHTTPChallenge(":5002")
+	src.CAFile = os.Getenv("PEBBLE_CA_FILE")
+	src.Email = "rotator@example.com"
+	src.AcceptTOS = true
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal([]string{"localhost"}, parseCert(r, creds[source.TLSCertPEM]).DNSNames)
+	r.NotEmpty(creds[source.TLSChainPEM])
+
+	// the certificate is revoked if the rotation is rolled back
+	r.NoError(src.Revoke(ctx))
+
+	// the account is found again by its key
+	next := source.NewACMESource().
+		WithDirectoryURL(directoryURL).
+		WithAccountSink(account).
+		WithDomains("localhost").
+		WithHTTPChallenge(":5002")
+	next.CAFile = src.CAFile
+	next.AcceptTOS = true
+	r.NoError(next.Init())
+	_, err = next.Read(ctx)
+	r.NoError(err)
+}
@@ -0,0 +1,98 @@
+package source
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/pkg/errors"
+)
+
+// acmeTXTTTL is the TTL of the TXT records of dns-01 challenges, in
+// seconds.
+const acmeTXTTTL = 60
+
+// Route53DNS answers dns-01 challenges with TXT records in an AWS Route53
+// hosted zone.
+type Route53DNS struct {
+	HostedZoneID string `yaml:"hosted_zone_id"`
+	// RoleArn is the role to assume to change the records, if any.
+	RoleArn    string `yaml:"role_arn,omitempty"`
+	ExternalID string `yaml:"external_id,omitempty"`
+
+	Svc route53iface.Route53API `yaml:"-"`
+}
+
+// Validate checks that the hosted zone is set.
+func (r *Route53DNS) Validate() error {
+	if r.HostedZoneID == "" {
+		return errors.New("missing hosted_zone_id")
+	}
+	return nil
+}
+
+// Init sets up a Route53 client, assuming RoleArn if set. It does
+// nothing if a client is already set.
+func (r *Route53DNS) Init() error {
+	if r.Svc != nil {
+		return nil
+	}
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
+	}
+	if r.RoleArn != "" {
+		sess.Config.Credentials = stscreds.NewCredentials(sess, r.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+			if r.ExternalID != "" {
+				p.ExternalID = aws.String(r.ExternalID)
+			}
+		})
+	}
+	r.Svc = route53.New(sess)
+	return nil
+}
+
+// SetTXT upserts the TXT records of fqdn and waits until the change has
+// reached every Route53 name server.
+func (r *Route53DNS) SetTXT(ctx context.Context, fqdn string, values []string) error {
+	return r.change(ctx, route53.ChangeActionUpsert, fqdn, values)
+}
+
+// DeleteTXT deletes the TXT records of fqdn.
+func (r *Route53DNS) DeleteTXT(ctx context.Context, fqdn string, values []string) error {
+	return r.change(ctx, route53.ChangeActionDelete, fqdn, values)
+}
+
+func (r *Route53DNS) change(ctx context.Context, action string, fqdn string, values []string) error {
+	var records []*route53.ResourceRecord
+	for _, v := range values {
+		records = append(records, &route53.ResourceRecord{Value: aws.String(strconv.Quote(v))})
+	}
+	out, err := r.Svc.ChangeResourceRecordSetsWithContext(ctx, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(r.HostedZoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("rotator acme dns-01 challenge"),
+			Changes: []*route53.Change{{
+				Action: aws.String(action),
+				ResourceRecordSet: &route53.ResourceRecordSet{
+					Name:            aws.String(fqdn),
+					Type:            aws.String(route53.RRTypeTxt),
+					TTL:             aws.Int64(acmeTXTTTL),
+					ResourceRecords: records,
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to %s TXT record in hosted zone %s", action, r.HostedZoneID)
+	}
+	if action == route53.ChangeActionDelete {
+		return nil
+	}
+	err = r.Svc.WaitUntilResourceRecordSetsChangedWithContext(ctx, &route53.GetChangeInput{Id: out.ChangeInfo.Id})
+	return errors.Wrap(err, "TXT record change did not complete")
+}
@@ -0,0 +1,473 @@
+package source_test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/aws/aws-sdk-go/service/route53/route53iface"
+	"github.com/chanzuckerberg/rotator/cmd"
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeAuthz struct {
+	domain string
+	token  string
+	valid  bool
+}
+
+// fakeACME is a minimal ACME CA. It does not check signatures, and checks
+// challenges by fetching http-01 responses from httpAddress and looking
+// up dns-01 records in dns.
+type fakeACME struct {
+	t           *testing.T
+	server      *httptest.Server
+	caCert      *x509.Certificate
+	caKey       interface{}
+	caPEM       string
+	validity    time.Duration
+	httpAddress string
+	dns         *fakeDNS
+
+	mu       sync.Mutex
+	accounts int
+	authzs   []*fakeAuthz
+	orders   [][]int
+	// issued maps orders to their certificate in certs
+	issued  map[int]int
+	certs   [][]byte
+	revoked [][]byte
+	// noChain makes the CA serve empty certificate chains
+	noChain bool
+}
+
+func newFakeACME(t *testing.T) *fakeACME {
+	r := require.New(t)
+	caPEM, caKeyPEM := newTestCA(r, 365*24*time.Hour)
+	block, _ := pem.Decode([]byte(caKeyPEM))
+	caKey, err := x509.ParseECPrivateKey(block.Bytes)
+	r.NoError(err)
+	f := &fakeACME{t: t, caCert: parseCert(r, caPEM), caKey: caKey, caPEM: caPEM, validity: 90 * 24 * time.Hour, issued: map[int]int{}}
+	f.server = httptest.NewServer(f)
+	return f
+}
+
+func (f *fakeACME) url(path string, args ...interface{}) string {
+	return f.server.URL + fmt.Sprintf(path, args...)
+}
+
+func (f *fakeACME) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Replay-Nonce", fmt.Sprintf("nonce-%d", time.Now().UnixNano()))
+	if r.URL.Path == "/dir" {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"newNonce":   f.url("/nonce"),
+			"newAccount": f.url("/account"),
+			"newOrder":   f.url("/order"),
+			"revokeCert": f.url("/revoke"),
+			"meta":       map[string]string{"termsOfService": f.url("/tos")},
+		})
+		return
+	}
+	if r.URL.Path == "/nonce" {
+		return
+	}
+
+	var jws struct{ Protected, Payload string }
+	_ = json.NewDecoder(r.Body).Decode(&jws)
+	payload, _ := base64.RawURLEncoding.DecodeString(jws.Payload)
+	var id int
+	switch {
+	case r.URL.Path == "/account":
+		// every registration creates an account
+		f.accounts++
+		w.Header().Set("Location", f.url("/account/%d", f.accounts))
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"status": "valid"})
+	case r.URL.Path == "/order":
+		var req struct{ Identifiers []struct{ Value string } }
+		_ = json.Unmarshal(payload, &req)
+		var ids []int
+		for _, identifier := range req.Identifiers {
+			f.authzs = append(f.authzs, &fakeAuthz{domain: identifier.Value, token: fmt.Sprintf("token%d", len(f.authzs))})
+			ids = append(ids, len(f.authzs)-1)
+		}
+		f.orders = append(f.orders, ids)
+		w.Header().Set("Location", f.url("/order/%d", len(f.orders)-1))
+		w.WriteHeader(http.StatusCreated)
+		f.writeOrder(w, len(f.orders)-1)
+	case scan(r.URL.Path, "/order/%d", &id):
+		w.Header().Set("Location", f.url("/order/%d", id))
+		f.writeOrder(w, id)
+	case scan(r.URL.Path, "/authz/%d", &id):
+		a := f.authzs[id]
+		status := "pending"
+		if a.valid {
+			status = "valid"
+		}
+		var challenges []map[string]string
+		for _, typ := range []string{"http-01", "dns-01"} {
+			challenges = append(challenges, map[string]string{"type": typ, "url": f.url("/challenge/%d/%s", id, typ), "token": a.token, "status": status})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"status":     status,
+			"identifier": map[string]string{"type": "dns", "value": strings.TrimPrefix(a.domain, "*.")},
+			"wildcard":   strings.HasPrefix(a.domain, "*."),
+			"challenges": challenges,
+		})
+	case strings.HasPrefix(r.URL.Path, "/challenge/"):
+		var typ string
+		_, _ = fmt.Sscanf(strings.Replace(r.URL.Path, "/", " ", -1), " challenge %d %s", &id, &typ)
+		a := f.authzs[id]
+		a.valid = f.check(a, typ)
+		_ = json.NewEncoder(w).Encode(map[string]string{"type": typ, "url": f.url(r.URL.Path), "token": a.token, "status": "processing"})
+	case scan(r.URL.Path, "/finalize/%d", &id):
+		var req struct{ CSR string }
+		_ = json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.CSR)
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil || f.status(id) != "ready" {
+			http.Error(w, `{"type": "urn:ietf:params:acme:error:orderNotReady"}`, http.StatusForbidden)
+			return
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(int64(len(f.certs) + 2)),
+			DNSNames:     csr.DNSNames,
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(f.validity),
+		}
+		cert, err := x509.CreateCertificate(rand.Reader, template, f.caCert, csr.PublicKey, f.caKey)
+		require.NoError(f.t, err)
+		f.certs = append(f.certs, cert)
+		f.issued[id] = len(f.certs) - 1
+		w.Header().Set("Location", f.url("/order/%d", id))
+		f.writeOrder(w, id)
+	case scan(r.URL.Path, "/cert/%d", &id):
+		w.Header().Set("Content-Type", "application/pem-certificate-chain")
+		if f.noChain {
+			return
+		}
+		_ = pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: f.certs[id]})
+		_, _ = w.Write([]byte(f.caPEM))
+	case r.URL.Path == "/revoke":
+		var req struct{ Certificate string }
+		_ = json.Unmarshal(payload, &req)
+		der, _ := base64.RawURLEncoding.DecodeString(req.Certificate)
+		f.revoked = append(f.revoked, der)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func scan(path string, format string, id *int) bool {
+	_, err := fmt.Sscanf(path, format, id)
+	return err == nil
+}
+
+// status returns the status of order id.
+func (f *fakeACME) status(id int) string {
+	for _, a := range f.orders[id] {
+		if !f.authzs[a].valid {
+			return "pending"
+		}
+	}
+	if _, ok := f.issued[id]; ok {
+		return "valid"
+	}
+	return "ready"
+}
+
+func (f *fakeACME) writeOrder(w http.ResponseWriter, id int) {
+	var authzs []string
+	for _, a := range f.orders[id] {
+		authzs = append(authzs, f.url("/authz/%d", a))
+	}
+	order := map[string]interface{}{
+		"status":         f.status(id),
+		"authorizations": authzs,
+		"finalize":       f.url("/finalize/%d", id),
+	}
+	if order["status"] == "valid" {
+		order["certificate"] = f.url("/cert/%d", f.issued[id])
+	}
+	_ = json.NewEncoder(w).Encode(order)
+}
+
+// check reports whether the challenge of typ of a is answered.
+func (f *fakeACME) check(a *fakeAuthz, typ string) bool {
+	switch typ {
+	case "http-01":
+		res, err := http.Get("http://" + f.httpAddress + "/.well-known/acme-challenge/" + a.token)
+		if err != nil {
+			return false
+		}
+		defer res.Body.Close()
+		b, _ := ioutil.ReadAll(res.Body)
+		return strings.HasPrefix(string(b), a.token+".")
+	case "dns-01":
+		return len(f.dns.records["_acme-challenge."+strings.TrimPrefix(a.domain, "*.")+"."]) > 0
+	}
+	return false
+}
+
+// fakeDNS is an ACMEDNSProvider that keeps records in memory.
+type fakeDNS struct {
+	records map[string][]string
+	deleted []string
+}
+
+func (d *fakeDNS) SetTXT(ctx context.Context, fqdn string, values []string) error {
+	d.records[fqdn] = values
+	return nil
+}
+
+func (d *fakeDNS) DeleteTXT(ctx context.Context, fqdn string, values []string) error {
+	delete(d.records, fqdn)
+	d.deleted = append(d.deleted, fqdn)
+	return nil
+}
+
+// freeAddress returns a local address nothing listens on.
+func freeAddress(r *require.Assertions) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	r.NoError(err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func TestACMESourceHTTP01(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	fake := newFakeACME(t)
+	defer fake.server.Close()
+	fake.httpAddress = freeAddress(r)
+
+	account := sink.NewBufSink().WithKeyToName(map[string]string{source.ACMEAccountKey: "acme-account"})
+	src := source.NewACMESource().
+		WithDirectoryURL(fake.url("/dir")).
+		WithAccountSink(account).
+		WithDomains("app.example.com", "www.example.com").
+		WithHTTPChallenge(fake.httpAddress)
+	src.AcceptTOS = true
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.NoError(src.Activate(ctx))
+	cert := parseCert(r, creds[source.TLSCertPEM])
+	r.Equal([]string{"app.example.com", "www.example.com"}, cert.DNSNames)
+	r.Equal(fake.caPEM, creds[source.TLSChainPEM])
+	r.NotEmpty(creds[source.TLSKeyPEM])
+	r.NotEmpty(creds[source.TLSPKCS12])
+
+	// the account key is kept for the next run
+	accountKey, err := account.Current(ctx, "acme-account")
+	r.NoError(err)
+	r.Contains(accountKey, "PRIVATE KEY")
+
+	// a fresh certificate is not renewed
+	src.Inspect(creds)
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due, plan.Reason)
+	r.Contains(plan.Reason, "renew_before is 720h0m0s")
+	again, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(again)
+
+	// a new run with the same account renews a certificate that expires soon
+	fake.validity = 10 * 24 * time.Hour
+	src.RenewBefore = 30 * 24 * time.Hour
+	src.Force()
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NoError(src.Activate(ctx))
+	next := source.NewACMESource().
+		WithDirectoryURL(fake.url("/dir")).
+		WithAccountSink(account).
+		WithDomains("app.example.com", "www.example.com").
+		WithHTTPChallenge(fake.httpAddress)
+	next.AcceptTOS = true
+	next.RenewBefore = 30 * 24 * time.Hour
+	r.NoError(next.Init())
+	next.Inspect(creds)
+	plan, err = next.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	_, err = next.Create(ctx)
+	r.NoError(err)
+	stored, err := account.Current(ctx, "acme-account")
+	r.NoError(err)
+	r.Equal(accountKey, stored)
+}
+
+func TestACMESourceDNS01(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	fake := newFakeACME(t)
+	defer fake.server.Close()
+	fake.dns = &fakeDNS{records: map[string][]string{}}
+
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	src := source.NewACMESource().
+		WithDirectoryURL(fake.url("/dir")).
+		WithDomains("example.com", "*.example.com").
+		WithDNSChallenge(fake.dns)
+	src.Account.KeyFile = filepath.Join(dir, "account.pem")
+	src.AcceptTOS = true
+	src.KeyAlgorithm = source.KeyAlgorithmRSA2048
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	cert := parseCert(r, creds[source.TLSCertPEM])
+	r.Equal([]string{"example.com", "*.example.com"}, cert.DNSNames)
+	r.NotEmpty(src.CredentialID(creds))
+
+	// both challenges were answered with the same record, which is gone
+	r.Equal([]string{"_acme-challenge.example.com."}, fake.dns.deleted)
+	r.Empty(fake.dns.records)
+	_, err = os.Stat(src.Account.KeyFile)
+	r.NoError(err)
+
+	// a rolled back certificate is revoked
+	r.NoError(src.Revoke(ctx))
+	r.Equal([][]byte{cert.Raw}, fake.revoked)
+
+	// a CA that issues no certificate fails the rotation
+	fake.noChain = true
+	src.Force()
+	_, err = src.Create(ctx)
+	r.Error(err)
+}
+
+// TestACMESourceREADMEExample rotates the example of the README against
+// the fake CA, with Buffer sinks in place of AWSSecretsManager.
+func TestACMESourceREADMEExample(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	fake := newFakeACME(t)
+	defer fake.server.Close()
+	fake.dns = &fakeDNS{records: map[string][]string{}}
+
+	conf, err := config.Load([]byte(`
+version: 2
+secrets:
+  - name: www-certificate
+    schedule: 24h
+    source:
+      kind: acme
+      email: ops@example.com
+      accept_tos: true
+      account:
+        sink:
+          kind: Buffer
+          key_to_name:
+            account_key: acme/account-key
+      domains: [example.com, "*.example.com"]
+      challenge: dns-01
+      route53:
+        hosted_zone_id: Z0123456789ABCDEFGHIJ
+    sinks:
+      - kind: Buffer
+        key_to_name:
+          cert_pem: www/tls-cert
+          key_pem: www/tls-key
+          chain_pem: www/tls-chain
+`))
+	r.NoError(err)
+	conf.Secrets[0].Source.(*source.ACMESource).
+		WithDirectoryURL(fake.url("/dir")).
+		WithDNSChallenge(fake.dns)
+	r.NoError(conf.Init())
+
+	r.NoError(cmd.RotateSecrets(ctx, conf))
+	certPEM, err := conf.Secrets[0].Sinks[0].(sink.Restorer).Current(ctx, "www/tls-cert")
+	r.NoError(err)
+	r.Equal([]string{"example.com", "*.example.com"}, parseCert(r, certPEM).DNSNames)
+	r.Len(fake.certs, 1)
+	r.Empty(fake.revoked)
+}
+
+func TestACMESourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	account := source.ACMEAccount{KeyFile: "account.pem"}
+	stdout := &sink.Location{Sink: sink.NewStdoutSink()}
+	tests := map[string]*source.ACMESource{
+		"tos not accepted":       {Account: account, Domains: []string{"a"}, Challenge: "http-01"},
+		"missing account":        {AcceptTOS: true, Domains: []string{"a"}, Challenge: "http-01"},
+		"account cannot read":    {AcceptTOS: true, Account: source.ACMEAccount{Sink: stdout}, Domains: []string{"a"}, Challenge: "http-01"},
+		"missing domains":        {AcceptTOS: true, Account: account, Challenge: "http-01"},
+		"unknown challenge":      {AcceptTOS: true, Account: account, Domains: []string{"a"}, Challenge: "tls-alpn-01"},
+		"wildcard with http-01":  {AcceptTOS: true, Account: account, Domains: []string{"*.a"}, Challenge: "http-01"},
+		"dns-01 without route53": {AcceptTOS: true, Account: account, Domains: []string{"a"}, Challenge: "dns-01"},
+		"missing hosted zone":    {AcceptTOS: true, Account: account, Domains: []string{"a"}, Challenge: "dns-01", Route53: &source.Route53DNS{}},
+		"unknown algorithm":      {AcceptTOS: true, Account: account, Domains: []string{"a"}, Challenge: "http-01", KeyAlgorithm: "dsa"},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+}
+
+type fakeRoute53 struct {
+	route53iface.Route53API
+	changes []*route53.ChangeResourceRecordSetsInput
+	waited  int
+}
+
+func (f *fakeRoute53) ChangeResourceRecordSetsWithContext(ctx aws.Context, in *route53.ChangeResourceRecordSetsInput, opts ...request.Option) (*route53.ChangeResourceRecordSetsOutput, error) {
+	f.changes = append(f.changes, in)
+	return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &route53.ChangeInfo{Id: aws.String("change")}}, nil
+}
+
+func (f *fakeRoute53) WaitUntilResourceRecordSetsChangedWithContext(ctx aws.Context, in *route53.GetChangeInput, opts ...request.WaiterOption) error {
+	f.waited++
+	return nil
+}
+
+func TestRoute53DNS(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	svc := &fakeRoute53{}
+	dns := &source.Route53DNS{HostedZoneID: "Z123", Svc: svc}
+	r.NoError(dns.Validate())
+	r.NoError(dns.Init())
+
+	r.NoError(dns.SetTXT(ctx, "_acme-challenge.example.com.", []string{"a", "b"}))
+	r.NoError(dns.DeleteTXT(ctx, "_acme-challenge.example.com.", []string{"a", "b"}))
+	r.Len(svc.changes, 2)
+	r.Equal(1, svc.waited)
+	set := svc.changes[0].ChangeBatch.Changes[0]
+	r.Equal(route53.ChangeActionUpsert, *set.Action)
+	r.Equal("Z123", *svc.changes[0].HostedZoneId)
+	r.Equal("_acme-challenge.example.com.", *set.ResourceRecordSet.Name)
+	r.Equal(`"a"`, *set.ResourceRecordSet.ResourceRecords[0].Value)
+	r.Equal(route53.ChangeActionDelete, *svc.changes[1].ChangeBatch.Changes[0].Action)
+}
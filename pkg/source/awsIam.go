@@ -2,12 +2,17 @@ package source
 
 import (
 	"context"
+	"fmt"
 	"sort"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iam"
 	cziAws "github.com/chanzuckerberg/go-misc/aws"
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
 )
 
@@ -17,12 +22,83 @@ const (
 	AwsSecretAccessKey string = "secretAccessKey"
 )
 
+const (
+	// awsIamDeactivatedTag and awsIamReactivatedTag, followed by an
+	// access key ID, name the tags on the user recording when rotator
+	// deactivated, or reactivate reactivated, that key. IAM does not
+	// record when the status of a key changed.
+	awsIamDeactivatedTag = "rotator:deactivated:"
+	awsIamReactivatedTag = "rotator:reactivated:"
+	// awsIamOwnedTag, followed by an access key ID, names the tag on the
+	// user recording when rotator created or adopted that key. Access
+	// keys cannot be tagged themselves.
+	awsIamOwnedTag = "rotator:owned:"
+)
+
 type AwsIamSource struct {
 	UserName   string         `yaml:"username"`
 	RoleArn    string         `yaml:"role_arn"`
 	ExternalID string         `yaml:"external_id"`
-	Client     *cziAws.Client `yaml:"client"`
-	MaxAge     time.Duration  `yaml:"max_age"`
+	Client     *cziAws.Client `yaml:"-"`
+	// Discovery, if set, makes the source rotate the keys of every user
+	// it finds rather than those of UserName, see Discover.
+	Discovery *AwsIamDiscovery `yaml:"discover,omitempty"`
+	// InactiveGrace, if set, makes rotations deactivate the older key
+	// instead of deleting it, and only delete it once it has been
+	// inactive for InactiveGrace, so that jobs still using it fail while
+	// the key can be reactivated. As a user has at most two keys, the
+	// new key is only created once the inactive key is deleted, so the
+	// active key is up to MaxAge plus InactiveGrace old by then.
+	InactiveGrace time.Duration `yaml:"inactive_grace,omitempty"`
+	// QuietPeriod, if set, makes rotations defer deleting or deactivating
+	// the older key while it was last used less than QuietPeriod ago, as
+	// reported by IAM, so that a key still in use is not retired just
+	// because it is old. FailOnRecentUse makes such rotations fail
+	// instead of logging a warning.
+	QuietPeriod     time.Duration `yaml:"quiet_period,omitempty"`
+	FailOnRecentUse bool          `yaml:"fail_on_recent_use,omitempty"`
+	// Adopt makes rotations keep the only key of a user until it is
+	// older than MaxAge, rather than create a second key next to it
+	// right away, e.g. for users whose key was set up by hand. Keys
+	// rotator created are kept that way regardless, if it records them,
+	// see tagsUser.
+	Adopt bool `yaml:"adopt,omitempty"`
+	// OwnedOnly makes rotations never deactivate or delete keys rotator
+	// did not create or adopt. Ownership is recorded in tags on the user.
+	OwnedOnly bool `yaml:"owned_only,omitempty"`
+	// MaxAge is the max_age of the secret the source belongs to.
+	MaxAge time.Duration `yaml:"-"`
+
+	// pending is the key created by Create that has not been
+	// activated or revoked yet.
+	pending *iam.AccessKey
+	// force makes the next rotation ignore MaxAge.
+	force bool
+}
+
+// AwsIamDiscovery selects the users whose keys a source rotates. Users
+// must match both the path prefix and the tags, if set.
+type AwsIamDiscovery struct {
+	// PathPrefix is the prefix of the paths of the users, e.g. /ci/.
+	PathPrefix string `yaml:"path_prefix,omitempty"`
+	// Tags are tags the users must have with these values, e.g.
+	// rotator:managed: "true".
+	Tags map[string]string `yaml:"tags,omitempty"`
+}
+
+// AwsIamUser is a user found by discovery. The names in the key_to_name
+// of the sinks are rendered with it, e.g. as
+// {{ .Tags.ci_repo }}_AWS_ACCESS_KEY_ID.
+type AwsIamUser struct {
+	UserName string
+	Path     string
+	Tags     map[string]string
+}
+
+func init() {
+	// max_age must be set on the secret in config files, so no
+	// default is applied
+	Register(KindAws, func() Source { return &AwsIamSource{} })
 }
 
 func NewAwsIamSource() *AwsIamSource {
@@ -51,61 +127,682 @@ func (src *AwsIamSource) WithMaxAge(maxAge time.Duration) *AwsIamSource {
 	return src
 }
 
+// SetMaxAge sets MaxAge from the max_age of the secret.
+func (src *AwsIamSource) SetMaxAge(maxAge time.Duration) {
+	src.MaxAge = maxAge
+}
+
+func (src *AwsIamSource) WithInactiveGrace(grace time.Duration) *AwsIamSource {
+	src.InactiveGrace = grace
+	return src
+}
+
+func (src *AwsIamSource) WithDiscovery(discovery *AwsIamDiscovery) *AwsIamSource {
+	src.Discovery = discovery
+	return src
+}
+
+func (src *AwsIamSource) WithQuietPeriod(quietPeriod time.Duration) *AwsIamSource {
+	src.QuietPeriod = quietPeriod
+	return src
+}
+
+// Validate checks that the role to assume and the max age are set, and
+// that users are either named or discovered.
+func (src *AwsIamSource) Validate() error {
+	var errs *multierror.Error
+	if src.RoleArn == "" {
+		errs = multierror.Append(errs, errors.New("missing role_arn"))
+	}
+	if src.MaxAge <= 0 {
+		errs = multierror.Append(errs, errors.New("missing max_age"))
+	}
+	if src.InactiveGrace < 0 {
+		errs = multierror.Append(errs, errors.New("inactive_grace must not be negative"))
+	}
+	if src.QuietPeriod < 0 {
+		errs = multierror.Append(errs, errors.New("quiet_period must not be negative"))
+	}
+	if src.FailOnRecentUse && src.QuietPeriod == 0 {
+		errs = multierror.Append(errs, errors.New("fail_on_recent_use requires quiet_period"))
+	}
+	if src.Discovery != nil {
+		if src.UserName != "" {
+			errs = multierror.Append(errs, errors.New("username and discover are mutually exclusive"))
+		}
+		if src.Discovery.PathPrefix == "" && len(src.Discovery.Tags) == 0 {
+			errs = multierror.Append(errs, errors.New("discover needs a path_prefix or tags"))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up an IAM client that assumes RoleArn, passing along
+// ExternalID if set. It does nothing if a client is already set.
+func (src *AwsIamSource) Init() error {
+	if src.Client != nil {
+		return nil
+	}
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
+	}
+	sess.Config.Credentials = stscreds.NewCredentials(sess, src.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+		if src.ExternalID != "" {
+			p.ExternalID = aws.String(src.ExternalID)
+		}
+	})
+	src.Client = cziAws.New(sess).WithIAM(sess.Config)
+	return nil
+}
+
 // RotateKeys rotates the AWS IAM keys for the user specified in src.
-// It returns any new key created and any error encountered.
-// If the user has less than two keys, RotateKeys creates a new key.
-// If the user has two keys, RotateKeys checks if the older key is older
-// than the MaxAge specified in src. If so, RotateKeys deletes that key
-// and returns a new key, else it does nothing and returns a nil key.
+// It returns any new key created and any error encountered, or a nil
+// key if no key is due. What it does depends on the keys of the user:
+// with no key, or only an inactive one, it creates a key. With one
+// active key, it creates a second key, unless Adopt is set and the key
+// is younger than MaxAge. With two active keys, it deletes the older
+// key and creates a new one once both are older than MaxAge. With an
+// active and an inactive key, it deletes the inactive key and creates a
+// new one once the active key is older than MaxAge. With two inactive
+// keys, it deletes the older one and creates a new one.
+//
+// With InactiveGrace, RotateKeys deactivates an active key instead of
+// deleting it, and returns a nil key. The inactive key is deleted, and a
+// new key returned, once it has been inactive for InactiveGrace.
+//
+// With QuietPeriod, RotateKeys leaves the key alone and returns a nil
+// key while it was used within QuietPeriod, or returns an error with
+// FailOnRecentUse.
+//
+// With OwnedOnly, RotateKeys returns an error rather than deactivate or
+// delete a key rotator did not create or adopt.
 func (src *AwsIamSource) RotateKeys(ctx context.Context) (*iam.AccessKey, error) {
 	svc := src.Client.IAM.Svc
 
-	// list a user's access keys
-	out, err := svc.ListAccessKeysWithContext(ctx, &iam.ListAccessKeysInput{
+	user, err := src.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	step, err := src.next(ctx, user, now)
+	if err != nil {
+		return nil, err
+	}
+	switch step.action {
+	case iamKeep:
+		return nil, nil
+	case iamBlocked:
+		return nil, errors.New(step.reason)
+	case iamDefer:
+		if src.FailOnRecentUse {
+			return nil, errors.Errorf("refusing to retire access key: %s", step.reason)
+		}
+		util.Logger(ctx).Warnf("aws: %s: deferring rotation, %s", src.UserName, step.reason)
+		return nil, nil
+	case iamAdopt:
+		err = src.own(ctx, *step.key.AccessKeyId)
+		if err != nil {
+			return nil, err
+		}
+		util.Logger(ctx).Infof("aws: %s: adopted access key %s, it is rotated once older than max_age %s", src.UserName, *step.key.AccessKeyId, src.MaxAge)
+		return nil, nil
+	case iamDeactivate:
+		err = src.deactivate(ctx, user, *step.key.AccessKeyId)
+		if err != nil {
+			return nil, err
+		}
+		util.Logger(ctx).Infof("aws: %s: deactivated access key %s%s, it is deleted once inactive_grace %s has passed", src.UserName, *step.key.AccessKeyId, step.lastUse(now), src.InactiveGrace)
+		return nil, nil
+	case iamReplace:
+		id := *step.key.AccessKeyId
+		_, err = svc.DeleteAccessKeyWithContext(ctx, &iam.DeleteAccessKeyInput{
+			AccessKeyId: aws.String(id),
+			UserName:    aws.String(src.UserName),
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to delete access key %s", id)
+		}
+		err = src.untag(ctx, user, id, awsIamDeactivatedTag, awsIamReactivatedTag, awsIamOwnedTag)
+		if err != nil {
+			return nil, err
+		}
+		if step.lastUsed != nil {
+			util.Logger(ctx).Infof("aws: %s: deleted access key %s%s", src.UserName, id, step.lastUse(now))
+		}
+	case iamCreate:
+		// a stale key is adopted so that it can be retired later
+		if step.key != nil {
+			err = src.own(ctx, *step.key.AccessKeyId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// create a new IAM access key
+	result, err := svc.CreateAccessKeyWithContext(ctx, &iam.CreateAccessKeyInput{
 		UserName: aws.String(src.UserName),
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to list access keys")
+		return nil, errors.Wrap(err, "unable to create new access key")
+	}
+	if !src.tagsUser() {
+		return result.AccessKey, nil
+	}
+	err = src.own(ctx, *result.AccessKey.AccessKeyId)
+	if err == nil {
+		return result.AccessKey, nil
+	}
+	// a key rotator does not own would block later rotations, or be
+	// joined by a second key right away
+	_, delErr := svc.DeleteAccessKeyWithContext(ctx, &iam.DeleteAccessKeyInput{
+		AccessKeyId: result.AccessKey.AccessKeyId,
+		UserName:    aws.String(src.UserName),
+	})
+	if delErr != nil {
+		return nil, multierror.Append(err, errors.Wrapf(delErr, "unable to delete access key %s", *result.AccessKey.AccessKeyId))
 	}
+	return nil, err
+}
 
-	keys := out.AccessKeyMetadata
-	sort.Slice(keys, func(i, j int) bool { return keys[i].CreateDate.Before(*keys[j].CreateDate) })
+// iamUser is the state of a user relevant to rotation.
+type iamUser struct {
+	// keys are the access keys of the user, oldest first.
+	keys []*iam.AccessKeyMetadata
+	// tags are the tags of the user. They are only listed if tagsUser.
+	tags map[string]string
+}
+
+// iamAction is what RotateKeys does.
+type iamAction int
+
+const (
+	// iamKeep changes nothing.
+	iamKeep iamAction = iota
+	// iamCreate creates a new key next to the keys of the user.
+	iamCreate
+	// iamReplace deletes a key and creates a new one.
+	iamReplace
+	// iamDeactivate deactivates a key.
+	iamDeactivate
+	// iamDefer would delete or deactivate a key, but it was used within
+	// QuietPeriod.
+	iamDefer
+	// iamAdopt records that rotator owns a key.
+	iamAdopt
+	// iamBlocked would delete or deactivate a key rotator does not own.
+	iamBlocked
+)
+
+// iamStep is what RotateKeys does next, and why.
+type iamStep struct {
+	action iamAction
+	// key is the key the action retires or adopts. For iamCreate, it is
+	// a key to adopt before creating the new key, if any.
+	key    *iam.AccessKeyMetadata
+	reason string
+	// lastUsed is when, where and by which service key was last used.
+	// It is only looked up with QuietPeriod.
+	lastUsed *iam.AccessKeyLastUsed
+}
+
+// lastUse describes the last use of the key of the step, e.g.
+// ", last used 2h0m0s ago by s3 in us-west-2", or returns an empty
+// string if it was not looked up.
+func (step iamStep) lastUse(now time.Time) string {
+	if step.lastUsed == nil {
+		return ""
+	}
+	if step.lastUsed.LastUsedDate == nil {
+		return ", never used"
+	}
+	return fmt.Sprintf(", last used %s ago by %s in %s",
+		now.Sub(*step.lastUsed.LastUsedDate).Round(time.Second),
+		aws.StringValue(step.lastUsed.ServiceName),
+		aws.StringValue(step.lastUsed.Region))
+}
+
+// tagsUser reports whether rotator records the state of keys in tags on
+// the user, which it does with InactiveGrace or OwnedOnly. Only then does
+// it know which keys it created.
+func (src *AwsIamSource) tagsUser() bool {
+	return src.InactiveGrace > 0 || src.OwnedOnly
+}
+
+// lookup returns the keys of the user and, if tagsUser, its tags.
+func (src *AwsIamSource) lookup(ctx context.Context) (iamUser, error) {
+	keys, err := src.listKeys(ctx)
+	if err != nil {
+		return iamUser{}, err
+	}
+	user := iamUser{keys: keys, tags: map[string]string{}}
+	if !src.tagsUser() {
+		return user, nil
+	}
+	// users have at most 50 tags, which fit in one page
+	out, err := src.Client.IAM.Svc.ListUserTagsWithContext(ctx, &iam.ListUserTagsInput{
+		UserName: aws.String(src.UserName),
+	})
+	if err != nil {
+		return iamUser{}, errors.Wrap(err, "unable to list user tags")
+	}
+	for _, t := range out.Tags {
+		user.tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
+	}
+	return user, nil
+}
+
+// tagTime returns the time recorded in the tag of the user named prefix
+// followed by keyID, if any.
+func (user iamUser) tagTime(prefix string, keyID string) (time.Time, bool) {
+	v, ok := user.tags[prefix+keyID]
+	if !ok {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	return t, err == nil
+}
+
+// owned reports whether rotator created or adopted the key with keyID.
+func (user iamUser) owned(keyID string) bool {
+	_, ok := user.tags[awsIamOwnedTag+keyID]
+	return ok
+}
+
+func inactive(key *iam.AccessKeyMetadata) bool {
+	return aws.StringValue(key.Status) == iam.StatusTypeInactive
+}
+
+// next reports what RotateKeys does next given the state of the user.
+// With QuietPeriod, it looks up the last use of the key it would retire.
+func (src *AwsIamSource) next(ctx context.Context, user iamUser, now time.Time) (iamStep, error) {
+	step := src.decide(user, now)
+	if step.action != iamReplace && step.action != iamDeactivate {
+		return step, nil
+	}
+	id := *step.key.AccessKeyId
+	if step.action == iamDeactivate && inactive(step.key) {
+		// only the deactivation is recorded
+		return step, nil
+	}
+	if src.OwnedOnly && !user.owned(id) {
+		return iamStep{
+			action: iamBlocked,
+			key:    step.key,
+			reason: fmt.Sprintf("%s, but access key %s was not created or adopted by rotator", step.reason, id),
+		}, nil
+	}
+	if src.QuietPeriod == 0 {
+		return step, nil
+	}
+	out, err := src.Client.IAM.Svc.GetAccessKeyLastUsedWithContext(ctx, &iam.GetAccessKeyLastUsedInput{
+		AccessKeyId: aws.String(id),
+	})
+	if err != nil {
+		return iamStep{}, errors.Wrapf(err, "unable to look up last use of access key %s", id)
+	}
+	step.lastUsed = out.AccessKeyLastUsed
+	used := step.lastUsed.LastUsedDate
+	if src.force || used == nil || now.Sub(*used) >= src.QuietPeriod {
+		return step, nil
+	}
+	return iamStep{
+		action:   iamDefer,
+		key:      step.key,
+		reason:   fmt.Sprintf("%s, but access key %s%s, quiet_period is %s", step.reason, id, step.lastUse(now), src.QuietPeriod),
+		lastUsed: step.lastUsed,
+	}, nil
+}
 
-	if len(keys) == 2 {
-		olderKey := keys[0]
-		newerKey := keys[1]
+// decide reports what RotateKeys would do given the keys of the user,
+// regardless of who owns the key it retires and when it was last used.
+func (src *AwsIamSource) decide(user iamUser, now time.Time) iamStep {
+	var active, retired []*iam.AccessKeyMetadata
+	for _, k := range user.keys {
+		if inactive(k) {
+			retired = append(retired, k)
+		} else {
+			active = append(active, k)
+		}
+	}
+	switch {
+	case len(user.keys) == 0:
+		return iamStep{action: iamCreate, reason: "user has no access key"}
+	case len(active) == 0 && len(retired) == 1:
+		return iamStep{action: iamCreate, reason: fmt.Sprintf("only access key %s is inactive", *retired[0].AccessKeyId)}
+	case len(active) == 0:
+		return iamStep{action: iamReplace, key: retired[0], reason: "both access keys are inactive"}
+	case len(retired) == 0 && len(active) == 1:
+		return src.decideSingle(user, active[0], now)
+	case len(retired) == 1:
+		return src.decideInactive(user, retired[0], active[0], now)
+	}
+	return src.decideActive(user, now)
+}
 
-		// nothing to do if either key within max age
-		// -- this ensures that all jobs using the older key (i.e. before newer key is created) have completed
-		if time.Since(*olderKey.CreateDate) <= src.MaxAge || time.Since(*newerKey.CreateDate) <= src.MaxAge {
-			return nil, nil
+// decideSingle decides for a user whose only key is active. A key rotator
+// created, or one adopted with Adopt, is kept until it is older than
+// MaxAge; a second key is created next to any other key right away.
+func (src *AwsIamSource) decideSingle(user iamUser, key *iam.AccessKeyMetadata, now time.Time) iamStep {
+	step := iamStep{action: iamCreate, reason: "user has 1 access key"}
+	if src.force {
+		step.reason = "forced"
+	}
+	id := *key.AccessKeyId
+	owned := user.owned(id)
+	if !src.Adopt && !owned {
+		return step
+	}
+	if src.OwnedOnly && !owned {
+		step.key = key
+	}
+	if src.force {
+		return step
+	}
+	age := now.Sub(aws.TimeValue(key.CreateDate)).Round(time.Second)
+	step.reason = fmt.Sprintf("only access key %s is %s old, max_age is %s", id, age, src.MaxAge)
+	if age <= src.MaxAge {
+		step.action = iamKeep
+		if step.key != nil {
+			step.action = iamAdopt
 		}
+	}
+	return step
+}
 
-		// else delete key
-		_, err = svc.DeleteAccessKeyWithContext(ctx, &iam.DeleteAccessKeyInput{
-			AccessKeyId: aws.String(*olderKey.AccessKeyId),
-			UserName:    aws.String(src.UserName),
+// decideInactive decides for a user with an inactive and an active key.
+// The inactive key is retired already, and replaced once the active key
+// is due, or with InactiveGrace once it has been inactive long enough.
+// The inactive key takes up the second slot, so with InactiveGrace the
+// active key ages past MaxAge until then; this is intended, as deleting
+// the inactive key earlier would cut its grace short.
+func (src *AwsIamSource) decideInactive(user iamUser, retired *iam.AccessKeyMetadata, active *iam.AccessKeyMetadata, now time.Time) iamStep {
+	id := *retired.AccessKeyId
+	if src.InactiveGrace > 0 {
+		since, ok := user.tagTime(awsIamDeactivatedTag, id)
+		if !ok {
+			// deactivated by someone else, the grace starts now
+			return iamStep{action: iamDeactivate, key: retired, reason: fmt.Sprintf("access key %s is inactive", id)}
+		}
+		elapsed := now.Sub(since).Round(time.Second)
+		reason := fmt.Sprintf("access key %s inactive for %s, inactive_grace is %s", id, elapsed, src.InactiveGrace)
+		switch {
+		case src.force:
+			return iamStep{action: iamReplace, key: retired, reason: "forced, " + reason}
+		case elapsed < src.InactiveGrace:
+			return iamStep{action: iamKeep, reason: reason}
+		}
+		return iamStep{action: iamReplace, key: retired, reason: reason}
+	}
+	age := now.Sub(aws.TimeValue(active.CreateDate)).Round(time.Second)
+	reason := fmt.Sprintf("access key %s is inactive, access key %s is %s old, max_age is %s", id, *active.AccessKeyId, age, src.MaxAge)
+	switch {
+	case src.force:
+		return iamStep{action: iamReplace, key: retired, reason: "forced"}
+	case age <= src.MaxAge:
+		return iamStep{action: iamKeep, reason: reason}
+	}
+	return iamStep{action: iamReplace, key: retired, reason: reason}
+}
+
+// decideActive decides for a user with two active keys, which retires
+// the older key once both are older than MaxAge.
+func (src *AwsIamSource) decideActive(user iamUser, now time.Time) iamStep {
+	due, reason := src.due(user.keys, now)
+	older := user.keys[0]
+	id := *older.AccessKeyId
+	switch {
+	case !due:
+		return iamStep{action: iamKeep, reason: reason}
+	case src.InactiveGrace == 0:
+		return iamStep{action: iamReplace, key: older, reason: reason}
+	}
+	if since, ok := user.tagTime(awsIamReactivatedTag, id); ok && now.Sub(since) < src.InactiveGrace {
+		return iamStep{action: iamKeep, reason: fmt.Sprintf("access key %s reactivated %s ago, inactive_grace is %s", id, now.Sub(since).Round(time.Second), src.InactiveGrace)}
+	}
+	return iamStep{action: iamDeactivate, key: older, reason: reason}
+}
+
+// deactivate deactivates the key of the user with keyID, and records
+// when.
+func (src *AwsIamSource) deactivate(ctx context.Context, user iamUser, keyID string) error {
+	_, err := src.Client.IAM.Svc.UpdateAccessKeyWithContext(ctx, &iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String(keyID),
+		Status:      aws.String(iam.StatusTypeInactive),
+		UserName:    aws.String(src.UserName),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to deactivate access key %s", keyID)
+	}
+	err = src.tag(ctx, awsIamDeactivatedTag, keyID)
+	if err != nil {
+		return errors.Wrapf(err, "unable to record deactivation of access key %s", keyID)
+	}
+	return src.untag(ctx, user, keyID, awsIamReactivatedTag)
+}
+
+// own records that rotator owns the key with keyID.
+func (src *AwsIamSource) own(ctx context.Context, keyID string) error {
+	err := src.tag(ctx, awsIamOwnedTag, keyID)
+	return errors.Wrapf(err, "unable to record ownership of access key %s", keyID)
+}
+
+// tag tags the user with prefix followed by keyID, recording the
+// current time.
+func (src *AwsIamSource) tag(ctx context.Context, prefix string, keyID string) error {
+	_, err := src.Client.IAM.Svc.TagUserWithContext(ctx, &iam.TagUserInput{
+		UserName: aws.String(src.UserName),
+		Tags: []*iam.Tag{{
+			Key:   aws.String(prefix + keyID),
+			Value: aws.String(time.Now().UTC().Format(time.RFC3339)),
+		}},
+	})
+	return err
+}
+
+// untag removes the tags of the user named by prefixes followed by
+// keyID, if the user has them.
+func (src *AwsIamSource) untag(ctx context.Context, user iamUser, keyID string, prefixes ...string) error {
+	var keys []*string
+	for _, prefix := range prefixes {
+		if _, ok := user.tags[prefix+keyID]; ok {
+			keys = append(keys, aws.String(prefix+keyID))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := src.Client.IAM.Svc.UntagUserWithContext(ctx, &iam.UntagUserInput{
+		UserName: aws.String(src.UserName),
+		TagKeys:  keys,
+	})
+	return errors.Wrapf(err, "unable to remove tags of access key %s", keyID)
+}
+
+// Discovers reports whether the source rotates the keys of the users it
+// finds rather than those of UserName.
+func (src *AwsIamSource) Discovers() bool {
+	return src.Discovery != nil
+}
+
+// Discover lists the users matching Discovery, and returns a source
+// rotating the keys of each one, with the same settings as src. The
+// tags of every user under PathPrefix are listed, so a narrow prefix
+// saves API calls.
+func (src *AwsIamSource) Discover(ctx context.Context) ([]Discovered, error) {
+	svc := src.Client.IAM.Svc
+	var users []*iam.User
+	err := svc.ListUsersPagesWithContext(ctx, &iam.ListUsersInput{
+		PathPrefix: aws.String(src.Discovery.PathPrefix),
+	}, func(page *iam.ListUsersOutput, lastPage bool) bool {
+		users = append(users, page.Users...)
+		return true
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list users")
+	}
+
+	var found []Discovered
+	for _, u := range users {
+		name := aws.StringValue(u.UserName)
+		// users have at most 50 tags, which fit in one page
+		out, err := svc.ListUserTagsWithContext(ctx, &iam.ListUserTagsInput{
+			UserName: u.UserName,
 		})
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to delete older access key")
+			return nil, errors.Wrapf(err, "unable to list tags of user %s", name)
+		}
+		user := AwsIamUser{UserName: name, Path: aws.StringValue(u.Path), Tags: map[string]string{}}
+		for _, t := range out.Tags {
+			user.Tags[aws.StringValue(t.Key)] = aws.StringValue(t.Value)
 		}
+		if !user.matches(src.Discovery.Tags) {
+			continue
+		}
+		s := *src
+		s.UserName = name
+		s.Discovery = nil
+		s.pending = nil
+		found = append(found, Discovered{Name: name, Source: &s, Vars: user})
 	}
+	return found, nil
+}
 
-	// create a new IAM access key
-	result, err := svc.CreateAccessKeyWithContext(ctx, &iam.CreateAccessKeyInput{
+// matches reports whether the user has every tag in tags.
+func (user AwsIamUser) matches(tags map[string]string) bool {
+	for k, v := range tags {
+		if got, ok := user.Tags[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// Reactivate reactivates the inactive key of the user with accessKeyID,
+// or the only inactive key if accessKeyID is empty, e.g. when a job that
+// was missed still needs it. Rotations leave it active for InactiveGrace
+// before deactivating it again. It returns the ID of the key.
+func (src *AwsIamSource) Reactivate(ctx context.Context, accessKeyID string) (string, error) {
+	keys, err := src.listKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+	var inactive []string
+	for _, k := range keys {
+		if aws.StringValue(k.Status) == iam.StatusTypeInactive && (accessKeyID == "" || *k.AccessKeyId == accessKeyID) {
+			inactive = append(inactive, *k.AccessKeyId)
+		}
+	}
+	switch {
+	case len(inactive) == 0 && accessKeyID != "":
+		return "", errors.Errorf("user %s has no inactive access key %s", src.UserName, accessKeyID)
+	case len(inactive) != 1:
+		return "", errors.Errorf("user %s has %d inactive access keys, name the one to reactivate", src.UserName, len(inactive))
+	}
+	id := inactive[0]
+
+	svc := src.Client.IAM.Svc
+	_, err = svc.UpdateAccessKeyWithContext(ctx, &iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String(id),
+		Status:      aws.String(iam.StatusTypeActive),
+		UserName:    aws.String(src.UserName),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "unable to reactivate access key %s", id)
+	}
+	_, err = svc.UntagUserWithContext(ctx, &iam.UntagUserInput{
 		UserName: aws.String(src.UserName),
+		TagKeys:  []*string{aws.String(awsIamDeactivatedTag + id)},
 	})
-	return result.AccessKey, errors.Wrap(err, "unable to create new access key")
+	if err != nil {
+		return id, errors.Wrapf(err, "unable to remove deactivation tag of access key %s", id)
+	}
+	err = src.tag(ctx, awsIamReactivatedTag, id)
+	return id, errors.Wrapf(err, "unable to record reactivation of access key %s", id)
 }
 
-func (src *AwsIamSource) Read() (map[string]string, error) {
-	newKey, err := src.RotateKeys(context.Background())
+// listKeys returns the access keys of the user, oldest first.
+func (src *AwsIamSource) listKeys(ctx context.Context) ([]*iam.AccessKeyMetadata, error) {
+	out, err := src.Client.IAM.Svc.ListAccessKeysWithContext(ctx, &iam.ListAccessKeysInput{
+		UserName: aws.String(src.UserName),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list access keys")
+	}
+	keys := out.AccessKeyMetadata
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreateDate.Before(*keys[j].CreateDate) })
+	return keys, nil
+}
+
+// due reports whether RotateKeys would replace one of the user's two
+// active keys, oldest first, at now, and why.
+func (src *AwsIamSource) due(keys []*iam.AccessKeyMetadata, now time.Time) (bool, string) {
+	if src.force {
+		return true, "forced"
+	}
+	newest := now.Sub(*keys[1].CreateDate)
+	if now.Sub(*keys[0].CreateDate) <= src.MaxAge || newest <= src.MaxAge {
+		return false, fmt.Sprintf("newest access key is %s old, max_age is %s", newest.Round(time.Second), src.MaxAge)
+	}
+	return true, fmt.Sprintf("newest access key is %s old, max_age is %s", newest.Round(time.Second), src.MaxAge)
+}
+
+// Plan reports whether Create would create a new key, without
+// changing anything.
+func (src *AwsIamSource) Plan(ctx context.Context) (Plan, error) {
+	user, err := src.lookup(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	now := time.Now()
+	step, err := src.next(ctx, user, now)
+	if err != nil {
+		return Plan{}, err
+	}
+	reason := step.reason
+	switch step.action {
+	case iamBlocked:
+		return Plan{}, errors.New(step.reason)
+	case iamAdopt:
+		reason = fmt.Sprintf("%s, would adopt access key %s", reason, *step.key.AccessKeyId)
+	case iamDeactivate:
+		reason = fmt.Sprintf("%s, would deactivate access key %s%s", reason, *step.key.AccessKeyId, step.lastUse(now))
+	case iamReplace:
+		reason = fmt.Sprintf("%s, would delete access key %s%s", reason, *step.key.AccessKeyId, step.lastUse(now))
+	}
+	due := step.action == iamCreate || step.action == iamReplace
+	return Plan{Due: due, Reason: reason, Keys: []string{AwsAccessKeyID, AwsSecretAccessKey}}, nil
+}
+
+// Force makes the next rotation create a new key even if the user's
+// keys are within MaxAge.
+func (src *AwsIamSource) Force() {
+	src.force = true
+}
+
+func (src *AwsIamSource) Read(ctx context.Context) (map[string]string, error) {
+	creds, err := src.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return creds, src.Activate(ctx)
+}
+
+// Create rotates the keys of the user and stages the new key until
+// it is activated or revoked. Any key deleted to make room for the
+// new one is inactive or already past MaxAge, and the key in use is
+// left untouched until the next rotation.
+func (src *AwsIamSource) Create(ctx context.Context) (map[string]string, error) {
+	newKey, err := src.RotateKeys(ctx)
 	if err != nil {
 		return nil, errors.Wrap(err, "unable to rotate keys")
 	}
 	if newKey == nil {
 		return nil, nil
 	}
+	src.pending = newKey
 	creds := map[string]string{
 		AwsAccessKeyID:     *newKey.AccessKeyId,
 		AwsSecretAccessKey: *newKey.SecretAccessKey,
@@ -113,6 +810,44 @@ func (src *AwsIamSource) Read() (map[string]string, error) {
 	return creds, nil
 }
 
+// Activate accepts the key staged by Create. The previous key stays
+// active so that jobs which already read it can complete; it is
+// retired by the next rotation once MaxAge has passed.
+func (src *AwsIamSource) Activate(ctx context.Context) error {
+	src.pending = nil
+	return nil
+}
+
+// Revoke deletes the key staged by Create, and the tag recording that
+// rotator owns it.
+func (src *AwsIamSource) Revoke(ctx context.Context) error {
+	if src.pending == nil {
+		return nil
+	}
+	keyID := *src.pending.AccessKeyId
+	_, err := src.Client.IAM.Svc.DeleteAccessKeyWithContext(ctx, &iam.DeleteAccessKeyInput{
+		AccessKeyId: src.pending.AccessKeyId,
+		UserName:    aws.String(src.UserName),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "unable to delete access key %s", keyID)
+	}
+	src.pending = nil
+	if src.tagsUser() {
+		_, err = src.Client.IAM.Svc.UntagUserWithContext(ctx, &iam.UntagUserInput{
+			UserName: aws.String(src.UserName),
+			TagKeys:  []*string{aws.String(awsIamOwnedTag + keyID)},
+		})
+		return errors.Wrapf(err, "unable to remove tags of access key %s", keyID)
+	}
+	return nil
+}
+
+// CredentialID returns the access key ID of creds.
+func (src *AwsIamSource) CredentialID(creds map[string]string) string {
+	return creds[AwsAccessKeyID]
+}
+
 func (src *AwsIamSource) Kind() Kind {
 	return KindAws
 }
@@ -6,11 +6,13 @@ import (
 	"testing"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/iam"
 	cziAws "github.com/chanzuckerberg/go-misc/aws"
 	awsMocks "github.com/chanzuckerberg/go-misc/aws/mocks"
 	"github.com/chanzuckerberg/rotator/pkg/source"
 	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
@@ -169,6 +171,591 @@ func (ts *TestSuite) TestAwsIamRotateTwoKeysNoneOlder() {
 	r.Nil(newKey)
 }
 
+func (ts *TestSuite) TestAwsIamCreateThenRevoke() {
+	t := ts.T()
+	r := require.New(t)
+
+	// mock aws list access keys functionality
+	keys := &iam.ListAccessKeysOutput{}
+	ts.mockIAM.EXPECT().ListAccessKeysWithContext(gomock.Any(), gomock.Any()).Return(keys, nil)
+
+	// stage a new key
+	creds, err := ts.src.Create(ts.ctx)
+	r.NoError(err)
+	r.Equal("newAccessKeyId", creds[source.AwsAccessKeyID])
+	r.Equal("newSecretAccessKey", creds[source.AwsSecretAccessKey])
+
+	// revoking deletes the staged key, and only once
+	r.NoError(ts.src.Revoke(ts.ctx))
+	r.NoError(ts.src.Revoke(ts.ctx))
+}
+
+func (ts *TestSuite) TestAwsIamPlanAndForce() {
+	t := ts.T()
+	r := require.New(t)
+
+	// mock aws list access keys functionality
+	key1 := &iam.AccessKeyMetadata{}
+	key1.SetAccessKeyId("accessKeyId1")
+	key1.SetCreateDate(time.Now().Add(-10 * time.Minute))
+	key2 := &iam.AccessKeyMetadata{}
+	key2.SetAccessKeyId("accessKeyId2")
+	key2.SetCreateDate(time.Now().Add(-10000 * time.Minute))
+	keys := &iam.ListAccessKeysOutput{}
+	keys.SetAccessKeyMetadata([]*iam.AccessKeyMetadata{
+		key1,
+		key2,
+	})
+	ts.mockIAM.EXPECT().ListAccessKeysWithContext(gomock.Any(), gomock.Any()).Return(keys, nil).Times(3)
+
+	// planning changes nothing and reports the newest key is within max age
+	plan, err := ts.src.Plan(ts.ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Contains(plan.Reason, "max_age")
+	r.ElementsMatch([]string{source.AwsAccessKeyID, source.AwsSecretAccessKey}, plan.Keys)
+
+	// forcing makes the keys due
+	ts.src.Force()
+	plan, err = ts.src.Plan(ts.ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	newKey, err := ts.src.RotateKeys(ts.ctx)
+	r.NoError(err)
+	r.NotNil(newKey)
+}
+
 func TestProviderSuite(t *testing.T) {
 	suite.Run(t, new(TestSuite))
 }
+
+func newGraceTest(t *testing.T) (*source.AwsIamSource, *awsMocks.MockIAMAPI, func()) {
+	ctrl := gomock.NewController(t)
+	sess, server := cziAws.NewMockSession()
+	client, mockIAM := cziAws.New(sess).WithMockIAM(ctrl)
+	src := source.NewAwsIamSource().WithUserName(userName).WithAwsClient(client).
+		WithMaxAge(time.Hour).
+		WithInactiveGrace(24 * time.Hour)
+	return src, mockIAM, func() {
+		ctrl.Finish()
+		server.Close()
+	}
+}
+
+func accessKey(id string, age time.Duration, status string) *iam.AccessKeyMetadata {
+	key := &iam.AccessKeyMetadata{}
+	key.SetAccessKeyId(id)
+	key.SetCreateDate(time.Now().Add(-age))
+	key.SetStatus(status)
+	return key
+}
+
+func expectUser(mockIAM *awsMocks.MockIAMAPI, keys []*iam.AccessKeyMetadata, tags map[string]string) {
+	mockIAM.EXPECT().ListAccessKeysWithContext(gomock.Any(), gomock.Any()).
+		Return(&iam.ListAccessKeysOutput{AccessKeyMetadata: keys}, nil)
+	out := &iam.ListUserTagsOutput{}
+	for k, v := range tags {
+		out.Tags = append(out.Tags, &iam.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	mockIAM.EXPECT().ListUserTagsWithContext(gomock.Any(), gomock.Any()).Return(out, nil)
+}
+
+func TestAwsIamInactiveGraceDeactivates(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mockIAM, teardown := newGraceTest(t)
+	defer teardown()
+	keys := []*iam.AccessKeyMetadata{
+		accessKey("older", 3*time.Hour, iam.StatusTypeActive),
+		accessKey("newer", 2*time.Hour, iam.StatusTypeActive),
+	}
+
+	// both keys are past max_age: the older one is deactivated, not
+	// deleted, and no key is created yet
+	expectUser(mockIAM, keys, nil)
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Contains(plan.Reason, "would deactivate access key older")
+
+	expectUser(mockIAM, keys, map[string]string{"rotator:reactivated:older": "2020-01-01T00:00:00Z"})
+	mockIAM.EXPECT().UpdateAccessKeyWithContext(gomock.Any(), &iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String("older"),
+		Status:      aws.String(iam.StatusTypeInactive),
+		UserName:    aws.String(userName),
+	}).Return(&iam.UpdateAccessKeyOutput{}, nil)
+	mockIAM.EXPECT().TagUserWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, in *iam.TagUserInput, _ ...interface{}) (*iam.TagUserOutput, error) {
+			r.Equal("rotator:deactivated:older", *in.Tags[0].Key)
+			deactivated, err := time.Parse(time.RFC3339, *in.Tags[0].Value)
+			r.NoError(err)
+			r.WithinDuration(time.Now(), deactivated, time.Minute)
+			return &iam.TagUserOutput{}, nil
+		})
+	mockIAM.EXPECT().UntagUserWithContext(gomock.Any(), &iam.UntagUserInput{
+		UserName: aws.String(userName),
+		TagKeys:  []*string{aws.String("rotator:reactivated:older")},
+	}).Return(&iam.UntagUserOutput{}, nil)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+}
+
+func TestAwsIamInactiveGraceDeletes(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mockIAM, teardown := newGraceTest(t)
+	defer teardown()
+	keys := []*iam.AccessKeyMetadata{
+		accessKey("older", 30*time.Hour, iam.StatusTypeInactive),
+		accessKey("newer", 29*time.Hour, iam.StatusTypeActive),
+	}
+	recent := map[string]string{"rotator:deactivated:older": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)}
+	past := map[string]string{"rotator:deactivated:older": time.Now().Add(-25 * time.Hour).UTC().Format(time.RFC3339)}
+
+	// the inactive key is kept within inactive_grace
+	expectUser(mockIAM, keys, recent)
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Contains(plan.Reason, "access key older inactive for 1h0m")
+	expectUser(mockIAM, keys, recent)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// and deleted, along with its tag, once it has passed
+	expectUser(mockIAM, keys, past)
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	expectUser(mockIAM, keys, past)
+	mockIAM.EXPECT().DeleteAccessKeyWithContext(gomock.Any(), &iam.DeleteAccessKeyInput{
+		AccessKeyId: aws.String("older"),
+		UserName:    aws.String(userName),
+	}).Return(&iam.DeleteAccessKeyOutput{}, nil)
+	mockIAM.EXPECT().UntagUserWithContext(gomock.Any(), &iam.UntagUserInput{
+		UserName: aws.String(userName),
+		TagKeys:  []*string{aws.String("rotator:deactivated:older")},
+	}).Return(&iam.UntagUserOutput{}, nil)
+	key := &iam.AccessKey{AccessKeyId: aws.String("new"), SecretAccessKey: aws.String("secret")}
+	mockIAM.EXPECT().CreateAccessKeyWithContext(gomock.Any(), gomock.Any()).
+		Return(&iam.CreateAccessKeyOutput{AccessKey: key}, nil)
+	// the new key is recorded as owned, so that it is not joined by a
+	// second key while it is the only one
+	mockIAM.EXPECT().TagUserWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, in *iam.TagUserInput, _ ...interface{}) (*iam.TagUserOutput, error) {
+			r.Equal("rotator:owned:new", *in.Tags[0].Key)
+			return &iam.TagUserOutput{}, nil
+		})
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal("new", creds[source.AwsAccessKeyID])
+}
+
+func TestAwsIamInactiveGraceReactivated(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mockIAM, teardown := newGraceTest(t)
+	defer teardown()
+	keys := []*iam.AccessKeyMetadata{
+		accessKey("older", 3*time.Hour, iam.StatusTypeActive),
+		accessKey("newer", 2*time.Hour, iam.StatusTypeActive),
+	}
+
+	// a reactivated key is left active for inactive_grace
+	expectUser(mockIAM, keys, map[string]string{"rotator:reactivated:older": time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)})
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Contains(plan.Reason, "access key older reactivated 1h0m")
+
+	// a key deactivated by someone else starts its grace now
+	keys[0].SetStatus(iam.StatusTypeInactive)
+	expectUser(mockIAM, keys, nil)
+	mockIAM.EXPECT().UpdateAccessKeyWithContext(gomock.Any(), gomock.Any()).Return(&iam.UpdateAccessKeyOutput{}, nil)
+	mockIAM.EXPECT().TagUserWithContext(gomock.Any(), gomock.Any()).Return(&iam.TagUserOutput{}, nil)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+}
+
+func TestAwsIamReactivate(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mockIAM, teardown := newGraceTest(t)
+	defer teardown()
+	list := func(keys ...*iam.AccessKeyMetadata) {
+		mockIAM.EXPECT().ListAccessKeysWithContext(gomock.Any(), gomock.Any()).
+			Return(&iam.ListAccessKeysOutput{AccessKeyMetadata: keys}, nil)
+	}
+	inactive := accessKey("older", 3*time.Hour, iam.StatusTypeInactive)
+	active := accessKey("newer", 2*time.Hour, iam.StatusTypeActive)
+
+	list(inactive, active)
+	mockIAM.EXPECT().UpdateAccessKeyWithContext(gomock.Any(), &iam.UpdateAccessKeyInput{
+		AccessKeyId: aws.String("older"),
+		Status:      aws.String(iam.StatusTypeActive),
+		UserName:    aws.String(userName),
+	}).Return(&iam.UpdateAccessKeyOutput{}, nil)
+	mockIAM.EXPECT().UntagUserWithContext(gomock.Any(), &iam.UntagUserInput{
+		UserName: aws.String(userName),
+		TagKeys:  []*string{aws.String("rotator:deactivated:older")},
+	}).Return(&iam.UntagUserOutput{}, nil)
+	mockIAM.EXPECT().TagUserWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, in *iam.TagUserInput, _ ...interface{}) (*iam.TagUserOutput, error) {
+			r.Equal("rotator:reactivated:older", *in.Tags[0].Key)
+			return &iam.TagUserOutput{}, nil
+		})
+	id, err := src.Reactivate(ctx, "")
+	r.NoError(err)
+	r.Equal("older", id)
+
+	// only inactive keys can be reactivated
+	list(inactive, active)
+	_, err = src.Reactivate(ctx, "newer")
+	r.Error(err)
+	list(active)
+	_, err = src.Reactivate(ctx, "")
+	r.Error(err)
+}
+
+func TestAwsIamQuietPeriod(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sess, server := cziAws.NewMockSession()
+	defer server.Close()
+	client, mockIAM := cziAws.New(sess).WithMockIAM(ctrl)
+	src := source.NewAwsIamSource().WithUserName(userName).WithAwsClient(client).
+		WithMaxAge(time.Hour).
+		WithQuietPeriod(24 * time.Hour)
+	keys := []*iam.AccessKeyMetadata{
+		accessKey("older", 3*time.Hour, iam.StatusTypeActive),
+		accessKey("newer", 2*time.Hour, iam.StatusTypeActive),
+	}
+	expect := func(lastUsed time.Duration) {
+		mockIAM.EXPECT().ListAccessKeysWithContext(gomock.Any(), gomock.Any()).
+			Return(&iam.ListAccessKeysOutput{AccessKeyMetadata: keys}, nil)
+		used := &iam.AccessKeyLastUsed{ServiceName: aws.String("s3"), Region: aws.String("us-west-2")}
+		used.SetLastUsedDate(time.Now().Add(-lastUsed))
+		mockIAM.EXPECT().GetAccessKeyLastUsedWithContext(gomock.Any(), &iam.GetAccessKeyLastUsedInput{
+			AccessKeyId: aws.String("older"),
+		}).Return(&iam.GetAccessKeyLastUsedOutput{AccessKeyLastUsed: used}, nil)
+	}
+
+	// the older key is past max_age but was used within quiet_period
+	expect(time.Hour)
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Contains(plan.Reason, "access key older, last used 1h0m0s ago by s3 in us-west-2, quiet_period is 24h0m0s")
+	expect(time.Hour)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// or refused
+	src.FailOnRecentUse = true
+	expect(time.Hour)
+	_, err = src.Create(ctx)
+	r.Error(err)
+	r.Contains(err.Error(), "by s3 in us-west-2")
+
+	// once quiet, it is deleted
+	expect(25 * time.Hour)
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Contains(plan.Reason, "last used 25h0m0s ago by s3 in us-west-2")
+	expect(25 * time.Hour)
+	mockIAM.EXPECT().DeleteAccessKeyWithContext(gomock.Any(), gomock.Any()).Return(&iam.DeleteAccessKeyOutput{}, nil)
+	key := &iam.AccessKey{AccessKeyId: aws.String("new"), SecretAccessKey: aws.String("secret")}
+	mockIAM.EXPECT().CreateAccessKeyWithContext(gomock.Any(), gomock.Any()).
+		Return(&iam.CreateAccessKeyOutput{AccessKey: key}, nil).Times(2)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal("new", creds[source.AwsAccessKeyID])
+
+	// and a forced rotation does not wait
+	src.Force()
+	expect(time.Hour)
+	mockIAM.EXPECT().DeleteAccessKeyWithContext(gomock.Any(), gomock.Any()).Return(&iam.DeleteAccessKeyOutput{}, nil)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+
+	r.Error(source.NewAwsIamSource().WithRoleArn("arn").WithQuietPeriod(-time.Hour).Validate())
+	src = source.NewAwsIamSource().WithRoleArn("arn")
+	src.FailOnRecentUse = true
+	r.Error(src.Validate())
+}
+
+func TestAwsIamDiscover(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sess, server := cziAws.NewMockSession()
+	defer server.Close()
+	client, mockIAM := cziAws.New(sess).WithMockIAM(ctrl)
+	src := source.NewAwsIamSource().WithRoleArn("arn").WithAwsClient(client).
+		WithMaxAge(time.Hour).
+		WithQuietPeriod(time.Hour).
+		WithDiscovery(&source.AwsIamDiscovery{PathPrefix: "/ci/", Tags: map[string]string{"rotator:managed": "true"}})
+	r.NoError(src.Validate())
+	r.True(src.Discovers())
+
+	mockIAM.EXPECT().ListUsersPagesWithContext(gomock.Any(), &iam.ListUsersInput{PathPrefix: aws.String("/ci/")}, gomock.Any()).DoAndReturn(
+		func(ctx context.Context, in *iam.ListUsersInput, fn func(*iam.ListUsersOutput, bool) bool, _ ...interface{}) error {
+			fn(&iam.ListUsersOutput{Users: []*iam.User{
+				{UserName: aws.String("ci-app"), Path: aws.String("/ci/")},
+			}}, false)
+			fn(&iam.ListUsersOutput{Users: []*iam.User{
+				{UserName: aws.String("ci-legacy"), Path: aws.String("/ci/")},
+			}}, true)
+			return nil
+		})
+	tags := map[string][]*iam.Tag{
+		"ci-app": {
+			{Key: aws.String("rotator:managed"), Value: aws.String("true")},
+			{Key: aws.String("ci_repo"), Value: aws.String("app")},
+		},
+		"ci-legacy": {
+			{Key: aws.String("ci_repo"), Value: aws.String("legacy")},
+		},
+	}
+	mockIAM.EXPECT().ListUserTagsWithContext(gomock.Any(), gomock.Any()).Times(2).DoAndReturn(
+		func(ctx context.Context, in *iam.ListUserTagsInput, _ ...interface{}) (*iam.ListUserTagsOutput, error) {
+			return &iam.ListUserTagsOutput{Tags: tags[*in.UserName]}, nil
+		})
+
+	found, err := src.Discover(ctx)
+	r.NoError(err)
+	r.Len(found, 1)
+	r.Equal("ci-app", found[0].Name)
+	r.Equal(source.AwsIamUser{
+		UserName: "ci-app",
+		Path:     "/ci/",
+		Tags:     map[string]string{"rotator:managed": "true", "ci_repo": "app"},
+	}, found[0].Vars)
+	user := found[0].Source.(*source.AwsIamSource)
+	r.Equal("ci-app", user.UserName)
+	r.Nil(user.Discovery)
+	r.Equal(time.Hour, user.QuietPeriod)
+	r.False(user.Discovers())
+
+	r.False(source.NewAwsIamSource().WithUserName(userName).Discovers())
+	r.Error(source.NewAwsIamSource().WithRoleArn("arn").WithUserName(userName).
+		WithDiscovery(&source.AwsIamDiscovery{PathPrefix: "/ci/"}).Validate())
+	r.Error(source.NewAwsIamSource().WithRoleArn("arn").WithDiscovery(&source.AwsIamDiscovery{}).Validate())
+}
+
+func TestAwsIamKeyStates(t *testing.T) {
+	ctx := context.Background()
+	owned := func(ids ...string) map[string]string {
+		tags := map[string]string{}
+		for _, id := range ids {
+			tags["rotator:owned:"+id] = "2020-01-01T00:00:00Z"
+		}
+		return tags
+	}
+	active, inactive := iam.StatusTypeActive, iam.StatusTypeInactive
+
+	tests := map[string]struct {
+		adopt     bool
+		ownedOnly bool
+		keys      []*iam.AccessKeyMetadata
+		tags      map[string]string
+		due       bool
+		reason    string
+		err       string
+	}{
+		"no key": {
+			due:    true,
+			reason: "user has no access key",
+		},
+		"only an inactive key": {
+			keys:   []*iam.AccessKeyMetadata{accessKey("a", time.Minute, inactive)},
+			due:    true,
+			reason: "only access key a is inactive",
+		},
+		"two inactive keys": {
+			keys:   []*iam.AccessKeyMetadata{accessKey("a", 3*time.Hour, inactive), accessKey("b", 2*time.Hour, inactive)},
+			due:    true,
+			reason: "both access keys are inactive, would delete access key a",
+		},
+		"a fresh key": {
+			keys:   []*iam.AccessKeyMetadata{accessKey("a", 10*time.Minute, active)},
+			due:    true,
+			reason: "user has 1 access key",
+		},
+		"an adopted fresh key": {
+			adopt:  true,
+			keys:   []*iam.AccessKeyMetadata{accessKey("a", 10*time.Minute, active)},
+			reason: "only access key a is 10m0s old, max_age is 1h0m0s",
+		},
+		"an adopted stale key": {
+			adopt:  true,
+			keys:   []*iam.AccessKeyMetadata{accessKey("a", 2*time.Hour, active)},
+			due:    true,
+			reason: "only access key a is 2h0m0s old",
+		},
+		"a stale key next to an inactive one": {
+			keys:   []*iam.AccessKeyMetadata{accessKey("a", 3*time.Hour, active), accessKey("b", 2*time.Hour, inactive)},
+			due:    true,
+			reason: "access key b is inactive, access key a is 3h0m0s old, max_age is 1h0m0s, would delete access key b",
+		},
+		"a fresh key next to an inactive one": {
+			keys:   []*iam.AccessKeyMetadata{accessKey("a", 2*time.Hour, inactive), accessKey("b", 10*time.Minute, active)},
+			reason: "access key a is inactive, access key b is 10m0s old",
+		},
+		"two stale owned keys": {
+			ownedOnly: true,
+			keys:      []*iam.AccessKeyMetadata{accessKey("a", 3*time.Hour, active), accessKey("b", 2*time.Hour, active)},
+			tags:      owned("a", "b"),
+			due:       true,
+			reason:    "would delete access key a",
+		},
+		"a stale key rotator does not own": {
+			ownedOnly: true,
+			keys:      []*iam.AccessKeyMetadata{accessKey("a", 3*time.Hour, active), accessKey("b", 2*time.Hour, active)},
+			tags:      owned("b"),
+			err:       "access key a was not created or adopted by rotator",
+		},
+		"a fresh key rotator owns": {
+			ownedOnly: true,
+			keys:      []*iam.AccessKeyMetadata{accessKey("a", 10*time.Minute, active)},
+			tags:      owned("a"),
+			reason:    "only access key a is 10m0s old, max_age is 1h0m0s",
+		},
+		"a stale key rotator owns": {
+			ownedOnly: true,
+			keys:      []*iam.AccessKeyMetadata{accessKey("a", 2*time.Hour, active)},
+			tags:      owned("a"),
+			due:       true,
+			reason:    "only access key a is 2h0m0s old",
+		},
+		"a fresh key rotator does not own, without adopt": {
+			ownedOnly: true,
+			keys:      []*iam.AccessKeyMetadata{accessKey("a", 10*time.Minute, active)},
+			due:       true,
+			reason:    "user has 1 access key",
+		},
+		"a fresh key rotator does not own": {
+			ownedOnly: true,
+			adopt:     true,
+			keys:      []*iam.AccessKeyMetadata{accessKey("a", 10*time.Minute, active)},
+			reason:    "would adopt access key a",
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r := require.New(t)
+			ctrl := gomock.NewController(t)
+			defer ctrl.Finish()
+			sess, server := cziAws.NewMockSession()
+			defer server.Close()
+			client, mockIAM := cziAws.New(sess).WithMockIAM(ctrl)
+			src := source.NewAwsIamSource().WithUserName(userName).WithAwsClient(client).WithMaxAge(time.Hour)
+			src.Adopt = test.adopt
+			src.OwnedOnly = test.ownedOnly
+			if test.ownedOnly {
+				expectUser(mockIAM, test.keys, test.tags)
+			} else {
+				mockIAM.EXPECT().ListAccessKeysWithContext(gomock.Any(), gomock.Any()).
+					Return(&iam.ListAccessKeysOutput{AccessKeyMetadata: test.keys}, nil)
+			}
+
+			plan, err := src.Plan(ctx)
+			if test.err != "" {
+				r.Error(err)
+				r.Contains(err.Error(), test.err)
+				return
+			}
+			r.NoError(err)
+			r.Equal(test.due, plan.Due)
+			r.Contains(plan.Reason, test.reason)
+		})
+	}
+}
+
+func TestAwsIamOwnedOnly(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sess, server := cziAws.NewMockSession()
+	defer server.Close()
+	client, mockIAM := cziAws.New(sess).WithMockIAM(ctrl)
+	src := source.NewAwsIamSource().WithUserName(userName).WithAwsClient(client).WithMaxAge(time.Hour)
+	src.Adopt = true
+	src.OwnedOnly = true
+	expectTag := func(key string) {
+		mockIAM.EXPECT().TagUserWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+			func(ctx context.Context, in *iam.TagUserInput, _ ...interface{}) (*iam.TagUserOutput, error) {
+				r.Equal(key, *in.Tags[0].Key)
+				return &iam.TagUserOutput{}, nil
+			})
+	}
+	newKey := &iam.AccessKey{AccessKeyId: aws.String("new"), SecretAccessKey: aws.String("secret")}
+
+	// a fresh key set up by hand is adopted
+	expectUser(mockIAM, []*iam.AccessKeyMetadata{accessKey("manual", 10*time.Minute, iam.StatusTypeActive)}, nil)
+	expectTag("rotator:owned:manual")
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// keys rotator creates are owned, and their tags removed with them
+	keys := []*iam.AccessKeyMetadata{
+		accessKey("manual", 3*time.Hour, iam.StatusTypeActive),
+		accessKey("newer", 2*time.Hour, iam.StatusTypeActive),
+	}
+	expectUser(mockIAM, keys, map[string]string{"rotator:owned:manual": "2020-01-01T00:00:00Z"})
+	mockIAM.EXPECT().DeleteAccessKeyWithContext(gomock.Any(), &iam.DeleteAccessKeyInput{
+		AccessKeyId: aws.String("manual"),
+		UserName:    aws.String(userName),
+	}).Return(&iam.DeleteAccessKeyOutput{}, nil)
+	mockIAM.EXPECT().UntagUserWithContext(gomock.Any(), &iam.UntagUserInput{
+		UserName: aws.String(userName),
+		TagKeys:  []*string{aws.String("rotator:owned:manual")},
+	}).Return(&iam.UntagUserOutput{}, nil)
+	mockIAM.EXPECT().CreateAccessKeyWithContext(gomock.Any(), gomock.Any()).
+		Return(&iam.CreateAccessKeyOutput{AccessKey: newKey}, nil)
+	expectTag("rotator:owned:new")
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal("new", creds[source.AwsAccessKeyID])
+
+	// revoking the new key removes its tag with it
+	mockIAM.EXPECT().DeleteAccessKeyWithContext(gomock.Any(), &iam.DeleteAccessKeyInput{
+		AccessKeyId: aws.String("new"),
+		UserName:    aws.String(userName),
+	}).Return(&iam.DeleteAccessKeyOutput{}, nil)
+	mockIAM.EXPECT().UntagUserWithContext(gomock.Any(), &iam.UntagUserInput{
+		UserName: aws.String(userName),
+		TagKeys:  []*string{aws.String("rotator:owned:new")},
+	}).Return(&iam.UntagUserOutput{}, nil)
+	r.NoError(src.Revoke(ctx))
+
+	// a key rotator does not own is never deleted
+	expectUser(mockIAM, keys, nil)
+	_, err = src.Create(ctx)
+	r.Error(err)
+	r.Contains(err.Error(), "access key manual was not created or adopted by rotator")
+
+	// nor is a new key left behind if its ownership cannot be recorded
+	expectUser(mockIAM, nil, nil)
+	mockIAM.EXPECT().CreateAccessKeyWithContext(gomock.Any(), gomock.Any()).
+		Return(&iam.CreateAccessKeyOutput{AccessKey: newKey}, nil)
+	mockIAM.EXPECT().TagUserWithContext(gomock.Any(), gomock.Any()).Return(nil, errors.New("access denied"))
+	mockIAM.EXPECT().DeleteAccessKeyWithContext(gomock.Any(), &iam.DeleteAccessKeyInput{
+		AccessKeyId: aws.String("new"),
+		UserName:    aws.String(userName),
+	}).Return(&iam.DeleteAccessKeyOutput{}, nil)
+	_, err = src.Create(ctx)
+	r.Error(err)
+	r.Contains(err.Error(), "unable to record ownership of access key new")
+}
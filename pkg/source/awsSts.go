@@ -0,0 +1,280 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+	cziAws "github.com/chanzuckerberg/go-misc/aws"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Key for the session token in the map returned by AwsStsSource,
+	// next to AwsAccessKeyID and AwsSecretAccessKey
+	AwsSessionToken string = "sessionToken"
+)
+
+const (
+	// DefaultStsDuration is how long the credentials of sources that do
+	// not set a duration are valid.
+	DefaultStsDuration = time.Hour
+	// DefaultStsSessionName names the sessions of sources that do not
+	// set a session name.
+	DefaultStsSessionName = "rotator"
+	// minStsDuration and maxStsDuration are the bounds AWS STS puts on
+	// the duration of a session.
+	minStsDuration = 15 * time.Minute
+	maxStsDuration = 12 * time.Hour
+)
+
+// AwsStsSource is a source that hands out temporary credentials of an
+// AWS IAM role, assumed with AWS STS, so that no long-lived access key
+// has to be distributed.
+//
+// The access key ID and expiry of the credentials are their
+// CredentialID, and Create only hands out new credentials once those
+// distributed last expire within RenewBefore, see Versioned. Without a
+// state store, every rotation hands out new credentials.
+type AwsStsSource struct {
+	// RoleArn is the role the credentials handed out are for. It is
+	// assumed with the credentials rotator runs with.
+	RoleArn    string `yaml:"role_arn"`
+	ExternalID string `yaml:"external_id,omitempty"`
+	// SessionName names the sessions, e.g. in CloudTrail. Defaults to
+	// DefaultStsSessionName.
+	SessionName string `yaml:"session_name,omitempty"`
+	// Duration is how long the credentials are valid. Defaults to
+	// DefaultStsDuration, and is capped by the maximum session duration
+	// of the role.
+	Duration time.Duration `yaml:"duration,omitempty"`
+	// Policy is an inline session policy, as JSON, and PolicyArns are
+	// managed session policies. They further restrict what the
+	// credentials allow.
+	Policy     string   `yaml:"policy,omitempty"`
+	PolicyArns []string `yaml:"policy_arns,omitempty"`
+	// RenewBefore is the remaining lifetime of the credentials
+	// distributed last below which new ones are handed out. Defaults to
+	// half of Duration.
+	RenewBefore time.Duration  `yaml:"renew_before,omitempty"`
+	Client      *cziAws.Client `yaml:"-"`
+
+	// distributed is the CredentialID of the credentials distributed last.
+	distributed string
+	// accessKeyID and expiration are those of the credentials last
+	// handed out.
+	accessKeyID string
+	expiration  time.Time
+	// force makes the next rotation hand out new credentials even if
+	// those distributed last are still valid long enough.
+	force bool
+}
+
+func init() {
+	Register(KindAwsSts, func() Source { return NewAwsStsSource() })
+}
+
+func NewAwsStsSource() *AwsStsSource {
+	return &AwsStsSource{}
+}
+
+func (src *AwsStsSource) WithRoleArn(roleArn string) *AwsStsSource {
+	src.RoleArn = roleArn
+	return src
+}
+
+func (src *AwsStsSource) WithDuration(duration time.Duration) *AwsStsSource {
+	src.Duration = duration
+	return src
+}
+
+func (src *AwsStsSource) WithRenewBefore(renewBefore time.Duration) *AwsStsSource {
+	src.RenewBefore = renewBefore
+	return src
+}
+
+func (src *AwsStsSource) WithAwsClient(client *cziAws.Client) *AwsStsSource {
+	src.Client = client
+	return src
+}
+
+func (src *AwsStsSource) Kind() Kind {
+	return KindAwsSts
+}
+
+// Validate checks that the role is set, that the duration is within the
+// bounds of AWS STS, that the credentials are renewed before they expire
+// and that the session policy is JSON.
+func (src *AwsStsSource) Validate() error {
+	var errs *multierror.Error
+	if src.RoleArn == "" {
+		errs = multierror.Append(errs, errors.New("missing role_arn"))
+	}
+	if src.Duration != 0 && (src.Duration < minStsDuration || src.Duration > maxStsDuration) {
+		errs = multierror.Append(errs, errors.Errorf("duration must be between %s and %s", minStsDuration, maxStsDuration))
+	}
+	if src.RenewBefore < 0 {
+		errs = multierror.Append(errs, errors.New("renew_before must not be negative"))
+	}
+	if src.RenewBefore >= src.duration() {
+		errs = multierror.Append(errs, errors.New("renew_before must be shorter than duration"))
+	}
+	if src.Policy != "" && !json.Valid([]byte(src.Policy)) {
+		errs = multierror.Append(errs, errors.New("policy is not valid JSON"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up an STS client with the credentials rotator runs with. It
+// does nothing if a client is already set.
+func (src *AwsStsSource) Init() error {
+	if src.Client != nil {
+		return nil
+	}
+	sess, err := session.NewSession(&aws.Config{})
+	if err != nil {
+		return errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
+	}
+	src.Client = cziAws.New(sess).WithSTS(sess.Config)
+	return nil
+}
+
+func (src *AwsStsSource) duration() time.Duration {
+	if src.Duration == 0 {
+		return DefaultStsDuration
+	}
+	return src.Duration
+}
+
+func (src *AwsStsSource) renewBefore() time.Duration {
+	if src.RenewBefore == 0 {
+		return src.duration() / 2
+	}
+	return src.RenewBefore
+}
+
+// assume assumes RoleArn and returns the credentials of the session.
+func (src *AwsStsSource) assume(ctx context.Context) (map[string]string, error) {
+	p := &stscreds.AssumeRoleProvider{
+		Client:          src.Client.STS.Svc,
+		RoleARN:         src.RoleArn,
+		RoleSessionName: DefaultStsSessionName,
+		Duration:        src.duration(),
+	}
+	if src.SessionName != "" {
+		p.RoleSessionName = src.SessionName
+	}
+	if src.ExternalID != "" {
+		p.ExternalID = aws.String(src.ExternalID)
+	}
+	if src.Policy != "" {
+		p.Policy = aws.String(src.Policy)
+	}
+	for _, arn := range src.PolicyArns {
+		p.PolicyArns = append(p.PolicyArns, &sts.PolicyDescriptorType{Arn: aws.String(arn)})
+	}
+	v, err := p.RetrieveWithContext(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to assume role %s", src.RoleArn)
+	}
+	src.accessKeyID = v.AccessKeyID
+	src.expiration = p.ExpiresAt()
+	return map[string]string{
+		AwsAccessKeyID:     v.AccessKeyID,
+		AwsSecretAccessKey: v.SecretAccessKey,
+		AwsSessionToken:    v.SessionToken,
+	}, nil
+}
+
+// id returns the CredentialID of the credentials with accessKeyID that
+// expire at expiration.
+func (src *AwsStsSource) id(accessKeyID string, expiration time.Time) string {
+	return fmt.Sprintf("%s@%s", accessKeyID, expiration.UTC().Format(time.RFC3339))
+}
+
+// stsExpiration returns the expiry recorded in credentialID, if any.
+func stsExpiration(credentialID string) (time.Time, bool) {
+	i := strings.LastIndex(credentialID, "@")
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, credentialID[i+1:])
+	return t, err == nil
+}
+
+// due reports whether new credentials should be handed out, and why.
+func (src *AwsStsSource) due(now time.Time) (bool, string) {
+	if src.force {
+		return true, "forced"
+	}
+	if src.distributed == "" {
+		return true, "no credentials distributed yet"
+	}
+	expiration, ok := stsExpiration(src.distributed)
+	if !ok {
+		return true, "expiry of the credentials distributed unknown"
+	}
+	left := expiration.Sub(now).Round(time.Second)
+	if left <= 0 {
+		return true, fmt.Sprintf("credentials distributed expired %s ago", -left)
+	}
+	reason := fmt.Sprintf("credentials distributed expire in %s, renew_before is %s", left, src.renewBefore())
+	return left < src.renewBefore(), reason
+}
+
+// SetDistributed sets the CredentialID of the credentials distributed
+// last.
+func (src *AwsStsSource) SetDistributed(credentialID string) {
+	src.distributed = credentialID
+}
+
+// Force makes the next rotation hand out new credentials even if those
+// distributed last are still valid long enough.
+func (src *AwsStsSource) Force() {
+	src.force = true
+}
+
+// Plan reports whether the credentials distributed last expire within
+// RenewBefore, without changing anything.
+func (src *AwsStsSource) Plan(ctx context.Context) (Plan, error) {
+	due, reason := src.due(time.Now())
+	return Plan{Due: due, Reason: reason, Keys: []string{AwsAccessKeyID, AwsSecretAccessKey, AwsSessionToken}}, nil
+}
+
+// Read returns the credentials of a new session.
+func (src *AwsStsSource) Read(ctx context.Context) (map[string]string, error) {
+	return src.assume(ctx)
+}
+
+// Create returns the credentials of a new session, or nil if those
+// distributed last are valid for at least RenewBefore more.
+func (src *AwsStsSource) Create(ctx context.Context) (map[string]string, error) {
+	if due, _ := src.due(time.Now()); !due {
+		return nil, nil
+	}
+	return src.assume(ctx)
+}
+
+// Activate is a no-op for AwsStsSource.
+func (src *AwsStsSource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for AwsStsSource. The credentials of a session
+// cannot be revoked on their own, and expire after Duration.
+func (src *AwsStsSource) Revoke(ctx context.Context) error {
+	return nil
+}
+
+// CredentialID returns the access key ID and expiry of the credentials
+// last handed out, e.g. ASIA...@2020-01-01T12:00:00Z.
+func (src *AwsStsSource) CredentialID(creds map[string]string) string {
+	return src.id(src.accessKeyID, src.expiration)
+}
@@ -0,0 +1,106 @@
+package source_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sts"
+	cziAws "github.com/chanzuckerberg/go-misc/aws"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAwsStsSource(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	sess, server := cziAws.NewMockSession()
+	defer server.Close()
+	client, mockSTS := cziAws.New(sess).WithMockSTS(ctrl)
+
+	src := source.NewAwsStsSource().WithRoleArn("arn:aws:iam::123456789012:role/ci").WithAwsClient(client).
+		WithDuration(time.Hour)
+	src.ExternalID = "some-id"
+	src.Policy = `{"Version": "2012-10-17", "Statement": [{"Effect": "Allow", "Action": "s3:GetObject", "Resource": "*"}]}`
+	src.PolicyArns = []string{"arn:aws:iam::aws:policy/ReadOnlyAccess"}
+	r.NoError(src.Validate())
+
+	expiration := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	mockSTS.EXPECT().AssumeRoleWithContext(gomock.Any(), gomock.Any()).DoAndReturn(
+		func(ctx context.Context, in *sts.AssumeRoleInput, _ ...interface{}) (*sts.AssumeRoleOutput, error) {
+			r.Equal("arn:aws:iam::123456789012:role/ci", *in.RoleArn)
+			r.Equal("rotator", *in.RoleSessionName)
+			r.Equal(int64(3600), *in.DurationSeconds)
+			r.Equal("some-id", *in.ExternalId)
+			r.Equal(src.Policy, *in.Policy)
+			r.Equal("arn:aws:iam::aws:policy/ReadOnlyAccess", *in.PolicyArns[0].Arn)
+			return &sts.AssumeRoleOutput{Credentials: &sts.Credentials{
+				AccessKeyId:     aws.String("ASIAFIRST"),
+				SecretAccessKey: aws.String("secret"),
+				SessionToken:    aws.String("token"),
+				Expiration:      aws.Time(expiration),
+			}}, nil
+		})
+
+	// nothing distributed yet
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Equal([]string{source.AwsAccessKeyID, source.AwsSecretAccessKey, source.AwsSessionToken}, plan.Keys)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{
+		source.AwsAccessKeyID:     "ASIAFIRST",
+		source.AwsSecretAccessKey: "secret",
+		source.AwsSessionToken:    "token",
+	}, creds)
+	id := src.CredentialID(creds)
+	r.Equal("ASIAFIRST@"+expiration.Format(time.RFC3339), id)
+
+	// credentials valid for longer than renew_before are kept
+	src.SetDistributed(id)
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Contains(plan.Reason, "renew_before is 30m0s")
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// and renewed once they are not
+	src.RenewBefore = 59 * time.Minute
+	src.SetDistributed("ASIAFIRST@" + time.Now().Add(50*time.Minute).UTC().Format(time.RFC3339))
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	src.SetDistributed("ASIAFIRST@" + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Contains(plan.Reason, "expired")
+}
+
+func TestAwsStsSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	role := "arn:aws:iam::123456789012:role/ci"
+	tests := map[string]*source.AwsStsSource{
+		"missing role_arn":      {},
+		"short duration":        {RoleArn: role, Duration: time.Minute},
+		"long duration":         {RoleArn: role, Duration: 24 * time.Hour},
+		"negative renewal":      {RoleArn: role, RenewBefore: -time.Minute},
+		"renewal past expiry":   {RoleArn: role, Duration: time.Hour, RenewBefore: time.Hour},
+		"default past expiry":   {RoleArn: role, RenewBefore: 2 * time.Hour},
+		"invalid policy":        {RoleArn: role, Policy: `{"Version":`},
+		"renewal past duration": {RoleArn: role, Duration: 15 * time.Minute, RenewBefore: 30 * time.Minute},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+	r.NoError(source.NewAwsStsSource().WithRoleArn(role).Validate())
+	r.NoError(source.NewAwsStsSource().WithRoleArn(role).WithDuration(12 * time.Hour).WithRenewBefore(time.Hour).Validate())
+}
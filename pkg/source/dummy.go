@@ -1,6 +1,7 @@
 package source
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/base64"
 
@@ -15,12 +16,16 @@ const (
 	Secret string = "secret"
 )
 
+func init() {
+	Register(KindDummy, func() Source { return NewDummySource() })
+}
+
 func NewDummySource() *DummySource {
 	return &DummySource{}
 }
 
 // Read returns a random number of length 16.
-func (src *DummySource) Read() (map[string]string, error) {
+func (src *DummySource) Read(ctx context.Context) (map[string]string, error) {
 	// reference: https://blog.questionable.services/article/generating-secure-random-numbers-crypto-rand/
 	b := make([]byte, 10)
 	_, err := rand.Read(b)
@@ -30,6 +35,27 @@ func (src *DummySource) Read() (map[string]string, error) {
 	return map[string]string{Secret: base64.URLEncoding.EncodeToString(b)}, nil
 }
 
+// Create returns a new random secret. DummySource keeps no state,
+// so there is nothing to stage.
+func (src *DummySource) Create(ctx context.Context) (map[string]string, error) {
+	return src.Read(ctx)
+}
+
+// Plan reports that DummySource always produces a new secret.
+func (src *DummySource) Plan(ctx context.Context) (Plan, error) {
+	return Plan{Due: true, Reason: "dummy source always rotates", Keys: []string{Secret}}, nil
+}
+
+// Activate is a no-op for DummySource.
+func (src *DummySource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for DummySource.
+func (src *DummySource) Revoke(ctx context.Context) error {
+	return nil
+}
+
 func (src *DummySource) Kind() Kind {
 	return KindDummy
 }
@@ -1,6 +1,7 @@
 package source_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/chanzuckerberg/rotator/pkg/source"
@@ -11,7 +12,7 @@ func TestReadFromDummySource(t *testing.T) {
 	r := require.New(t)
 
 	src := source.DummySource{}
-	creds, err := src.Read()
+	creds, err := src.Read(context.Background())
 	r.Nil(err)
 	r.Len(creds[source.Secret], 16)
 }
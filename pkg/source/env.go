@@ -1,8 +1,11 @@
 package source
 
 import (
+	"context"
 	"fmt"
 	"os"
+
+	"github.com/pkg/errors"
 )
 
 // Env is a source that reads an environment variable
@@ -10,6 +13,10 @@ type Env struct {
 	Name string `yaml:"name"`
 }
 
+func init() {
+	Register(KindEnv, func() Source { return NewEnvSource() })
+}
+
 // NewEnvSource returns a new env soruce
 func NewEnvSource() *Env {
 	return &Env{}
@@ -26,7 +33,16 @@ func (e *Env) WithName(name string) *Env {
 	return e
 }
 
-func (e *Env) Read() (map[string]string, error) {
+// Validate checks that the name of the environment variable is set.
+func (e *Env) Validate() error {
+	if e.Name == "" {
+		return errors.New("missing name")
+	}
+	return nil
+}
+
+// Read returns the value of the environment variable.
+func (e *Env) Read(ctx context.Context) (map[string]string, error) {
 	env, present := os.LookupEnv(e.Name)
 
 	if !present {
@@ -35,3 +51,28 @@ func (e *Env) Read() (map[string]string, error) {
 
 	return map[string]string{e.Name: env}, nil
 }
+
+// Create reads the environment variable. The value is owned by
+// whoever sets the variable, so there is nothing to stage.
+func (e *Env) Create(ctx context.Context) (map[string]string, error) {
+	return e.Read(ctx)
+}
+
+// Plan reports whether the environment variable is set. Env does not
+// know how old the value is, so it is always due.
+func (e *Env) Plan(ctx context.Context) (Plan, error) {
+	if _, present := os.LookupEnv(e.Name); !present {
+		return Plan{}, fmt.Errorf("Environment variable %s not present", e.Name)
+	}
+	return Plan{Due: true, Reason: "env source does not track the age of its value", Keys: []string{e.Name}}, nil
+}
+
+// Activate is a no-op for Env.
+func (e *Env) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for Env.
+func (e *Env) Revoke(ctx context.Context) error {
+	return nil
+}
@@ -1,6 +1,7 @@
 package source_test
 
 import (
+	"context"
 	"os"
 	"testing"
 
@@ -20,7 +21,7 @@ func TestReadFromEnvSource(t *testing.T) {
 	r.NoError(os.Setenv(envName.String(), "testo"))
 	defer os.Unsetenv(envName.String())
 
-	vals, err := src.Read()
+	vals, err := src.Read(context.Background())
 	r.NoError(err)
 	r.Equal("testo", vals[envName.String()])
 
@@ -28,6 +29,6 @@ func TestReadFromEnvSource(t *testing.T) {
 	envNotPresent := uuid.New()
 	srcNotPresent := source.NewEnvSource().WithName(envNotPresent.String())
 
-	vals, err = srcNotPresent.Read()
+	vals, err = srcNotPresent.Read(context.Background())
 	r.Error(err, "Environment variable")
 }
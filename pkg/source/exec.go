@@ -0,0 +1,184 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/redact"
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultExecTimeout bounds commands that do not set a timeout.
+	DefaultExecTimeout = time.Minute
+	// maxExecStderr bounds the stderr quoted in errors.
+	maxExecStderr = 4096
+)
+
+// ExecSource is a source that runs a command, e.g. a script that rotates
+// an API key through a vendor's API, and hands out the JSON object of
+// strings it prints to stdout.
+//
+// The optional activate and revoke commands are run by Activate and
+// Revoke with that object on stdin, e.g. to delete the key the new one
+// replaces, or the new one when the rotation is rolled back.
+//
+// stderr is logged, and quoted in errors, with the credential and any
+// other secret value seen by rotator redacted.
+type ExecSource struct {
+	// Command is the program to run and its arguments. It is not run
+	// by a shell.
+	Command []string `yaml:"command"`
+	// ActivateCommand and RevokeCommand are run by Activate and Revoke.
+	ActivateCommand []string `yaml:"activate_command,omitempty"`
+	RevokeCommand   []string `yaml:"revoke_command,omitempty"`
+	// Timeout bounds each command. Defaults to DefaultExecTimeout.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// pending is the credential printed by Command that has not been
+	// activated or revoked yet.
+	pending map[string]string
+}
+
+func init() {
+	Register(KindExec, func() Source { return NewExecSource() })
+}
+
+func NewExecSource() *ExecSource {
+	return &ExecSource{}
+}
+
+func (src *ExecSource) WithCommand(command ...string) *ExecSource {
+	src.Command = command
+	return src
+}
+
+func (src *ExecSource) Kind() Kind {
+	return KindExec
+}
+
+// Validate checks that the command is set.
+func (src *ExecSource) Validate() error {
+	var errs *multierror.Error
+	if len(src.Command) == 0 || src.Command[0] == "" {
+		errs = multierror.Append(errs, errors.New("missing command"))
+	}
+	if src.Timeout < 0 {
+		errs = multierror.Append(errs, errors.New("timeout must not be negative"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// run runs command with stdin, and returns its stdout. stderr is logged
+// at debug level, or quoted in the error if the command fails.
+func (src *ExecSource) run(ctx context.Context, command []string, stdin []byte) ([]byte, error) {
+	timeout := src.Timeout
+	if timeout == 0 {
+		timeout = DefaultExecTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = errors.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s failed: %s", command[0], execStderr(stderr.String()))
+	}
+	if stderr.Len() > 0 {
+		util.Logger(ctx).Debugf("exec: %s: %s", command[0], redact.Scrub(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// execStderr returns the redacted end of stderr, the part most likely to
+// explain a failure.
+func execStderr(stderr string) string {
+	stderr = strings.TrimSpace(redact.Scrub(stderr))
+	if len(stderr) > maxExecStderr {
+		stderr = "..." + stderr[len(stderr)-maxExecStderr:]
+	}
+	if stderr == "" {
+		return "no output on stderr"
+	}
+	return stderr
+}
+
+// Plan reports that a rotation is due, without running the command.
+// ExecSource cannot tell whether it is, nor the keys the command prints.
+func (src *ExecSource) Plan(ctx context.Context) (Plan, error) {
+	return Plan{Due: true, Reason: "exec source does not track the age of its value"}, nil
+}
+
+func (src *ExecSource) Read(ctx context.Context) (map[string]string, error) {
+	creds, err := src.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return creds, src.Activate(ctx)
+}
+
+// Create runs Command and parses its stdout.
+func (src *ExecSource) Create(ctx context.Context) (map[string]string, error) {
+	stdout, err := src.run(ctx, src.Command, nil)
+	if err != nil {
+		return nil, err
+	}
+	var creds map[string]string
+	err = json.Unmarshal(stdout, &creds)
+	if err != nil {
+		// the error is not wrapped, as it may quote stdout
+		return nil, errors.Errorf("%s did not print a JSON object of strings", src.Command[0])
+	}
+	if len(creds) == 0 {
+		return nil, errors.Errorf("%s printed no credential", src.Command[0])
+	}
+	redact.ScopeFrom(ctx).RegisterMap(creds)
+	src.pending = creds
+	return creds, nil
+}
+
+// Activate runs ActivateCommand, if set, with the credential printed by
+// Command on stdin.
+func (src *ExecSource) Activate(ctx context.Context) error {
+	err := src.finish(ctx, src.ActivateCommand)
+	return errors.Wrap(err, "unable to activate credential")
+}
+
+// Revoke runs RevokeCommand, if set, with the credential printed by
+// Command on stdin.
+func (src *ExecSource) Revoke(ctx context.Context) error {
+	err := src.finish(ctx, src.RevokeCommand)
+	return errors.Wrap(err, "unable to revoke credential")
+}
+
+// finish runs command with the pending credential on stdin.
+func (src *ExecSource) finish(ctx context.Context, command []string) error {
+	if src.pending == nil {
+		return nil
+	}
+	if len(command) > 0 {
+		stdin, err := json.Marshal(src.pending)
+		if err != nil {
+			return err
+		}
+		_, err = src.run(ctx, command, stdin)
+		if err != nil {
+			return err
+		}
+	}
+	src.pending = nil
+	return nil
+}
@@ -0,0 +1,102 @@
+package source_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/redact"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecSource(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	activated := filepath.Join(dir, "activated")
+	revoked := filepath.Join(dir, "revoked")
+
+	src := source.NewExecSource().WithCommand("sh", "-c", `echo rotating >&2; echo '{"token": "exec-token-1"}'`)
+	src.ActivateCommand = []string{"sh", "-c", "cat > " + activated}
+	src.RevokeCommand = []string{"sh", "-c", "cat > " + revoked}
+	r.NoError(src.Validate())
+
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"token": "exec-token-1"}, creds)
+	r.NoError(src.Activate(ctx))
+	b, err := ioutil.ReadFile(activated)
+	r.NoError(err)
+	r.JSONEq(`{"token": "exec-token-1"}`, string(b))
+
+	// nothing is run once the credential is activated
+	r.NoError(src.Revoke(ctx))
+	_, err = os.Stat(revoked)
+	r.True(os.IsNotExist(err))
+
+	_, err = src.Create(ctx)
+	r.NoError(err)
+	r.NoError(src.Revoke(ctx))
+	b, err = ioutil.ReadFile(revoked)
+	r.NoError(err)
+	r.JSONEq(`{"token": "exec-token-1"}`, string(b))
+}
+
+func TestExecSourceErrors(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	// stderr is quoted in errors, with secret values redacted
+	redact.Register("exec-secret-value")
+	src := source.NewExecSource().WithCommand("sh", "-c", "echo 'login failed for exec-secret-value' >&2; exit 3")
+	_, err := src.Create(ctx)
+	r.Error(err)
+	r.Contains(err.Error(), "login failed for "+redact.Redacted)
+	r.NotContains(err.Error(), "exec-secret-value")
+
+	// stdout is never quoted
+	src = source.NewExecSource().WithCommand("sh", "-c", `echo '{"token": 42, "secret": "exec-stdout-value"}'`)
+	_, err = src.Create(ctx)
+	r.Error(err)
+	r.NotContains(err.Error(), "exec-stdout-value")
+
+	src = source.NewExecSource().WithCommand("sh", "-c", "echo '{}'")
+	_, err = src.Create(ctx)
+	r.Error(err)
+
+	src = source.NewExecSource().WithCommand("sleep", "5")
+	src.Timeout = 50 * time.Millisecond
+	_, err = src.Create(ctx)
+	r.Error(err)
+	r.Contains(err.Error(), "timed out")
+
+	src = source.NewExecSource().WithCommand("sh", "-c", `echo '{"token": "exec-token-2"}'`)
+	src.ActivateCommand = []string{"false"}
+	_, err = src.Create(ctx)
+	r.NoError(err)
+	r.Error(src.Activate(ctx))
+}
+
+func TestExecSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string]*source.ExecSource{
+		"missing command":  {},
+		"empty command":    {Command: []string{""}},
+		"negative timeout": {Command: []string{"true"}, Timeout: -time.Second},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+	r.NoError(source.NewExecSource().WithCommand("true").Validate())
+}
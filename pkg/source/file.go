@@ -0,0 +1,287 @@
+package source
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats of files read by FileSource.
+const (
+	FileFormatJSON   = "json"
+	FileFormatYAML   = "yaml"
+	FileFormatDotenv = "dotenv"
+)
+
+// FileSource is a source that reads the credential from a JSON or YAML
+// object, or a dotenv file, written by whatever rotates it, e.g. a
+// script or a vendor's CLI.
+//
+// With Hash, the SHA-256 hash of the credential is its CredentialID, and
+// Create only returns the credential once it differs from the one
+// distributed by the last rotation, see Versioned.
+type FileSource struct {
+	Path string `yaml:"path"`
+	// Format is json, yaml or dotenv. Defaults to the one matching the
+	// extension of Path.
+	Format string `yaml:"format,omitempty"`
+	// Fields are the fields of the file to hand out. Defaults to all
+	// fields.
+	Fields []string `yaml:"fields,omitempty"`
+	// Hash makes rotations skip credentials that did not change since
+	// the last rotation.
+	Hash bool `yaml:"hash,omitempty"`
+
+	// distributed is the CredentialID of the credential distributed last.
+	distributed string
+	// force makes the next rotation hand out the credential even if it
+	// did not change.
+	force bool
+}
+
+func init() {
+	Register(KindFile, func() Source { return NewFileSource() })
+}
+
+func NewFileSource() *FileSource {
+	return &FileSource{}
+}
+
+func (src *FileSource) WithPath(path string) *FileSource {
+	src.Path = path
+	return src
+}
+
+func (src *FileSource) WithHash() *FileSource {
+	src.Hash = true
+	return src
+}
+
+func (src *FileSource) Kind() Kind {
+	return KindFile
+}
+
+// Validate checks that the path is set and its format known.
+func (src *FileSource) Validate() error {
+	var errs *multierror.Error
+	if src.Path == "" {
+		errs = multierror.Append(errs, errors.New("missing path"))
+	}
+	switch src.format() {
+	case FileFormatJSON, FileFormatYAML, FileFormatDotenv:
+	case "":
+		errs = multierror.Append(errs, errors.Errorf("missing format, it cannot be told from the extension of %s", src.Path))
+	default:
+		errs = multierror.Append(errs, errors.Errorf("unknown format %q", src.Format))
+	}
+	return errs.ErrorOrNil()
+}
+
+func (src *FileSource) format() string {
+	if src.Format != "" {
+		return src.Format
+	}
+	switch strings.ToLower(filepath.Ext(src.Path)) {
+	case ".json":
+		return FileFormatJSON
+	case ".yaml", ".yml":
+		return FileFormatYAML
+	case ".env":
+		return FileFormatDotenv
+	}
+	if filepath.Base(src.Path) == ".env" {
+		return FileFormatDotenv
+	}
+	return ""
+}
+
+// read parses the file and returns the fields to hand out.
+func (src *FileSource) read() (map[string]string, error) {
+	b, err := ioutil.ReadFile(src.Path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", src.Path)
+	}
+	var data map[string]interface{}
+	switch src.format() {
+	case FileFormatJSON:
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err = d.Decode(&data)
+	case FileFormatYAML:
+		err = yaml.Unmarshal(b, &data)
+	case FileFormatDotenv:
+		data, err = parseDotenv(b)
+	}
+	if err != nil {
+		// the error is not wrapped, as it may quote the file
+		return nil, errors.Errorf("unable to parse %s as %s", src.Path, src.format())
+	}
+	creds, err := stringFields(data, src.Fields)
+	return creds, errors.Wrapf(err, "unable to read %s", src.Path)
+}
+
+// parseDotenv parses lines of KEY=value, optionally prefixed with export.
+// Values may be quoted; double quoted values may contain escapes such
+// as \n. Blank lines and lines starting with # are skipped.
+func parseDotenv(b []byte) (map[string]interface{}, error) {
+	data := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i < 1 {
+			return nil, errors.Errorf("line %d is not KEY=value", n)
+		}
+		key, val := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch {
+		case len(val) >= 2 && val[0] == '"' && val[len(val)-1] == '"':
+			unquoted, err := strconv.Unquote(val)
+			if err != nil {
+				return nil, errors.Errorf("line %d has an invalid double quoted value", n)
+			}
+			val = unquoted
+		case len(val) >= 2 && val[0] == '\'' && val[len(val)-1] == '\'':
+			val = val[1 : len(val)-1]
+		}
+		data[key] = val
+	}
+	return data, scanner.Err()
+}
+
+// stringFields returns the fields of data as strings, encoding those
+// that are not strings as JSON and leaving out null ones. If fields is
+// set, only those are returned, and all of them must be present.
+func stringFields(data map[string]interface{}, fields []string) (map[string]string, error) {
+	all := map[string]string{}
+	for k, v := range data {
+		if v == nil {
+			continue
+		}
+		if s, ok := v.(string); ok {
+			all[k] = s
+			continue
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to encode field %s", k)
+		}
+		all[k] = string(b)
+	}
+	if len(fields) == 0 {
+		return all, nil
+	}
+	selected := map[string]string{}
+	for _, f := range fields {
+		v, ok := all[f]
+		if !ok {
+			return nil, errors.Errorf("field %s not found", f)
+		}
+		selected[f] = v
+	}
+	return selected, nil
+}
+
+// due reports whether creds should be handed out, and why.
+func (src *FileSource) due(creds map[string]string) (bool, string) {
+	switch {
+	case src.force:
+		return true, "forced"
+	case !src.Hash:
+		return true, "file source does not track changes without hash"
+	case src.distributed == "":
+		return true, "no credential distributed yet"
+	case src.distributed == src.CredentialID(creds):
+		return false, "credential did not change since the last rotation"
+	}
+	return true, "credential changed since the last rotation"
+}
+
+// SetDistributed sets the CredentialID of the credential distributed
+// last.
+func (src *FileSource) SetDistributed(credentialID string) {
+	src.distributed = credentialID
+}
+
+// Force makes the next rotation hand out the credential even if it did
+// not change.
+func (src *FileSource) Force() {
+	src.force = true
+}
+
+// Plan reads the file and reports whether the credential changed.
+func (src *FileSource) Plan(ctx context.Context) (Plan, error) {
+	creds, err := src.read()
+	if err != nil {
+		return Plan{}, err
+	}
+	var keys []string
+	for k := range creds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	due, reason := src.due(creds)
+	return Plan{Due: due, Reason: reason, Keys: keys}, nil
+}
+
+// Read returns the fields of the file.
+func (src *FileSource) Read(ctx context.Context) (map[string]string, error) {
+	return src.read()
+}
+
+// Create returns the fields of the file, or nil with Hash if they did
+// not change since the last rotation. The file is owned by whoever
+// writes it, so there is nothing to stage.
+func (src *FileSource) Create(ctx context.Context) (map[string]string, error) {
+	creds, err := src.read()
+	if err != nil {
+		return nil, err
+	}
+	if due, _ := src.due(creds); !due {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// Activate is a no-op for FileSource.
+func (src *FileSource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for FileSource.
+func (src *FileSource) Revoke(ctx context.Context) error {
+	return nil
+}
+
+// CredentialID returns the SHA-256 hash of creds with Hash, or "".
+func (src *FileSource) CredentialID(creds map[string]string) string {
+	if !src.Hash {
+		return ""
+	}
+	keys := make([]string, 0, len(creds))
+	for k := range creds {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		// lengths keep keys and values from running into each other
+		fmt.Fprintf(h, "%d:%s%d:%s", len(k), k, len(creds[k]), creds[k])
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
@@ -0,0 +1,129 @@
+package source_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSourceFormats(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+
+	tests := map[string]string{
+		"creds.json": `{"username": "app", "password": "s3cret", "port": 5432, "unset": null}`,
+		"creds.yml":  "username: app\npassword: s3cret\nport: 5432\n",
+		".env": `# written by rotate.sh
+export USERNAME=app
+PASSWORD="s3cret"
+PORT='5432'
+`,
+	}
+	for name, content := range tests {
+		path := filepath.Join(dir, name)
+		r.NoError(ioutil.WriteFile(path, []byte(content), 0600))
+		src := source.NewFileSource().WithPath(path)
+		r.NoError(src.Validate(), name)
+
+		creds, err := src.Read(ctx)
+		r.NoError(err, name)
+		r.Len(creds, 3, name)
+		plan, err := src.Plan(ctx)
+		r.NoError(err, name)
+		r.True(plan.Due, name)
+		r.Len(plan.Keys, 3, name)
+	}
+
+	src := source.NewFileSource().WithPath(filepath.Join(dir, "creds.json"))
+	creds, err := src.Read(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"username": "app", "password": "s3cret", "port": "5432"}, creds)
+
+	src.Fields = []string{"password"}
+	creds, err = src.Read(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"password": "s3cret"}, creds)
+	src.Fields = []string{"missing"}
+	_, err = src.Read(ctx)
+	r.Error(err)
+
+	// parse errors do not quote the file
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte(`{"password": "s3cret"`), 0600))
+	_, err = source.NewFileSource().WithPath(filepath.Join(dir, "broken.json")).Read(ctx)
+	r.Error(err)
+	r.NotContains(err.Error(), "s3cret")
+
+	_, err = source.NewFileSource().WithPath(filepath.Join(dir, "missing.json")).Read(ctx)
+	r.Error(err)
+}
+
+func TestFileSourceHash(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "creds.json")
+	r.NoError(ioutil.WriteFile(path, []byte(`{"token": "first"}`), 0600))
+
+	src := source.NewFileSource().WithPath(path).WithHash()
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	id := src.CredentialID(creds)
+	r.Len(id, 64)
+
+	// the same credential is not handed out again, even if reformatted
+	src.SetDistributed(id)
+	r.NoError(ioutil.WriteFile(path, []byte("{\n  \"token\": \"first\"\n}\n"), 0600))
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+
+	// a new one is
+	r.NoError(ioutil.WriteFile(path, []byte(`{"token": "second"}`), 0600))
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"token": "second"}, creds)
+	r.NotEqual(id, src.CredentialID(creds))
+
+	// and so is a forced one
+	src.SetDistributed(src.CredentialID(creds))
+	src.Force()
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+
+	// without hash, the credential is always handed out
+	src = source.NewFileSource().WithPath(path)
+	src.SetDistributed(id)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+	r.Equal("", src.CredentialID(creds))
+}
+
+func TestFileSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string]*source.FileSource{
+		"missing path":      {},
+		"unknown format":    {Path: "creds.json", Format: "toml"},
+		"unknown extension": {Path: "creds.txt"},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+	r.NoError((&source.FileSource{Path: "creds.txt", Format: "dotenv"}).Validate())
+	r.NoError((&source.FileSource{Path: "/run/secrets/.env"}).Validate())
+}
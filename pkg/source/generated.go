@@ -0,0 +1,283 @@
+package source
+
+import (
+	"context"
+	"crypto/rand"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// Alphabets of generated values.
+const (
+	AlphabetAlphanumeric = "alphanumeric"
+	AlphabetHex          = "hex"
+	AlphabetBase32       = "base32"
+	AlphabetCustom       = "custom"
+)
+
+// Character classes that generated values can be required to contain.
+const (
+	ClassUpper  = "upper"
+	ClassLower  = "lower"
+	ClassDigit  = "digit"
+	ClassSymbol = "symbol"
+)
+
+const (
+	// DefaultGeneratedLength is the length of generated values that do
+	// not set one.
+	DefaultGeneratedLength = 32
+	// maxGeneratedLength bounds the length of generated values.
+	maxGeneratedLength = 4096
+	// maxGenerateAttempts bounds the number of values drawn to find one
+	// with every required character class.
+	maxGenerateAttempts = 1000
+	// ambiguousCharacters are left out by exclude_ambiguous, as they are
+	// easily confused with each other when read or typed.
+	ambiguousCharacters = "0O1Il|`'\""
+)
+
+var alphabets = map[string]string{
+	AlphabetAlphanumeric: "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789",
+	AlphabetHex:          "0123456789abcdef",
+	AlphabetBase32:       "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567",
+}
+
+var classes = map[string]func(r rune) bool{
+	ClassUpper:  func(r rune) bool { return r >= 'A' && r <= 'Z' },
+	ClassLower:  func(r rune) bool { return r >= 'a' && r <= 'z' },
+	ClassDigit:  func(r rune) bool { return r >= '0' && r <= '9' },
+	ClassSymbol: func(r rune) bool { return !(r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9') },
+}
+
+// GeneratedSource is a source that generates random values, such as
+// passwords, API tokens and signing secrets, from crypto/rand.
+type GeneratedSource struct {
+	// Outputs are the values generated on each rotation. If empty, a
+	// single alphanumeric value of DefaultGeneratedLength is generated
+	// under the key "secret".
+	Outputs []GeneratedOutput `yaml:"outputs,omitempty"`
+}
+
+// GeneratedOutput is a value generated by GeneratedSource and the
+// policy it is generated with.
+type GeneratedOutput struct {
+	// Key is the key of the value in the credentials of the source.
+	Key string `yaml:"key"`
+	// Length is the number of characters of the value. Zero means
+	// DefaultGeneratedLength.
+	Length int `yaml:"length,omitempty"`
+	// Alphabet is the set of characters the value is drawn from:
+	// alphanumeric, the default, hex, base32 or custom.
+	Alphabet string `yaml:"alphabet,omitempty"`
+	// Characters is the alphabet of custom values.
+	Characters string `yaml:"characters,omitempty"`
+	// Require lists the character classes that must each appear at
+	// least once in the value: upper, lower, digit or symbol.
+	Require []string `yaml:"require,omitempty"`
+	// ExcludeAmbiguous leaves out characters that are easily confused,
+	// such as 0 and O, or 1, l and I.
+	ExcludeAmbiguous bool `yaml:"exclude_ambiguous,omitempty"`
+}
+
+func init() {
+	Register(KindGenerated, func() Source { return NewGeneratedSource() })
+}
+
+func NewGeneratedSource() *GeneratedSource {
+	return &GeneratedSource{}
+}
+
+// WithOutput adds an output to the values generated by src.
+func (src *GeneratedSource) WithOutput(output GeneratedOutput) *GeneratedSource {
+	src.Outputs = append(src.Outputs, output)
+	return src
+}
+
+func (src *GeneratedSource) Kind() Kind {
+	return KindGenerated
+}
+
+// outputs returns the outputs of src, or the default output if none is set.
+func (src *GeneratedSource) outputs() []GeneratedOutput {
+	if len(src.Outputs) == 0 {
+		return []GeneratedOutput{{Key: Secret}}
+	}
+	return src.Outputs
+}
+
+// Validate checks that the keys of the outputs are set and unique, and
+// that each policy can be satisfied.
+func (src *GeneratedSource) Validate() error {
+	var errs *multierror.Error
+	keys := map[string]bool{}
+	for _, output := range src.outputs() {
+		if output.Key == "" {
+			errs = multierror.Append(errs, errors.New("missing key in output"))
+			continue
+		}
+		if keys[output.Key] {
+			errs = multierror.Append(errs, errors.Errorf("duplicate output key %s", output.Key))
+		}
+		keys[output.Key] = true
+		err := output.validate()
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrapf(err, "output %s", output.Key))
+		}
+	}
+	return errs.ErrorOrNil()
+}
+
+func (output GeneratedOutput) validate() error {
+	if output.Length < 0 || output.Length > maxGeneratedLength {
+		return errors.Errorf("length must be between 1 and %d", maxGeneratedLength)
+	}
+	if output.Characters != "" && output.Alphabet != AlphabetCustom {
+		return errors.New("characters is only used with the custom alphabet")
+	}
+	alphabet, err := output.alphabet()
+	if err != nil {
+		return err
+	}
+	if len(alphabet) < 2 {
+		return errors.New("alphabet must have at least 2 distinct characters")
+	}
+	if len(output.Require) > output.length() {
+		return errors.Errorf("length %d is too short for %d required character classes", output.length(), len(output.Require))
+	}
+	for _, class := range output.Require {
+		in, ok := classes[class]
+		if !ok {
+			return errors.Errorf("unknown character class %q", class)
+		}
+		if !containsClass(alphabet, in) {
+			return errors.Errorf("alphabet has no %s characters", class)
+		}
+	}
+	return nil
+}
+
+func (output GeneratedOutput) length() int {
+	if output.Length == 0 {
+		return DefaultGeneratedLength
+	}
+	return output.Length
+}
+
+// alphabet returns the distinct characters values of output are drawn
+// from, in a stable order.
+func (output GeneratedOutput) alphabet() ([]rune, error) {
+	var chars string
+	switch output.Alphabet {
+	case "":
+		chars = alphabets[AlphabetAlphanumeric]
+	case AlphabetCustom:
+		if output.Characters == "" {
+			return nil, errors.New("missing characters for custom alphabet")
+		}
+		chars = output.Characters
+	default:
+		var ok bool
+		chars, ok = alphabets[output.Alphabet]
+		if !ok {
+			return nil, errors.Errorf("unknown alphabet %q", output.Alphabet)
+		}
+	}
+
+	seen := map[rune]bool{}
+	var alphabet []rune
+	for _, r := range chars {
+		if seen[r] || output.ExcludeAmbiguous && strings.ContainsRune(ambiguousCharacters, r) {
+			continue
+		}
+		seen[r] = true
+		alphabet = append(alphabet, r)
+	}
+	sort.Slice(alphabet, func(i, j int) bool { return alphabet[i] < alphabet[j] })
+	return alphabet, nil
+}
+
+// generate returns a random value that follows the policy of output.
+// Values missing a required character class are drawn again, so that
+// every valid value is equally likely.
+func (output GeneratedOutput) generate() (string, error) {
+	alphabet, err := output.alphabet()
+	if err != nil {
+		return "", err
+	}
+	n := big.NewInt(int64(len(alphabet)))
+	value := make([]rune, output.length())
+	for attempt := 0; attempt < maxGenerateAttempts; attempt++ {
+		for i := range value {
+			j, err := rand.Int(rand.Reader, n)
+			if err != nil {
+				return "", errors.Wrap(err, "unable to generate random characters")
+			}
+			value[i] = alphabet[j.Int64()]
+		}
+		if output.hasRequiredClasses(value) {
+			return string(value), nil
+		}
+	}
+	return "", errors.Errorf("unable to generate a value with every required character class in %d attempts, increase the length", maxGenerateAttempts)
+}
+
+func (output GeneratedOutput) hasRequiredClasses(value []rune) bool {
+	for _, class := range output.Require {
+		if !containsClass(value, classes[class]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsClass(chars []rune, in func(rune) bool) bool {
+	for _, r := range chars {
+		if in(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Read returns a new random value for each output.
+func (src *GeneratedSource) Read(ctx context.Context) (map[string]string, error) {
+	creds := map[string]string{}
+	for _, output := range src.outputs() {
+		value, err := output.generate()
+		if err != nil {
+			return nil, errors.Wrapf(err, "output %s", output.Key)
+		}
+		creds[output.Key] = value
+	}
+	return creds, nil
+}
+
+// Create returns new random values. GeneratedSource keeps no state,
+// so there is nothing to stage.
+func (src *GeneratedSource) Create(ctx context.Context) (map[string]string, error) {
+	return src.Read(ctx)
+}
+
+// Plan reports that GeneratedSource always produces new values.
+func (src *GeneratedSource) Plan(ctx context.Context) (Plan, error) {
+	var keys []string
+	for _, output := range src.outputs() {
+		keys = append(keys, output.Key)
+	}
+	return Plan{Due: true, Reason: "generated source always rotates", Keys: keys}, nil
+}
+
+// Activate is a no-op for GeneratedSource.
+func (src *GeneratedSource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for GeneratedSource.
+func (src *GeneratedSource) Revoke(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,92 @@
+package source_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeneratedSourceDefault(t *testing.T) {
+	r := require.New(t)
+
+	src := source.NewGeneratedSource()
+	r.NoError(src.Validate())
+	creds, err := src.Read(context.Background())
+	r.NoError(err)
+	r.Len(creds, 1)
+	r.Len(creds[source.Secret], source.DefaultGeneratedLength)
+	for _, c := range creds[source.Secret] {
+		r.True(c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c)), "unexpected character %q", c)
+	}
+
+	plan, err := src.Plan(context.Background())
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Equal([]string{source.Secret}, plan.Keys)
+}
+
+func TestGeneratedSourceOutputs(t *testing.T) {
+	r := require.New(t)
+
+	src := source.NewGeneratedSource().
+		WithOutput(source.GeneratedOutput{
+			Key:              "password",
+			Length:           12,
+			Require:          []string{source.ClassUpper, source.ClassLower, source.ClassDigit, source.ClassSymbol},
+			Alphabet:         source.AlphabetCustom,
+			Characters:       "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789!@#%",
+			ExcludeAmbiguous: true,
+		}).
+		WithOutput(source.GeneratedOutput{Key: "token", Length: 40, Alphabet: source.AlphabetHex}).
+		WithOutput(source.GeneratedOutput{Key: "otp_seed", Length: 16, Alphabet: source.AlphabetBase32})
+	r.NoError(src.Validate())
+
+	for i := 0; i < 50; i++ {
+		creds, err := src.Read(context.Background())
+		r.NoError(err)
+		r.Len(creds, 3)
+
+		password := creds["password"]
+		r.Len(password, 12)
+		r.True(strings.ContainsAny(password, "ABCDEFGHIJKLMNOPQRSTUVWXYZ"))
+		r.True(strings.ContainsAny(password, "abcdefghijklmnopqrstuvwxyz"))
+		r.True(strings.ContainsAny(password, "23456789"))
+		r.True(strings.ContainsAny(password, "!@#%"))
+		r.False(strings.ContainsAny(password, "0O1Il"))
+
+		r.Len(creds["token"], 40)
+		r.Empty(strings.Trim(creds["token"], "0123456789abcdef"))
+		r.Len(creds["otp_seed"], 16)
+		r.Empty(strings.Trim(creds["otp_seed"], "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"))
+	}
+
+	plan, err := src.Plan(context.Background())
+	r.NoError(err)
+	r.Equal([]string{"password", "token", "otp_seed"}, plan.Keys)
+}
+
+func TestGeneratedSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string][]source.GeneratedOutput{
+		"missing key":         {{Length: 8}},
+		"duplicate key":       {{Key: "a"}, {Key: "a"}},
+		"unknown alphabet":    {{Key: "a", Alphabet: "emoji"}},
+		"missing characters":  {{Key: "a", Alphabet: source.AlphabetCustom}},
+		"stray characters":    {{Key: "a", Characters: "abc"}},
+		"single character":    {{Key: "a", Alphabet: source.AlphabetCustom, Characters: "aaaa"}},
+		"negative length":     {{Key: "a", Length: -1}},
+		"unknown class":       {{Key: "a", Require: []string{"emoji"}}},
+		"class not available": {{Key: "a", Alphabet: source.AlphabetHex, Require: []string{source.ClassUpper}}},
+		"too short":           {{Key: "a", Length: 2, Require: []string{source.ClassUpper, source.ClassLower, source.ClassDigit}}},
+		"all ambiguous":       {{Key: "a", Alphabet: source.AlphabetCustom, Characters: "0O1Il", ExcludeAmbiguous: true}},
+	}
+	for name, outputs := range tests {
+		src := &source.GeneratedSource{Outputs: outputs}
+		r.Error(src.Validate(), name)
+	}
+}
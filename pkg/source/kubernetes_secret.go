@@ -0,0 +1,231 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// KubernetesSecretSource is a source that hands out the keys of a
+// Kubernetes Secret, so that secrets issued in the cluster, e.g. by
+// cert-manager, are distributed to sinks outside of it.
+//
+// The resourceVersion of the Secret is its CredentialID, and Create only
+// returns the Secret once it changed since the last rotation, see
+// Versioned.
+type KubernetesSecretSource struct {
+	// Kubeconfig and Context select the cluster from a kubeconfig file.
+	// If neither is set, the in-cluster config of the service account
+	// rotator runs as is used, falling back to the default kubeconfig
+	// outside of a cluster.
+	Kubeconfig string `yaml:"kubeconfig,omitempty"`
+	Context    string `yaml:"context,omitempty"`
+	Namespace  string `yaml:"namespace"`
+	Name       string `yaml:"name"`
+	// Keys are the keys of the Secret to hand out. Defaults to all keys.
+	Keys []string `yaml:"keys,omitempty"`
+
+	Client kubernetes.Interface `yaml:"-"`
+
+	// distributed is the CredentialID of the Secret distributed last.
+	distributed string
+	// resourceVersion is the resourceVersion of the Secret last read.
+	resourceVersion string
+	// force makes the next rotation hand out the Secret even if it did
+	// not change.
+	force bool
+}
+
+// kubernetesSecret is the part of a Secret handed out.
+type kubernetesSecret struct {
+	data            map[string]string
+	resourceVersion string
+}
+
+func init() {
+	Register(KindKubernetesSecret, func() Source { return NewKubernetesSecretSource() })
+}
+
+func NewKubernetesSecretSource() *KubernetesSecretSource {
+	return &KubernetesSecretSource{}
+}
+
+func (src *KubernetesSecretSource) WithSecret(namespace string, name string) *KubernetesSecretSource {
+	src.Namespace = namespace
+	src.Name = name
+	return src
+}
+
+func (src *KubernetesSecretSource) WithClient(client kubernetes.Interface) *KubernetesSecretSource {
+	src.Client = client
+	return src
+}
+
+func (src *KubernetesSecretSource) Kind() Kind {
+	return KindKubernetesSecret
+}
+
+// Validate checks that the Secret is named.
+func (src *KubernetesSecretSource) Validate() error {
+	var errs *multierror.Error
+	if src.Namespace == "" {
+		errs = multierror.Append(errs, errors.New("missing namespace"))
+	}
+	if src.Name == "" {
+		errs = multierror.Append(errs, errors.New("missing name"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up a client of the cluster. It does nothing if a client is
+// already set.
+func (src *KubernetesSecretSource) Init() error {
+	if src.Client != nil {
+		return nil
+	}
+	conf, err := src.restConfig()
+	if err != nil {
+		return errors.Wrap(err, "unable to configure kubernetes client")
+	}
+	client, err := kubernetes.NewForConfig(conf)
+	if err != nil {
+		return errors.Wrap(err, "unable to set up kubernetes client")
+	}
+	src.Client = client
+	return nil
+}
+
+func (src *KubernetesSecretSource) restConfig() (*rest.Config, error) {
+	if src.Kubeconfig == "" && src.Context == "" {
+		conf, err := rest.InClusterConfig()
+		if err != rest.ErrNotInCluster {
+			return conf, err
+		}
+	}
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = src.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: src.Context}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// read returns the keys of the Secret to hand out.
+func (src *KubernetesSecretSource) read(ctx context.Context) (*kubernetesSecret, error) {
+	secret, err := src.Client.CoreV1().Secrets(src.Namespace).Get(ctx, src.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, errors.Errorf("secret %s/%s not found", src.Namespace, src.Name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read secret %s/%s", src.Namespace, src.Name)
+	}
+
+	s := &kubernetesSecret{data: map[string]string{}, resourceVersion: secret.ResourceVersion}
+	if len(src.Keys) == 0 {
+		for k, v := range secret.Data {
+			s.data[k] = string(v)
+		}
+		if len(s.data) == 0 {
+			return nil, errors.Errorf("secret %s/%s has no data", src.Namespace, src.Name)
+		}
+		return s, nil
+	}
+	for _, k := range src.Keys {
+		v, ok := secret.Data[k]
+		if !ok {
+			return nil, errors.Errorf("key %s not found in secret %s/%s", k, src.Namespace, src.Name)
+		}
+		s.data[k] = string(v)
+	}
+	return s, nil
+}
+
+// id returns the CredentialID of resourceVersion.
+func (src *KubernetesSecretSource) id(resourceVersion string) string {
+	return fmt.Sprintf("%s/%s@%s", src.Namespace, src.Name, resourceVersion)
+}
+
+// due reports whether s should be handed out, and why.
+func (src *KubernetesSecretSource) due(s *kubernetesSecret) (bool, string) {
+	switch {
+	case src.force:
+		return true, "forced"
+	case src.distributed == "":
+		return true, fmt.Sprintf("resourceVersion %s, no version distributed yet", s.resourceVersion)
+	case src.distributed == src.id(s.resourceVersion):
+		return false, fmt.Sprintf("resourceVersion %s already distributed", s.resourceVersion)
+	}
+	return true, fmt.Sprintf("resourceVersion %s, distributed %s", s.resourceVersion, src.distributed)
+}
+
+// SetDistributed sets the CredentialID of the Secret distributed last.
+func (src *KubernetesSecretSource) SetDistributed(credentialID string) {
+	src.distributed = credentialID
+}
+
+// Force makes the next rotation hand out the Secret even if it did not
+// change.
+func (src *KubernetesSecretSource) Force() {
+	src.force = true
+}
+
+// Plan reads the Secret and reports whether it changed.
+func (src *KubernetesSecretSource) Plan(ctx context.Context) (Plan, error) {
+	s, err := src.read(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	var keys []string
+	for k := range s.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	due, reason := src.due(s)
+	return Plan{Due: due, Reason: reason, Keys: keys}, nil
+}
+
+// Read returns the keys of the Secret.
+func (src *KubernetesSecretSource) Read(ctx context.Context) (map[string]string, error) {
+	s, err := src.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	src.resourceVersion = s.resourceVersion
+	return s.data, nil
+}
+
+// Create returns the keys of the Secret, or nil if it did not change
+// since the last rotation.
+func (src *KubernetesSecretSource) Create(ctx context.Context) (map[string]string, error) {
+	s, err := src.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if due, _ := src.due(s); !due {
+		return nil, nil
+	}
+	src.resourceVersion = s.resourceVersion
+	return s.data, nil
+}
+
+// Activate is a no-op for KubernetesSecretSource.
+func (src *KubernetesSecretSource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for KubernetesSecretSource.
+func (src *KubernetesSecretSource) Revoke(ctx context.Context) error {
+	return nil
+}
+
+// CredentialID returns the namespace, name and resourceVersion of the
+// Secret last read, e.g. cert-manager/app-tls@12345.
+func (src *KubernetesSecretSource) CredentialID(creds map[string]string) string {
+	return src.id(src.resourceVersion)
+}
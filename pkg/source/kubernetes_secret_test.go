@@ -0,0 +1,125 @@
+package source_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func tlsSecret(resourceVersion string, cert string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "cert-manager", Name: "app-tls", ResourceVersion: resourceVersion},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			"tls.crt": []byte(cert),
+			"tls.key": []byte("key"),
+			"ca.crt":  []byte("ca"),
+		},
+	}
+}
+
+func TestKubernetesSecretSource(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(tlsSecret("100", "first"))
+
+	src := source.NewKubernetesSecretSource().WithSecret("cert-manager", "app-tls").WithClient(client)
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+
+	// nothing distributed yet
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Equal([]string{"ca.crt", "tls.crt", "tls.key"}, plan.Keys)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"tls.crt": "first", "tls.key": "key", "ca.crt": "ca"}, creds)
+	r.Equal("cert-manager/app-tls@100", src.CredentialID(creds))
+
+	// the same resourceVersion is not distributed again
+	src.SetDistributed("cert-manager/app-tls@100")
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// a new one is
+	_, err = client.CoreV1().Secrets("cert-manager").Update(ctx, tlsSecret("101", "second"), metav1.UpdateOptions{})
+	r.NoError(err)
+	src.Keys = []string{"tls.crt", "tls.key"}
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"tls.crt": "second", "tls.key": "key"}, creds)
+	r.Equal("cert-manager/app-tls@101", src.CredentialID(creds))
+
+	// and so is a forced one
+	src.SetDistributed("cert-manager/app-tls@101")
+	src.Force()
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+
+	src.Keys = []string{"missing"}
+	_, err = src.Read(ctx)
+	r.Error(err)
+
+	src = source.NewKubernetesSecretSource().WithSecret("cert-manager", "missing").WithClient(client)
+	_, err = src.Read(ctx)
+	r.Error(err)
+	r.Contains(err.Error(), "not found")
+}
+
+func TestKubernetesSecretSourceKubeconfig(t *testing.T) {
+	r := require.New(t)
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "config")
+	r.NoError(ioutil.WriteFile(kubeconfig, []byte(`apiVersion: v1
+kind: Config
+clusters:
+- name: kind
+  cluster: {server: "https://127.0.0.1:6443"}
+users:
+- name: rotator
+  user: {token: abc}
+contexts:
+- name: kind-rotator
+  context: {cluster: kind, user: rotator}
+current-context: kind-rotator
+`), 0600))
+
+	src := source.NewKubernetesSecretSource().WithSecret("default", "app")
+	src.Kubeconfig = kubeconfig
+	r.NoError(src.Init())
+	r.NotNil(src.Client)
+
+	src = source.NewKubernetesSecretSource().WithSecret("default", "app")
+	src.Kubeconfig = kubeconfig
+	src.Context = "missing"
+	r.Error(src.Init())
+}
+
+func TestKubernetesSecretSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string]*source.KubernetesSecretSource{
+		"missing namespace": {Name: "app"},
+		"missing name":      {Namespace: "default"},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+	r.NoError(source.NewKubernetesSecretSource().WithSecret("default", "app").Validate())
+}
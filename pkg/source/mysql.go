@@ -0,0 +1,397 @@
+package source
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Keys for the map returned by Read()
+	MySQLUsername = "username"
+	MySQLPassword = "password"
+	MySQLHost     = "host"
+	MySQLPort     = "port"
+	MySQLDSN      = "dsn"
+)
+
+const (
+	// DefaultMySQLAdminDSNEnv is the environment variable the admin DSN
+	// is read from if admin_dsn_env is not set.
+	DefaultMySQLAdminDSNEnv = "MYSQL_ADMIN_DSN"
+	// DefaultMySQLPort is the port used in the returned DSN if port is
+	// not set.
+	DefaultMySQLPort = 3306
+	// DefaultMySQLUserHost is the host part of the account if user_host
+	// is not set.
+	DefaultMySQLUserHost = "%"
+)
+
+// MySQLSource is a source that sets new passwords on MySQL accounts.
+//
+// By default it relies on the dual passwords of MySQL 8.0.14 and later,
+// the same way AwsIamSource relies on users having two access keys: a
+// new password is set while the current one is retained as the
+// secondary password, so that clients which already read it keep
+// working. The retained password is only discarded by the next rotation,
+// once the current password is older than MaxAge.
+//
+// With SinglePassword, e.g. for MariaDB, the new password replaces the
+// current one once it has been written to every sink.
+type MySQLSource struct {
+	// AdminDSNEnv names the environment variable holding the DSN, in
+	// the format of github.com/go-sql-driver/mysql, rotator connects
+	// with to change passwords.
+	AdminDSNEnv string `yaml:"admin_dsn_env,omitempty"`
+	// Username and UserHost name the account whose password is rotated,
+	// i.e. 'username'@'user_host'.
+	Username string `yaml:"username"`
+	UserHost string `yaml:"user_host,omitempty"`
+	// Host, Port, Database and TLS are used to compose the DSN returned
+	// to sinks.
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port,omitempty"`
+	Database string `yaml:"database,omitempty"`
+	TLS      string `yaml:"tls,omitempty"`
+	// PasswordLength is the length of new passwords. Zero means
+	// DefaultGeneratedLength.
+	PasswordLength int `yaml:"password_length,omitempty"`
+	// SinglePassword replaces the password instead of retaining the
+	// current one, for servers without dual passwords.
+	SinglePassword bool `yaml:"single_password,omitempty"`
+
+	DB *sql.DB `yaml:"-"`
+	// MaxAge is the max_age of the secret the source belongs to.
+	MaxAge time.Duration `yaml:"-"`
+
+	// pending is the password staged by Create that has not been
+	// activated or revoked yet.
+	pending string
+	// current is the password the sinks hold, as passed by Inspect, or
+	// empty if no sink can read it back.
+	current string
+	// force makes the next rotation ignore MaxAge.
+	force bool
+}
+
+// mysqlAccount is the state of an account relevant to rotation.
+type mysqlAccount struct {
+	// age is the time since the primary password was set, or negative
+	// if the server does not know.
+	age time.Duration
+	// retained is true if the account has a secondary password.
+	retained bool
+}
+
+func init() {
+	// max_age must be set on the secret in config files, so no
+	// default is applied
+	Register(KindMySQL, func() Source { return &MySQLSource{} })
+}
+
+func NewMySQLSource() *MySQLSource {
+	return &MySQLSource{
+		MaxAge: DefaultMaxAge,
+	}
+}
+
+func (src *MySQLSource) WithUsername(username string) *MySQLSource {
+	src.Username = username
+	return src
+}
+
+func (src *MySQLSource) WithDatabase(host string, port int, database string) *MySQLSource {
+	src.Host = host
+	src.Port = port
+	src.Database = database
+	return src
+}
+
+func (src *MySQLSource) WithDB(db *sql.DB) *MySQLSource {
+	src.DB = db
+	return src
+}
+
+func (src *MySQLSource) WithMaxAge(maxAge time.Duration) *MySQLSource {
+	src.MaxAge = maxAge
+	return src
+}
+
+// SetMaxAge sets MaxAge from the max_age of the secret.
+func (src *MySQLSource) SetMaxAge(maxAge time.Duration) {
+	src.MaxAge = maxAge
+}
+
+func (src *MySQLSource) Kind() Kind {
+	return KindMySQL
+}
+
+// Validate checks that the account and what is needed to compose the
+// DSN are set, and that max_age is set when passwords are retained.
+func (src *MySQLSource) Validate() error {
+	var errs *multierror.Error
+	if src.Username == "" {
+		errs = multierror.Append(errs, errors.New("missing username"))
+	}
+	if src.Host == "" {
+		errs = multierror.Append(errs, errors.New("missing host"))
+	}
+	if src.Port < 0 || src.Port > 65535 {
+		errs = multierror.Append(errs, errors.Errorf("invalid port %d", src.Port))
+	}
+	if !src.SinglePassword && src.MaxAge <= 0 {
+		errs = multierror.Append(errs, errors.New("missing max_age"))
+	}
+	err := src.passwordPolicy().validate()
+	if err != nil {
+		errs = multierror.Append(errs, errors.Wrap(err, "password"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init connects to the database with the DSN in AdminDSNEnv. It does
+// nothing if a database is already set.
+func (src *MySQLSource) Init() error {
+	if src.DB != nil {
+		return nil
+	}
+	env := src.AdminDSNEnv
+	if env == "" {
+		env = DefaultMySQLAdminDSNEnv
+	}
+	dsn, ok := os.LookupEnv(env)
+	if !ok {
+		return errors.Errorf("missing admin DSN: %s is not set", env)
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect to mysql")
+	}
+	src.DB = db
+	return nil
+}
+
+func (src *MySQLSource) passwordPolicy() GeneratedOutput {
+	return GeneratedOutput{Key: MySQLPassword, Length: src.PasswordLength}
+}
+
+func (src *MySQLSource) userHost() string {
+	if src.UserHost == "" {
+		return DefaultMySQLUserHost
+	}
+	return src.UserHost
+}
+
+// account returns 'username'@'user_host' quoted for statements.
+// Account management statements do not take query parameters.
+func (src *MySQLSource) account() string {
+	return fmt.Sprintf("%s@%s", mysqlQuote(src.Username), mysqlQuote(src.userHost()))
+}
+
+// lookup returns the age of the primary password of the account and
+// whether it has a secondary password.
+func (src *MySQLSource) lookup(ctx context.Context) (mysqlAccount, error) {
+	var seconds sql.NullInt64
+	var retained bool
+	err := src.DB.QueryRowContext(ctx,
+		"SELECT TIMESTAMPDIFF(SECOND, password_last_changed, NOW()), JSON_CONTAINS_PATH(COALESCE(User_attributes, '{}'), 'one', '$.additional_password') FROM mysql.user WHERE User = ? AND Host = ?",
+		src.Username, src.userHost(),
+	).Scan(&seconds, &retained)
+	if err == sql.ErrNoRows {
+		return mysqlAccount{}, errors.Errorf("account %s does not exist", src.account())
+	}
+	if err != nil {
+		return mysqlAccount{}, errors.Wrapf(err, "unable to look up account %s", src.account())
+	}
+	account := mysqlAccount{age: -1, retained: retained}
+	if seconds.Valid {
+		account.age = time.Duration(seconds.Int64) * time.Second
+	}
+	return account, nil
+}
+
+// due reports whether Create would set a new password given the state
+// of the account, and why.
+func (src *MySQLSource) due(account mysqlAccount) (bool, string) {
+	if src.force {
+		return true, "forced"
+	}
+	if !account.retained {
+		return true, "account has no secondary password"
+	}
+	if account.age < 0 {
+		return true, "password age is unknown"
+	}
+	reason := fmt.Sprintf("password is %s old, max_age is %s", account.age.Round(time.Second), src.MaxAge)
+	return account.age > src.MaxAge, reason
+}
+
+// Plan reports whether Create would set a new password, without
+// changing anything.
+func (src *MySQLSource) Plan(ctx context.Context) (Plan, error) {
+	keys := []string{MySQLDSN, MySQLHost, MySQLPassword, MySQLPort, MySQLUsername}
+	if src.SinglePassword {
+		return Plan{Due: true, Reason: "mysql source does not track the age of single passwords", Keys: keys}, nil
+	}
+	account, err := src.lookup(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	due, reason := src.due(account)
+	return Plan{Due: due, Reason: reason, Keys: keys}, nil
+}
+
+// InspectKeys returns the key of the password in use. Only dual passwords
+// need it, to restore it when a rotation is rolled back.
+func (src *MySQLSource) InspectKeys() []string {
+	if src.SinglePassword {
+		return nil
+	}
+	return []string{MySQLPassword}
+}
+
+// Inspect keeps the password in use, if current holds one.
+func (src *MySQLSource) Inspect(current map[string]string) {
+	src.current = current[MySQLPassword]
+}
+
+// Force makes the next rotation set a new password even if the current
+// one is within MaxAge.
+func (src *MySQLSource) Force() {
+	src.force = true
+}
+
+func (src *MySQLSource) Read(ctx context.Context) (map[string]string, error) {
+	creds, err := src.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return creds, src.Activate(ctx)
+}
+
+// Create generates a new password. With dual passwords, it is set right
+// away and the current password is retained; like RotateKeys, it does
+// nothing while both passwords may still be in use, and otherwise
+// discards the retained one first. With SinglePassword, it is only set by
+// Activate.
+func (src *MySQLSource) Create(ctx context.Context) (map[string]string, error) {
+	if src.SinglePassword {
+		password, err := src.passwordPolicy().generate()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to generate password")
+		}
+		src.pending = password
+		return src.credentials(password), nil
+	}
+
+	account, err := src.lookup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if due, _ := src.due(account); !due {
+		return nil, nil
+	}
+	password, err := src.passwordPolicy().generate()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate password")
+	}
+	if account.retained {
+		_, err = src.DB.ExecContext(ctx, fmt.Sprintf("ALTER USER %s DISCARD OLD PASSWORD", src.account()))
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to discard older password")
+		}
+	}
+	_, err = src.DB.ExecContext(ctx, fmt.Sprintf("ALTER USER %s IDENTIFIED BY %s RETAIN CURRENT PASSWORD", src.account(), mysqlQuote(password)))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to set new password")
+	}
+	src.pending = password
+	return src.credentials(password), nil
+}
+
+// Activate sets the password staged by Create with SinglePassword. With
+// dual passwords, the previous password stays valid so that jobs which
+// already read it can complete; it is discarded by the next rotation
+// once MaxAge has passed.
+func (src *MySQLSource) Activate(ctx context.Context) error {
+	if src.pending == "" {
+		return nil
+	}
+	if src.SinglePassword {
+		_, err := src.DB.ExecContext(ctx, fmt.Sprintf("ALTER USER %s IDENTIFIED BY %s", src.account(), mysqlQuote(src.pending)))
+		if err != nil {
+			return errors.Wrap(err, "unable to set new password")
+		}
+	}
+	src.pending = ""
+	return nil
+}
+
+// Revoke discards the password staged by Create. With dual passwords,
+// MySQL cannot promote the secondary password, the one in use, back, so
+// the password in use, as passed by Inspect, is set as the primary
+// password again and the secondary one discarded. Only the password in
+// use stays valid, and as the account has no secondary password, the
+// next rotation is due right away. Revoke fails if no sink of the secret
+// can read back the password in use.
+func (src *MySQLSource) Revoke(ctx context.Context) error {
+	if src.pending == "" {
+		return nil
+	}
+	if !src.SinglePassword {
+		if src.current == "" {
+			return errors.Errorf("unable to restore the password in use: no sink of %s can read it back", src.account())
+		}
+		_, err := src.DB.ExecContext(ctx, fmt.Sprintf("ALTER USER %s IDENTIFIED BY %s", src.account(), mysqlQuote(src.current)))
+		if err != nil {
+			return errors.Wrap(err, "unable to restore the password in use")
+		}
+		_, err = src.DB.ExecContext(ctx, fmt.Sprintf("ALTER USER %s DISCARD OLD PASSWORD", src.account()))
+		if err != nil {
+			return errors.Wrap(err, "unable to discard new password")
+		}
+	}
+	src.pending = ""
+	return nil
+}
+
+// CredentialID returns the username of creds.
+func (src *MySQLSource) CredentialID(creds map[string]string) string {
+	return creds[MySQLUsername]
+}
+
+func (src *MySQLSource) credentials(password string) map[string]string {
+	port := src.Port
+	if port == 0 {
+		port = DefaultMySQLPort
+	}
+	addr := net.JoinHostPort(src.Host, strconv.Itoa(port))
+	dsn := mysql.NewConfig()
+	dsn.User = src.Username
+	dsn.Passwd = password
+	dsn.Net = "tcp"
+	dsn.Addr = addr
+	dsn.DBName = src.Database
+	dsn.TLSConfig = src.TLS
+	return map[string]string{
+		MySQLUsername: src.Username,
+		MySQLPassword: password,
+		MySQLHost:     src.Host,
+		MySQLPort:     strconv.Itoa(port),
+		MySQLDSN:      dsn.FormatDSN(),
+	}
+}
+
+// mysqlQuote quotes s as a MySQL string literal.
+func mysqlQuote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,89 @@
+// +build integration
+
+package source_test
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMySQLSource_Integration runs against the server in
+// MYSQL_ADMIN_DSN, e.g. a local container:
+//
+//   docker run -d -p 3306:3306 -e MYSQL_ROOT_PASSWORD=admin mysql:8.0
+//   MYSQL_ADMIN_DSN='root:admin@tcp(localhost:3306)/'
+func TestMySQLSource_Integration(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	adminDSN, ok := os.LookupEnv(source.DefaultMySQLAdminDSNEnv)
+	if !ok {
+		t.Skipf("%s is not set", source.DefaultMySQLAdminDSNEnv)
+	}
+	admin, err := mysql.ParseDSN(adminDSN)
+	r.NoError(err)
+
+	host, port, err := net.SplitHostPort(admin.Addr)
+	r.NoError(err)
+	src := source.NewMySQLSource().
+		WithUsername("rotator_test").
+		WithMaxAge(time.Hour)
+	src.Host = host
+	src.Port, err = strconv.Atoi(port)
+	r.NoError(err)
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+	defer src.DB.Close()
+	_, err = src.DB.Exec("DROP USER IF EXISTS 'rotator_test'@'%'")
+	r.NoError(err)
+	_, err = src.DB.Exec("CREATE USER 'rotator_test'@'%' IDENTIFIED BY 'initial'")
+	r.NoError(err)
+	defer src.DB.Exec("DROP USER 'rotator_test'@'%'") // nolint:errcheck
+
+	connect := func(password string) error {
+		conf := mysql.NewConfig()
+		conf.User = "rotator_test"
+		conf.Passwd = password
+		conf.Net = "tcp"
+		conf.Addr = admin.Addr
+		db, err := sql.Open("mysql", conf.FormatDSN())
+		r.NoError(err)
+		defer db.Close()
+		return db.PingContext(ctx)
+	}
+
+	// the first rotation retains the initial password
+	first, err := src.Read(ctx)
+	r.NoError(err)
+	r.NoError(connect(first[source.MySQLPassword]))
+	r.NoError(connect("initial"))
+
+	// the second one waits for max_age, unless forced
+	creds, err := src.Read(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+	src.Force()
+	second, err := src.Read(ctx)
+	r.NoError(err)
+	r.NoError(connect(second[source.MySQLPassword]))
+	r.NoError(connect(first[source.MySQLPassword]))
+	r.Error(connect("initial"))
+
+	// a revoked password does not work, the one in use still does
+	src.Force()
+	src.Inspect(map[string]string{source.MySQLPassword: second[source.MySQLPassword]})
+	third, err := src.Create(ctx)
+	r.NoError(err)
+	r.NoError(src.Revoke(ctx))
+	r.Error(connect(third[source.MySQLPassword]))
+	r.NoError(connect(second[source.MySQLPassword]))
+	r.Error(connect(first[source.MySQLPassword]))
+}
@@ -0,0 +1,209 @@
+package source_test
+
+import (
+	"context"
+	"database/sql"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+)
+
+const mysqlLookup = "SELECT TIMESTAMPDIFF"
+
+func mysqlAccountRows(seconds interface{}, retained bool) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"age", "retained"}).AddRow(seconds, retained)
+}
+
+func mysqlStatement(statement string) string {
+	return "^" + regexp.QuoteMeta(statement) + "$"
+}
+
+func newMySQLSource(r *require.Assertions) (*source.MySQLSource, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	r.NoError(err)
+	src := source.NewMySQLSource().
+		WithUsername("app").
+		WithDatabase("db.example.com", 0, "app").
+		WithMaxAge(24 * time.Hour).
+		WithDB(db)
+	r.NoError(src.Validate())
+	return src, mock
+}
+
+func TestMySQLSourceRetainsCurrentPassword(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mock := newMySQLSource(r)
+	defer src.DB.Close()
+
+	// the first rotation keeps the password in use as the secondary one
+	mock.ExpectQuery(mysqlLookup).WithArgs("app", "%").WillReturnRows(mysqlAccountRows(3600, false))
+	mock.ExpectExec(`^ALTER USER 'app'@'%' IDENTIFIED BY '[A-Za-z0-9]{32}' RETAIN CURRENT PASSWORD$`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	creds, err := src.Read(ctx)
+	r.NoError(err)
+	r.NoError(mock.ExpectationsWereMet())
+
+	r.Equal("app", creds[source.MySQLUsername])
+	r.Equal("app", src.CredentialID(creds))
+	r.Len(creds[source.MySQLPassword], source.DefaultGeneratedLength)
+	r.Equal("db.example.com", creds[source.MySQLHost])
+	r.Equal("3306", creds[source.MySQLPort])
+	dsn, err := mysql.ParseDSN(creds[source.MySQLDSN])
+	r.NoError(err)
+	r.Equal("app", dsn.User)
+	r.Equal(creds[source.MySQLPassword], dsn.Passwd)
+	r.Equal("tcp", dsn.Net)
+	r.Equal("db.example.com:3306", dsn.Addr)
+	r.Equal("app", dsn.DBName)
+}
+
+func TestMySQLSourceWithinMaxAge(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mock := newMySQLSource(r)
+	defer src.DB.Close()
+
+	// nothing to do while both passwords may still be in use
+	mock.ExpectQuery(mysqlLookup).WillReturnRows(mysqlAccountRows(3600, true))
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Equal("password is 1h0m0s old, max_age is 24h0m0s", plan.Reason)
+	r.Equal([]string{source.MySQLDSN, source.MySQLHost, source.MySQLPassword, source.MySQLPort, source.MySQLUsername}, plan.Keys)
+
+	mock.ExpectQuery(mysqlLookup).WillReturnRows(mysqlAccountRows(3600, true))
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+	r.NoError(mock.ExpectationsWereMet())
+
+	// unless forced, which discards the older password
+	src.Force()
+	mock.ExpectQuery(mysqlLookup).WillReturnRows(mysqlAccountRows(3600, true))
+	mock.ExpectExec(mysqlStatement(`ALTER USER 'app'@'%' DISCARD OLD PASSWORD`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RETAIN CURRENT PASSWORD").WillReturnResult(sqlmock.NewResult(0, 0))
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestMySQLSourcePastMaxAge(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mock := newMySQLSource(r)
+	defer src.DB.Close()
+	src.UserHost = "10.0.%"
+
+	for _, age := range []interface{}{int64((25 * time.Hour).Seconds()), nil} {
+		mock.ExpectQuery(mysqlLookup).WithArgs("app", "10.0.%").WillReturnRows(mysqlAccountRows(age, true))
+		mock.ExpectExec(mysqlStatement(`ALTER USER 'app'@'10.0.%' DISCARD OLD PASSWORD`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(`^ALTER USER 'app'@'10.0.%' IDENTIFIED BY '[A-Za-z0-9]{32}' RETAIN CURRENT PASSWORD$`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		creds, err := src.Read(ctx)
+		r.NoError(err)
+		r.NotNil(creds)
+		r.NoError(mock.ExpectationsWereMet())
+	}
+}
+
+func TestMySQLSourceRevoke(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mock := newMySQLSource(r)
+	defer src.DB.Close()
+
+	r.Equal([]string{source.MySQLPassword}, src.InspectKeys())
+	src.Inspect(map[string]string{source.MySQLPassword: "in-use"})
+	mock.ExpectQuery(mysqlLookup).WillReturnRows(mysqlAccountRows(int64((25 * time.Hour).Seconds()), true))
+	mock.ExpectExec(mysqlStatement(`ALTER USER 'app'@'%' DISCARD OLD PASSWORD`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("RETAIN CURRENT PASSWORD").WillReturnResult(sqlmock.NewResult(0, 0))
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+
+	// the password in use is the primary one again, and the only one
+	mock.ExpectExec(mysqlStatement(`ALTER USER 'app'@'%' IDENTIFIED BY 'in-use'`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(mysqlStatement(`ALTER USER 'app'@'%' DISCARD OLD PASSWORD`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	r.NoError(src.Revoke(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+
+	// so the next rotation retains it rather than discarding anything
+	src.Inspect(map[string]string{source.MySQLPassword: "in-use"})
+	mock.ExpectQuery(mysqlLookup).WillReturnRows(mysqlAccountRows(0, false))
+	mock.ExpectExec(`^ALTER USER 'app'@'%' IDENTIFIED BY '[A-Za-z0-9]{32}' RETAIN CURRENT PASSWORD$`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+	r.NoError(mock.ExpectationsWereMet())
+
+	// without the password in use, nothing is changed
+	src.Inspect(map[string]string{})
+	r.Error(src.Revoke(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+
+	mock.ExpectQuery(mysqlLookup).WillReturnError(sql.ErrConnDone)
+	_, err = src.Create(ctx)
+	r.Error(err)
+	mock.ExpectQuery(mysqlLookup).WillReturnRows(sqlmock.NewRows([]string{"age", "retained"}))
+	_, err = src.Create(ctx)
+	r.EqualError(err, `account 'app'@'%' does not exist`)
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestMySQLSourceSinglePassword(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	src, mock := newMySQLSource(r)
+	defer src.DB.Close()
+	src.SinglePassword = true
+	src.MaxAge = 0
+	src.TLS = "true"
+	src.Username = `o'brien`
+	r.NoError(src.Validate())
+
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+
+	// the password is only replaced once the rotation is activated
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.NoError(mock.ExpectationsWereMet())
+	dsn, err := mysql.ParseDSN(creds[source.MySQLDSN])
+	r.NoError(err)
+	r.Equal("true", dsn.TLSConfig)
+
+	mock.ExpectExec(mysqlStatement(`ALTER USER 'o\'brien'@'%' IDENTIFIED BY '` + creds[source.MySQLPassword] + `'`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	r.NoError(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+
+	_, err = src.Create(ctx)
+	r.NoError(err)
+	r.NoError(src.Revoke(ctx))
+	r.NoError(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestMySQLSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string]*source.MySQLSource{
+		"missing username": {Host: "h", MaxAge: time.Hour},
+		"missing host":     {Username: "u", MaxAge: time.Hour},
+		"missing max_age":  {Username: "u", Host: "h"},
+		"invalid port":     {Username: "u", Host: "h", MaxAge: time.Hour, Port: -1},
+		"invalid length":   {Username: "u", Host: "h", MaxAge: time.Hour, PasswordLength: 5000},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+}
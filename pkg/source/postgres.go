@@ -0,0 +1,453 @@
+package source
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// Keys for the map returned by Read()
+	PostgresUsername = "username"
+	PostgresPassword = "password"
+	PostgresHost     = "host"
+	PostgresPort     = "port"
+	PostgresDSN      = "dsn"
+)
+
+const (
+	// DefaultPostgresAdminDSNEnv is the environment variable the admin
+	// DSN is read from if admin_dsn_env is not set.
+	DefaultPostgresAdminDSNEnv = "POSTGRES_ADMIN_DSN"
+	// DefaultPostgresPort is the port used in the returned DSN if port
+	// is not set.
+	DefaultPostgresPort = 5432
+
+	PasswordEncryptionScram  = "scram-sha-256"
+	PasswordEncryptionServer = "server"
+
+	// postgresActiveComment marks the role in use in dual-role mode. It
+	// is followed by postgresSince and the time the role was activated.
+	postgresActiveComment = "rotator: active"
+	postgresSince         = " since "
+	// postgresStandbyComment marks the role not in use in dual-role mode.
+	postgresStandbyComment = "rotator: standby"
+	// scramIterations is the iteration count of the SCRAM-SHA-256
+	// verifiers sent to the server, the default of PostgreSQL.
+	scramIterations = 4096
+)
+
+// PostgresSource is a source that sets new passwords on PostgreSQL roles.
+//
+// With a single role, the new password is only set once it has been
+// written to every sink, so the sinks hold a password that is not valid
+// yet until the rotation is activated.
+//
+// With two roles, the password of the role not in use is changed, and
+// the roles swap places once the rotation is activated, so the live
+// password is never invalid. The role in use, and since when, is tracked
+// with a comment on each role, and the roles only swap places again once
+// the role in use is older than MaxAge.
+type PostgresSource struct {
+	// AdminDSNEnv names the environment variable holding the DSN
+	// rotator connects with to change passwords.
+	AdminDSNEnv string `yaml:"admin_dsn_env,omitempty"`
+	// Role is the role whose password is rotated. It is mutually
+	// exclusive with Roles.
+	Role string `yaml:"role,omitempty"`
+	// Roles are two roles with the same privileges that rotator
+	// alternates between. Before the first rotation, the first one is
+	// assumed to be in use.
+	Roles []string `yaml:"roles,omitempty"`
+	// Host, Port, Database and SSLMode are used to compose the DSN
+	// returned to sinks.
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port,omitempty"`
+	Database string `yaml:"database"`
+	SSLMode  string `yaml:"sslmode,omitempty"`
+	// PasswordLength is the length of new passwords. Zero means
+	// DefaultGeneratedLength.
+	PasswordLength int `yaml:"password_length,omitempty"`
+	// PasswordEncryption is scram-sha-256, the default, to send the
+	// server a SCRAM-SHA-256 verifier computed by rotator so that the
+	// password never reaches the server or its logs, or server to send
+	// the password and let the server hash it.
+	PasswordEncryption string `yaml:"password_encryption,omitempty"`
+	// MaxAge is the max_age of the secret the source belongs to.
+	MaxAge time.Duration `yaml:"-"`
+
+	DB *sql.DB `yaml:"-"`
+
+	// pending is the role and password staged by Create that have not
+	// been activated or revoked yet.
+	pending *postgresCredential
+	// force makes the next rotation ignore MaxAge.
+	force bool
+}
+
+type postgresCredential struct {
+	role     string
+	password string
+}
+
+// postgresRoles are the role in use, the role to rotate next and when
+// the role in use was activated, if known.
+type postgresRoles struct {
+	active    string
+	next      string
+	activated time.Time
+}
+
+func init() {
+	Register(KindPostgres, func() Source { return NewPostgresSource() })
+}
+
+func NewPostgresSource() *PostgresSource {
+	return &PostgresSource{
+		MaxAge: DefaultMaxAge,
+	}
+}
+
+func (src *PostgresSource) WithRole(role string) *PostgresSource {
+	src.Role = role
+	return src
+}
+
+func (src *PostgresSource) WithRoles(roles ...string) *PostgresSource {
+	src.Roles = roles
+	return src
+}
+
+func (src *PostgresSource) WithDatabase(host string, port int, database string) *PostgresSource {
+	src.Host = host
+	src.Port = port
+	src.Database = database
+	return src
+}
+
+func (src *PostgresSource) WithDB(db *sql.DB) *PostgresSource {
+	src.DB = db
+	return src
+}
+
+func (src *PostgresSource) WithMaxAge(maxAge time.Duration) *PostgresSource {
+	src.MaxAge = maxAge
+	return src
+}
+
+// SetMaxAge sets MaxAge from the max_age of the secret.
+func (src *PostgresSource) SetMaxAge(maxAge time.Duration) {
+	src.MaxAge = maxAge
+}
+
+func (src *PostgresSource) Kind() Kind {
+	return KindPostgres
+}
+
+// Validate checks that either one role or two roles are set, along with
+// what is needed to compose the DSN.
+func (src *PostgresSource) Validate() error {
+	var errs *multierror.Error
+	switch {
+	case src.Role == "" && len(src.Roles) == 0:
+		errs = multierror.Append(errs, errors.New("missing role or roles"))
+	case src.Role != "" && len(src.Roles) != 0:
+		errs = multierror.Append(errs, errors.New("role and roles are mutually exclusive"))
+	case len(src.Roles) != 0 && (len(src.Roles) != 2 || src.Roles[0] == src.Roles[1] || src.Roles[0] == "" || src.Roles[1] == ""):
+		errs = multierror.Append(errs, errors.New("roles must be two distinct roles"))
+	}
+	if len(src.Roles) != 0 && src.MaxAge <= 0 {
+		errs = multierror.Append(errs, errors.New("missing max_age"))
+	}
+	if src.Host == "" {
+		errs = multierror.Append(errs, errors.New("missing host"))
+	}
+	if src.Database == "" {
+		errs = multierror.Append(errs, errors.New("missing database"))
+	}
+	if src.Port < 0 || src.Port > 65535 {
+		errs = multierror.Append(errs, errors.Errorf("invalid port %d", src.Port))
+	}
+	switch src.PasswordEncryption {
+	case "", PasswordEncryptionScram, PasswordEncryptionServer:
+	default:
+		errs = multierror.Append(errs, errors.Errorf("unknown password_encryption %q", src.PasswordEncryption))
+	}
+	err := src.passwordPolicy().validate()
+	if err != nil {
+		errs = multierror.Append(errs, errors.Wrap(err, "password"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init connects to the database with the DSN in AdminDSNEnv. It does
+// nothing if a database is already set.
+func (src *PostgresSource) Init() error {
+	if src.DB != nil {
+		return nil
+	}
+	env := src.AdminDSNEnv
+	if env == "" {
+		env = DefaultPostgresAdminDSNEnv
+	}
+	dsn, ok := os.LookupEnv(env)
+	if !ok {
+		return errors.Errorf("missing admin DSN: %s is not set", env)
+	}
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return errors.Wrap(err, "unable to connect to postgres")
+	}
+	src.DB = db
+	return nil
+}
+
+func (src *PostgresSource) passwordPolicy() GeneratedOutput {
+	return GeneratedOutput{Key: PostgresPassword, Length: src.PasswordLength}
+}
+
+// roles returns the role in use and the role to rotate next.
+func (src *PostgresSource) roles(ctx context.Context) (postgresRoles, error) {
+	if len(src.Roles) == 0 {
+		return postgresRoles{active: src.Role, next: src.Role}, nil
+	}
+	var active, comment string
+	err := src.DB.QueryRowContext(ctx,
+		"SELECT rolname, shobj_description(oid, 'pg_authid') FROM pg_roles WHERE rolname IN ($1, $2) AND shobj_description(oid, 'pg_authid') LIKE $3",
+		src.Roles[0], src.Roles[1], postgresActiveComment+"%",
+	).Scan(&active, &comment)
+	if err == sql.ErrNoRows {
+		return postgresRoles{active: src.Roles[0], next: src.Roles[1]}, nil
+	}
+	if err != nil {
+		return postgresRoles{}, errors.Wrap(err, "unable to find the role in use")
+	}
+	roles := postgresRoles{active: src.Roles[1], next: src.Roles[0]}
+	if active == src.Roles[0] {
+		roles = postgresRoles{active: src.Roles[0], next: src.Roles[1]}
+	}
+	// comments written before activation times were recorded have none
+	since := strings.TrimPrefix(comment, postgresActiveComment+postgresSince)
+	if activated, err := time.Parse(time.RFC3339, since); err == nil {
+		roles.activated = activated
+	}
+	return roles, nil
+}
+
+// due reports whether Create would set a new password at now, and why.
+// A single role is always due, as its password has no recorded age.
+func (src *PostgresSource) due(roles postgresRoles, now time.Time) (bool, string) {
+	if src.force {
+		return true, "forced"
+	}
+	if len(src.Roles) == 0 {
+		return true, "postgres source does not track the age of single-role passwords"
+	}
+	if roles.activated.IsZero() {
+		return true, fmt.Sprintf("%s has no recorded activation time", roles.active)
+	}
+	age := now.Sub(roles.activated)
+	return age > src.MaxAge, fmt.Sprintf("%s has been in use for %s, max_age is %s", roles.active, age.Round(time.Second), src.MaxAge)
+}
+
+// Plan reports whether Create would set a new password, and on which
+// role, without changing anything.
+func (src *PostgresSource) Plan(ctx context.Context) (Plan, error) {
+	roles, err := src.roles(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	due, reason := src.due(roles, time.Now())
+	if due {
+		reason = fmt.Sprintf("%s, would set the password of %s", reason, roles.next)
+	}
+	return Plan{
+		Due:    due,
+		Reason: reason,
+		Keys:   []string{PostgresDSN, PostgresHost, PostgresPassword, PostgresPort, PostgresUsername},
+	}, nil
+}
+
+// Force makes the next rotation set a new password even if the role in
+// use is within MaxAge.
+func (src *PostgresSource) Force() {
+	src.force = true
+}
+
+func (src *PostgresSource) Read(ctx context.Context) (map[string]string, error) {
+	creds, err := src.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return creds, src.Activate(ctx)
+}
+
+// Create generates a new password. With two roles, it is set right away
+// on the role not in use, unless the role in use is within MaxAge. With a
+// single role, it is only set by Activate.
+func (src *PostgresSource) Create(ctx context.Context) (map[string]string, error) {
+	roles, err := src.roles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if due, _ := src.due(roles, time.Now()); !due {
+		return nil, nil
+	}
+	next := roles.next
+	password, err := src.passwordPolicy().generate()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate password")
+	}
+	if len(src.Roles) != 0 {
+		err = src.setPassword(ctx, next, password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	src.pending = &postgresCredential{role: next, password: password}
+	return src.credentials(next, password), nil
+}
+
+// Activate sets the password staged by Create on a single role, or marks
+// the role it was set on as in use with two roles.
+func (src *PostgresSource) Activate(ctx context.Context) error {
+	if src.pending == nil {
+		return nil
+	}
+	if len(src.Roles) == 0 {
+		err := src.setPassword(ctx, src.pending.role, src.pending.password)
+		if err != nil {
+			return err
+		}
+		src.pending = nil
+		return nil
+	}
+
+	standby := src.Roles[0]
+	if standby == src.pending.role {
+		standby = src.Roles[1]
+	}
+	tx, err := src.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "unable to mark the new role as in use")
+	}
+	defer tx.Rollback() // nolint:errcheck
+	marks := []struct{ role, comment string }{
+		{standby, postgresStandbyComment},
+		{src.pending.role, postgresActiveComment + postgresSince + time.Now().UTC().Format(time.RFC3339)},
+	}
+	for _, mark := range marks {
+		_, err = tx.ExecContext(ctx, fmt.Sprintf("COMMENT ON ROLE %s IS %s", pq.QuoteIdentifier(mark.role), pq.QuoteLiteral(mark.comment)))
+		if err != nil {
+			return errors.Wrapf(err, "unable to mark role %s", mark.role)
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrap(err, "unable to mark the new role as in use")
+	}
+	src.pending = nil
+	return nil
+}
+
+// Revoke discards the password staged by Create. With two roles, the
+// role not in use gets another new password that nobody knows.
+func (src *PostgresSource) Revoke(ctx context.Context) error {
+	if src.pending == nil {
+		return nil
+	}
+	if len(src.Roles) != 0 {
+		password, err := src.passwordPolicy().generate()
+		if err != nil {
+			return errors.Wrap(err, "unable to generate password")
+		}
+		err = src.setPassword(ctx, src.pending.role, password)
+		if err != nil {
+			return err
+		}
+	}
+	src.pending = nil
+	return nil
+}
+
+// CredentialID returns the role of creds.
+func (src *PostgresSource) CredentialID(creds map[string]string) string {
+	return creds[PostgresUsername]
+}
+
+// setPassword sets password on role. ALTER ROLE does not take query
+// parameters, so the password is sent as a quoted literal.
+func (src *PostgresSource) setPassword(ctx context.Context, role string, password string) error {
+	if src.PasswordEncryption != PasswordEncryptionServer {
+		var err error
+		password, err = scramVerifier(password)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := src.DB.ExecContext(ctx, fmt.Sprintf("ALTER ROLE %s WITH PASSWORD %s", pq.QuoteIdentifier(role), pq.QuoteLiteral(password)))
+	return errors.Wrapf(err, "unable to set the password of role %s", role)
+}
+
+func (src *PostgresSource) credentials(role string, password string) map[string]string {
+	port := src.Port
+	if port == 0 {
+		port = DefaultPostgresPort
+	}
+	dsn := url.URL{
+		Scheme: "postgres",
+		User:   url.UserPassword(role, password),
+		Host:   net.JoinHostPort(src.Host, strconv.Itoa(port)),
+		Path:   "/" + src.Database,
+	}
+	if src.SSLMode != "" {
+		dsn.RawQuery = url.Values{"sslmode": {src.SSLMode}}.Encode()
+	}
+	return map[string]string{
+		PostgresUsername: role,
+		PostgresPassword: password,
+		PostgresHost:     src.Host,
+		PostgresPort:     strconv.Itoa(port),
+		PostgresDSN:      dsn.String(),
+	}
+}
+
+// scramVerifier returns the SCRAM-SHA-256 verifier of password in the
+// format PostgreSQL stores and accepts in place of a password (RFC 5802,
+// RFC 7677). Passwords are generated from ASCII letters and digits, so
+// SASLprep leaves them unchanged.
+func scramVerifier(password string) (string, error) {
+	salt := make([]byte, 16)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to generate salt")
+	}
+	salted := pbkdf2.Key([]byte(password), salt, scramIterations, sha256.Size, sha256.New)
+	clientKey := hmacSHA256(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	serverKey := hmacSHA256(salted, "Server Key")
+	enc := base64.StdEncoding
+	return fmt.Sprintf("SCRAM-SHA-256$%d:%s$%s:%s",
+		scramIterations, enc.EncodeToString(salt), enc.EncodeToString(storedKey[:]), enc.EncodeToString(serverKey)), nil
+}
+
+func hmacSHA256(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	_, _ = mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
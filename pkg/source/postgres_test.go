@@ -0,0 +1,224 @@
+package source_test
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"os"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+// scramStatement matches ALTER ROLE statements that set a SCRAM-SHA-256
+// verifier rather than a plaintext password.
+func scramStatement(role string) string {
+	return regexp.QuoteMeta(`ALTER ROLE "`+role+`" WITH PASSWORD 'SCRAM-SHA-256$4096:`) + `[A-Za-z0-9+/=]+\$[A-Za-z0-9+/=]+:[A-Za-z0-9+/=]+'$`
+}
+
+func newPostgresMock(r *require.Assertions) (*sql.DB, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	r.NoError(err)
+	return db, mock
+}
+
+func TestPostgresSourceSingleRole(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db, mock := newPostgresMock(r)
+	defer db.Close()
+
+	src := source.NewPostgresSource().
+		WithRole("app").
+		WithDatabase("db.example.com", 0, "app").
+		WithDB(db)
+	src.SSLMode = "require"
+	r.NoError(src.Validate())
+
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Equal([]string{source.PostgresDSN, source.PostgresHost, source.PostgresPassword, source.PostgresPort, source.PostgresUsername}, plan.Keys)
+
+	// the password is only set once the rotation is activated
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.NoError(mock.ExpectationsWereMet())
+	r.Equal("app", creds[source.PostgresUsername])
+	r.Equal("app", src.CredentialID(creds))
+	r.Len(creds[source.PostgresPassword], source.DefaultGeneratedLength)
+	r.Equal("db.example.com", creds[source.PostgresHost])
+	r.Equal("5432", creds[source.PostgresPort])
+	dsn, err := url.Parse(creds[source.PostgresDSN])
+	r.NoError(err)
+	r.Equal("postgres", dsn.Scheme)
+	r.Equal("db.example.com:5432", dsn.Host)
+	r.Equal("/app", dsn.Path)
+	r.Equal("require", dsn.Query().Get("sslmode"))
+	password, _ := dsn.User.Password()
+	r.Equal(creds[source.PostgresPassword], password)
+
+	mock.ExpectExec(scramStatement("app")).WillReturnResult(sqlmock.NewResult(0, 0))
+	r.NoError(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+
+	// revoking a single role only discards the staged password
+	_, err = src.Create(ctx)
+	r.NoError(err)
+	r.NoError(src.Revoke(ctx))
+	r.NoError(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestPostgresSourceServerEncryption(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db, mock := newPostgresMock(r)
+	defer db.Close()
+
+	src := source.NewPostgresSource().
+		WithRole(`odd"role`).
+		WithDatabase("localhost", 5433, "app").
+		WithDB(db)
+	src.PasswordEncryption = source.PasswordEncryptionServer
+
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	mock.ExpectExec(regexp.QuoteMeta(`ALTER ROLE "odd""role" WITH PASSWORD '` + creds[source.PostgresPassword] + `'`)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	r.NoError(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestPostgresSourceDualRoles(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db, mock := newPostgresMock(r)
+	defer db.Close()
+
+	src := source.NewPostgresSource().
+		WithRoles("app_a", "app_b").
+		WithDatabase("localhost", 5432, "app").
+		WithDB(db).
+		WithMaxAge(time.Hour)
+	r.NoError(src.Validate())
+	activeQuery := regexp.QuoteMeta("SELECT rolname, shobj_description(oid, 'pg_authid') FROM pg_roles")
+	active := func(role string, comment string) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"rolname", "comment"}).AddRow(role, comment)
+	}
+
+	// before the first rotation, the first role is in use
+	mock.ExpectQuery(activeQuery).
+		WithArgs("app_a", "app_b", "rotator: active%").
+		WillReturnRows(sqlmock.NewRows([]string{"rolname", "comment"}))
+	mock.ExpectExec(scramStatement("app_b")).WillReturnResult(sqlmock.NewResult(0, 0))
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal("app_b", creds[source.PostgresUsername])
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`COMMENT ON ROLE "app_a" IS 'rotator: standby'`)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta(`COMMENT ON ROLE "app_b" IS 'rotator: active since `)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	r.NoError(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+
+	// the roles do not swap again until the role in use is older than max_age
+	recent := "rotator: active since " + time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	mock.ExpectQuery(activeQuery).WillReturnRows(active("app_b", recent))
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Contains(plan.Reason, "app_b has been in use for 1m")
+	r.Contains(plan.Reason, "max_age is 1h0m0s")
+	mock.ExpectQuery(activeQuery).WillReturnRows(active("app_b", recent))
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// the next rotation changes the role not in use
+	old := "rotator: active since " + time.Now().Add(-2*time.Hour).UTC().Format(time.RFC3339)
+	mock.ExpectQuery(activeQuery).WillReturnRows(active("app_b", old))
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Contains(plan.Reason, "would set the password of app_a")
+
+	// a role marked before activation times were recorded is due
+	mock.ExpectQuery(activeQuery).WillReturnRows(active("app_b", "rotator: active"))
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Contains(plan.Reason, "app_b has no recorded activation time")
+
+	// revoking gives the role not in use a password nobody knows
+	mock.ExpectQuery(activeQuery).WillReturnRows(active("app_b", old))
+	mock.ExpectExec(scramStatement("app_a")).WillReturnResult(sqlmock.NewResult(0, 0))
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal("app_a", creds[source.PostgresUsername])
+	mock.ExpectExec(scramStatement("app_a")).WillReturnResult(sqlmock.NewResult(0, 0))
+	r.NoError(src.Revoke(ctx))
+	r.NoError(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestPostgresSourceActivateRollsBack(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	db, mock := newPostgresMock(r)
+	defer db.Close()
+
+	src := source.NewPostgresSource().
+		WithRoles("app_a", "app_b").
+		WithDatabase("localhost", 5432, "app").
+		WithDB(db)
+	mock.ExpectQuery("SELECT rolname").WillReturnRows(sqlmock.NewRows([]string{"rolname", "comment"}).AddRow("app_a", "rotator: active"))
+	mock.ExpectExec(scramStatement("app_b")).WillReturnResult(sqlmock.NewResult(0, 0))
+	_, err := src.Create(ctx)
+	r.NoError(err)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("COMMENT ON ROLE").WillReturnError(sql.ErrConnDone)
+	mock.ExpectRollback()
+	r.Error(src.Activate(ctx))
+	r.NoError(mock.ExpectationsWereMet())
+}
+
+func TestPostgresSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string]*source.PostgresSource{
+		"missing role":       {Host: "h", Database: "d"},
+		"role and roles":     {Role: "a", Roles: []string{"b", "c"}, Host: "h", Database: "d"},
+		"one role":           {Roles: []string{"a"}, Host: "h", Database: "d"},
+		"same roles":         {Roles: []string{"a", "a"}, Host: "h", Database: "d"},
+		"missing max age":    {Roles: []string{"a", "b"}, Host: "h", Database: "d"},
+		"missing host":       {Role: "a", Database: "d"},
+		"missing database":   {Role: "a", Host: "h"},
+		"invalid port":       {Role: "a", Host: "h", Database: "d", Port: 70000},
+		"unknown encryption": {Role: "a", Host: "h", Database: "d", PasswordEncryption: "md5"},
+		"invalid length":     {Role: "a", Host: "h", Database: "d", PasswordLength: -1},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+}
+
+func TestPostgresSourceInit(t *testing.T) {
+	r := require.New(t)
+
+	src := source.NewPostgresSource().WithRole("app")
+	src.AdminDSNEnv = "ROTATOR_TEST_UNSET_POSTGRES_DSN"
+	r.Error(src.Init())
+
+	r.NoError(os.Setenv(src.AdminDSNEnv, "postgres://admin@localhost/postgres"))
+	defer os.Unsetenv(src.AdminDSNEnv)
+	r.NoError(src.Init())
+	r.NotNil(src.DB)
+	r.NoError(src.DB.Close())
+}
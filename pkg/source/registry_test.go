@@ -0,0 +1,73 @@
+package source_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+const kindCustom source.Kind = "custom"
+
+// customSource stands in for a kind registered from outside rotator.
+type customSource struct {
+	source.DummySource `yaml:"-"`
+
+	Label string `yaml:"label"`
+}
+
+func (src *customSource) Validate() error {
+	if src.Label == "" {
+		return source.Error("missing label")
+	}
+	return nil
+}
+
+func (src *customSource) Create(ctx context.Context) (map[string]string, error) {
+	return map[string]string{source.Secret: src.Label}, nil
+}
+
+func (src *customSource) Kind() source.Kind { return kindCustom }
+
+func init() {
+	source.Register(kindCustom, func() source.Source { return &customSource{} })
+}
+
+func TestRegistry(t *testing.T) {
+	r := require.New(t)
+
+	r.Contains(source.Kinds(), kindCustom)
+	r.Contains(source.Kinds(), source.KindAws)
+	r.Panics(func() {
+		source.Register(kindCustom, func() source.Source { return &customSource{} })
+	})
+
+	src, err := decode(r, "{kind: custom, label: hello}")
+	r.NoError(err)
+	r.Equal(&customSource{Label: "hello"}, src)
+
+	fields, err := source.Encode(src)
+	r.NoError(err)
+	r.Equal(map[string]interface{}{"kind": "custom", "label": "hello"}, fields)
+
+	// validation is left to the caller
+	src, err = decode(r, "{kind: custom}")
+	r.NoError(err)
+	r.Error(src.(source.Validator).Validate())
+
+	_, err = decode(r, "{kind: unknown}")
+	r.Error(err)
+	_, err = decode(r, "{label: hello}")
+	r.Error(err)
+	_, err = decode(r, "{kind: custom, label: hello, lable: typo}")
+	r.Error(err)
+	r.Contains(err.Error(), `line 1: unknown field "lable" in custom source config`)
+}
+
+func decode(r *require.Assertions, in string) (source.Source, error) {
+	var node yaml.Node
+	r.NoError(yaml.Unmarshal([]byte(in), &node))
+	return source.Decode(node.Content[0])
+}
@@ -1,6 +1,7 @@
 package source
 
 import (
+	"context"
 	"time"
 )
 
@@ -8,14 +9,118 @@ import (
 //
 // Read reads the secret from the underlying source.
 // It returns the secret and any error encountered
-// that caused the read to stop early.
+// that caused the read to stop early. For sources that
+// mint credentials, Read is equivalent to Create followed
+// by Activate.
 //
-// Kind returns the kind of sink.
+// Create stages a new credential at the source without
+// retiring the credential currently in use. It returns
+// nil if the credential is not due for rotation yet.
+//
+// Activate is called once the staged credential has been
+// written to and verified in every sink. It retires the
+// credential that was in use before Create, if the source
+// needs to.
+//
+// Revoke discards the credential staged by Create. It is
+// called when the rotation is rolled back.
+//
+// Kind returns the kind of source.
 type Source interface {
-	Read() (map[string]string, error)
+	Read(ctx context.Context) (map[string]string, error)
+	Create(ctx context.Context) (map[string]string, error)
+	Activate(ctx context.Context) error
+	Revoke(ctx context.Context) error
 	Kind() Kind
 }
 
+// Identifier is implemented by sources that can name the credential
+// they produced without revealing it, e.g. by its AWS access key ID.
+// The name is recorded in the state store.
+type Identifier interface {
+	CredentialID(creds map[string]string) string
+}
+
+// Planner is implemented by sources that can tell, without changing
+// anything, whether Create would produce a new credential.
+type Planner interface {
+	Plan(ctx context.Context) (Plan, error)
+}
+
+// Plan describes what Create would do.
+type Plan struct {
+	// Due is true if Create would produce a new credential.
+	Due bool
+	// Reason explains Due, e.g. the age of the current credential.
+	Reason string
+	// Keys are the keys of the credential map Create would return.
+	Keys []string
+}
+
+// Forcer is implemented by sources that skip rotation of credentials
+// that are not due yet. After Force, the next Create produces a new
+// credential regardless of age.
+type Forcer interface {
+	Force()
+}
+
+// Ager is implemented by sources that track the age of their
+// credentials themselves. SetMaxAge passes them the max_age of the
+// secret before the source config is validated.
+type Ager interface {
+	SetMaxAge(maxAge time.Duration)
+}
+
+// Inspector is implemented by sources that can only tell whether they
+// are due from the credential in use, e.g. from the expiry of a
+// certificate. Before Plan and Create, Inspect is passed the values of
+// InspectKeys currently held by the sinks of the secret that can read
+// them back. Keys no sink holds are left out.
+type Inspector interface {
+	InspectKeys() []string
+	Inspect(current map[string]string)
+}
+
+// Versioned is implemented by sources that need to know which credential
+// the sinks hold, e.g. to only hand out a new version of a secret kept in
+// Vault, or to renew the lease of the credential a new one replaces.
+// With a state store, SetDistributed is passed the CredentialID recorded
+// by the last rotation before Plan and Create, unless the sinks changed
+// since.
+type Versioned interface {
+	Identifier
+	SetDistributed(credentialID string)
+}
+
+// Reactivator is implemented by sources that retire credentials by
+// deactivating them before deleting them. Reactivate reactivates the
+// retired credential named by credentialID, or the only one if it is
+// empty, e.g. in an emergency, and returns its ID.
+type Reactivator interface {
+	Reactivate(ctx context.Context, credentialID string) (string, error)
+}
+
+// Discoverer is implemented by sources that can stand for many
+// credentials found when a rotation runs, e.g. the access keys of every
+// IAM user with a tag. Discovers reports whether the source is configured
+// to. Discover returns a source for each credential found, which is
+// rotated as a secret of its own.
+type Discoverer interface {
+	Discovers() bool
+	Discover(ctx context.Context) ([]Discovered, error)
+}
+
+// Discovered is a credential found by a Discoverer.
+type Discovered struct {
+	// Name tells the credential apart from the others found, e.g. the
+	// name of an IAM user. It is appended to the name of the secret.
+	Name   string
+	Source Source
+	// Vars are the data the names in the key_to_name of the sinks are
+	// rendered with as text/template templates.
+	Vars interface{}
+}
+
 type Kind string
 
 type Error string
@@ -26,6 +131,19 @@ const (
 	KindDummy Kind = "dummy"
 	KindAws   Kind = "aws"
 	KindEnv   Kind = "env"
+
+	KindGenerated        Kind = "generated"
+	KindPostgres         Kind = "postgres"
+	KindMySQL            Kind = "mysql"
+	KindSSHKeypair       Kind = "ssh_keypair"
+	KindTLSCert          Kind = "tls_cert"
+	KindACME             Kind = "acme"
+	KindVaultKV          Kind = "vault_kv"
+	KindVaultDynamic     Kind = "vault_dynamic"
+	KindFile             Kind = "file"
+	KindExec             Kind = "exec"
+	KindKubernetesSecret Kind = "kubernetes_secret"
+	KindAwsSts           Kind = "aws_sts"
 )
 const (
 	ErrUnknownKind Error = "unknown source"
@@ -0,0 +1,232 @@
+package source
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// Keys for the map returned by Read()
+	SSHPrivateKey  = "private_key"
+	SSHPublicKey   = "public_key"
+	SSHFingerprint = "fingerprint"
+)
+
+// Types of SSH keys.
+const (
+	SSHKeyTypeEd25519 = "ed25519"
+	SSHKeyTypeRSA     = "rsa"
+)
+
+const (
+	// DefaultSSHRSABits is the size of RSA keys that do not set one.
+	DefaultSSHRSABits = 4096
+	// minSSHRSABits and maxSSHRSABits bound the size of RSA keys.
+	minSSHRSABits = 2048
+	maxSSHRSABits = 16384
+)
+
+// SSHKeypairSource is a source that generates SSH keypairs, e.g. for
+// deploy keys. It returns the private key in the OpenSSH format, the
+// public key in the authorized_keys format and the SHA256 fingerprint of
+// the public key.
+type SSHKeypairSource struct {
+	// KeyType is the type of keys: ed25519, the default, or rsa.
+	KeyType string `yaml:"type,omitempty"`
+	// Bits is the size of RSA keys. Zero means DefaultSSHRSABits.
+	Bits int `yaml:"bits,omitempty"`
+	// Comment is appended to the public key and stored in the private
+	// key, e.g. to tell where a key is used.
+	Comment string `yaml:"comment,omitempty"`
+}
+
+func init() {
+	Register(KindSSHKeypair, func() Source { return NewSSHKeypairSource() })
+}
+
+func NewSSHKeypairSource() *SSHKeypairSource {
+	return &SSHKeypairSource{}
+}
+
+func (src *SSHKeypairSource) WithKeyType(keyType string) *SSHKeypairSource {
+	src.KeyType = keyType
+	return src
+}
+
+func (src *SSHKeypairSource) WithComment(comment string) *SSHKeypairSource {
+	src.Comment = comment
+	return src
+}
+
+func (src *SSHKeypairSource) Kind() Kind {
+	return KindSSHKeypair
+}
+
+// Validate checks the type of keys and, for RSA keys, their size.
+func (src *SSHKeypairSource) Validate() error {
+	var errs *multierror.Error
+	switch src.KeyType {
+	case "", SSHKeyTypeEd25519:
+		if src.Bits != 0 {
+			errs = multierror.Append(errs, errors.New("bits is only used with rsa keys"))
+		}
+	case SSHKeyTypeRSA:
+		if src.Bits != 0 && (src.Bits < minSSHRSABits || src.Bits > maxSSHRSABits) {
+			errs = multierror.Append(errs, errors.Errorf("bits must be between %d and %d", minSSHRSABits, maxSSHRSABits))
+		}
+	default:
+		errs = multierror.Append(errs, errors.Errorf("unknown key type %q", src.KeyType))
+	}
+	if strings.ContainsAny(src.Comment, "\r\n") {
+		errs = multierror.Append(errs, errors.New("comment must be a single line"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Read returns a new keypair.
+func (src *SSHKeypairSource) Read(ctx context.Context) (map[string]string, error) {
+	var private interface{}
+	var public interface{}
+	switch src.KeyType {
+	case "", SSHKeyTypeEd25519:
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to generate ed25519 key")
+		}
+		private, public = priv, pub
+	case SSHKeyTypeRSA:
+		bits := src.Bits
+		if bits == 0 {
+			bits = DefaultSSHRSABits
+		}
+		priv, err := rsa.GenerateKey(rand.Reader, bits)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to generate rsa key")
+		}
+		private, public = priv, &priv.PublicKey
+	default:
+		return nil, errors.Errorf("unknown key type %q", src.KeyType)
+	}
+
+	sshPublic, err := ssh.NewPublicKey(public)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode public key")
+	}
+	privatePEM, err := marshalOpenSSHPrivateKey(private, src.Comment)
+	if err != nil {
+		return nil, err
+	}
+	authorizedKey := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(sshPublic)), "\n")
+	if src.Comment != "" {
+		authorizedKey += " " + src.Comment
+	}
+	return map[string]string{
+		SSHPrivateKey:  string(privatePEM),
+		SSHPublicKey:   authorizedKey,
+		SSHFingerprint: ssh.FingerprintSHA256(sshPublic),
+	}, nil
+}
+
+// Create returns a new keypair. SSHKeypairSource keeps no state, so
+// there is nothing to stage.
+func (src *SSHKeypairSource) Create(ctx context.Context) (map[string]string, error) {
+	return src.Read(ctx)
+}
+
+// Plan reports that SSHKeypairSource always produces a new keypair.
+func (src *SSHKeypairSource) Plan(ctx context.Context) (Plan, error) {
+	return Plan{
+		Due:    true,
+		Reason: "ssh_keypair source always rotates",
+		Keys:   []string{SSHFingerprint, SSHPrivateKey, SSHPublicKey},
+	}, nil
+}
+
+// Activate is a no-op for SSHKeypairSource.
+func (src *SSHKeypairSource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for SSHKeypairSource.
+func (src *SSHKeypairSource) Revoke(ctx context.Context) error {
+	return nil
+}
+
+// CredentialID returns the fingerprint of creds.
+func (src *SSHKeypairSource) CredentialID(creds map[string]string) string {
+	return creds[SSHFingerprint]
+}
+
+// marshalOpenSSHPrivateKey encodes an unencrypted ed25519 or RSA key in
+// the OpenSSH format, as written by ssh-keygen. See PROTOCOL.key in the
+// OpenSSH sources.
+func marshalOpenSSHPrivateKey(key interface{}, comment string) ([]byte, error) {
+	var public ssh.PublicKey
+	var keyType string
+	var fields interface{}
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+		pub := k.Public().(ed25519.PublicKey)
+		keyType = ssh.KeyAlgoED25519
+		fields = struct {
+			Pub  []byte
+			Priv []byte
+		}{pub, k}
+		public, _ = ssh.NewPublicKey(pub)
+	case *rsa.PrivateKey:
+		keyType = ssh.KeyAlgoRSA
+		fields = struct {
+			N    *big.Int
+			E    *big.Int
+			D    *big.Int
+			Iqmp *big.Int
+			P    *big.Int
+			Q    *big.Int
+		}{k.N, big.NewInt(int64(k.E)), k.D, k.Precomputed.Qinv, k.Primes[0], k.Primes[1]}
+		public, _ = ssh.NewPublicKey(&k.PublicKey)
+	default:
+		return nil, errors.Errorf("unsupported key type %T", key)
+	}
+
+	// the check ints let ssh-keygen tell a wrong passphrase apart from a
+	// corrupt key, they only need to match
+	var check [4]byte
+	_, err := rand.Read(check[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate check bytes")
+	}
+	checkInt := binary.BigEndian.Uint32(check[:])
+	private := ssh.Marshal(struct {
+		Check1  uint32
+		Check2  uint32
+		KeyType string
+		Rest    []byte `ssh:"rest"`
+	}{checkInt, checkInt, keyType, ssh.Marshal(fields)})
+	private = append(private, ssh.Marshal(struct{ Comment string }{comment})...)
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	envelope := ssh.Marshal(struct {
+		CipherName   string
+		KdfName      string
+		KdfOpts      string
+		NumKeys      uint32
+		PubKey       []byte
+		PrivKeyBlock []byte
+	}{"none", "none", "", 1, public.Marshal(), private})
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "OPENSSH PRIVATE KEY",
+		Bytes: append([]byte("openssh-key-v1\x00"), envelope...),
+	}), nil
+}
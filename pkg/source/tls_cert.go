@@ -0,0 +1,575 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	// Keys for the map returned by Read()
+	TLSCertPEM        = "cert_pem"
+	TLSKeyPEM         = "key_pem"
+	TLSChainPEM       = "chain_pem"
+	TLSPKCS12         = "pkcs12"
+	TLSPKCS12Password = "pkcs12_password"
+
+	// Keys of the CA read from a sink.
+	TLSCACert = "cert"
+	TLSCAKey  = "key"
+)
+
+// Algorithms of the keys of issued certificates.
+const (
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	KeyAlgorithmECDSAP384 = "ecdsa-p384"
+	KeyAlgorithmEd25519   = "ed25519"
+	KeyAlgorithmRSA2048   = "rsa-2048"
+	KeyAlgorithmRSA3072   = "rsa-3072"
+	KeyAlgorithmRSA4096   = "rsa-4096"
+)
+
+// Extended key usages of issued certificates.
+const (
+	ExtKeyUsageServer = "server"
+	ExtKeyUsageClient = "client"
+)
+
+const (
+	// DefaultTLSValidity is the validity of issued certificates if
+	// validity is not set.
+	DefaultTLSValidity = 90 * 24 * time.Hour
+	// tlsBackdate is how long before their issuance certificates become
+	// valid, to allow for clock skew between hosts.
+	tlsBackdate = 5 * time.Minute
+)
+
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	ExtKeyUsageServer: x509.ExtKeyUsageServerAuth,
+	ExtKeyUsageClient: x509.ExtKeyUsageClientAuth,
+}
+
+// TLSCertSource is a source that issues TLS certificates signed by a CA
+// that rotator holds, e.g. for mTLS between internal services.
+//
+// It only issues a new certificate once the one in use expires within
+// RenewBefore. The certificate in use is read back from the sinks of the
+// secret that can read values, see Inspector. If none can, a new
+// certificate is issued on every rotation.
+type TLSCertSource struct {
+	// CA is where the certificate and key of the CA are read from.
+	CA TLSCA `yaml:"ca"`
+	// CommonName, DNSNames, IPAddresses and URIs are the subject and
+	// the subject alternative names of issued certificates.
+	CommonName  string   `yaml:"common_name,omitempty"`
+	DNSNames    []string `yaml:"dns_names,omitempty"`
+	IPAddresses []string `yaml:"ip_addresses,omitempty"`
+	URIs        []string `yaml:"uris,omitempty"`
+	// KeyAlgorithm is the algorithm of the keys of issued certificates.
+	// Defaults to ecdsa-p256.
+	KeyAlgorithm string `yaml:"key_algorithm,omitempty"`
+	// ExtKeyUsage lists what issued certificates are used for: server,
+	// client or both, the default.
+	ExtKeyUsage []string `yaml:"ext_key_usage,omitempty"`
+	// Validity is how long issued certificates are valid. Zero means
+	// DefaultTLSValidity. Certificates never outlive the CA.
+	Validity time.Duration `yaml:"validity,omitempty"`
+	// RenewBefore is how long before the certificate in use expires a
+	// new one is issued. Zero means a third of Validity.
+	RenewBefore time.Duration `yaml:"renew_before,omitempty"`
+
+	// current is the certificate in use, as passed to Inspect.
+	current *x509.Certificate
+	// force makes the next rotation ignore the expiry of current.
+	force bool
+}
+
+// TLSCA is where TLSCertSource reads the certificate and key of its CA
+// from: either PEM files, or a sink whose key_to_name maps cert and key
+// to the names they are stored under. Besides the CA certificate, the
+// certificate may hold the intermediate certificates up to a root, which
+// are added to the chain of issued certificates.
+type TLSCA struct {
+	CertFile string         `yaml:"cert_file,omitempty"`
+	KeyFile  string         `yaml:"key_file,omitempty"`
+	Sink     *sink.Location `yaml:"sink,omitempty"`
+}
+
+func init() {
+	Register(KindTLSCert, func() Source { return NewTLSCertSource() })
+}
+
+func NewTLSCertSource() *TLSCertSource {
+	return &TLSCertSource{}
+}
+
+func (src *TLSCertSource) WithCAFiles(certFile string, keyFile string) *TLSCertSource {
+	src.CA = TLSCA{CertFile: certFile, KeyFile: keyFile}
+	return src
+}
+
+func (src *TLSCertSource) WithCASink(s sink.Sink) *TLSCertSource {
+	src.CA = TLSCA{Sink: &sink.Location{Sink: s}}
+	return src
+}
+
+func (src *TLSCertSource) WithDNSNames(names ...string) *TLSCertSource {
+	src.DNSNames = names
+	return src
+}
+
+func (src *TLSCertSource) Kind() Kind {
+	return KindTLSCert
+}
+
+// Validate checks where the CA is read from, that issued certificates
+// have a name, and their key algorithm, usage and validity.
+func (src *TLSCertSource) Validate() error {
+	var errs *multierror.Error
+	switch {
+	case src.CA.Sink != nil && (src.CA.CertFile != "" || src.CA.KeyFile != ""):
+		errs = multierror.Append(errs, errors.New("ca files and ca sink are mutually exclusive"))
+	case src.CA.Sink != nil:
+		err := src.CA.Sink.Validate(TLSCACert, TLSCAKey)
+		if err != nil {
+			errs = multierror.Append(errs, errors.Wrap(err, "ca"))
+		}
+	case src.CA.CertFile == "" || src.CA.KeyFile == "":
+		errs = multierror.Append(errs, errors.New("missing ca cert_file and key_file, or ca sink"))
+	}
+
+	if src.CommonName == "" && len(src.DNSNames) == 0 && len(src.IPAddresses) == 0 && len(src.URIs) == 0 {
+		errs = multierror.Append(errs, errors.New("missing common_name, dns_names, ip_addresses or uris"))
+	}
+	for _, ip := range src.IPAddresses {
+		if net.ParseIP(ip) == nil {
+			errs = multierror.Append(errs, errors.Errorf("invalid ip address %q", ip))
+		}
+	}
+	for _, uri := range src.URIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" {
+			errs = multierror.Append(errs, errors.Errorf("invalid uri %q", uri))
+		}
+	}
+	if !validKeyAlgorithm(src.KeyAlgorithm) {
+		errs = multierror.Append(errs, errors.Errorf("unknown key_algorithm %q", src.KeyAlgorithm))
+	}
+	for _, usage := range src.ExtKeyUsage {
+		if _, ok := extKeyUsages[usage]; !ok {
+			errs = multierror.Append(errs, errors.Errorf("unknown ext_key_usage %q", usage))
+		}
+	}
+	if src.Validity < 0 {
+		errs = multierror.Append(errs, errors.New("validity must not be negative"))
+	}
+	if src.RenewBefore < 0 || src.RenewBefore >= src.validity() {
+		errs = multierror.Append(errs, errors.New("renew_before must be shorter than validity"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up the CA sink, if any.
+func (src *TLSCertSource) Init() error {
+	if src.CA.Sink == nil {
+		return nil
+	}
+	return errors.Wrap(src.CA.Sink.Init(), "ca")
+}
+
+func (src *TLSCertSource) validity() time.Duration {
+	if src.Validity == 0 {
+		return DefaultTLSValidity
+	}
+	return src.Validity
+}
+
+func (src *TLSCertSource) renewBefore() time.Duration {
+	if src.RenewBefore == 0 {
+		return src.validity() / 3
+	}
+	return src.RenewBefore
+}
+
+// InspectKeys returns the key of the certificate in use.
+func (src *TLSCertSource) InspectKeys() []string {
+	return []string{TLSCertPEM}
+}
+
+// Inspect keeps the certificate in use, if current holds one that can be
+// parsed.
+func (src *TLSCertSource) Inspect(current map[string]string) {
+	src.current = parseCertificatePEM(current[TLSCertPEM])
+}
+
+// parseCertificatePEM returns the certificate in the first PEM block of
+// s, or nil if there is none.
+func parseCertificatePEM(s string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil
+	}
+	return cert
+}
+
+// due reports whether a new certificate should be issued, and why.
+func (src *TLSCertSource) due(now time.Time) (bool, string) {
+	if src.force {
+		return true, "forced"
+	}
+	if src.current == nil {
+		return true, "no certificate in use found in sinks"
+	}
+	if !src.matches(src.current) {
+		return true, "names of certificate in use differ from config"
+	}
+	remaining := src.current.NotAfter.Sub(now)
+	reason := fmt.Sprintf("certificate expires in %s, renew_before is %s", remaining.Round(time.Second), src.renewBefore())
+	return remaining < src.renewBefore(), reason
+}
+
+// matches reports whether cert has the names of certificates issued by src.
+func (src *TLSCertSource) matches(cert *x509.Certificate) bool {
+	var ips, uris []string
+	for _, ip := range cert.IPAddresses {
+		ips = append(ips, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		uris = append(uris, uri.String())
+	}
+	var want []string
+	for _, ip := range src.IPAddresses {
+		want = append(want, net.ParseIP(ip).String())
+	}
+	return cert.Subject.CommonName == src.CommonName &&
+		sameStrings(cert.DNSNames, src.DNSNames) &&
+		sameStrings(ips, want) &&
+		sameStrings(uris, src.URIs)
+}
+
+// sameStrings reports whether a and b hold the same strings, in any order.
+func sameStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string{}, a...)
+	b = append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Plan reports whether Create would issue a new certificate.
+func (src *TLSCertSource) Plan(ctx context.Context) (Plan, error) {
+	due, reason := src.due(time.Now())
+	return Plan{
+		Due:    due,
+		Reason: reason,
+		Keys:   []string{TLSChainPEM, TLSCertPEM, TLSKeyPEM, TLSPKCS12, TLSPKCS12Password},
+	}, nil
+}
+
+// Force makes the next rotation issue a new certificate even if the one
+// in use does not expire soon.
+func (src *TLSCertSource) Force() {
+	src.force = true
+}
+
+func (src *TLSCertSource) Read(ctx context.Context) (map[string]string, error) {
+	return src.Create(ctx)
+}
+
+// Create issues a new certificate, or returns nil if the certificate in
+// use does not expire within RenewBefore.
+func (src *TLSCertSource) Create(ctx context.Context) (map[string]string, error) {
+	now := time.Now()
+	if due, _ := src.due(now); !due {
+		return nil, nil
+	}
+	chain, caKey, err := src.loadCA(ctx)
+	if err != nil {
+		return nil, err
+	}
+	key, err := generateTLSKey(src.KeyAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	template, err := src.template(now, chain[0])
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, chain[0], key.Public(), caKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to sign certificate")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse issued certificate")
+	}
+	return tlsCredentials(key, cert, chain)
+}
+
+// tlsCredentials returns the outputs of a certificate with its key and
+// the chain of its issuer.
+func tlsCredentials(key crypto.Signer, cert *x509.Certificate, chain []*x509.Certificate) (map[string]string, error) {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode private key")
+	}
+
+	password, err := GeneratedOutput{Key: TLSPKCS12Password}.generate()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate pkcs12 password")
+	}
+	p12, err := pkcs12.Encode(rand.Reader, key, cert, chain, password)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to encode pkcs12")
+	}
+
+	var chainPEM bytes.Buffer
+	for _, c := range chain {
+		_ = pem.Encode(&chainPEM, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return map[string]string{
+		TLSCertPEM:        string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		TLSKeyPEM:         string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})),
+		TLSChainPEM:       chainPEM.String(),
+		TLSPKCS12:         base64.StdEncoding.EncodeToString(p12),
+		TLSPKCS12Password: password,
+	}, nil
+}
+
+// template returns the template of a certificate issued at now by ca.
+func (src *TLSCertSource) template(now time.Time, ca *x509.Certificate) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to generate serial number")
+	}
+	notAfter := now.Add(src.validity())
+	if notAfter.After(ca.NotAfter) {
+		notAfter = ca.NotAfter
+	}
+	if !notAfter.After(now) {
+		return nil, errors.Errorf("ca expired on %s", ca.NotAfter.Format(time.RFC3339))
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: src.CommonName},
+		DNSNames:     src.DNSNames,
+		NotBefore:    now.Add(-tlsBackdate),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	usages := src.ExtKeyUsage
+	if len(usages) == 0 {
+		usages = []string{ExtKeyUsageServer, ExtKeyUsageClient}
+	}
+	for _, usage := range usages {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, extKeyUsages[usage])
+	}
+	if strings.HasPrefix(src.KeyAlgorithm, "rsa-") {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	for _, ip := range src.IPAddresses {
+		template.IPAddresses = append(template.IPAddresses, net.ParseIP(ip))
+	}
+	for _, uri := range src.URIs {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid uri %q", uri)
+		}
+		template.URIs = append(template.URIs, u)
+	}
+	return template, nil
+}
+
+// validKeyAlgorithm reports whether algorithm is empty or one of the
+// KeyAlgorithm constants.
+func validKeyAlgorithm(algorithm string) bool {
+	switch algorithm {
+	case "", KeyAlgorithmECDSAP256, KeyAlgorithmECDSAP384, KeyAlgorithmEd25519,
+		KeyAlgorithmRSA2048, KeyAlgorithmRSA3072, KeyAlgorithmRSA4096:
+		return true
+	}
+	return false
+}
+
+// generateTLSKey generates a certificate key with algorithm, ecdsa-p256
+// if empty.
+func generateTLSKey(algorithm string) (crypto.Signer, error) {
+	var key crypto.Signer
+	var err error
+	switch algorithm {
+	case "", KeyAlgorithmECDSAP256:
+		key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyAlgorithmECDSAP384:
+		key, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case KeyAlgorithmEd25519:
+		_, key, err = ed25519.GenerateKey(rand.Reader)
+	case KeyAlgorithmRSA2048:
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+	case KeyAlgorithmRSA3072:
+		key, err = rsa.GenerateKey(rand.Reader, 3072)
+	case KeyAlgorithmRSA4096:
+		key, err = rsa.GenerateKey(rand.Reader, 4096)
+	default:
+		return nil, errors.Errorf("unknown key_algorithm %q", algorithm)
+	}
+	return key, errors.Wrap(err, "unable to generate key")
+}
+
+// loadCA returns the certificate chain of the CA, starting with the CA
+// certificate, and its key.
+func (src *TLSCertSource) loadCA(ctx context.Context) ([]*x509.Certificate, crypto.Signer, error) {
+	var certPEM, keyPEM []byte
+	if src.CA.Sink != nil {
+		cert, err := src.CA.Sink.Read(ctx, TLSCACert)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read ca certificate")
+		}
+		key, err := src.CA.Sink.Read(ctx, TLSCAKey)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read ca key")
+		}
+		certPEM, keyPEM = []byte(cert), []byte(key)
+	} else {
+		var err error
+		certPEM, err = ioutil.ReadFile(src.CA.CertFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read ca certificate")
+		}
+		keyPEM, err = ioutil.ReadFile(src.CA.KeyFile)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to read ca key")
+		}
+	}
+
+	var chain []*x509.Certificate
+	for block, rest := pem.Decode(certPEM); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "unable to parse ca certificate")
+		}
+		chain = append(chain, cert)
+	}
+	if len(chain) == 0 {
+		return nil, nil, errors.New("no certificate found in ca certificate")
+	}
+	if !chain[0].IsCA {
+		return nil, nil, errors.New("ca certificate is not a CA")
+	}
+	key, err := parsePrivateKey(keyPEM)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "unable to parse ca key")
+	}
+	if !samePublicKey(chain[0].PublicKey, key.Public()) {
+		return nil, nil, errors.New("ca key does not match ca certificate")
+	}
+	return chain, key, nil
+}
+
+// parsePrivateKey parses the first PEM block of b as a PKCS #8, PKCS #1
+// or SEC 1 private key.
+func parsePrivateKey(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// samePublicKey reports whether two public keys are equal.
+func samePublicKey(a crypto.PublicKey, b crypto.PublicKey) bool {
+	ad, err := x509.MarshalPKIXPublicKey(a)
+	if err != nil {
+		return false
+	}
+	bd, err := x509.MarshalPKIXPublicKey(b)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(ad, bd)
+}
+
+// Activate is a no-op for TLSCertSource, the certificate in use stays
+// valid until it expires.
+func (src *TLSCertSource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for TLSCertSource. The CA publishes no revocation
+// list, so the discarded certificate stays valid until it expires.
+func (src *TLSCertSource) Revoke(ctx context.Context) error {
+	return nil
+}
+
+// CredentialID returns the SHA-256 fingerprint of the certificate in
+// creds.
+func (src *TLSCertSource) CredentialID(creds map[string]string) string {
+	return certificateID(creds)
+}
+
+// certificateID returns the SHA-256 fingerprint of the certificate in
+// creds.
+func certificateID(creds map[string]string) string {
+	block, _ := pem.Decode([]byte(creds[TLSCertPEM]))
+	if block == nil {
+		return ""
+	}
+	sum := sha256.Sum256(block.Bytes)
+	return fmt.Sprintf("%x", sum)
+}
@@ -0,0 +1,255 @@
+package source_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/cmd"
+	"github.com/chanzuckerberg/rotator/pkg/config"
+	"github.com/chanzuckerberg/rotator/pkg/sink"
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// newTestCA returns the PEM encoded certificate and key of a new CA that
+// expires after validity.
+func newTestCA(r *require.Assertions, validity time.Duration) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	r.NoError(err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "rotator test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validity),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	r.NoError(err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	r.NoError(err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}))
+}
+
+func parseCert(r *require.Assertions, certPEM string) *x509.Certificate {
+	block, _ := pem.Decode([]byte(certPEM))
+	r.NotNil(block)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	r.NoError(err)
+	return cert
+}
+
+func TestTLSCertSourceFromFiles(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	caCert, caKey := newTestCA(r, 365*24*time.Hour)
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "ca.pem"), []byte(caCert), 0600))
+	r.NoError(ioutil.WriteFile(filepath.Join(dir, "ca-key.pem"), []byte(caKey), 0600))
+
+	src := source.NewTLSCertSource().
+		WithCAFiles(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "ca-key.pem")).
+		WithDNSNames("svc.example.internal", "svc")
+	src.IPAddresses = []string{"10.0.0.1"}
+	src.URIs = []string{"spiffe://example/svc"}
+	src.Validity = 30 * 24 * time.Hour
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+
+	creds, err := src.Read(ctx)
+	r.NoError(err)
+	r.Len(creds, 5)
+	cert := parseCert(r, creds[source.TLSCertPEM])
+	r.ElementsMatch([]string{"svc.example.internal", "svc"}, cert.DNSNames)
+	r.Equal("10.0.0.1", cert.IPAddresses[0].String())
+	r.Equal("spiffe://example/svc", cert.URIs[0].String())
+	r.WithinDuration(time.Now().Add(30*24*time.Hour), cert.NotAfter, time.Minute)
+	r.Equal(caCert, creds[source.TLSChainPEM])
+	r.NotEmpty(src.CredentialID(creds))
+
+	// the certificate is signed by the CA and usable for mTLS
+	roots := x509.NewCertPool()
+	r.True(roots.AppendCertsFromPEM([]byte(caCert)))
+	for _, usage := range []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth} {
+		_, err = cert.Verify(x509.VerifyOptions{DNSName: "svc", Roots: roots, KeyUsages: []x509.ExtKeyUsage{usage}})
+		r.NoError(err)
+	}
+
+	// the key matches the certificate, in both encodings
+	block, _ := pem.Decode([]byte(creds[source.TLSKeyPEM]))
+	r.Equal("PRIVATE KEY", block.Type)
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	r.NoError(err)
+	r.Equal(&key.(*ecdsa.PrivateKey).PublicKey, cert.PublicKey)
+	p12, err := base64.StdEncoding.DecodeString(creds[source.TLSPKCS12])
+	r.NoError(err)
+	p12Key, p12Cert, p12CAs, err := pkcs12.DecodeChain(p12, creds[source.TLSPKCS12Password])
+	r.NoError(err)
+	r.Equal(key, p12Key)
+	r.Equal(cert.Raw, p12Cert.Raw)
+	r.Len(p12CAs, 1)
+}
+
+func TestTLSCertSourceRenewal(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	caCert, caKey := newTestCA(r, 365*24*time.Hour)
+	ca := sink.NewBufSink().WithKeyToName(map[string]string{source.TLSCACert: "ca.crt", source.TLSCAKey: "ca.key"})
+	r.NoError(ca.Write(ctx, "ca.crt", caCert))
+	r.NoError(ca.Write(ctx, "ca.key", caKey))
+
+	src := source.NewTLSCertSource().WithCASink(ca)
+	src.CommonName = "svc"
+	src.KeyAlgorithm = source.KeyAlgorithmRSA2048
+	src.ExtKeyUsage = []string{source.ExtKeyUsageServer}
+	src.Validity = 24 * time.Hour
+	src.RenewBefore = 8 * time.Hour
+	r.NoError(src.Validate())
+	r.Equal([]string{source.TLSCertPEM}, src.InspectKeys())
+
+	// nothing in use yet
+	src.Inspect(map[string]string{})
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	cert := parseCert(r, creds[source.TLSCertPEM])
+	r.Equal("svc", cert.Subject.CommonName)
+	r.Equal([]x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}, cert.ExtKeyUsage)
+	r.Equal(2048, cert.PublicKey.(interface{ Size() int }).Size()*8)
+
+	// a fresh certificate is not renewed
+	src.Inspect(creds)
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due, plan.Reason)
+	r.Contains(plan.Reason, "renew_before is 8h0m0s")
+	again, err := src.Create(ctx)
+	r.NoError(err)
+	r.Nil(again)
+
+	// unless its names changed, or forced
+	src.CommonName = "other"
+	again, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(again)
+	src.CommonName = "svc"
+	src.Force()
+	again, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(again)
+}
+
+func TestTLSCertSourceCappedByCA(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	caCert, caKey := newTestCA(r, 48*time.Hour)
+	ca := sink.NewBufSink().WithKeyToName(map[string]string{source.TLSCACert: "ca.crt", source.TLSCAKey: "ca.key"})
+	r.NoError(ca.Write(ctx, "ca.crt", caCert))
+	r.NoError(ca.Write(ctx, "ca.key", caKey))
+
+	src := source.NewTLSCertSource().WithCASink(ca).WithDNSNames("svc")
+	src.KeyAlgorithm = source.KeyAlgorithmEd25519
+	creds, err := src.Read(ctx)
+	r.NoError(err)
+	r.Equal(parseCert(r, caCert).NotAfter, parseCert(r, creds[source.TLSCertPEM]).NotAfter)
+
+	// the certificate in use expires within renew_before, a third of 90 days
+	src.Inspect(creds)
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+
+	// a key that does not match the CA is rejected
+	_, otherKey := newTestCA(r, time.Hour)
+	r.NoError(ca.Write(ctx, "ca.key", otherKey))
+	_, err = src.Create(ctx)
+	r.Error(err)
+}
+
+func TestTLSCertSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	files := source.TLSCA{CertFile: "ca.pem", KeyFile: "ca-key.pem"}
+	stdout := &sink.Location{Sink: sink.NewStdoutSink()}
+	buf := &sink.Location{Sink: sink.NewBufSink().WithKeyToName(map[string]string{source.TLSCACert: "ca.crt"})}
+	tests := map[string]*source.TLSCertSource{
+		"missing ca":            {DNSNames: []string{"a"}},
+		"missing ca key":        {CA: source.TLSCA{CertFile: "ca.pem"}, DNSNames: []string{"a"}},
+		"files and sink":        {CA: source.TLSCA{CertFile: "ca.pem", KeyFile: "k", Sink: buf}, DNSNames: []string{"a"}},
+		"sink cannot read":      {CA: source.TLSCA{Sink: stdout}, DNSNames: []string{"a"}},
+		"sink missing key name": {CA: source.TLSCA{Sink: buf}, DNSNames: []string{"a"}},
+		"missing names":         {CA: files},
+		"invalid ip":            {CA: files, IPAddresses: []string{"10.0.0"}},
+		"invalid uri":           {CA: files, URIs: []string{"svc"}},
+		"unknown algorithm":     {CA: files, DNSNames: []string{"a"}, KeyAlgorithm: "dsa"},
+		"unknown usage":         {CA: files, DNSNames: []string{"a"}, ExtKeyUsage: []string{"email"}},
+		"renew after expiry":    {CA: files, DNSNames: []string{"a"}, Validity: time.Hour, RenewBefore: 2 * time.Hour},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+}
+
+// TestTLSCertSourceREADMEExample rotates the example of the README, with
+// Buffer sinks in place of AWSSecretsManager.
+func TestTLSCertSourceREADMEExample(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	conf, err := config.Load([]byte(`
+version: 2
+secrets:
+  - name: billing-mtls
+    schedule: 24h
+    source:
+      kind: tls_cert
+      ca:
+        sink:
+          kind: Buffer
+          key_to_name:
+            cert: internal-ca/cert
+            key: internal-ca/key
+      dns_names: [billing.internal.example.com]
+      validity: 720h
+    sinks:
+      - kind: Buffer
+        key_to_name:
+          cert_pem: billing/tls-cert
+          key_pem: billing/tls-key
+          chain_pem: billing/tls-chain
+`))
+	r.NoError(err)
+	caCert, caKey := newTestCA(r, 365*24*time.Hour)
+	ca := conf.Secrets[0].Source.(*source.TLSCertSource).CA.Sink
+	r.NoError(ca.Write(ctx, "internal-ca/cert", caCert))
+	r.NoError(ca.Write(ctx, "internal-ca/key", caKey))
+	r.NoError(conf.Init())
+
+	r.NoError(cmd.RotateSecrets(ctx, conf))
+	buf := conf.Secrets[0].Sinks[0]
+	certPEM, err := buf.(sink.Restorer).Current(ctx, "billing/tls-cert")
+	r.NoError(err)
+	r.Equal([]string{"billing.internal.example.com"}, parseCert(r, certPEM).DNSNames)
+	chain, err := buf.(sink.Restorer).Current(ctx, "billing/tls-chain")
+	r.NoError(err)
+	r.Equal(caCert, chain)
+}
@@ -0,0 +1,245 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// Vault auth methods.
+const (
+	VaultAuthToken      = "token"
+	VaultAuthAppRole    = "approle"
+	VaultAuthKubernetes = "kubernetes"
+)
+
+const (
+	DefaultVaultTokenEnv    = "VAULT_TOKEN"
+	DefaultVaultSecretIDEnv = "VAULT_SECRET_ID"
+	DefaultVaultJWTFile     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+// VaultConfig is how Vault sources connect and authenticate to Vault.
+// The environment variables of the Vault CLI, such as VAULT_ADDR and
+// VAULT_CACERT, are honored.
+type VaultConfig struct {
+	// Address of the Vault server. Defaults to VAULT_ADDR.
+	Address   string    `yaml:"address,omitempty"`
+	Namespace string    `yaml:"namespace,omitempty"`
+	Auth      VaultAuth `yaml:"auth,omitempty"`
+
+	Client *vault.Client `yaml:"-"`
+}
+
+// VaultAuth is how Vault sources log in to Vault.
+type VaultAuth struct {
+	// Method is token (default), approle or kubernetes.
+	Method string `yaml:"method,omitempty"`
+	// Mount is the path the auth method is enabled at. Defaults to the
+	// name of the method.
+	Mount string `yaml:"mount,omitempty"`
+	// TokenEnv is the environment variable holding the token of the
+	// token method. Defaults to VAULT_TOKEN.
+	TokenEnv string `yaml:"token_env,omitempty"`
+	// RoleID and SecretIDEnv, the environment variable holding the
+	// secret ID, log in with the approle method. SecretIDEnv defaults
+	// to VAULT_SECRET_ID.
+	RoleID      string `yaml:"role_id,omitempty"`
+	SecretIDEnv string `yaml:"secret_id_env,omitempty"`
+	// Role and JWTFile, the service account token, log in with the
+	// kubernetes method. JWTFile defaults to the token mounted in pods.
+	Role    string `yaml:"role,omitempty"`
+	JWTFile string `yaml:"jwt_file,omitempty"`
+}
+
+func (c *VaultConfig) validate() error {
+	var errs *multierror.Error
+	switch c.Auth.Method {
+	case "", VaultAuthToken:
+	case VaultAuthAppRole:
+		if c.Auth.RoleID == "" {
+			errs = multierror.Append(errs, errors.New("missing auth role_id"))
+		}
+	case VaultAuthKubernetes:
+		if c.Auth.Role == "" {
+			errs = multierror.Append(errs, errors.New("missing auth role"))
+		}
+	default:
+		errs = multierror.Append(errs, errors.Errorf("unknown auth method %q", c.Auth.Method))
+	}
+	return errs.ErrorOrNil()
+}
+
+// init sets up a client of Address, unless one is already set.
+func (c *VaultConfig) init() error {
+	if c.Client != nil {
+		return nil
+	}
+	conf := vault.DefaultConfig()
+	if conf.Error != nil {
+		return errors.Wrap(conf.Error, "unable to configure vault client")
+	}
+	if c.Address != "" {
+		conf.Address = c.Address
+	}
+	client, err := vault.NewClient(conf)
+	if err != nil {
+		return errors.Wrap(err, "unable to set up vault client")
+	}
+	client.ClearToken()
+	if c.Namespace != "" {
+		client.SetNamespace(c.Namespace)
+	}
+	c.Client = client
+	return nil
+}
+
+func (c *VaultConfig) mount() string {
+	if c.Auth.Mount != "" {
+		return strings.Trim(c.Auth.Mount, "/")
+	}
+	return c.Auth.Method
+}
+
+// login sets the token of the client. It logs in again on every call,
+// so that tokens do not expire between the rotations of a long-running
+// rotator.
+func (c *VaultConfig) login(ctx context.Context) error {
+	var body map[string]interface{}
+	switch c.Auth.Method {
+	case "", VaultAuthToken:
+		env := c.Auth.TokenEnv
+		if env == "" {
+			env = DefaultVaultTokenEnv
+		}
+		token, ok := os.LookupEnv(env)
+		if !ok {
+			return errors.Errorf("missing env var %s", env)
+		}
+		c.Client.SetToken(token)
+		return nil
+	case VaultAuthAppRole:
+		env := c.Auth.SecretIDEnv
+		if env == "" {
+			env = DefaultVaultSecretIDEnv
+		}
+		secretID, ok := os.LookupEnv(env)
+		if !ok {
+			return errors.Errorf("missing env var %s", env)
+		}
+		body = map[string]interface{}{"role_id": c.Auth.RoleID, "secret_id": secretID}
+	case VaultAuthKubernetes:
+		file := c.Auth.JWTFile
+		if file == "" {
+			file = DefaultVaultJWTFile
+		}
+		jwt, err := ioutil.ReadFile(file)
+		if err != nil {
+			return errors.Wrap(err, "unable to read service account token")
+		}
+		body = map[string]interface{}{"role": c.Auth.Role, "jwt": strings.TrimSpace(string(jwt))}
+	}
+
+	c.Client.ClearToken()
+	secret, err := c.request(ctx, http.MethodPut, "auth/"+c.mount()+"/login", body)
+	if err != nil {
+		return errors.Wrapf(err, "unable to log in to vault with %s", c.Auth.Method)
+	}
+	if secret == nil || secret.Auth == nil || secret.Auth.ClientToken == "" {
+		return errors.Errorf("no token returned by %s login", c.Auth.Method)
+	}
+	c.Client.SetToken(secret.Auth.ClientToken)
+	return nil
+}
+
+// request sends a request to the API path under /v1/, and returns the
+// secret in the response, or nil if the path was not found or the
+// response is empty.
+func (c *VaultConfig) request(ctx context.Context, method string, path string, body interface{}) (*vault.Secret, error) {
+	req := c.Client.NewRequest(method, "/v1/"+path)
+	if body != nil {
+		err := req.SetJSONBody(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resp, err := c.Client.RawRequestWithContext(ctx, req)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+	return vault.ParseSecret(resp.Body)
+}
+
+// vaultLease is what Vault knows about a lease.
+type vaultLease struct {
+	ID        string
+	Issued    time.Time
+	TTL       time.Duration
+	Renewable bool
+}
+
+// lookupLease returns the lease with id, or nil if it expired or was
+// revoked.
+func (c *VaultConfig) lookupLease(ctx context.Context, id string) (*vaultLease, error) {
+	secret, err := c.request(ctx, http.MethodPut, "sys/leases/lookup", map[string]interface{}{"lease_id": id})
+	var respErr *vault.ResponseError
+	if errors.As(err, &respErr) && respErr.StatusCode == http.StatusBadRequest {
+		// vault answers lookups of unknown leases with "invalid lease"
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to look up lease %s", id)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, nil
+	}
+	lease := &vaultLease{ID: id}
+	if issued, ok := secret.Data["issue_time"].(string); ok {
+		lease.Issued, err = time.Parse(time.RFC3339Nano, issued)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse issue time of lease %s", id)
+		}
+	}
+	if ttl, ok := secret.Data["ttl"].(json.Number); ok {
+		seconds, err := ttl.Int64()
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to parse ttl of lease %s", id)
+		}
+		lease.TTL = time.Duration(seconds) * time.Second
+	}
+	lease.Renewable, _ = secret.Data["renewable"].(bool)
+	return lease, nil
+}
+
+// renewLease asks Vault to let the lease with id expire increment from
+// now. Secrets engines cap increment at the max TTL of the lease.
+func (c *VaultConfig) renewLease(ctx context.Context, id string, increment time.Duration) error {
+	_, err := c.request(ctx, http.MethodPut, "sys/leases/renew", map[string]interface{}{
+		"lease_id":  id,
+		"increment": int64(increment / time.Second),
+	})
+	return errors.Wrapf(err, "unable to renew lease %s", id)
+}
+
+// revokeLease revokes the lease with id, and with it the credential.
+func (c *VaultConfig) revokeLease(ctx context.Context, id string) error {
+	_, err := c.request(ctx, http.MethodPut, "sys/leases/revoke", map[string]interface{}{"lease_id": id})
+	return errors.Wrapf(err, "unable to revoke lease %s", id)
+}
@@ -0,0 +1,273 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/util"
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+// VaultDynamicSource is a source that requests credentials from a Vault
+// secrets engine that leases them, e.g. database/creds/app or
+// aws/creds/ci.
+//
+// It gives the same guarantee as AwsIamSource: the credential a new one
+// replaces stays valid for MaxAge once the new one has reached every
+// sink, so that jobs which already read it can complete. Activate renews
+// the lease of the replaced credential, or cuts it short, to expire
+// MaxAge later, and Vault revokes it then. The lease of the credential in
+// the sinks is its CredentialID, which the rotation passes back with a
+// state store, see Versioned. Without one, every rotation requests a new
+// credential and replaced leases expire with their own TTL.
+type VaultDynamicSource struct {
+	Vault VaultConfig `yaml:",inline"`
+	// Path to request credentials from, e.g. database/creds/app.
+	Path string `yaml:"path"`
+	// Data is written to Path to request credentials, for secrets
+	// engines that take parameters. If not set, Path is read.
+	Data map[string]string `yaml:"data,omitempty"`
+	// Fields are the fields of the credential to hand out. Defaults to
+	// all fields.
+	Fields []string `yaml:"fields,omitempty"`
+
+	// MaxAge is the max_age of the secret the source belongs to.
+	MaxAge time.Duration `yaml:"-"`
+
+	// distributed is the lease of the credential in the sinks.
+	distributed string
+	// pending is the lease of the credential created by Create that has
+	// not been activated or revoked yet.
+	pending string
+	// leaseID is the lease of the credential last created.
+	leaseID string
+	// force makes the next rotation ignore MaxAge.
+	force bool
+}
+
+func init() {
+	// max_age must be set on the secret in config files, so no
+	// default is applied
+	Register(KindVaultDynamic, func() Source { return &VaultDynamicSource{} })
+}
+
+func NewVaultDynamicSource() *VaultDynamicSource {
+	return &VaultDynamicSource{
+		MaxAge: DefaultMaxAge,
+	}
+}
+
+func (src *VaultDynamicSource) WithVault(vault VaultConfig) *VaultDynamicSource {
+	src.Vault = vault
+	return src
+}
+
+func (src *VaultDynamicSource) WithPath(path string) *VaultDynamicSource {
+	src.Path = path
+	return src
+}
+
+func (src *VaultDynamicSource) WithMaxAge(maxAge time.Duration) *VaultDynamicSource {
+	src.MaxAge = maxAge
+	return src
+}
+
+// SetMaxAge sets MaxAge from the max_age of the secret.
+func (src *VaultDynamicSource) SetMaxAge(maxAge time.Duration) {
+	src.MaxAge = maxAge
+}
+
+func (src *VaultDynamicSource) Kind() Kind {
+	return KindVaultDynamic
+}
+
+// Validate checks the auth method, the path and the max age.
+func (src *VaultDynamicSource) Validate() error {
+	var errs *multierror.Error
+	errs = multierror.Append(errs, src.Vault.validate())
+	if strings.Trim(src.Path, "/") == "" {
+		errs = multierror.Append(errs, errors.New("missing path"))
+	}
+	if src.MaxAge <= 0 {
+		errs = multierror.Append(errs, errors.New("missing max_age"))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up the Vault client.
+func (src *VaultDynamicSource) Init() error {
+	return src.Vault.init()
+}
+
+func (src *VaultDynamicSource) path() string {
+	return strings.Trim(src.Path, "/")
+}
+
+// current logs in and returns the lease of the credential in the sinks,
+// or nil if it is not known or no longer valid.
+func (src *VaultDynamicSource) current(ctx context.Context) (*vaultLease, error) {
+	err := src.Vault.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if src.distributed == "" {
+		return nil, nil
+	}
+	return src.Vault.lookupLease(ctx, src.distributed)
+}
+
+// due reports whether Create would request a new credential given the
+// lease of the credential in the sinks, and why.
+func (src *VaultDynamicSource) due(lease *vaultLease) (bool, string) {
+	switch {
+	case src.force:
+		return true, "forced"
+	case src.distributed == "":
+		return true, "no lease distributed yet"
+	case lease == nil:
+		return true, fmt.Sprintf("lease %s expired or was revoked", src.distributed)
+	}
+	age := time.Since(lease.Issued).Round(time.Second)
+	if age > src.MaxAge {
+		return true, fmt.Sprintf("lease is %s old, max_age is %s", age, src.MaxAge)
+	}
+	if lease.TTL < src.MaxAge {
+		return true, fmt.Sprintf("lease expires in %s, max_age is %s", lease.TTL, src.MaxAge)
+	}
+	return false, fmt.Sprintf("lease is %s old and expires in %s, max_age is %s", age, lease.TTL, src.MaxAge)
+}
+
+// SetDistributed sets the lease of the credential in the sinks.
+func (src *VaultDynamicSource) SetDistributed(credentialID string) {
+	src.distributed = credentialID
+}
+
+// Force makes the next rotation request a new credential even if the
+// one in the sinks is within MaxAge.
+func (src *VaultDynamicSource) Force() {
+	src.force = true
+}
+
+// Plan reports whether Create would request a new credential, without
+// changing anything. Its keys are Fields, since the fields of a
+// credential are only known once it is issued.
+func (src *VaultDynamicSource) Plan(ctx context.Context) (Plan, error) {
+	lease, err := src.current(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	due, reason := src.due(lease)
+	var keys []string
+	keys = append(keys, src.Fields...)
+	sort.Strings(keys)
+	return Plan{Due: due, Reason: reason, Keys: keys}, nil
+}
+
+func (src *VaultDynamicSource) Read(ctx context.Context) (map[string]string, error) {
+	creds, err := src.Create(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return creds, src.Activate(ctx)
+}
+
+// Create requests a new credential, unless the one in the sinks is
+// within MaxAge and its lease lasts at least MaxAge more. The credential
+// in the sinks is left untouched until Activate.
+func (src *VaultDynamicSource) Create(ctx context.Context) (map[string]string, error) {
+	lease, err := src.current(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if due, _ := src.due(lease); !due {
+		return nil, nil
+	}
+
+	method := http.MethodGet
+	var body interface{}
+	if src.Data != nil {
+		method, body = http.MethodPut, src.Data
+	}
+	secret, err := src.Vault.request(ctx, method, src.path(), body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to request credentials from %s", src.path())
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.Errorf("no credentials returned by %s", src.path())
+	}
+	if secret.LeaseID == "" {
+		return nil, errors.Errorf("no lease returned by %s, use the vault_kv source for secrets that are not leased", src.path())
+	}
+	creds, err := stringFields(secret.Data, src.Fields)
+	if err != nil {
+		// the credential is not handed out, so it is not left behind
+		_ = src.Vault.revokeLease(ctx, secret.LeaseID)
+		return nil, errors.Wrapf(err, "unable to read credentials from %s", src.path())
+	}
+	ttl := time.Duration(secret.LeaseDuration) * time.Second
+	if ttl < src.MaxAge {
+		util.Logger(ctx).Warnf("vault_dynamic: lease of %s expires in %s, before max_age %s has passed", src.path(), ttl, src.MaxAge)
+	}
+	src.pending = secret.LeaseID
+	src.leaseID = secret.LeaseID
+	return creds, nil
+}
+
+// Activate accepts the credential created by Create. The lease of the
+// credential it replaces is renewed to expire MaxAge later, so that jobs
+// which already read it can complete, or cut short to do so if it lasts
+// longer. Leases that cannot be renewed expire with their own TTL.
+func (src *VaultDynamicSource) Activate(ctx context.Context) error {
+	if src.pending == "" {
+		return nil
+	}
+	if src.distributed != "" {
+		// the new credential is in every sink by now, so failing to
+		// shorten the grace of the replaced one is not worth failing for
+		err := src.retire(ctx, src.distributed)
+		if err != nil {
+			util.Logger(ctx).Warnf("vault_dynamic: %s", err)
+		}
+	}
+	src.distributed = src.pending
+	src.pending = ""
+	return nil
+}
+
+// retire renews the lease with id to expire MaxAge later.
+func (src *VaultDynamicSource) retire(ctx context.Context, id string) error {
+	lease, err := src.Vault.lookupLease(ctx, id)
+	if err != nil || lease == nil {
+		return err
+	}
+	if !lease.Renewable {
+		if lease.TTL < src.MaxAge {
+			return errors.Errorf("lease %s cannot be renewed and expires in %s, before max_age %s has passed", id, lease.TTL, src.MaxAge)
+		}
+		return nil
+	}
+	return src.Vault.renewLease(ctx, id, src.MaxAge)
+}
+
+// Revoke revokes the lease of the credential created by Create.
+func (src *VaultDynamicSource) Revoke(ctx context.Context) error {
+	if src.pending == "" {
+		return nil
+	}
+	err := src.Vault.revokeLease(ctx, src.pending)
+	if err != nil {
+		return err
+	}
+	src.pending = ""
+	return nil
+}
+
+// CredentialID returns the lease of the credential last created.
+func (src *VaultDynamicSource) CredentialID(creds map[string]string) string {
+	return src.leaseID
+}
@@ -0,0 +1,142 @@
+package source_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+func newVaultDynamicSource(r *require.Assertions, fake *fakeVault) (*source.VaultDynamicSource, func()) {
+	server := httptest.NewServer(fake)
+	src := source.NewVaultDynamicSource().
+		WithVault(source.VaultConfig{Address: server.URL, Auth: source.VaultAuth{TokenEnv: "ROTATOR_TEST_VAULT_TOKEN"}}).
+		WithPath("database/creds/app").
+		WithMaxAge(time.Hour)
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+	return src, server.Close
+}
+
+func TestVaultDynamicSourceLeases(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	os.Setenv("ROTATOR_TEST_VAULT_TOKEN", "root")
+	defer os.Unsetenv("ROTATOR_TEST_VAULT_TOKEN")
+
+	fake := newFakeVault()
+	src, teardown := newVaultDynamicSource(r, fake)
+	defer teardown()
+
+	// nothing distributed yet
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Nil(plan.Keys)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"username": "v-app-1", "password": "secret"}, creds)
+	first := src.CredentialID(creds)
+	r.Equal("database/creds/app/1", first)
+	r.NoError(src.Activate(ctx))
+
+	// the lease in the sinks is within max_age and lasts long enough
+	src.SetDistributed(first)
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// a new credential is requested once the lease would expire within
+	// max_age, and the replaced lease is renewed to last max_age
+	fake.leases[first].expires = time.Now().Add(10 * time.Minute)
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Contains(plan.Reason, "lease expires in")
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal("v-app-2", creds["username"])
+	second := src.CredentialID(creds)
+	r.NoError(src.Activate(ctx))
+	r.WithinDuration(time.Now().Add(time.Hour), fake.leases[first].expires, time.Minute)
+
+	// leases past max_age are cut short the same way
+	fake.leases[second].issued = time.Now().Add(-2 * time.Hour)
+	src.SetDistributed(second)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+	r.NoError(src.Activate(ctx))
+	r.WithinDuration(time.Now().Add(time.Hour), fake.leases[second].expires, time.Minute)
+
+	// the lease of a credential that is rolled back is revoked
+	src.Force()
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	rolledBack := src.CredentialID(creds)
+	r.Contains(fake.leases, rolledBack)
+	r.NoError(src.Revoke(ctx))
+	r.NotContains(fake.leases, rolledBack)
+}
+
+func TestVaultDynamicSourceExpiredLease(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	os.Setenv("ROTATOR_TEST_VAULT_TOKEN", "root")
+	defer os.Unsetenv("ROTATOR_TEST_VAULT_TOKEN")
+
+	fake := newFakeVault()
+	fake.renewable = false
+	src, teardown := newVaultDynamicSource(r, fake)
+	defer teardown()
+	src.Path = "database/creds/report"
+	src.Data = map[string]string{"ttl": "2h"}
+	src.Fields = []string{"username", "password"}
+
+	src.SetDistributed("database/creds/report/0")
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Equal("lease database/creds/report/0 expired or was revoked", plan.Reason)
+	r.Equal([]string{"password", "username"}, plan.Keys)
+
+	creds, err := src.Read(ctx)
+	r.NoError(err)
+	r.Len(creds, 2)
+	r.Equal(map[string]string{"ttl": "2h"}, fake.leases[src.CredentialID(creds)].params)
+
+	// leases that cannot be renewed are left to expire
+	first := src.CredentialID(creds)
+	expires := fake.leases[first].expires
+	src.Force()
+	_, err = src.Read(ctx)
+	r.NoError(err)
+	r.Equal(expires, fake.leases[first].expires)
+
+	// leases whose credentials are not handed out are revoked
+	src.Fields = []string{"missing"}
+	_, err = src.Create(ctx)
+	r.Error(err)
+	r.Len(fake.leases, 2)
+}
+
+func TestVaultDynamicSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string]*source.VaultDynamicSource{
+		"missing path":        {MaxAge: time.Hour},
+		"missing max_age":     {Path: "database/creds/app"},
+		"unknown auth method": {Path: "database/creds/app", MaxAge: time.Hour, Vault: source.VaultConfig{Auth: source.VaultAuth{Method: "ldap"}}},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+	r.NoError((&source.VaultDynamicSource{Path: "database/creds/app", MaxAge: time.Hour}).Validate())
+}
@@ -0,0 +1,234 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultVaultKVMount = "secret"
+)
+
+// VaultKVSource is a source that hands out the fields of a secret in the
+// Vault KV secrets engine, so that rotator distributes secrets managed
+// in Vault to sinks Vault cannot reach.
+//
+// With KV version 2, the version of the secret is its CredentialID, and
+// Create only returns the secret once Vault holds a version that was not
+// distributed by the last rotation, see Versioned.
+type VaultKVSource struct {
+	Vault VaultConfig `yaml:",inline"`
+	// Mount is the path the KV secrets engine is enabled at. Defaults to
+	// DefaultVaultKVMount.
+	Mount string `yaml:"mount,omitempty"`
+	// Path of the secret, relative to Mount.
+	Path string `yaml:"path"`
+	// KVVersion is the version of the KV secrets engine, 1 or 2.
+	// Defaults to 2.
+	KVVersion int `yaml:"kv_version,omitempty"`
+	// Fields are the fields of the secret to hand out. Defaults to all
+	// fields.
+	Fields []string `yaml:"fields,omitempty"`
+
+	// distributed is the CredentialID of the version distributed last.
+	distributed string
+	// version is the version of the secret last read.
+	version int64
+	// force makes the next rotation hand out the secret even if its
+	// version was distributed already.
+	force bool
+}
+
+// vaultKVSecret is a version of a secret in the KV secrets engine.
+type vaultKVSecret struct {
+	data map[string]string
+	// version is 0 with KV version 1.
+	version int64
+}
+
+func init() {
+	Register(KindVaultKV, func() Source { return NewVaultKVSource() })
+}
+
+func NewVaultKVSource() *VaultKVSource {
+	return &VaultKVSource{}
+}
+
+func (src *VaultKVSource) WithVault(vault VaultConfig) *VaultKVSource {
+	src.Vault = vault
+	return src
+}
+
+func (src *VaultKVSource) WithPath(mount string, path string) *VaultKVSource {
+	src.Mount = mount
+	src.Path = path
+	return src
+}
+
+func (src *VaultKVSource) Kind() Kind {
+	return KindVaultKV
+}
+
+// Validate checks the auth method, the path and the KV version.
+func (src *VaultKVSource) Validate() error {
+	var errs *multierror.Error
+	errs = multierror.Append(errs, src.Vault.validate())
+	if strings.Trim(src.Path, "/") == "" {
+		errs = multierror.Append(errs, errors.New("missing path"))
+	}
+	if src.KVVersion != 0 && src.KVVersion != 1 && src.KVVersion != 2 {
+		errs = multierror.Append(errs, errors.Errorf("kv_version must be 1 or 2, not %d", src.KVVersion))
+	}
+	return errs.ErrorOrNil()
+}
+
+// Init sets up the Vault client.
+func (src *VaultKVSource) Init() error {
+	return src.Vault.init()
+}
+
+func (src *VaultKVSource) mount() string {
+	if src.Mount == "" {
+		return DefaultVaultKVMount
+	}
+	return strings.Trim(src.Mount, "/")
+}
+
+func (src *VaultKVSource) path() string {
+	return strings.Trim(src.Path, "/")
+}
+
+// read returns the latest version of the secret.
+func (src *VaultKVSource) read(ctx context.Context) (*vaultKVSecret, error) {
+	err := src.Vault.login(ctx)
+	if err != nil {
+		return nil, err
+	}
+	apiPath := src.mount() + "/" + src.path()
+	if src.KVVersion != 1 {
+		apiPath = src.mount() + "/data/" + src.path()
+	}
+	secret, err := src.Vault.request(ctx, http.MethodGet, apiPath, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", apiPath)
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, errors.Errorf("no secret found at %s", apiPath)
+	}
+
+	raw := secret.Data
+	kv := &vaultKVSecret{data: map[string]string{}}
+	if src.KVVersion != 1 {
+		data, ok := secret.Data["data"].(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("no secret found at %s, its latest version may be deleted", apiPath)
+		}
+		raw = data
+		if metadata, ok := secret.Data["metadata"].(map[string]interface{}); ok {
+			if v, ok := metadata["version"].(json.Number); ok {
+				kv.version, _ = v.Int64()
+			}
+		}
+	}
+	kv.data, err = stringFields(raw, src.Fields)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", apiPath)
+	}
+	return kv, nil
+}
+
+// id returns the CredentialID of version, or "" with KV version 1.
+func (src *VaultKVSource) id(version int64) string {
+	if version == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s@v%d", src.mount(), src.path(), version)
+}
+
+// due reports whether kv should be handed out, and why.
+func (src *VaultKVSource) due(kv *vaultKVSecret) (bool, string) {
+	switch {
+	case src.force:
+		return true, "forced"
+	case kv.version == 0:
+		return true, "kv version 1 secrets have no version"
+	case src.distributed == "":
+		return true, fmt.Sprintf("version %d, no version distributed yet", kv.version)
+	case src.distributed == src.id(kv.version):
+		return false, fmt.Sprintf("version %d already distributed", kv.version)
+	}
+	return true, fmt.Sprintf("version %d, distributed %s", kv.version, src.distributed)
+}
+
+// SetDistributed sets the CredentialID of the version distributed last.
+func (src *VaultKVSource) SetDistributed(credentialID string) {
+	src.distributed = credentialID
+}
+
+// Force makes the next rotation hand out the secret even if its version
+// was distributed already.
+func (src *VaultKVSource) Force() {
+	src.force = true
+}
+
+// Plan reads the secret and reports whether its version is new.
+func (src *VaultKVSource) Plan(ctx context.Context) (Plan, error) {
+	kv, err := src.read(ctx)
+	if err != nil {
+		return Plan{}, err
+	}
+	var keys []string
+	for k := range kv.data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	due, reason := src.due(kv)
+	return Plan{Due: due, Reason: reason, Keys: keys}, nil
+}
+
+// Read returns the fields of the latest version of the secret.
+func (src *VaultKVSource) Read(ctx context.Context) (map[string]string, error) {
+	kv, err := src.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	src.version = kv.version
+	return kv.data, nil
+}
+
+// Create returns the fields of the latest version of the secret, or nil
+// if that version was distributed already.
+func (src *VaultKVSource) Create(ctx context.Context) (map[string]string, error) {
+	kv, err := src.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if due, _ := src.due(kv); !due {
+		return nil, nil
+	}
+	src.version = kv.version
+	return kv.data, nil
+}
+
+// Activate is a no-op for VaultKVSource.
+func (src *VaultKVSource) Activate(ctx context.Context) error {
+	return nil
+}
+
+// Revoke is a no-op for VaultKVSource.
+func (src *VaultKVSource) Revoke(ctx context.Context) error {
+	return nil
+}
+
+// CredentialID returns the path and version of the secret last read,
+// e.g. secret/app@v3, or "" with KV version 1.
+func (src *VaultKVSource) CredentialID(creds map[string]string) string {
+	return src.id(src.version)
+}
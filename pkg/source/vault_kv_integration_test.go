@@ -0,0 +1,56 @@
+// +build integration
+
+package source_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	vault "github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/require"
+)
+
+// TestVaultKVSource_Integration runs against the Vault server in
+// VAULT_ADDR with the token in VAULT_TOKEN, e.g. a dev server, which
+// mounts KV version 2 at secret/:
+//
+//   vault server -dev -dev-root-token-id=root
+//   VAULT_ADDR=http://127.0.0.1:8200 VAULT_TOKEN=root
+func TestVaultKVSource_Integration(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	if _, ok := os.LookupEnv("VAULT_ADDR"); !ok {
+		t.Skip("VAULT_ADDR is not set")
+	}
+	client, err := vault.NewClient(vault.DefaultConfig())
+	r.NoError(err)
+	write := func(password string) {
+		_, err := client.Logical().Write("secret/data/rotator-test", map[string]interface{}{
+			"data": map[string]interface{}{"username": "app", "password": password},
+		})
+		r.NoError(err)
+	}
+	defer client.Logical().Delete("secret/metadata/rotator-test") // nolint:errcheck
+
+	write("first")
+	src := source.NewVaultKVSource().WithPath("secret", "rotator-test")
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal("first", creds["password"])
+	id := src.CredentialID(creds)
+
+	src.SetDistributed(id)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	write("second")
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal("second", creds["password"])
+	r.NotEqual(id, src.CredentialID(creds))
+}
@@ -0,0 +1,281 @@
+package source_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/source"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeVault serves KV v1 secrets under kv/, KV v2 secrets under secret/
+// and leased database credentials under database/creds/, and logs in
+// with approle and kubernetes.
+type fakeVault struct {
+	mu     sync.Mutex
+	token  string
+	kv1    map[string]map[string]interface{}
+	kv2    map[string][]map[string]interface{}
+	logins []map[string]string
+	// leases are the database credentials issued, by lease ID.
+	leases    map[string]*fakeLease
+	leaseTTL  time.Duration
+	renewable bool
+}
+
+type fakeLease struct {
+	issued  time.Time
+	expires time.Time
+	// params are the parameters the credential was requested with.
+	params map[string]string
+}
+
+func newFakeVault() *fakeVault {
+	return &fakeVault{
+		token: "root",
+		kv1:   map[string]map[string]interface{}{},
+		kv2:   map[string][]map[string]interface{}{},
+		// the database secrets engine defaults to leases of 768h
+		leases:    map[string]*fakeLease{},
+		leaseTTL:  768 * time.Hour,
+		renewable: true,
+	}
+}
+
+func (f *fakeVault) put(path string, data map[string]interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.kv2[path] = append(f.kv2[path], data)
+}
+
+func (f *fakeVault) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if strings.HasPrefix(path, "auth/") && strings.HasSuffix(path, "/login") {
+		var login map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&login)
+		login["mount"] = strings.TrimSuffix(strings.TrimPrefix(path, "auth/"), "/login")
+		f.logins = append(f.logins, login)
+		if login["secret_id"] == "wrong" {
+			http.Error(w, `{"errors": ["invalid secret id"]}`, http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"auth": map[string]interface{}{"client_token": f.token}})
+		return
+	}
+	if r.Header.Get("X-Vault-Token") != f.token {
+		http.Error(w, `{"errors": ["permission denied"]}`, http.StatusForbidden)
+		return
+	}
+	if strings.HasPrefix(path, "database/creds/") || strings.HasPrefix(path, "sys/leases/") {
+		f.serveLeases(w, r, path)
+		return
+	}
+	if strings.HasPrefix(path, "secret/data/") {
+		versions := f.kv2[strings.TrimPrefix(path, "secret/data/")]
+		if len(versions) == 0 {
+			http.Error(w, `{"errors": []}`, http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"data":     versions[len(versions)-1],
+			"metadata": map[string]interface{}{"version": len(versions)},
+		}})
+		return
+	}
+	data, ok := f.kv1[strings.TrimPrefix(path, "kv/")]
+	if !ok {
+		http.Error(w, `{"errors": []}`, http.StatusNotFound)
+		return
+	}
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": data})
+}
+
+func (f *fakeVault) serveLeases(w http.ResponseWriter, r *http.Request, path string) {
+	var body map[string]interface{}
+	_ = json.NewDecoder(r.Body).Decode(&body)
+	if strings.HasPrefix(path, "database/creds/") {
+		id := fmt.Sprintf("%s/%d", path, len(f.leases)+1)
+		now := time.Now()
+		lease := &fakeLease{issued: now, expires: now.Add(f.leaseTTL), params: map[string]string{}}
+		for k, v := range body {
+			lease.params[k] = fmt.Sprint(v)
+		}
+		f.leases[id] = lease
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"lease_id":       id,
+			"lease_duration": int(f.leaseTTL / time.Second),
+			"renewable":      f.renewable,
+			"data":           map[string]interface{}{"username": fmt.Sprintf("v-app-%d", len(f.leases)), "password": "secret"},
+		})
+		return
+	}
+	id, _ := body["lease_id"].(string)
+	lease, ok := f.leases[id]
+	if !ok || time.Now().After(lease.expires) {
+		http.Error(w, `{"errors": ["invalid lease"]}`, http.StatusBadRequest)
+		return
+	}
+	switch path {
+	case "sys/leases/lookup":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"data": map[string]interface{}{
+			"id":          id,
+			"issue_time":  lease.issued.Format(time.RFC3339Nano),
+			"expire_time": lease.expires.Format(time.RFC3339Nano),
+			"ttl":         int(time.Until(lease.expires) / time.Second),
+			"renewable":   f.renewable,
+		}})
+	case "sys/leases/renew":
+		increment, _ := body["increment"].(float64)
+		lease.expires = time.Now().Add(time.Duration(increment) * time.Second)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"lease_id": id})
+	case "sys/leases/revoke":
+		delete(f.leases, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, `{"errors": []}`, http.StatusNotFound)
+	}
+}
+
+func newVaultKVSource(r *require.Assertions, fake *fakeVault, auth source.VaultAuth) (*source.VaultKVSource, func()) {
+	server := httptest.NewServer(fake)
+	src := source.NewVaultKVSource().
+		WithVault(source.VaultConfig{Address: server.URL, Auth: auth}).
+		WithPath("secret", "app/db")
+	r.NoError(src.Validate())
+	r.NoError(src.Init())
+	return src, server.Close
+}
+
+func TestVaultKVSourceVersions(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	os.Setenv("ROTATOR_TEST_VAULT_TOKEN", "root")
+	defer os.Unsetenv("ROTATOR_TEST_VAULT_TOKEN")
+
+	fake := newFakeVault()
+	fake.put("app/db", map[string]interface{}{"username": "app", "password": "first"})
+	src, teardown := newVaultKVSource(r, fake, source.VaultAuth{TokenEnv: "ROTATOR_TEST_VAULT_TOKEN"})
+	defer teardown()
+
+	// nothing distributed yet
+	plan, err := src.Plan(ctx)
+	r.NoError(err)
+	r.True(plan.Due)
+	r.Equal([]string{"password", "username"}, plan.Keys)
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"username": "app", "password": "first"}, creds)
+	r.Equal("secret/app/db@v1", src.CredentialID(creds))
+
+	// the same version is not distributed again
+	src.SetDistributed("secret/app/db@v1")
+	plan, err = src.Plan(ctx)
+	r.NoError(err)
+	r.False(plan.Due)
+	r.Equal("version 1 already distributed", plan.Reason)
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Nil(creds)
+
+	// a new version is
+	fake.put("app/db", map[string]interface{}{"username": "app", "password": "second", "port": json.Number("5432")})
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"username": "app", "password": "second", "port": "5432"}, creds)
+	r.Equal("secret/app/db@v2", src.CredentialID(creds))
+
+	// and so is a forced one
+	src.SetDistributed("secret/app/db@v2")
+	src.Force()
+	creds, err = src.Create(ctx)
+	r.NoError(err)
+	r.NotNil(creds)
+
+	// only the listed fields are handed out
+	src.Fields = []string{"password"}
+	creds, err = src.Read(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"password": "second"}, creds)
+	src.Fields = []string{"missing"}
+	_, err = src.Read(ctx)
+	r.Error(err)
+
+	src.Path = "app/missing"
+	_, err = src.Read(ctx)
+	r.Error(err)
+}
+
+func TestVaultKVSourceV1AppRole(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	os.Setenv(source.DefaultVaultSecretIDEnv, "secret-id")
+	defer os.Unsetenv(source.DefaultVaultSecretIDEnv)
+
+	fake := newFakeVault()
+	fake.token = "approle-token"
+	fake.kv1["app/api"] = map[string]interface{}{"token": "abc"}
+	src, teardown := newVaultKVSource(r, fake, source.VaultAuth{Method: source.VaultAuthAppRole, RoleID: "role-id"})
+	defer teardown()
+	src.Mount = "kv"
+	src.Path = "/app/api/"
+	src.KVVersion = 1
+
+	// kv version 1 secrets are always handed out
+	src.SetDistributed("")
+	creds, err := src.Create(ctx)
+	r.NoError(err)
+	r.Equal(map[string]string{"token": "abc"}, creds)
+	r.Equal("", src.CredentialID(creds))
+	r.Equal([]map[string]string{{"mount": "approle", "role_id": "role-id", "secret_id": "secret-id"}}, fake.logins)
+
+	os.Setenv(source.DefaultVaultSecretIDEnv, "wrong")
+	_, err = src.Read(ctx)
+	r.Error(err)
+}
+
+func TestVaultKVSourceKubernetesAuth(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "rotator")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	jwtFile := filepath.Join(dir, "token")
+	r.NoError(ioutil.WriteFile(jwtFile, []byte("jwt\n"), 0600))
+
+	fake := newFakeVault()
+	fake.put("app/db", map[string]interface{}{"password": "first"})
+	src, teardown := newVaultKVSource(r, fake, source.VaultAuth{Method: source.VaultAuthKubernetes, Mount: "k8s", Role: "rotator", JWTFile: jwtFile})
+	defer teardown()
+
+	_, err = src.Read(ctx)
+	r.NoError(err)
+	r.Equal([]map[string]string{{"mount": "k8s", "role": "rotator", "jwt": "jwt"}}, fake.logins)
+}
+
+func TestVaultKVSourceValidate(t *testing.T) {
+	r := require.New(t)
+
+	tests := map[string]*source.VaultKVSource{
+		"missing path":        {},
+		"unknown kv version":  {Path: "p", KVVersion: 3},
+		"unknown auth method": {Path: "p", Vault: source.VaultConfig{Auth: source.VaultAuth{Method: "ldap"}}},
+		"missing role_id":     {Path: "p", Vault: source.VaultConfig{Auth: source.VaultAuth{Method: "approle"}}},
+		"missing role":        {Path: "p", Vault: source.VaultConfig{Auth: source.VaultAuth{Method: "kubernetes"}}},
+	}
+	for name, src := range tests {
+		r.Error(src.Validate(), name)
+	}
+	r.NoError((&source.VaultKVSource{Path: "p"}).Validate())
+}
@@ -0,0 +1,67 @@
+package state
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	boltBucket      = "secrets"
+	boltOpenTimeout = 30 * time.Second
+)
+
+// BoltStore is a Store backed by a local BoltDB file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore opens, or creates, the BoltDB file at path. Only one
+// process can hold the file open at a time; NewBoltStore waits for
+// the file to be released for up to 30 seconds.
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to open state file %s", path)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(boltBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrapf(err, "unable to initialize state file %s", path)
+	}
+	return &BoltStore{db: db}, nil
+}
+
+func (s *BoltStore) Get(ctx context.Context, secret string) (*Record, error) {
+	var rec *Record
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(boltBucket)).Get([]byte(secret))
+		if b == nil {
+			return nil
+		}
+		rec = &Record{}
+		return json.Unmarshal(b, rec)
+	})
+	return rec, errors.Wrapf(err, "unable to read state of %s", secret)
+}
+
+func (s *BoltStore) Put(ctx context.Context, rec *Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode state of %s", rec.Secret)
+	}
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(boltBucket)).Put([]byte(rec.Secret), b)
+	})
+	return errors.Wrapf(err, "unable to write state of %s", rec.Secret)
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,65 @@
+package state_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/stretchr/testify/require"
+)
+
+func testRecord() *state.Record {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &state.Record{
+		Secret:       "test",
+		LastRotated:  now,
+		LastAttempt:  now,
+		CredentialID: "AKIAEXAMPLE",
+		Outcome:      state.OutcomeRotated,
+		Sinks: []state.SinkRecord{
+			{Kind: "TravisCI", Fingerprint: "abc", LastWritten: now, Outcome: state.OutcomeRotated},
+		},
+	}
+}
+
+func TestBoltStore(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	dir, err := ioutil.TempDir("", "rotator-state")
+	r.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "state.db")
+
+	store, err := state.NewBoltStore(path)
+	r.NoError(err)
+
+	rec, err := store.Get(ctx, "test")
+	r.NoError(err)
+	r.Nil(rec)
+
+	r.NoError(store.Put(ctx, testRecord()))
+	r.NoError(store.Close())
+
+	// records survive reopening the file
+	store, err = state.NewBoltStore(path)
+	r.NoError(err)
+	defer store.Close()
+	rec, err = store.Get(ctx, "test")
+	r.NoError(err)
+	r.Equal(testRecord(), rec)
+}
+
+func TestConfigValidate(t *testing.T) {
+	r := require.New(t)
+
+	r.NoError((&state.Config{Backend: state.BackendBolt, Path: "state.db"}).Validate())
+	r.NoError((&state.Config{Backend: state.BackendS3, Bucket: "bucket", Region: "us-west-2"}).Validate())
+	r.Error((&state.Config{Backend: state.BackendBolt}).Validate())
+	r.Error((&state.Config{Backend: state.BackendS3, Bucket: "bucket"}).Validate())
+	r.Error((&state.Config{Backend: "consul"}).Validate())
+}
@@ -0,0 +1,76 @@
+package state
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	cziAws "github.com/chanzuckerberg/go-misc/aws"
+	"github.com/pkg/errors"
+)
+
+type Backend string
+
+const (
+	BackendBolt Backend = "bolt"
+	BackendS3   Backend = "s3"
+)
+
+// Config selects and configures a Store backend.
+type Config struct {
+	Backend Backend `yaml:"backend"`
+
+	// bolt
+	Path string `yaml:"path,omitempty"`
+
+	// s3
+	Bucket     string `yaml:"bucket,omitempty"`
+	Prefix     string `yaml:"prefix,omitempty"`
+	Region     string `yaml:"region,omitempty"`
+	RoleArn    string `yaml:"role_arn,omitempty"`
+	ExternalID string `yaml:"external_id,omitempty"`
+}
+
+// Validate checks that the fields required by the backend are set.
+func (c *Config) Validate() error {
+	switch c.Backend {
+	case BackendBolt:
+		if c.Path == "" {
+			return errors.New("missing path in bolt state config")
+		}
+	case BackendS3:
+		if c.Bucket == "" || c.Region == "" {
+			return errors.New("missing bucket or region in s3 state config")
+		}
+	default:
+		return errors.Wrapf(ErrUnknownBackend, "%q", c.Backend)
+	}
+	return nil
+}
+
+// Open returns the Store described by c.
+func (c *Config) Open() (Store, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	switch c.Backend {
+	case BackendBolt:
+		return NewBoltStore(c.Path)
+	case BackendS3:
+		sess, err := session.NewSession(&aws.Config{
+			Region: aws.String(c.Region),
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to set up aws session: make sure you have a shared credentials file or your environment variables set")
+		}
+		if c.RoleArn != "" {
+			sess.Config.Credentials = stscreds.NewCredentials(sess, c.RoleArn, func(p *stscreds.AssumeRoleProvider) {
+				if c.ExternalID != "" {
+					p.ExternalID = aws.String(c.ExternalID)
+				}
+			})
+		}
+		client := cziAws.New(sess).WithS3(sess.Config)
+		return NewS3Store(client.S3.Svc, c.Bucket, c.Prefix), nil
+	}
+	return nil, ErrUnknownBackend
+}
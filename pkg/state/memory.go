@@ -0,0 +1,41 @@
+package state
+
+import (
+	"context"
+	"sync"
+)
+
+// MemoryStore is a Store that keeps records in memory. It does not
+// persist anything between runs and is mostly useful in tests.
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]Record{}}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, secret string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[secret]
+	if !ok {
+		return nil, nil
+	}
+	rec.Sinks = append([]SinkRecord(nil), rec.Sinks...)
+	return &rec, nil
+}
+
+func (s *MemoryStore) Put(ctx context.Context, rec *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored := *rec
+	stored.Sinks = append([]SinkRecord(nil), rec.Sinks...)
+	s.records[rec.Secret] = stored
+	return nil
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package state
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/pkg/errors"
+)
+
+// S3Store is a Store that keeps one JSON object per secret in an S3
+// bucket, so that runs on different machines share their state.
+type S3Store struct {
+	Svc    s3iface.S3API
+	Bucket string
+	Prefix string
+}
+
+func NewS3Store(svc s3iface.S3API, bucket string, prefix string) *S3Store {
+	return &S3Store{Svc: svc, Bucket: bucket, Prefix: prefix}
+}
+
+func (s *S3Store) key(secret string) string {
+	return path.Join(s.Prefix, secret+".json")
+}
+
+func (s *S3Store) Get(ctx context.Context, secret string) (*Record, error) {
+	out, err := s.Svc.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(secret)),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == s3.ErrCodeNoSuchKey {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read state of %s from s3://%s/%s", secret, s.Bucket, s.key(secret))
+	}
+	defer out.Body.Close()
+
+	b, err := ioutil.ReadAll(out.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read state of %s from s3://%s/%s", secret, s.Bucket, s.key(secret))
+	}
+	rec := &Record{}
+	err = json.Unmarshal(b, rec)
+	return rec, errors.Wrapf(err, "unable to decode state of %s", secret)
+}
+
+func (s *S3Store) Put(ctx context.Context, rec *Record) error {
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return errors.Wrapf(err, "unable to encode state of %s", rec.Secret)
+	}
+	_, err = s.Svc.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.Bucket),
+		Key:         aws.String(s.key(rec.Secret)),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	return errors.Wrapf(err, "unable to write state of %s to s3://%s/%s", rec.Secret, s.Bucket, s.key(rec.Secret))
+}
+
+func (s *S3Store) Close() error {
+	return nil
+}
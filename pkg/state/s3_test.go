@@ -0,0 +1,69 @@
+package state_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/chanzuckerberg/rotator/pkg/state"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an in-memory stand-in for the object calls made by S3Store.
+type fakeS3 struct {
+	s3iface.S3API
+
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3() *fakeS3 {
+	return &fakeS3{objects: map[string][]byte{}}
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, ok := f.objects[*in.Bucket+"/"+*in.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(b))}, nil
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	b, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.objects[*in.Bucket+"/"+*in.Key] = b
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestS3Store(t *testing.T) {
+	r := require.New(t)
+	ctx := context.Background()
+
+	svc := newFakeS3()
+	store := state.NewS3Store(svc, "bucket", "rotator")
+
+	rec, err := store.Get(ctx, "test")
+	r.NoError(err)
+	r.Nil(rec)
+
+	r.NoError(store.Put(ctx, testRecord()))
+	r.Contains(svc.objects, "bucket/rotator/test.json")
+
+	rec, err = store.Get(ctx, "test")
+	r.NoError(err)
+	r.Equal(testRecord(), rec)
+}
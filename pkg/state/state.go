@@ -0,0 +1,68 @@
+package state
+
+import (
+	"context"
+	"time"
+)
+
+// Store persists what rotator knows about past rotations, so that
+// rotation can be scheduled for sources that do not track the age of
+// their credentials themselves.
+//
+// Get returns the record for the named secret, or nil if the secret
+// has never been recorded.
+//
+// Put creates or replaces the record for rec.Secret.
+//
+// Close releases any resources held by the store.
+type Store interface {
+	Get(ctx context.Context, secret string) (*Record, error)
+	Put(ctx context.Context, rec *Record) error
+	Close() error
+}
+
+// Record describes the last rotation of a secret. It never holds
+// credential values.
+type Record struct {
+	Secret string `json:"secret"`
+	// LastRotated is the time of the last successful rotation.
+	LastRotated time.Time `json:"last_rotated,omitempty"`
+	// LastAttempt is the time of the last rotation, successful or not.
+	LastAttempt time.Time `json:"last_attempt"`
+	// CredentialID identifies the credential distributed by the last
+	// successful rotation, e.g. an AWS access key ID, if the source
+	// can name it.
+	CredentialID string  `json:"credential_id,omitempty"`
+	Outcome      Outcome `json:"outcome"`
+	// Sinks holds one entry per sink of the secret, in config order.
+	Sinks []SinkRecord `json:"sinks,omitempty"`
+}
+
+// SinkRecord describes the last write of a secret to one of its sinks.
+type SinkRecord struct {
+	Kind string `json:"kind"`
+	// Fingerprint identifies the configuration of the sink, so that
+	// adding a sink or changing its target can be detected.
+	Fingerprint string    `json:"fingerprint"`
+	LastWritten time.Time `json:"last_written,omitempty"`
+	Outcome     Outcome   `json:"outcome"`
+}
+
+type Outcome string
+
+const (
+	// OutcomeRotated means the new credential was written to every sink and activated.
+	OutcomeRotated Outcome = "rotated"
+	// OutcomeRolledBack means the rotation failed and was rolled back.
+	OutcomeRolledBack Outcome = "rolled_back"
+	// OutcomeFailed means the rotation failed and could not be fully rolled back.
+	OutcomeFailed Outcome = "failed"
+)
+
+type Error string
+
+func (e Error) Error() string { return string(e) }
+
+const (
+	ErrUnknownBackend Error = "unknown state backend"
+)
@@ -0,0 +1,25 @@
+package util
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+)
+
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying log, so that sources and
+// sinks log through the logger of the secret they are rotating rather
+// than interleaving with other secrets.
+func WithLogger(ctx context.Context, log logrus.FieldLogger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// Logger returns the logger carried by ctx, or the standard logger if
+// there is none.
+func Logger(ctx context.Context) logrus.FieldLogger {
+	if log, ok := ctx.Value(loggerKey{}).(logrus.FieldLogger); ok {
+		return log
+	}
+	return logrus.StandardLogger()
+}
@@ -0,0 +1,192 @@
+package util
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	yamlUnmarshalerType = reflect.TypeOf((*yaml.Unmarshaler)(nil)).Elem()
+	yamlNodeType        = reflect.TypeOf(yaml.Node{})
+)
+
+// DecodeStrict decodes node into out, like node.Decode, and also checks
+// that every mapping key matches a field of out. Unknown keys are
+// reported along with the other problems found while decoding, in a
+// *yaml.TypeError with one message per problem, prefixed with its line
+// number. Top level keys listed in ignore are allowed.
+//
+// Values of types implementing yaml.Unmarshaler are not checked, as
+// they decode themselves.
+func DecodeStrict(node *yaml.Node, out interface{}, ignore ...string) error {
+	errs := unknownFields(node, reflect.TypeOf(out), ignore)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].line < errs[j].line })
+	var msgs []string
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	err := node.Decode(out)
+	if te, ok := err.(*yaml.TypeError); ok {
+		msgs = append(msgs, te.Errors...)
+	} else if err != nil {
+		return err
+	}
+	if len(msgs) > 0 {
+		return &yaml.TypeError{Errors: msgs}
+	}
+	return nil
+}
+
+// ErrorMessages returns one message per problem in err: the messages
+// of a *yaml.TypeError, the errors of a *multierror.Error, or else the
+// message of err. It returns nil if err is nil.
+func ErrorMessages(err error) []string {
+	if err == nil {
+		return nil
+	}
+	switch e := errors.Cause(err).(type) {
+	case *yaml.TypeError:
+		return e.Errors
+	case *multierror.Error:
+		var msgs []string
+		for _, err := range e.Errors {
+			msgs = append(msgs, ErrorMessages(err)...)
+		}
+		return msgs
+	}
+	return []string{err.Error()}
+}
+
+// YAMLFields returns the YAML keys of the fields of struct type t, the
+// same way yaml.v3 names them, and whether any key is allowed because
+// t has an inlined map.
+func YAMLFields(t reflect.Type) (map[string]reflect.StructField, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := map[string]reflect.StructField{}
+	anyKey := false
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if hasFlag(parts[1:], "inline") {
+			ft := f.Type
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Map {
+				anyKey = true
+				continue
+			}
+			inner, innerAny := YAMLFields(ft)
+			for k, v := range inner {
+				fields[k] = v
+			}
+			anyKey = anyKey || innerAny
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		name := parts[0]
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f
+	}
+	return fields, anyKey
+}
+
+type fieldError struct {
+	line int
+	key  string
+}
+
+func (e fieldError) Error() string {
+	return fmt.Sprintf("line %d: unknown field %q", e.line, e.key)
+}
+
+func unknownFields(node *yaml.Node, t reflect.Type, ignore []string) []fieldError {
+	if node == nil || t == nil {
+		return nil
+	}
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil
+		}
+		return unknownFields(node.Content[0], t, ignore)
+	case yaml.AliasNode:
+		return unknownFields(node.Alias, t, ignore)
+	}
+	for {
+		if t.Implements(yamlUnmarshalerType) || reflect.PtrTo(t).Implements(yamlUnmarshalerType) {
+			return nil
+		}
+		if t.Kind() != reflect.Ptr {
+			break
+		}
+		t = t.Elem()
+	}
+
+	var errs []fieldError
+	switch t.Kind() {
+	case reflect.Struct:
+		if t == yamlNodeType || node.Kind != yaml.MappingNode {
+			return nil
+		}
+		fields, anyKey := YAMLFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, val := node.Content[i], node.Content[i+1]
+			if hasFlag(ignore, key.Value) {
+				continue
+			}
+			f, ok := fields[key.Value]
+			if !ok {
+				if !anyKey {
+					errs = append(errs, fieldError{line: key.Line, key: key.Value})
+				}
+				continue
+			}
+			errs = append(errs, unknownFields(val, f.Type, nil)...)
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return nil
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			errs = append(errs, unknownFields(node.Content[i], t.Elem(), nil)...)
+		}
+	case reflect.Slice, reflect.Array:
+		if node.Kind != yaml.SequenceNode {
+			return nil
+		}
+		for _, item := range node.Content {
+			errs = append(errs, unknownFields(item, t.Elem(), nil)...)
+		}
+	}
+	return errs
+}
+
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}